@@ -39,38 +39,45 @@ func (b *Buffer) reset() {
 }
 
 // ReadFromFD reads data from the file descriptor
+// return io.EOF if peer closed, ErrBufferFull if no space to read (frame is larger than buffer)
 func (b *Buffer) ReadFromFD(fd int) error {
 	b.reset()
+	if b.end == len(b.buf) {
+		return ErrBufferFull
+	}
 	n, err := syscall.Read(fd, b.buf[b.end:])
 	if err != nil {
 		return err
 	}
 	if n == 0 {
-		return syscall.EAGAIN
+		return io.EOF
 	}
 	b.end += n
 	return nil
 }
 
 // AsyncReadFromFD
-// async block read event from fd to buf
-func (b *Buffer) AsyncReadFromFD(fd int, uring *ioUring, cb EventCallBack) error {
+// async block read event from fd to buf, cb after bytes read;
+// done (optional) is called when read op completes, eg: buffer is not written by kernel any more
+func (b *Buffer) AsyncReadFromFD(fd int, uring *ioUring, cb EventCallBack, done func()) error {
 	b.reset()
-	uring.addRecvSqe(func(info *eventInfo) error {
+	return uring.addRecvSqe(func(info *eventInfo) error {
+		if done != nil {
+			defer done()
+		}
 		n := info.cqe.Res
 		if n < 0 {
 			return ErrIOUringReadFail
 		}
 		if n == 0 {
-			return syscall.EAGAIN
+			// peer closed
+			return io.EOF
 		}
 		b.end += int(n)
 		//log.Infof("cb %+v buff start %d end %d n %d buff %s", cb, b.start, b.end, n, b.buf[b.start:b.end])
 		err := cb(info)
 		return err
 	}, fd, b.buf[b.end:], len(b.buf[b.end:]), 0)
-
-	return nil
 }
 
 // ReadFromReader reads data from the reader. If the reader blocks, it will block
@@ -106,6 +113,6 @@ func (b *Buffer) Read(offset, limit int) ([]byte, error) {
 
 // ReadAll Reads all bytes
 func (b *Buffer) ReadAll() []byte {
-	buf, _ := b.Read(b.start, b.end)
+	buf, _ := b.Read(0, b.Len())
 	return buf
 }
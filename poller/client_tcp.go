@@ -0,0 +1,187 @@
+package poller
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/timingwheel"
+)
+
+// Client TCP client
+// non block dial out connect, connect fd is registered in the same
+// event poller / io_uring loops, use the same Handler, Decoder and Encoder
+type Client struct {
+	*Server
+}
+
+// NewClient
+// init client event loops without listen, Run to start
+func NewClient(handler Handler, opts ...Option) (*Client, error) {
+	options := getOptions(opts...)
+
+	s, err := newServer(-1, handler, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{Server: s}, nil
+}
+
+// Dial
+// non block connect to address with dial timeout option, wait connect done by event loop (DialAsync);
+// notice: Run before Dial, don't Dial in Handler (blocks event loop), use DialAsync
+func (s *Server) Dial(address string) (*Conn, error) {
+	type dialResult struct {
+		c   *Conn
+		err error
+	}
+	ch := make(chan dialResult, 1)
+	s.DialAsync(address, func(c *Conn, err error) {
+		ch <- dialResult{c: c, err: err}
+	})
+	r := <-ch
+	return r.c, r.err
+}
+
+// DialAsync
+// non block connect to address with dial timeout option, connecting fd is registered in event loop,
+// cb is called with connected conn (after OnConnect) or err in event loop,
+// or in caller goroutine if connected (eg: unix socket) or failed at once
+func (s *Server) DialAsync(address string, cb func(c *Conn, err error)) {
+	cfd, connected, err := startConnect(address, s.options.keepaliveInterval)
+	if err != nil {
+		log.Errorf("dial %s err %s", address, err.Error())
+		cb(nil, err)
+		return
+	}
+	if connected {
+		cb(s.AttachFD(cfd, address))
+		return
+	}
+
+	d := &dialing{fd: cfd, address: address, cb: cb}
+	s.dials.Store(cfd, d)
+	if s.options.dialTimeout > 0 {
+		d.mu.Lock()
+		d.timer = s.timingWheel.AfterFunc(s.options.dialTimeout, func() {
+			s.postEvent(&eventInfo{fd: cfd, etype: ETypeConnect, cb: func(*eventInfo) error {
+				s.finishDial(d, ErrDialTimeout)
+				return nil
+			}})
+		})
+		d.mu.Unlock()
+	}
+
+	if len(s.iourings) != 0 {
+		err = s.GetIoUring(cfd).addConnectPollSqe(func(*eventInfo) error {
+			// fd may be closed by dial timeout
+			if atomic.LoadInt32(&d.done) == 0 {
+				s.finishDial(d, connectResult(cfd))
+			}
+			return nil
+		}, cfd)
+		if err != nil {
+			s.finishDial(d, err)
+		}
+		return
+	}
+	err = addWriteEventFD(s.pollerFD, cfd)
+	if err != nil {
+		s.finishDial(d, err)
+	}
+}
+
+// dialing
+// non block connect in progress, done by writable event or dial timeout once
+type dialing struct {
+	fd      int
+	address string
+	cb      func(c *Conn, err error)
+	mu      sync.Mutex // guard timer, writable event may be before timer set
+	timer   *timingwheel.Timer
+	done    int32
+}
+
+// processDialEvent
+// connecting fd is writable (connected or failed) in event loop, false if fd is not connecting
+func (s *Server) processDialEvent(fd int) bool {
+	v, ok := s.dials.Load(fd)
+	if !ok {
+		return false
+	}
+	s.finishDial(v.(*dialing), connectResult(fd))
+	return true
+}
+
+// finishDial
+// attach connected fd, or close fd if connect err (timeout)
+func (s *Server) finishDial(d *dialing, err error) {
+	if !atomic.CompareAndSwapInt32(&d.done, 0, 1) {
+		return
+	}
+	// fd is not closed and reused before removed
+	s.dials.Delete(d.fd)
+	d.mu.Lock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.mu.Unlock()
+
+	if len(s.iourings) == 0 {
+		delEventFD(s.pollerFD, d.fd)
+	} else if err != nil {
+		// complete in flight poll op
+		syscall.Shutdown(d.fd, syscall.SHUT_RDWR)
+	}
+	if err != nil {
+		log.Errorf("dial %s err %s", d.address, err.Error())
+		syscall.Close(d.fd)
+		d.cb(nil, err)
+		return
+	}
+	d.cb(s.AttachFD(d.fd, d.address))
+}
+
+// AttachFD
+// register connected socket fd (eg: inherited fd, one end of socketpair) as dialed connect,
+// OnConnect is called (after PROXY header parsed with WithProxyProtocol);
+// the connect is served by event loops if running
+func (s *Server) AttachFD(cfd int, address string) (*Conn, error) {
+	err := syscall.SetNonblock(cfd, true)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newConn(s.pollerFD, cfd, address, s)
+	conn.proxyPending = s.options.proxyProtocol
+	s.conns.Store(cfd, conn)
+	atomic.AddInt64(&s.connsNum, 1)
+
+	if len(s.iourings) != 0 {
+		s.onConnect(conn)
+		// new connected server, async read data from socket
+		conn.AsyncBlockRead()
+		return conn, nil
+	}
+
+	if s.options.tlsConfig != nil {
+		// dialed connect is not limited, counted only
+		atomic.AddInt64(s.tlsHandshakes, 1)
+		conn.initTLS(s.options.tlsConfig, true)
+	}
+
+	// OnConnect before read event added, happens before OnMessage
+	s.onConnect(conn)
+
+	err = addReadEvent(s.pollerFD, cfd)
+	if err != nil {
+		log.Error(err)
+		conn.Close()
+		s.handler.OnClose(conn, err)
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,161 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClientDial(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true))
+	h := newTestHandler(false)
+	client := startClient(t, h)
+
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recv(t, h.connects).(*Conn); got != c {
+		t.Fatalf("OnConnect conn %p; want %p", got, c)
+	}
+	if c.GetAddr() != addr {
+		t.Errorf("GetAddr() = %s; want %s", c.GetAddr(), addr)
+	}
+
+	data := []byte("ping")
+	if _, err = c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := recv(t, h.msgs).([]byte); !bytes.Equal(got, data) {
+		t.Fatalf("echo %q; want %q", got, data)
+	}
+}
+
+func TestClientDialAsyncInHandler(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true))
+
+	// dial in OnMessage of an accepted connect must not block its event loop
+	proxyAddr := freeAddr(t)
+	var client *Client
+	dialed := make(chan error, 1)
+	proxy := &funcHandler{onMessage: func(c *Conn, b []byte) {
+		client.DialAsync(addr, func(out *Conn, err error) {
+			if err == nil {
+				_, err = out.Write([]byte("via proxy"))
+			}
+			dialed <- err
+		})
+	}}
+	h := newTestHandler(false)
+	client = startClient(t, h)
+	startServer(t, proxyAddr, proxy)
+
+	c, err := client.Dial(proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("go"))
+	if err := recv(t, dialed); err != nil {
+		t.Fatal(err)
+	}
+	recv(t, h.connects) // proxy connect
+	recv(t, h.connects) // dialed in handler
+	if got := recv(t, h.msgs).([]byte); string(got) != "via proxy" {
+		t.Fatalf("echo %q", got)
+	}
+}
+
+func TestClientDialRefused(t *testing.T) {
+	client := startClient(t, newTestHandler(false))
+	_, err := client.Dial(freeAddr(t))
+	if err != syscall.ECONNREFUSED {
+		t.Fatalf("Dial() err %v; want %v", err, syscall.ECONNREFUSED)
+	}
+}
+
+func TestClientDialTimeout(t *testing.T) {
+	// listen with full accept queue, SYN is dropped
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err = syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, _ := syscall.Getsockname(fd)
+	addr := getAddr(sa)
+
+	client := startClient(t, newTestHandler(false), WithDialTimeout(200*time.Millisecond))
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		c, err := client.Dial(addr)
+		if err == ErrDialTimeout {
+			if d := time.Since(start); d > 3*time.Second {
+				t.Fatalf("dial timeout after %s", d)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	t.Skip("accept queue is not full, SYN is not dropped")
+}
+
+func TestClientDialIoUring(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true))
+	h := newTestHandler(false)
+	client, err := NewClient(h, WithIoMode(IOModeUring))
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	go client.Run()
+	defer client.Stop()
+
+	c, err := client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv(t, h.connects)
+	c.Write([]byte("uring"))
+	if got := recv(t, h.msgs).([]byte); string(got) != "uring" {
+		t.Fatalf("echo %q", got)
+	}
+}
+
+// funcHandler handler by funcs
+type funcHandler struct {
+	onConnect func(c *Conn)
+	onMessage func(c *Conn, bytes []byte)
+	onClose   func(c *Conn, err error)
+}
+
+func (h *funcHandler) OnConnect(c *Conn) {
+	if h.onConnect != nil {
+		h.onConnect(c)
+	}
+}
+
+func (h *funcHandler) OnMessage(c *Conn, bytes []byte) {
+	if h.onMessage != nil {
+		h.onMessage(c, bytes)
+	}
+}
+
+func (h *funcHandler) OnClose(c *Conn, err error) {
+	if h.onClose != nil {
+		h.onClose(c, err)
+	}
+}
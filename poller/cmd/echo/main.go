@@ -45,6 +45,10 @@ var ioMode = flag.String("ioMode", "", "ioMode")
 var mapIoMode = map[string]poller.IOMode{
 	"iouring":      poller.IOModeUring,
 	"epollIouring": poller.IOModeEpollUring,
+	"iouringPoll":  poller.IOModeUringPoll,
+	"sqpoll":       poller.IOModeUringSQPoll,
+	"wq":           poller.IOModeUringWQ,
+	"iouK":         poller.IOModeIouK,
 }
 
 func main() {
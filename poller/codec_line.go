@@ -0,0 +1,71 @@
+package poller
+
+import (
+	"bytes"
+	"io"
+)
+
+type lineDecoder struct {
+	// maxLineLen
+	// max length of line without delimiter, must less than read buffer len
+	maxLineLen int
+}
+
+// NewLineDecoder
+// Creates a '\n' line-delimited frame decoder, trailing "\r\n" / "\n" is trimmed
+func NewLineDecoder(maxLineLen int) Decoder {
+	if maxLineLen <= 0 {
+		panic("maxLineLen must greater than 0")
+	}
+
+	return &lineDecoder{maxLineLen: maxLineLen}
+}
+
+// Decode
+// decode one line, empty if line is not complete
+func (d *lineDecoder) Decode(buffer *Buffer) (value []byte, err error) {
+	value = []byte{}
+	buf, _ := buffer.Seek(buffer.Len())
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		if len(buf) > d.maxLineLen {
+			err = ErrFrameTooLarge
+		}
+		return
+	}
+	if i > d.maxLineLen+1 {
+		err = ErrFrameTooLarge
+		return
+	}
+
+	value, err = buffer.Read(0, i+1)
+	if err != nil {
+		return
+	}
+
+	value = value[:i]
+	if i > 0 && value[i-1] == '\r' {
+		value = value[:i-1]
+	}
+
+	return
+}
+
+type lineEncoder struct{}
+
+// NewLineEncoder
+// Creates a '\n' line-delimited frame encoder
+func NewLineEncoder() Encoder {
+	return &lineEncoder{}
+}
+
+// EncodeToWriter
+// Encodes bytes with '\n' delimiter and writes it to Writer
+func (e *lineEncoder) EncodeToWriter(w io.Writer, bytes []byte) error {
+	buffer := make([]byte, len(bytes)+1)
+	copy(buffer, bytes)
+	buffer[len(bytes)] = '\n'
+
+	_, err := w.Write(buffer)
+	return err
+}
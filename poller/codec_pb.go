@@ -1 +1,112 @@
 package poller
+
+import (
+	"encoding/binary"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// PBHandler optional Handler hook for protobuf decoder with registered message type,
+// OnPBMessage with the decoded message instead of OnMessage
+type PBHandler interface {
+	OnPBMessage(c *Conn, msg proto.Message)
+}
+
+type pbDecoder struct {
+	// maxFrameLen
+	// max length of varint length-delimited frame payload, must less than read buffer len
+	maxFrameLen int
+	// msgType registered proto message type, new message to unmarshal frame payload
+	msgType protoreflect.MessageType
+}
+
+// NewPBDecoder
+// Creates a varint length-delimited protobuf frame decoder (same as protodelim/java writeDelimitedTo)
+// msg registered proto message type, handler implement PBHandler to get decoded message, nil just frame payload bytes
+// maxFrameLen max frame payload length, frame is not complete if large than read buffer len
+func NewPBDecoder(msg proto.Message, maxFrameLen int) Decoder {
+	if maxFrameLen <= 0 {
+		panic("maxFrameLen must greater than 0")
+	}
+
+	d := &pbDecoder{maxFrameLen: maxFrameLen}
+	if msg != nil {
+		d.msgType = msg.ProtoReflect().Type()
+	}
+	return d
+}
+
+// Decode
+// decode one varint length-delimited frame payload, empty if frame is not complete
+func (d *pbDecoder) Decode(buffer *Buffer) (value []byte, err error) {
+	value = []byte{}
+	bytes, _ := buffer.Seek(buffer.Len())
+	valueLen, n := binary.Uvarint(bytes)
+	if n == 0 {
+		return
+	}
+	if n < 0 || valueLen > uint64(d.maxFrameLen) {
+		err = ErrFrameTooLarge
+		return
+	}
+
+	value, err = buffer.Read(n, int(valueLen))
+	if err == ErrBufferNotEnough {
+		return []byte{}, nil
+	}
+
+	return
+}
+
+// unmarshal
+// unmarshal frame payload to new registered proto message
+func (d *pbDecoder) unmarshal(bytes []byte) (proto.Message, error) {
+	msg := d.msgType.New().Interface()
+	err := proto.Unmarshal(bytes, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+type pbEncoder struct{}
+
+// NewPBEncoder
+// Creates a varint length-delimited protobuf frame encoder
+// notice: io_uring async write keeps the frame buffer, so don't reuse it from pool
+func NewPBEncoder() Encoder {
+	return &pbEncoder{}
+}
+
+// EncodeToWriter
+// Encodes marshaled proto message bytes to varint length-delimited frame and writes it to Writer
+func (e *pbEncoder) EncodeToWriter(w io.Writer, bytes []byte) error {
+	_, err := w.Write(appendPBFrame(bytes))
+	return err
+}
+
+// appendPBFrame
+// varint length prefix + payload
+func appendPBFrame(bytes []byte) []byte {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(len(bytes)))
+
+	buffer := make([]byte, n+len(bytes))
+	copy(buffer, header[:n])
+	copy(buffer[n:], bytes)
+	return buffer
+}
+
+// WritePB
+// marshal proto message, write varint length-delimited frame to connect
+func (c *Conn) WritePB(msg proto.Message) error {
+	bytes, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Write(appendPBFrame(bytes))
+	return err
+}
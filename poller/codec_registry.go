@@ -0,0 +1,107 @@
+package poller
+
+import (
+	"bytes"
+)
+
+// Codec
+// decoder and encoder of one framing protocol,
+// negotiated per connect by the magic prefix client sent first
+type Codec struct {
+	Name    string  // codec name, eg: raw, pb, line
+	Magic   []byte  // magic prefix, empty for the default codec if no magic matched
+	Decoder Decoder // frame decoder, nil: OnMessage with all read bytes
+	Encoder Encoder // frame encoder for Conn.WriteWithEncoder
+}
+
+// CodecRegistry
+// registered codecs to negotiate per connect, register before server run
+type CodecRegistry struct {
+	codecs       []*Codec
+	defaultCodec *Codec
+}
+
+// NewCodecRegistry
+// Creates a codec registry, server use it by WithCodecRegistry option
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{}
+}
+
+// Register
+// register codec, codec with empty magic is the default one (last registered);
+// notice: magic should not be the prefix of other magic, first registered matched
+func (r *CodecRegistry) Register(codec *Codec) *CodecRegistry {
+	if codec == nil {
+		panic("codec must not be nil")
+	}
+	if len(codec.Magic) == 0 {
+		r.defaultCodec = codec
+		return r
+	}
+	r.codecs = append(r.codecs, codec)
+	return r
+}
+
+// match
+// match codec by connect first read bytes,
+// wait if bytes is the prefix of some magic, default codec if no magic matched
+func (r *CodecRegistry) match(b []byte) (codec *Codec, wait bool) {
+	for _, c := range r.codecs {
+		if bytes.HasPrefix(b, c.Magic) {
+			return c, false
+		}
+		if bytes.HasPrefix(c.Magic, b) {
+			wait = true
+		}
+	}
+	if wait {
+		return nil, true
+	}
+
+	return r.defaultCodec, false
+}
+
+// negotiateCodec
+// negotiate codec from buffer magic prefix, magic is discarded;
+// return false if need wait more bytes
+func (c *Conn) negotiateCodec(r *CodecRegistry, buffer *Buffer) (ok bool, err error) {
+	b, _ := buffer.Seek(buffer.Len())
+	codec, wait := r.match(b)
+	if wait {
+		return
+	}
+	if codec == nil {
+		err = ErrCodecNotMatch
+		return
+	}
+
+	_, err = buffer.Read(len(codec.Magic), 0)
+	if err != nil {
+		return
+	}
+	c.setCodec(codec)
+
+	return true, nil
+}
+
+// UseCodec
+// dialed connect use codec, write magic prefix to negotiate with server
+func (c *Conn) UseCodec(codec *Codec) error {
+	c.setCodec(codec)
+	if len(codec.Magic) == 0 {
+		return nil
+	}
+	_, err := c.Write(codec.Magic)
+	return err
+}
+
+// GetCodec gets the negotiated codec, nil if not negotiated
+func (c *Conn) GetCodec() *Codec {
+	return c.codec
+}
+
+func (c *Conn) setCodec(codec *Codec) {
+	c.codec = codec
+	c.decoder = codec.Decoder
+	c.encoder = codec.Encoder
+}
@@ -0,0 +1,216 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// pbFrame varint length-delimited frame of message
+func pbFrame(t *testing.T, msg proto.Message) []byte {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(binary.AppendUvarint(nil, uint64(len(b))), b...)
+}
+
+func TestPBDecoderPartialFrames(t *testing.T) {
+	h := &pbRecorder{}
+	c, err := pollertest.New(h, poller.WithDecoder(poller.NewPBDecoder(&wrapperspb.StringValue{}, 1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var stream []byte
+	want := []string{"a", "", string(make([]byte, 300))}
+	for _, v := range want {
+		stream = append(stream, pbFrame(t, wrapperspb.String(v))...)
+	}
+	// one byte per read, varint length prefix of 300 is split
+	if err = c.WriteChunks(stream, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.pbMsgs) != len(want) {
+		t.Fatalf("got %d messages; want %d", len(h.pbMsgs), len(want))
+	}
+	for i, msg := range h.pbMsgs {
+		if got := msg.(*wrapperspb.StringValue).GetValue(); got != want[i] {
+			t.Errorf("message %d = %q; want %q", i, got, want[i])
+		}
+	}
+	if len(h.msgs) != 0 {
+		t.Errorf("OnMessage called %d times with PBHandler", len(h.msgs))
+	}
+}
+
+func TestPBDecoderFrameBytes(t *testing.T) {
+	h := &recorder{}
+	c, err := pollertest.New(h, poller.WithDecoder(poller.NewPBDecoder(nil, 1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	frame := pbFrame(t, wrapperspb.String("payload"))
+	if err = c.Write(append(frame, frame...)); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.msgs) != 2 || !proto.Equal(unmarshalString(t, h.msgs[0]), wrapperspb.String("payload")) {
+		t.Fatalf("OnMessage frames %q", h.msgs)
+	}
+}
+
+func unmarshalString(t *testing.T, b []byte) *wrapperspb.StringValue {
+	msg := &wrapperspb.StringValue{}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestPBDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes []byte
+	}{
+		{"frame too large", binary.AppendUvarint(nil, 1025)},
+		{"varint overflow", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &pbRecorder{}
+			c, err := pollertest.New(h, poller.WithDecoder(poller.NewPBDecoder(&wrapperspb.StringValue{}, 1024)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if err = c.Write(tt.bytes); err != poller.ErrFrameTooLarge {
+				t.Fatalf("Write() err %v; want %v", err, poller.ErrFrameTooLarge)
+			}
+			if len(h.closes) != 1 || h.closes[0] != poller.ErrFrameTooLarge {
+				t.Fatalf("OnClose errs %v", h.closes)
+			}
+		})
+	}
+}
+
+func TestLineDecoder(t *testing.T) {
+	h := &recorder{}
+	c, err := pollertest.New(h, poller.WithDecoder(poller.NewLineDecoder(8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.WriteChunks([]byte("ping\r\n\nhello\n"), 3); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ping", "", "hello"}
+	if len(h.msgs) != len(want) {
+		t.Fatalf("lines %q; want %q", h.msgs, want)
+	}
+	for i := range want {
+		if string(h.msgs[i]) != want[i] {
+			t.Errorf("line %d = %q; want %q", i, h.msgs[i], want[i])
+		}
+	}
+
+	if err = c.Write([]byte("too long line")); err != poller.ErrFrameTooLarge {
+		t.Fatalf("Write() err %v; want %v", err, poller.ErrFrameTooLarge)
+	}
+}
+
+func testRegistry() *poller.CodecRegistry {
+	return poller.NewCodecRegistry().
+		Register(&poller.Codec{Name: "line", Magic: []byte("LN"), Decoder: poller.NewLineDecoder(64)}).
+		Register(&poller.Codec{Name: "pb", Magic: []byte("PB"), Decoder: poller.NewPBDecoder(nil, 64), Encoder: poller.NewPBEncoder()}).
+		Register(&poller.Codec{Name: "raw", Decoder: poller.NewHeaderLenDecoder(2)})
+}
+
+func TestCodecRegistryNegotiate(t *testing.T) {
+	pb := pbFrame(t, wrapperspb.String("x"))
+	raw := append([]byte{0, 3}, "raw"...)
+	tests := []struct {
+		name  string
+		bytes []byte
+		codec string
+		msgs  [][]byte
+	}{
+		{"line", []byte("LNa\nb\n"), "line", [][]byte{[]byte("a"), []byte("b")}},
+		{"pb", append([]byte("PB"), pb...), "pb", [][]byte{pb[1:]}},
+		{"default raw", raw, "raw", [][]byte{[]byte("raw")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &recorder{}
+			c, err := pollertest.New(h, poller.WithCodecRegistry(testRegistry()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			// magic split across reads
+			if err = c.WriteChunks(tt.bytes, 1); err != nil {
+				t.Fatal(err)
+			}
+			codec := c.PollerConn().GetCodec()
+			if codec == nil || codec.Name != tt.codec {
+				t.Fatalf("negotiated codec %v; want %s", codec, tt.codec)
+			}
+			if len(h.msgs) != len(tt.msgs) {
+				t.Fatalf("msgs %q; want %q", h.msgs, tt.msgs)
+			}
+			for i := range tt.msgs {
+				if string(h.msgs[i]) != string(tt.msgs[i]) {
+					t.Errorf("msg %d = %q; want %q", i, h.msgs[i], tt.msgs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCodecRegistryNotMatch(t *testing.T) {
+	registry := poller.NewCodecRegistry().Register(&poller.Codec{Name: "line", Magic: []byte("LN")})
+	h := &recorder{}
+	c, err := pollertest.New(h, poller.WithCodecRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.Write([]byte("XX")); err != poller.ErrCodecNotMatch {
+		t.Fatalf("Write() err %v; want %v", err, poller.ErrCodecNotMatch)
+	}
+}
+
+func TestPBEncoderWritePB(t *testing.T) {
+	h := &recorder{onMessage: func(c *poller.Conn, bytes []byte) {
+		c.WritePB(wrapperspb.String("pong"))
+	}}
+	c, err := pollertest.New(h, poller.WithDecoder(poller.NewPBDecoder(nil, 64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err = c.Write(pbFrame(t, wrapperspb.String("ping"))); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Recv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := pbFrame(t, wrapperspb.String("pong")); string(resp) != string(want) {
+		t.Fatalf("WritePB frame %q; want %q", resp, want)
+	}
+}
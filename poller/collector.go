@@ -0,0 +1,134 @@
+package poller
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// collectorNamespace metric name prefix
+	collectorNamespace = "poller"
+)
+
+// Collector
+// prometheus.Collector of server stats, register it next to metric.HttpMetrics:
+// prometheus.MustRegister(poller.NewCollector("echo", server));
+// per connect metrics are collected only if WithConnStats option is set, labelled by connect id and addr
+type Collector struct {
+	server *Server
+
+	conns        *prometheus.Desc
+	accepted     *prometheus.Desc
+	timeouts     *prometheus.Desc
+	rejected     *prometheus.Desc
+	bytesIn      *prometheus.Desc
+	bytesOut     *prometheus.Desc
+	msgsIn       *prometheus.Desc
+	msgsOut      *prometheus.Desc
+	decodeErrors *prometheus.Desc
+	queueLen     *prometheus.Desc
+	sqEntries    *prometheus.Desc
+	sqReady      *prometheus.Desc
+	cqEntries    *prometheus.Desc
+	cqReady      *prometheus.Desc
+	inFlight     *prometheus.Desc
+
+	connBytesIn      *prometheus.Desc
+	connBytesOut     *prometheus.Desc
+	connMsgsIn       *prometheus.Desc
+	connMsgsOut      *prometheus.Desc
+	connDecodeErrors *prometheus.Desc
+}
+
+// NewCollector
+// Creates collector of server, metrics have const label server="name"
+func NewCollector(name string, s *Server) *Collector {
+	labels := prometheus.Labels{"server": name}
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(collectorNamespace, "", name), help, variableLabels, labels)
+	}
+	return &Collector{
+		server: s,
+
+		conns:        desc("connections", "Current connections."),
+		accepted:     desc("accepted_connections_total", "Accepted connections."),
+		timeouts:     desc("timeouts_total", "Connections closed by read/write deadline or idle timeout."),
+		rejected:     desc("rejected_connections_total", "Connections rejected by max connections limits."),
+		bytesIn:      desc("read_bytes_total", "Bytes read from connections."),
+		bytesOut:     desc("written_bytes_total", "Bytes written to connections."),
+		msgsIn:       desc("read_messages_total", "Messages handled by OnMessage."),
+		msgsOut:      desc("written_messages_total", "Messages written to connections."),
+		decodeErrors: desc("decode_errors_total", "Decoder errors."),
+		queueLen:     desc("event_queue_length", "Queued events of io event queue.", "queue"),
+		sqEntries:    desc("iouring_sq_entries", "io_uring submission queue entries.", "ring"),
+		sqReady:      desc("iouring_sq_ready", "io_uring submission queue entries not consumed by kernel.", "ring"),
+		cqEntries:    desc("iouring_cq_entries", "io_uring completion queue entries.", "ring"),
+		cqReady:      desc("iouring_cq_ready", "io_uring completion queue entries not reaped.", "ring"),
+		inFlight:     desc("iouring_inflight_ops", "io_uring submitted ops without complete event.", "ring"),
+
+		connBytesIn:      desc("conn_read_bytes_total", "Bytes read from connection.", "conn", "addr"),
+		connBytesOut:     desc("conn_written_bytes_total", "Bytes written to connection.", "conn", "addr"),
+		connMsgsIn:       desc("conn_read_messages_total", "Messages of connection handled by OnMessage.", "conn", "addr"),
+		connMsgsOut:      desc("conn_written_messages_total", "Messages written to connection.", "conn", "addr"),
+		connDecodeErrors: desc("conn_decode_errors_total", "Decoder errors of connection.", "conn", "addr"),
+	}
+}
+
+// Describe prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.conns, c.accepted, c.timeouts, c.rejected, c.bytesIn, c.bytesOut, c.msgsIn, c.msgsOut, c.decodeErrors,
+		c.queueLen, c.sqEntries, c.sqReady, c.cqEntries, c.cqReady, c.inFlight,
+		c.connBytesIn, c.connBytesOut, c.connMsgsIn, c.connMsgsOut, c.connDecodeErrors,
+	} {
+		ch <- d
+	}
+}
+
+// Collect prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	st := c.server.Stats()
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+
+	gauge(c.conns, float64(st.ConnsNum))
+	counter(c.accepted, st.Accepted)
+	counter(c.timeouts, st.Timeouts)
+	counter(c.rejected, st.Rejected)
+	counter(c.bytesIn, st.BytesIn)
+	counter(c.bytesOut, st.BytesOut)
+	counter(c.msgsIn, st.MsgsIn)
+	counter(c.msgsOut, st.MsgsOut)
+	counter(c.decodeErrors, st.DecodeErrors)
+	for i, n := range st.EventQueueLens {
+		gauge(c.queueLen, float64(n), strconv.Itoa(i))
+	}
+	for i, r := range st.IoUrings {
+		ring := strconv.Itoa(i)
+		gauge(c.sqEntries, float64(r.SQEntries), ring)
+		gauge(c.sqReady, float64(r.SQReady), ring)
+		gauge(c.cqEntries, float64(r.CQEntries), ring)
+		gauge(c.cqReady, float64(r.CQReady), ring)
+		gauge(c.inFlight, float64(r.InFlight), ring)
+	}
+
+	if !c.server.options.connStats {
+		return
+	}
+	c.server.RangeConns(func(conn *Conn) bool {
+		cs := conn.Stats()
+		// label by connect id, series of fd would reset when closed fd is reused
+		id, addr := strconv.FormatUint(conn.id, 10), conn.GetAddr()
+		counter(c.connBytesIn, cs.BytesIn, id, addr)
+		counter(c.connBytesOut, cs.BytesOut, id, addr)
+		counter(c.connMsgsIn, cs.MsgsIn, id, addr)
+		counter(c.connMsgsOut, cs.MsgsOut, id, addr)
+		counter(c.connDecodeErrors, cs.DecodeErrors, id, addr)
+		return true
+	})
+}
@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// connLabels "conn" label of per connect metrics
+func connLabels(t *testing.T, c *Collector) map[string]bool {
+	ch := make(chan prometheus.Metric, 1024)
+	c.Collect(ch)
+	close(ch)
+
+	labels := map[string]bool{}
+	for m := range ch {
+		if m.Desc() != c.connBytesIn {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "conn" {
+				labels[l.GetValue()] = true
+			}
+		}
+	}
+	return labels
+}
+
+func TestCollectorConnLabels(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	s := startServer(t, addr, h, WithConnStats())
+	c := NewCollector("test", s)
+
+	// closed fd is reused by the next connect, conn label is not
+	seen := map[string]bool{}
+	fds := map[int]bool{}
+	for i := 0; i < 2; i++ {
+		peer, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := recv(t, h.connects).(*Conn)
+		fds[conn.GetFd()] = true
+		labels := connLabels(t, c)
+		if len(labels) != 1 {
+			t.Fatalf("conn labels %v; want 1", labels)
+		}
+		for l := range labels {
+			if seen[l] {
+				t.Fatalf("conn label %s of closed connect is reused", l)
+			}
+			seen[l] = true
+		}
+		peer.Close()
+		recv(t, h.closes)
+	}
+	if len(fds) != 1 {
+		t.Logf("fd is not reused: %v", fds)
+	}
+}
@@ -1,34 +1,83 @@
 package poller
 
 import (
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/timingwheel"
 )
 
+// connSeq connect id seq
+var connSeq uint64
+
 // Conn keepalive connection
 type Conn struct {
 	server       *Server     // server reference
 	pollerFD     int         // event poller File descriptor
 	fd           int         // socket connect File descriptor
+	id           uint64      // connect id, unique in process, fd is reused after close
 	addr         string      // peer address
 	buffer       *Buffer     // Read the buffer
+	readState    int32       // read buffer holders num and released flag
 	lastReadTime time.Time   // Time of last read
 	data         interface{} // Business custom data, used as an extension
+	closed       int32       // closed flag, 1: closed
+	tls          *tlsConn    // tls session, nil: plaintext
+	codec        *Codec      // negotiated codec by registry
+	decoder      Decoder     // connect frame decoder, default server decoder option
+	encoder      Encoder     // connect frame encoder, default server encoder option
+
+	lock            sync.Mutex      // guard write queue, read state and address replaced by PROXY header
+	wq              writeQueue      // outbound write queue
+	lastWriteTime   time.Time       // Time of last write
+	readPause       readPauseReason // reasons of read paused, 0: reading
+	readStopped     bool            // io_uring read op not added again when paused
+	closeAfterFlush bool            // close connect after write queue drained
+	multishotRead   uint64          // user data of io_uring multishot recv/poll op
+
+	readDeadline  time.Time          // read deadline, zero: none
+	writeDeadline time.Time          // write deadline, zero: none
+	idleTimeout   time.Duration      // idle timeout, 0: none
+	readTimer     *timingwheel.Timer // read deadline timer
+	writeTimer    *timingwheel.Timer // write deadline timer
+	idleTimer     *timingwheel.Timer // idle timeout timer
+
+	stats *ConnStats // connect io counters, nil: WithConnStats option is not set
+
+	proxyPending bool         // PROXY protocol header is not parsed
+	proxyHeader  *ProxyHeader // parsed PROXY protocol header
+
+	admitted      bool               // counted by server admission limits
+	admitIP       string             // source ip counted by admission limits
+	msgBucket     *tokenBucket       // OnMessage rate limit, nil: no limit
+	byteBucket    *tokenBucket       // read bytes rate limit, nil: no limit
+	throttleTimer *timingwheel.Timer // resume read paused by rate limit
 }
 
 // newConn create tcp connection
 func newConn(pollerFD, fd int, addr string, server *Server) *Conn {
-	return &Conn{
+	c := &Conn{
 		server:       server,
 		pollerFD:     pollerFD,
 		fd:           fd,
+		id:           atomic.AddUint64(&connSeq, 1),
 		addr:         addr,
-		buffer:       NewBuffer(server.readBufferPool.Get().([]byte)),
+		buffer:       server.newReadBuffer(fd),
 		lastReadTime: time.Now(),
+		decoder:      server.options.decoder,
+		encoder:      server.options.encoder,
+	}
+	if server.options.connStats {
+		c.stats = &ConnStats{}
 	}
+	c.initRateLimit(server.options)
+	if server.options.timeout > 0 {
+		c.SetIdleTimeout(server.options.timeout)
+	}
+	return c
 }
 
 // GetFd gets the file descriptor
@@ -36,8 +85,15 @@ func (c *Conn) GetFd() int {
 	return c.fd
 }
 
+// GetID gets the connect id, unique in process unlike reused fd
+func (c *Conn) GetID() uint64 {
+	return c.id
+}
+
 // GetAddr gets the client address
 func (c *Conn) GetAddr() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.addr
 }
 
@@ -50,40 +106,137 @@ func (c *Conn) GetBuff() *Buffer {
 // block read bytes until read readBufferLen bytes from connect fd
 func (c *Conn) Read() error {
 	c.lastReadTime = time.Now()
+	if c.tls != nil {
+		return c.tls.read(c)
+	}
+	// closed by other goroutine (eg: Shutdown) while reading, buffer is dropped after read
+	if !c.startRead() {
+		return nil
+	}
+	defer c.finishRead()
+
 	fd := c.GetFd()
 	for {
+		// paused by handler or rate limit (event may be queued before pause), read again after resume
+		if c.IsReadPaused() {
+			return nil
+		}
+		n := c.buffer.Len()
 		err := c.buffer.ReadFromFD(fd)
 		if err != nil {
-			// There is no data to read in the buffer
+			// There is no data to read in the socket
 			if err == syscall.EAGAIN {
 				return nil
 			}
 			return err
 		}
+		c.addBytesIn(c.buffer.Len() - n)
 
 		err = c.MsgFilter()
 		if err != nil {
 			log.Errorf("msg filter err:%s", err.Error())
-			continue
+			return err
+		}
+		// closed by handler, fd may be reused by new connect
+		if c.IsClosed() {
+			return nil
 		}
 	}
 }
 
 // MsgFilter
-// use msg decoder and onmessage handle
+// negotiate codec if use codec registry,
+// use msg decoder to decode all complete frames and onmessage handle
 func (c *Conn) MsgFilter() (err error) {
-	if c.server.options.decoder == nil {
-		c.server.handler.OnMessage(c, c.buffer.ReadAll())
-		return
+	return c.msgFilter(c.buffer)
+}
+
+// msgFilter
+// filter msg from buffer, connect buffer or provided buffer of io_uring
+func (c *Conn) msgFilter(b *Buffer) (err error) {
+	var msgs int
+	if c.msgBucket != nil || c.byteBucket != nil {
+		n := b.Len()
+		defer func() {
+			c.throttle(msgs, n-b.Len())
+		}()
 	}
 
-	val, err := c.server.options.decoder.Decode(c.buffer)
-	if err != nil {
+	if c.proxyPending {
+		if !c.processProxyHeader(b) {
+			return nil
+		}
+		c.server.handler.OnConnect(c)
+		if c.IsClosed() || b.Len() == 0 {
+			return nil
+		}
+	}
+
+	if c.codec == nil && c.server.options.codecRegistry != nil {
+		ok, err := c.negotiateCodec(c.server.options.codecRegistry, b)
+		if !ok {
+			return err
+		}
+	}
+
+	if c.decoder == nil {
+		msgs++
+		c.addMsgIn()
+		c.server.handler.OnMessage(c, b.ReadAll())
 		return
 	}
+
+	for !c.IsClosed() {
+		n := b.Len()
+		val, err := c.decoder.Decode(b)
+		if err != nil {
+			c.addDecodeError()
+			return err
+		}
+		// frame is not complete
+		if b.Len() == n {
+			return nil
+		}
+
+		msgs++
+		c.addMsgIn()
+		err = c.onFrame(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// onFrame
+// onmessage handle decoded frame, or OnPBMessage with registered proto message
+func (c *Conn) onFrame(val []byte) error {
+	if d, ok := c.decoder.(*pbDecoder); ok && d.msgType != nil {
+		if h, ok := c.server.handler.(PBHandler); ok {
+			msg, err := d.unmarshal(val)
+			if err != nil {
+				return err
+			}
+			h.OnPBMessage(c, msg)
+			return nil
+		}
+	}
+
 	c.server.handler.OnMessage(c, val)
+	return nil
+}
 
-	return
+// SetDecoder
+// set connect frame decoder, eg: switch protocol after upgrade
+func (c *Conn) SetDecoder(decoder Decoder) {
+	c.decoder = decoder
+}
+
+// SetEncoder
+// set connect frame encoder
+func (c *Conn) SetEncoder(encoder Encoder) {
+	c.encoder = encoder
 }
 
 // AsyncBlockRead  trigger a async kernerl block read from connect socket fd to buff
@@ -91,11 +244,27 @@ func (c *Conn) AsyncBlockRead() {
 	c.lastReadTime = time.Now()
 	fd := c.GetFd()
 	ring := c.server.GetIoUring(fd)
-	c.buffer.AsyncReadFromFD(fd, ring, c.getReadCallback())
+	if c.server.options.ioMode == IOModeUringPoll {
+		c.asyncPollIn(ring)
+		return
+	}
+	if ring.multishotRecv {
+		op, err := ring.addMultishotRecvSqe(c.getBufRingReadCallback(ring), fd)
+		if err == nil {
+			atomic.StoreUint64(&c.multishotRead, uint64(op))
+		}
+		return
+	}
+	if ring.bufRing != nil {
+		ring.addRecvSelectSqe(c.getBufRingReadCallback(ring), fd)
+		return
+	}
+	c.asyncRecv(ring)
 }
 
 func (c *Conn) getReadCallback() EventCallBack {
 	return func(e *eventInfo) (err error) {
+		c.addBytesIn(int(e.cqe.Res))
 		err = c.MsgFilter()
 		return
 	}
@@ -105,6 +274,15 @@ func (c *Conn) getReadCallback() EventCallBack {
 // process connect read complete event
 // add async block read bytes event until read readBufferLen bytes from connect fd
 func (c *Conn) processReadEvent(e *eventInfo) (err error) {
+	// closed by other goroutine after the event is dispatched,
+	// give back provided buffer, connect read buffer of recv op is left to gc
+	if !c.startRead() {
+		c.server.GetIoUring(c.fd).releaseBuffer(e)
+		return nil
+	}
+	defer c.finishRead()
+
+	c.lastReadTime = time.Now()
 	err = e.cb(e)
 	if err != nil {
 		// There is no data to read in the buffer
@@ -113,68 +291,120 @@ func (c *Conn) processReadEvent(e *eventInfo) (err error) {
 		}
 		return err
 	}
-	// if un use poll in ready, need add read event op again
-	c.AsyncBlockRead()
-	return
-}
-
-// AsyncBlockWrite
-// async block write bytes
-func (c *Conn) AsyncBlockWrite(bytes []byte) {
-	ring := c.server.GetIoUring(c.fd)
-	ring.addSendSqe(noOpsEventCb, c.fd, bytes, len(bytes), 0)
-}
-
-func (c *Conn) processWirteEvent(e *eventInfo) (err error) {
-	if e.cqe.Res < 0 {
-		err = ErrIOUringWriteFail
+	if c.IsClosed() {
 		return
 	}
-
-	err = e.cb(e)
-	if err != nil {
+	if c.stopReadIfPaused() {
+		if e.hasMore() {
+			c.cancelMultishotRead()
+		}
 		return
 	}
-
+	if e.hasMore() {
+		// multishot recv op is still in flight
+		return
+	}
+	// if un use poll in ready, need add read event op again
+	c.AsyncBlockRead()
 	return
 }
 
 // Write Writer impl
-// notice: if use iouring async write to fd, return 0, nil
+// write bytes directly, the left bytes are queued and flushed on EPOLLOUT;
+// io_uring mode queue bytes and flush by async send ops.
+// return len(bytes) if accepted, ErrWriteQueueFull if too many bytes queued (slow peer),
+// the connect is closed if part of bytes is written
 func (c *Conn) Write(bytes []byte) (int, error) {
-	if c.server.options.ioMode == IOModeUring {
-		c.AsyncBlockWrite(bytes)
-		return 0, nil
+	var n int
+	var err error
+	if c.tls != nil {
+		n, err = c.tls.conn.Write(bytes)
+	} else {
+		n, err = c.write(bytes, false)
 	}
-
-	return syscall.Write(c.fd, bytes)
+	if err == nil && n > 0 {
+		c.addMsgOut()
+	}
+	return n, err
 }
 
 // WriteWithEncoder
 // write with encoder, encode bytes to writer
 func (c *Conn) WriteWithEncoder(bytes []byte) error {
-	return c.server.options.encoder.EncodeToWriter(c, bytes)
+	return c.encoder.EncodeToWriter(c, bytes)
 }
 
 // Close Closes the connection
 func (c *Conn) Close() {
+	c.close()
+}
+
+// close
+// return false if the connection has been closed
+func (c *Conn) close() bool {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return false
+	}
+	c.release()
+
+	if c.tls != nil {
+		c.tls.close()
+	}
+
+	c.lock.Lock()
+	c.wq.release()
+	c.stopTimers()
+	c.lock.Unlock()
+
+	pollerFD := c.pollerFD
+	if c.server.iourings != nil {
+		c.cancelMultishotRead()
+		// connect fd is not in poller, in flight read op holds the socket file,
+		// shutdown to send FIN and complete the read op
+		syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+		pollerFD = -1
+	}
+
 	// Remove from the file descriptor that epoll is listening for
-	err := closeFD(c.pollerFD, c.fd)
+	err := closeFD(pollerFD, c.fd)
 	if err != nil {
 		log.Error(err)
 	}
-	c.CloseConnect()
+	return true
 }
 
+// CloseConnect
+// free connect session without close fd (eg: io_uring read complete event err)
 func (c *Conn) CloseConnect() {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	c.release()
+}
+
+// release
+// remove conn from conns before fd is closed, so a reused fd can't be removed by mistake
+func (c *Conn) release() {
 	// Remove conn from conns
 	c.server.conns.Delete(c.fd)
-	// Return the cache
-	c.server.readBufferPool.Put(c.buffer.buf)
+	// Leave all joined groups
+	c.server.groups.leaveAll(c)
+	// Release admission limits
+	if c.admitted {
+		c.server.limits.release(c.admitIP)
+	}
+	// Return the cache, or drop it after read in progress
+	c.releaseReadBuffer()
 	// Subtract one from the number of connections
 	atomic.AddInt64(&c.server.connsNum, -1)
 }
 
+// IsClosed
+// connect is closed or not
+func (c *Conn) IsClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
 // CloseRead closes connection
 func (c *Conn) CloseRead() error {
 	err := closeFDRead(int(c.fd))
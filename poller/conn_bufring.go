@@ -0,0 +1,235 @@
+package poller
+
+import (
+	"io"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/ii64/gouring"
+)
+
+// newReadBuffer
+// connect read buffer, io_uring provided buffer ring / poll mode hold read buffer lazily
+func (s *Server) newReadBuffer(fd int) *Buffer {
+	if len(s.iourings) != 0 && (s.GetIoUring(fd).bufRing != nil || s.options.ioMode == IOModeUringPoll) {
+		return NewBuffer(nil)
+	}
+	return NewBuffer(s.readBufferPool.Get().([]byte))
+}
+
+// holdReadBuffer
+// get read buffer from pool if not held
+func (c *Conn) holdReadBuffer() {
+	if c.buffer.buf == nil {
+		c.buffer.buf = c.server.readBufferPool.Get().([]byte)
+	}
+}
+
+// dropReadBuffer
+// put read buffer back to pool
+func (c *Conn) dropReadBuffer() {
+	if c.buffer.buf == nil {
+		return
+	}
+	c.server.readBufferPool.Put(c.buffer.buf)
+	c.buffer.buf = nil
+	c.buffer.start = 0
+	c.buffer.end = 0
+}
+
+const (
+	// readReleasedFlag read state flag, connect is released, read buffer is dropped by the last holder
+	readReleasedFlag int32 = 1 << 30
+)
+
+// startRead
+// hold read buffer (reading, or in flight recv op), false if connect is released;
+// read state is holders num with released flag
+func (c *Conn) startRead() bool {
+	for {
+		st := atomic.LoadInt32(&c.readState)
+		if st&readReleasedFlag != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.readState, st, st+1) {
+			return true
+		}
+	}
+}
+
+// finishRead
+// unhold read buffer, drop it if connect is released and no holder left
+func (c *Conn) finishRead() {
+	if atomic.AddInt32(&c.readState, -1) == readReleasedFlag {
+		c.dropReadBuffer()
+	}
+}
+
+// releaseReadBuffer
+// drop read buffer of released connect, or let the last holder drop it
+func (c *Conn) releaseReadBuffer() {
+	for {
+		st := atomic.LoadInt32(&c.readState)
+		if st&readReleasedFlag != 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&c.readState, st, st|readReleasedFlag) {
+			if st == 0 {
+				c.dropReadBuffer()
+			}
+			return
+		}
+	}
+}
+
+// asyncRecv
+// add recv op to connect read buffer, the op holds read buffer until complete;
+// buffer of in flight op of released connect is not put back to pool, the event is dropped
+func (c *Conn) asyncRecv(ring *ioUring) {
+	if !c.startRead() {
+		return
+	}
+	c.holdReadBuffer()
+	err := c.buffer.AsyncReadFromFD(c.fd, ring, c.getReadCallback(), c.finishRead)
+	if err != nil {
+		c.finishRead()
+	}
+}
+
+// getBufRingReadCallback
+// recv complete with buffer selected from provided buffer ring,
+// filter msg then recycle the buffer to ring
+func (c *Conn) getBufRingReadCallback(ring *ioUring) EventCallBack {
+	return func(e *eventInfo) (err error) {
+		defer ring.releaseBuffer(e)
+
+		n := e.cqe.Res
+		if n == -int32(syscall.ENOBUFS) {
+			// all provided buffers are in use, read to connect read buffer this time;
+			// return EAGAIN, read complete event add recv select op again
+			c.asyncRecv(ring)
+			return syscall.EAGAIN
+		}
+		if n == -int32(syscall.ECANCELED) {
+			// multishot recv op canceled, read paused or connect closed
+			return syscall.EAGAIN
+		}
+		if n == -int32(syscall.EINVAL) && ring.multishotRecv {
+			// multishot recv flag is not supported, add one-shot recv select op
+			ring.disableMultishotRecv()
+			atomic.StoreUint64(&c.multishotRead, 0)
+			if !c.IsClosed() {
+				c.AsyncBlockRead()
+			}
+			return syscall.EAGAIN
+		}
+		if n < 0 {
+			return ErrIOUringReadFail
+		}
+		if n == 0 {
+			// peer closed
+			return io.EOF
+		}
+
+		c.addBytesIn(int(n))
+		return c.filterBufRingBytes(ring.bufRing.get(e.bid, int(n)))
+	}
+}
+
+// filterBufRingBytes
+// filter msg from provided buffer bytes directly if nothing buffered,
+// copy the partial frame left to connect read buffer; drop read buffer if drained
+func (c *Conn) filterBufRingBytes(bytes []byte) (err error) {
+	if c.buffer.Len() == 0 {
+		b := NewBuffer(bytes)
+		b.end = len(bytes)
+		err = c.msgFilter(b)
+		if err == nil && b.Len() > 0 && !c.IsClosed() {
+			c.holdReadBuffer()
+			c.buffer.start = 0
+			c.buffer.end = copy(c.buffer.buf, b.buf[b.start:b.end])
+		}
+	} else {
+		c.buffer.reset()
+		if len(bytes) > len(c.buffer.buf)-c.buffer.end {
+			return ErrBufferFull
+		}
+		c.buffer.end += copy(c.buffer.buf[c.buffer.end:], bytes)
+		err = c.msgFilter(c.buffer)
+	}
+
+	if err == nil && c.buffer.Len() == 0 && !c.IsClosed() {
+		c.dropReadBuffer()
+	}
+	return
+}
+
+// asyncPollIn
+// io_uring poll mode, add (multishot) poll in op, read bytes from socket when ready
+func (c *Conn) asyncPollIn(ring *ioUring) {
+	op, err := ring.addPollInSqe(c.fd)
+	if err == nil && ring.multishotPoll {
+		atomic.StoreUint64(&c.multishotRead, uint64(op))
+	}
+}
+
+// processPollInEvent
+// io_uring poll mode, socket is readable, non block read with lazy held read buffer,
+// add poll in op again if multishot poll op is done or not supported
+func (c *Conn) processPollInEvent(e *eventInfo) (err error) {
+	// closed by other goroutine, read buffer is dropped after read
+	if !c.startRead() {
+		return nil
+	}
+	defer c.finishRead()
+
+	ring := c.server.GetIoUring(c.fd)
+	if e.cqe.Res < 0 {
+		if e.cqe.Res == -int32(syscall.ECANCELED) {
+			return nil
+		}
+		if e.cqe.Res == -int32(syscall.EINVAL) && ring.multishotPoll {
+			// multishot poll flag is not supported, add one-shot poll in op
+			ring.disableMultishotPoll()
+			atomic.StoreUint64(&c.multishotRead, 0)
+			if !c.IsClosed() {
+				c.AsyncBlockRead()
+			}
+			return nil
+		}
+		return ErrIOUringReadFail
+	}
+	if c.IsClosed() {
+		return nil
+	}
+	if c.stopReadIfPaused() {
+		if e.hasMore() {
+			c.cancelMultishotRead()
+		}
+		return nil
+	}
+
+	c.holdReadBuffer()
+	err = c.Read()
+	if err != nil || c.IsClosed() {
+		return
+	}
+	if c.buffer.Len() == 0 {
+		c.dropReadBuffer()
+	}
+
+	if !e.hasMore() {
+		c.AsyncBlockRead()
+	}
+	return nil
+}
+
+// cancelMultishotRead
+// cancel in flight multishot recv/poll op, eg: read paused, connect closed
+func (c *Conn) cancelMultishotRead() {
+	op := atomic.SwapUint64(&c.multishotRead, 0)
+	if op == 0 {
+		return
+	}
+	c.server.GetIoUring(c.fd).addCancelSqe(gouring.UserData(op))
+}
@@ -0,0 +1,156 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// newLineConn loopback connect of recorder with line decoder
+func newLineConn(t *testing.T, h poller.Handler, opts ...poller.Option) *pollertest.Conn {
+	opts = append([]poller.Option{poller.WithDecoder(poller.NewLineDecoder(1024))}, opts...)
+	c, err := pollertest.New(h, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestProxyHeaderPartialReads(t *testing.T) {
+	h := &recorder{}
+	c := newLineConn(t, h, poller.WithProxyProtocol())
+	if h.connects != 0 {
+		t.Fatal("OnConnect before PROXY header")
+	}
+
+	header := "PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\n"
+	c.WriteChunks([]byte(header[:20]), 3)
+	if h.connects != 0 {
+		t.Fatal("OnConnect with partial PROXY header")
+	}
+	// header and first line in one read
+	c.WriteChunks([]byte(header[20:]+"hello\n"), 0)
+	conn := c.PollerConn()
+	if h.connects != 1 || fmt.Sprintf("%q", h.msgs) != `["hello"]` {
+		t.Fatalf("connects %d messages %q", h.connects, h.msgs)
+	}
+	if conn.GetAddr() != "203.0.113.7:40000" || conn.ProxyHeader() == nil || conn.ProxyHeader().Version != 1 {
+		t.Fatalf("addr %s header %+v", conn.GetAddr(), conn.ProxyHeader())
+	}
+}
+
+func TestProxyHeaderLocal(t *testing.T) {
+	h := &recorder{}
+	c := newLineConn(t, h, poller.WithProxyProtocol())
+	// v2 LOCAL command without addresses, eg: load balancer health check
+	c.Write([]byte("\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00ping\n"))
+	conn := c.PollerConn()
+	if h.connects != 1 || len(h.msgs) != 1 || conn.ProxyHeader() == nil || !conn.ProxyHeader().Local {
+		t.Fatalf("connects %d messages %q header %+v", h.connects, h.msgs, conn.ProxyHeader())
+	}
+	if conn.GetAddr() != pollertest.Addr {
+		t.Fatalf("LOCAL header replaced addr %s", conn.GetAddr())
+	}
+}
+
+func TestProxyHeaderInvalid(t *testing.T) {
+	h := &recorder{}
+	c := newLineConn(t, h, poller.WithProxyProtocol())
+	c.Write([]byte("GET / HTTP/1.1\r\n"))
+	// OnConnect and OnClose are not called for connect without valid header
+	if !c.PollerConn().IsClosed() || h.connects != 0 || len(h.closes) != 0 || len(h.msgs) != 0 {
+		t.Fatalf("connects %d closes %v messages %q", h.connects, h.closes, h.msgs)
+	}
+}
+
+func TestMsgRateLimitPauseRead(t *testing.T) {
+	h := &recorder{}
+	c := newLineConn(t, h, poller.WithMsgRateLimit(20, 2), poller.WithTimeout(time.Millisecond, time.Hour))
+	conn := c.PollerConn()
+
+	// messages of one read are handled at once, the debt of 2 tokens pauses read 100ms
+	c.Write([]byte("a\nb\nc\nd\n"))
+	if len(h.msgs) != 4 || !conn.IsReadPaused() {
+		t.Fatalf("messages %q paused %v", h.msgs, conn.IsReadPaused())
+	}
+	c.Write([]byte("e\n"))
+	c.ReadEvent()
+	if len(h.msgs) != 4 {
+		t.Fatalf("messages %q read while throttled", h.msgs)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if conn.IsReadPaused() {
+		t.Fatal("read is not resumed after tokens refilled")
+	}
+	c.ReadEvent()
+	if len(h.msgs) != 5 || conn.IsReadPaused() {
+		t.Fatalf("messages %q paused %v after resume", h.msgs, conn.IsReadPaused())
+	}
+}
+
+func TestByteRateLimitPauseRead(t *testing.T) {
+	h := &recorder{}
+	c := newLineConn(t, h, poller.WithByteRateLimit(1000, 100), poller.WithTimeout(time.Millisecond, time.Hour))
+	conn := c.PollerConn()
+
+	// 100 bytes by burst
+	line := append(payloadLine(49), payloadLine(49)...)
+	c.Write(line)
+	if len(h.msgs) != 2 || conn.IsReadPaused() {
+		t.Fatalf("messages %d paused %v", len(h.msgs), conn.IsReadPaused())
+	}
+	c.Write(payloadLine(99))
+	if len(h.msgs) != 3 || !conn.IsReadPaused() {
+		t.Fatalf("messages %d paused %v; want read paused 100ms", len(h.msgs), conn.IsReadPaused())
+	}
+	time.Sleep(150 * time.Millisecond)
+	if conn.IsReadPaused() {
+		t.Fatal("read is not resumed after tokens refilled")
+	}
+}
+
+func TestRateLimitHandlerPauseOverlap(t *testing.T) {
+	h := &recorder{}
+	c := newLineConn(t, h, poller.WithMsgRateLimit(20, 2), poller.WithTimeout(time.Millisecond, time.Hour))
+	conn := c.PollerConn()
+
+	// handler resume doesn't undo the throttle
+	c.Write([]byte("a\nb\nc\nd\n"))
+	conn.PauseRead()
+	conn.ResumeRead()
+	c.Write([]byte("e\n"))
+	c.ReadEvent()
+	if len(h.msgs) != 4 || !conn.IsReadPaused() {
+		t.Fatalf("messages %q paused %v; want throttled after handler resume", h.msgs, conn.IsReadPaused())
+	}
+
+	// throttle resume doesn't undo the handler pause
+	conn.PauseRead()
+	time.Sleep(150 * time.Millisecond)
+	c.ReadEvent()
+	if len(h.msgs) != 4 || !conn.IsReadPaused() {
+		t.Fatalf("messages %q paused %v; want paused by handler after tokens refilled", h.msgs, conn.IsReadPaused())
+	}
+	conn.ResumeRead()
+	c.ReadEvent()
+	if len(h.msgs) != 5 || conn.IsReadPaused() {
+		t.Fatalf("messages %q paused %v after all pauses cleared", h.msgs, conn.IsReadPaused())
+	}
+}
+
+// payloadLine line of n bytes and '\n'
+func payloadLine(n int) []byte {
+	b := make([]byte, n, n+1)
+	for i := range b {
+		b[i] = 'a' + byte(i%26)
+	}
+	return append(b, '\n')
+}
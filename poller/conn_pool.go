@@ -0,0 +1,248 @@
+package poller
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/log"
+)
+
+// ConnPool keyed by dial address connect pool
+// with idle connect eviction and health check
+type ConnPool struct {
+	dialer  Dialer                 // dial out connect (Server/Client)
+	options *poolOptions           // pool parameters
+	lock    sync.Mutex             // lock for idles, actives, closed
+	idles   map[string][]*idleConn // idle connects per address key
+	actives map[string]int         // dialed connect num (idle + in use) per address key
+	closed  bool                   // pool closed flag
+	stop    chan struct{}          // pool close signal
+}
+
+type idleConn struct {
+	c       *Conn
+	putTime time.Time // Time of put back to pool
+}
+
+// poolOptions ConnPool opt config
+type poolOptions struct {
+	maxIdle     int                // max idle connect num per address key
+	maxActive   int                // max dialed connect num per address key, 0 unlimited
+	idleTimeout time.Duration      // idle connect evict timeout
+	checkTicker time.Duration      // idle evict and health check interval
+	healthCheck func(c *Conn) bool // connect health check
+}
+
+type PoolOption interface {
+	apply(*poolOptions)
+}
+
+type funcPoolOption struct {
+	f func(*poolOptions)
+}
+
+func (fpo *funcPoolOption) apply(po *poolOptions) {
+	fpo.f(po)
+}
+
+func newFuncPoolOption(f func(*poolOptions)) *funcPoolOption {
+	return &funcPoolOption{
+		f: f,
+	}
+}
+
+func WithPoolMaxIdle(num int) PoolOption {
+	return newFuncPoolOption(func(o *poolOptions) {
+		if num <= 0 {
+			panic("pool maxIdle must greater than 0")
+		}
+		o.maxIdle = num
+	})
+}
+
+func WithPoolMaxActive(num int) PoolOption {
+	return newFuncPoolOption(func(o *poolOptions) {
+		if num < 0 {
+			panic("pool maxActive must greater than or equal to 0")
+		}
+		o.maxActive = num
+	})
+}
+
+func WithPoolIdleTimeout(checkTicker, idleTimeout time.Duration) PoolOption {
+	return newFuncPoolOption(func(o *poolOptions) {
+		if checkTicker <= 0 {
+			panic("pool checkTicker must greater than 0")
+		}
+		if idleTimeout <= 0 {
+			panic("pool idleTimeout must greater than 0")
+		}
+		o.checkTicker = checkTicker
+		o.idleTimeout = idleTimeout
+	})
+}
+
+func WithPoolHealthCheck(f func(c *Conn) bool) PoolOption {
+	return newFuncPoolOption(func(o *poolOptions) {
+		o.healthCheck = f
+	})
+}
+
+func getPoolOptions(opts ...PoolOption) *poolOptions {
+	options := &poolOptions{
+		maxIdle:     8,
+		maxActive:   0,
+		idleTimeout: 5 * time.Minute,
+		checkTicker: 30 * time.Second,
+		healthCheck: defaultHealthCheck,
+	}
+
+	for _, o := range opts {
+		o.apply(options)
+	}
+	return options
+}
+
+// defaultHealthCheck
+// connect is not closed and socket has no pending error
+func defaultHealthCheck(c *Conn) bool {
+	if c.IsClosed() {
+		return false
+	}
+	soErr, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil || soErr != 0 {
+		return false
+	}
+	return true
+}
+
+// NewConnPool
+// new connect pool, start idle evict and health check goroutine
+func NewConnPool(dialer Dialer, opts ...PoolOption) *ConnPool {
+	p := &ConnPool{
+		dialer:  dialer,
+		options: getPoolOptions(opts...),
+		idles:   map[string][]*idleConn{},
+		actives: map[string]int{},
+		stop:    make(chan struct{}),
+	}
+	go p.checkIdle()
+
+	return p
+}
+
+// Get
+// get a healthy idle connect by address, dial new connect if no idle
+func (p *ConnPool) Get(address string) (*Conn, error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, ErrPoolClosed
+	}
+
+	for idles := p.idles[address]; len(idles) > 0; idles = p.idles[address] {
+		ic := idles[len(idles)-1]
+		p.idles[address] = idles[:len(idles)-1]
+		if time.Since(ic.putTime) < p.options.idleTimeout && p.options.healthCheck(ic.c) {
+			p.lock.Unlock()
+			return ic.c, nil
+		}
+		p.actives[address]--
+		ic.c.Close()
+	}
+
+	if p.options.maxActive > 0 && p.actives[address] >= p.options.maxActive {
+		p.lock.Unlock()
+		return nil, ErrPoolExhausted
+	}
+	p.actives[address]++
+	p.lock.Unlock()
+
+	c, err := p.dialer.Dial(address)
+	if err != nil {
+		p.lock.Lock()
+		p.actives[address]--
+		p.lock.Unlock()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Put
+// put back connect to pool by dial address, closed/unhealthy connect is dropped
+func (p *ConnPool) Put(c *Conn) {
+	address := c.GetAddr()
+
+	p.lock.Lock()
+	if p.closed || len(p.idles[address]) >= p.options.maxIdle || !p.options.healthCheck(c) {
+		p.actives[address]--
+		p.lock.Unlock()
+		c.Close()
+		return
+	}
+	p.idles[address] = append(p.idles[address], &idleConn{c: c, putTime: time.Now()})
+	p.lock.Unlock()
+}
+
+// Close
+// stop idle check, close all idle connects
+func (p *ConnPool) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	idles := p.idles
+	p.idles = map[string][]*idleConn{}
+	p.lock.Unlock()
+
+	close(p.stop)
+	for _, conns := range idles {
+		for _, ic := range conns {
+			ic.c.Close()
+		}
+	}
+}
+
+// checkIdle
+// tick to evict idle timeout and unhealthy connects
+func (p *ConnPool) checkIdle() {
+	ticker := time.NewTicker(p.options.checkTicker)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.evict()
+		}
+	}
+}
+
+func (p *ConnPool) evict() {
+	var evicted []*Conn
+	p.lock.Lock()
+	for address, idles := range p.idles {
+		keep := idles[:0]
+		for _, ic := range idles {
+			if time.Since(ic.putTime) < p.options.idleTimeout && p.options.healthCheck(ic.c) {
+				keep = append(keep, ic)
+				continue
+			}
+			p.actives[address]--
+			evicted = append(evicted, ic.c)
+		}
+		p.idles[address] = keep
+	}
+	p.lock.Unlock()
+
+	for _, c := range evicted {
+		c.Close()
+	}
+	if len(evicted) > 0 {
+		log.Infof("conn pool evict %d idle connects", len(evicted))
+	}
+}
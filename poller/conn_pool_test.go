@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnPoolGetPut(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true))
+	client := startClient(t, newTestHandler(false))
+
+	pool := NewConnPool(client, WithPoolMaxActive(2), WithPoolMaxIdle(1))
+	defer pool.Close()
+
+	c1, err := pool.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pool.Get(addr); err != ErrPoolExhausted {
+		t.Fatalf("Get() err %v; want %v", err, ErrPoolExhausted)
+	}
+
+	// idle connect is reused, connect exceed max idle is closed
+	pool.Put(c1)
+	pool.Put(c2)
+	if !c2.IsClosed() {
+		t.Error("connect exceed max idle is not closed")
+	}
+	c, err := pool.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != c1 {
+		t.Error("idle connect is not reused")
+	}
+
+	// closed connect is dropped, dial new one
+	c.Close()
+	pool.Put(c)
+	if c, err = pool.Get(addr); err != nil || c == c1 {
+		t.Fatalf("Get() = %p, %v; want new connect", c, err)
+	}
+	pool.Put(c)
+
+	pool.Close()
+	if !c.IsClosed() {
+		t.Error("idle connect is not closed by pool Close")
+	}
+	if _, err = pool.Get(addr); err != ErrPoolClosed {
+		t.Fatalf("Get() err %v; want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestConnPoolEvict(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true))
+	client := startClient(t, newTestHandler(false))
+
+	pool := NewConnPool(client, WithPoolIdleTimeout(10*time.Millisecond, 30*time.Millisecond))
+	defer pool.Close()
+
+	c, err := pool.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c)
+	deadline := time.Now().Add(3 * time.Second)
+	for !c.IsClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("idle timeout connect is not evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnPoolHealthCheck(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true))
+	client := startClient(t, newTestHandler(false))
+
+	healthy := true
+	pool := NewConnPool(client, WithPoolHealthCheck(func(c *Conn) bool { return healthy }))
+	defer pool.Close()
+
+	c, err := pool.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c)
+	healthy = false
+	c2, err := pool.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 == c || !c.IsClosed() {
+		t.Fatal("unhealthy idle connect is reused")
+	}
+}
@@ -0,0 +1,155 @@
+package poller
+
+import (
+	"time"
+
+	"github.com/weedge/lib/timingwheel"
+)
+
+const (
+	// timingWheelSize timing wheel buckets num, timeout beyond tick*size is in overflow wheel
+	timingWheelSize = 512
+)
+
+// newTimingWheel
+// connect read/write deadline and idle timeout timers, tick is timeout check precision
+func newTimingWheel(tick time.Duration) *timingwheel.TimingWheel {
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	tw := timingwheel.NewTimingWheel(tick, timingWheelSize)
+	tw.Start()
+	return tw
+}
+
+// SetDeadline
+// set read and write deadline, zero time cancel
+func (c *Conn) SetDeadline(t time.Time) error {
+	err := c.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline
+// close connect with ErrReadTimeout at t, reset by next set, zero time cancel;
+// eg: set in OnConnect/OnMessage for next msg
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	c.lock.Lock()
+	c.readDeadline = t
+	c.readTimer = c.resetTimer(c.readTimer, t, ETypeTimeout)
+	c.lock.Unlock()
+	return nil
+}
+
+// SetWriteDeadline
+// close connect with ErrWriteTimeout at t if written bytes are still queued, zero time cancel
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	c.lock.Lock()
+	c.writeDeadline = t
+	c.writeTimer = c.resetTimer(c.writeTimer, t, ETypeWriteTimeout)
+	c.lock.Unlock()
+	return nil
+}
+
+// SetIdleTimeout
+// close connect with ErrIdleTimeout if no bytes read or written during d, 0 cancel;
+// default is server timeout option
+func (c *Conn) SetIdleTimeout(d time.Duration) error {
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	c.lock.Lock()
+	c.idleTimeout = d
+	var t time.Time
+	if d > 0 {
+		t = time.Now().Add(d)
+	}
+	c.idleTimer = c.resetTimer(c.idleTimer, t, ETypeIdleTimeout)
+	c.lock.Unlock()
+	return nil
+}
+
+// resetTimer
+// stop old timer, add timer to post timeout event at t, hold lock
+func (c *Conn) resetTimer(old *timingwheel.Timer, t time.Time, etype EventType) *timingwheel.Timer {
+	if old != nil {
+		old.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+
+	fd := c.fd
+	return c.server.timingWheel.AfterFunc(time.Until(t), func() {
+		if c.IsClosed() {
+			return
+		}
+		// handle timeout in connect event goroutine
+		c.server.postEvent(&eventInfo{fd: fd, etype: etype})
+	})
+}
+
+// stopTimers
+// stop deadline, idle and rate limit timers when connect closed, hold lock
+func (c *Conn) stopTimers() {
+	for _, t := range []*timingwheel.Timer{c.readTimer, c.writeTimer, c.idleTimer, c.throttleTimer} {
+		if t != nil {
+			t.Stop()
+		}
+	}
+	c.readTimer, c.writeTimer, c.idleTimer, c.throttleTimer = nil, nil, nil, nil
+}
+
+// isTimeoutEvent
+func isTimeoutEvent(etype EventType) bool {
+	return etype == ETypeTimeout || etype == ETypeWriteTimeout || etype == ETypeIdleTimeout
+}
+
+// processTimeoutEvent
+// return timeout err to close connect, nil if not timeout any more:
+// deadline is reset or canceled, write deadline with nothing queued,
+// idle timer of active connect is added again for the left time
+func (c *Conn) processTimeoutEvent(etype EventType) error {
+	lastActive := c.getLastActiveTime()
+	pending := c.HasPendingWrite()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch etype {
+	case ETypeTimeout:
+		if c.expired(&c.readTimer, c.readDeadline, etype) {
+			return ErrReadTimeout
+		}
+	case ETypeWriteTimeout:
+		if c.expired(&c.writeTimer, c.writeDeadline, etype) && pending {
+			return ErrWriteTimeout
+		}
+	case ETypeIdleTimeout:
+		if c.idleTimeout > 0 && c.expired(&c.idleTimer, lastActive.Add(c.idleTimeout), etype) {
+			return ErrIdleTimeout
+		}
+	}
+	return nil
+}
+
+// expired
+// deadline is passed, otherwise add timer again for the left time
+// (eg: deadline reset, bucket of timing wheel expires a tick early), hold lock
+func (c *Conn) expired(timer **timingwheel.Timer, deadline time.Time, etype EventType) bool {
+	if deadline.IsZero() {
+		return false
+	}
+	if time.Now().Before(deadline) {
+		*timer = c.resetTimer(*timer, deadline, etype)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// newTimeoutConn loopback connect with 1ms timing wheel tick
+func newTimeoutConn(t *testing.T, h poller.Handler, timeout time.Duration) *pollertest.Conn {
+	c, err := pollertest.New(h, poller.WithTimeout(time.Millisecond, timeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// expectClosed connect is closed once with err
+func expectClosed(t *testing.T, h *recorder, c *pollertest.Conn, err error) {
+	t.Helper()
+	if !c.PollerConn().IsClosed() || len(h.closes) != 1 || h.closes[0] != err {
+		t.Fatalf("closed %v OnClose %v; want %v", c.PollerConn().IsClosed(), h.closes, err)
+	}
+}
+
+// expectOpen connect is not closed
+func expectOpen(t *testing.T, h *recorder, c *pollertest.Conn) {
+	t.Helper()
+	if c.PollerConn().IsClosed() || len(h.closes) != 0 {
+		t.Fatalf("closed %v OnClose %v; want open", c.PollerConn().IsClosed(), h.closes)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	h := &recorder{}
+	c := newTimeoutConn(t, h, time.Hour)
+
+	c.PollerConn().SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	c.TimerEvents(5 * time.Millisecond)
+	expectOpen(t, h, c)
+	c.TimerEvents(40 * time.Millisecond)
+	expectClosed(t, h, c, poller.ErrReadTimeout)
+}
+
+func TestReadDeadlineReset(t *testing.T) {
+	h := &recorder{}
+	c := newTimeoutConn(t, h, time.Hour)
+	conn := c.PollerConn()
+
+	// extend before expiry, the first deadline doesn't close
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	conn.SetReadDeadline(time.Now().Add(80 * time.Millisecond))
+	c.TimerEvents(40 * time.Millisecond)
+	expectOpen(t, h, c)
+
+	// zero time cancels
+	conn.SetReadDeadline(time.Time{})
+	c.TimerEvents(80 * time.Millisecond)
+	expectOpen(t, h, c)
+
+	// deadline set in OnMessage for next msg
+	h.onMessage = func(c *poller.Conn, bytes []byte) {
+		c.SetReadDeadline(time.Now().Add(30 * time.Millisecond))
+	}
+	c.Write([]byte("a"))
+	c.TimerEvents(15 * time.Millisecond)
+	c.Write([]byte("b"))
+	c.TimerEvents(15 * time.Millisecond)
+	expectOpen(t, h, c)
+	c.TimerEvents(50 * time.Millisecond)
+	expectClosed(t, h, c, poller.ErrReadTimeout)
+}
+
+func TestWriteDeadline(t *testing.T) {
+	t.Run("nothing queued", func(t *testing.T) {
+		h := &recorder{}
+		c := newTimeoutConn(t, h, time.Hour)
+		c.PollerConn().SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+		c.TimerEvents(30 * time.Millisecond)
+		expectOpen(t, h, c)
+	})
+	t.Run("queued bytes of slow peer", func(t *testing.T) {
+		h := &recorder{}
+		c := newTimeoutConn(t, h, time.Hour)
+		c.SetWriteBuffer(4096)
+		c.PollerConn().Write(payload(256 * 1024))
+		if !c.PollerConn().HasPendingWrite() {
+			t.Fatal("bytes are not queued for slow peer")
+		}
+		c.PollerConn().SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+		c.TimerEvents(30 * time.Millisecond)
+		expectClosed(t, h, c, poller.ErrWriteTimeout)
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	t.Run("server timeout option", func(t *testing.T) {
+		h := &recorder{}
+		c := newTimeoutConn(t, h, 30*time.Millisecond)
+
+		// read keeps connect active
+		c.TimerEvents(20 * time.Millisecond)
+		c.Write([]byte("ping"))
+		c.TimerEvents(20 * time.Millisecond)
+		expectOpen(t, h, c)
+		c.TimerEvents(40 * time.Millisecond)
+		expectClosed(t, h, c, poller.ErrIdleTimeout)
+	})
+	t.Run("write keeps active", func(t *testing.T) {
+		h := &recorder{}
+		c := newTimeoutConn(t, h, time.Hour)
+		conn := c.PollerConn()
+		conn.SetIdleTimeout(30 * time.Millisecond)
+
+		c.TimerEvents(20 * time.Millisecond)
+		conn.Write([]byte("pong"))
+		c.TimerEvents(20 * time.Millisecond)
+		expectOpen(t, h, c)
+		c.TimerEvents(40 * time.Millisecond)
+		expectClosed(t, h, c, poller.ErrIdleTimeout)
+	})
+	t.Run("cancel", func(t *testing.T) {
+		h := &recorder{}
+		c := newTimeoutConn(t, h, 10*time.Millisecond)
+		c.PollerConn().SetIdleTimeout(0)
+		c.TimerEvents(30 * time.Millisecond)
+		expectOpen(t, h, c)
+	})
+}
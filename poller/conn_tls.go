@@ -0,0 +1,314 @@
+package poller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// tlsHandshakeTimeout close connect if tls handshake is not done in time
+	tlsHandshakeTimeout = 10 * time.Second
+	// tlsRecordBufferLen read ciphertext bytes from connect fd once, max tls record size
+	tlsRecordBufferLen = 16*1024 + 2048
+)
+
+var tlsRecordBufferPool = &sync.Pool{
+	New: func() interface{} {
+		return make([]byte, tlsRecordBufferLen)
+	},
+}
+
+// errTLSWouldBlock
+// no more ciphertext buffered, temporary net error so tls record layer keeps the partial record
+var errTLSWouldBlock net.Error = &wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (e *wouldBlockError) Error() string   { return "tls transport would block" }
+func (e *wouldBlockError) Timeout() bool   { return true }
+func (e *wouldBlockError) Temporary() bool { return true }
+
+// tlsConn
+// tls session state machine of connect:
+// handshaking: handshake goroutine block read ciphertext fed by io event consumer,
+// handshaked: io event consumer feed ciphertext then decrypt until would block
+type tlsConn struct {
+	conn       *tls.Conn
+	transport  *tlsTransport
+	handshaked int32
+}
+
+// initTLS
+// init tls session over non-blocking connect fd
+func (c *Conn) initTLS(config *tls.Config, isClient bool) {
+	t := &tlsConn{transport: newTLSTransport(c)}
+	if isClient {
+		if config.ServerName == "" && !config.InsecureSkipVerify {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(c.GetAddr())
+		}
+		t.conn = tls.Client(t.transport, config)
+	} else {
+		t.conn = tls.Server(t.transport, config)
+	}
+	c.tls = t
+}
+
+// handshake
+// run tls handshake in its own goroutine, don't block io goroutines;
+// the goroutine blocks until ciphertext is fed by io events, counted by server tlsHandshakes;
+// OnConnect after handshake done, then decrypt application data buffered while handshaking
+func (t *tlsConn) handshake(c *Conn) {
+	defer atomic.AddInt64(c.server.tlsHandshakes, -1)
+	// timer task runs in its own goroutine, unblock handshake by transport err
+	timer := c.server.timingWheel.AfterFunc(tlsHandshakeTimeout, func() {
+		t.transport.closeWithError(ErrTLSHandshakeTimeout)
+	})
+	err := t.conn.Handshake()
+	timer.Stop()
+	if err != nil {
+		log.Warnf("connect fd %d addr %s tls handshake err %s", c.fd, c.GetAddr(), err.Error())
+		c.Close()
+		return
+	}
+
+	t.transport.setNonBlock()
+	c.server.handler.OnConnect(c)
+	atomic.StoreInt32(&t.handshaked, 1)
+	c.server.postEvent(&eventInfo{fd: c.fd, etype: ETypeIn})
+}
+
+// read
+// feed ciphertext from connect fd, decrypt plaintext to connect buffer and filter msg
+func (t *tlsConn) read(c *Conn) error {
+	if atomic.LoadInt32(&t.handshaked) == 1 && c.IsReadPaused() {
+		return nil
+	}
+	err := t.transport.feed()
+	if atomic.LoadInt32(&t.handshaked) == 0 {
+		// handshake goroutine get transport err
+		return nil
+	}
+
+	for {
+		// paused by handler, buffered ciphertext is decrypted after resume
+		if c.IsReadPaused() {
+			return err
+		}
+		n, rerr := c.buffer.ReadFromReader(t.conn)
+		if n > 0 {
+			ferr := c.MsgFilter()
+			if ferr != nil {
+				log.Errorf("msg filter err:%s", ferr.Error())
+				return ferr
+			}
+			if c.IsClosed() {
+				return nil
+			}
+		}
+		if rerr == errTLSWouldBlock {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+		if n == 0 {
+			// no space to read plaintext, frame is larger than buffer
+			return ErrBufferFull
+		}
+	}
+
+	return err
+}
+
+// resumeTLSRead
+// ciphertext fed and plaintext decrypted before pause are not reported by socket readiness,
+// read again by io event after resume
+func (c *Conn) resumeTLSRead() {
+	if atomic.LoadInt32(&c.tls.handshaked) == 0 {
+		return
+	}
+	c.server.postEvent(&eventInfo{fd: c.fd, etype: ETypeIn})
+}
+
+// close
+// send close notify alert if handshake done, wake up handshake goroutine
+func (t *tlsConn) close() {
+	if atomic.LoadInt32(&t.handshaked) == 1 {
+		t.conn.CloseWrite()
+	}
+	t.transport.closeWithError(ErrConnClosed)
+}
+
+// tlsTransport
+// net.Conn of tls record layer over non-blocking connect fd,
+// read from ciphertext buffer fed by io event consumer, write to connect write queue
+type tlsTransport struct {
+	c          *Conn
+	fd         int
+	localAddr  tlsAddr
+	remoteAddr tlsAddr
+	lock       sync.Mutex
+	cond       *sync.Cond
+	in         bytes.Buffer
+	proxy      []byte // ciphertext prefix before PROXY header parsed
+	err        error  // read err after buffered ciphertext consumed
+	nonBlock   bool
+}
+
+func newTLSTransport(c *Conn) *tlsTransport {
+	t := &tlsTransport{c: c, fd: c.fd, remoteAddr: tlsAddr(c.GetAddr())}
+	if sa, err := syscall.Getsockname(c.fd); err == nil {
+		t.localAddr = tlsAddr(getAddr(sa))
+	}
+	t.cond = sync.NewCond(&t.lock)
+	return t
+}
+
+// feed
+// read ciphertext from non-blocking connect fd until EAGAIN
+func (t *tlsTransport) feed() (err error) {
+	buf := tlsRecordBufferPool.Get().([]byte)
+	defer tlsRecordBufferPool.Put(buf)
+	for {
+		n, rerr := syscall.Read(t.fd, buf)
+		if rerr == syscall.EAGAIN {
+			return
+		}
+		if rerr == syscall.EINTR {
+			continue
+		}
+		if rerr == nil && n == 0 {
+			rerr = io.EOF
+		}
+		if rerr != nil {
+			t.closeWithError(rerr)
+			return rerr
+		}
+
+		t.c.addBytesIn(n)
+		b := buf[:n]
+		if t.c.proxyPending {
+			b, err = t.feedProxyHeader(b)
+			if err != nil {
+				t.closeWithError(err)
+				return
+			}
+			if len(b) == 0 {
+				continue
+			}
+		}
+		t.lock.Lock()
+		t.in.Write(b)
+		t.lock.Unlock()
+		t.cond.Broadcast()
+	}
+}
+
+// feedProxyHeader
+// buffer bytes until PROXY header parsed, return tls bytes after header
+func (t *tlsTransport) feedProxyHeader(b []byte) ([]byte, error) {
+	t.proxy = append(t.proxy, b...)
+	h, n, err := parseProxyHeader(t.proxy)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	b = t.proxy[n:]
+	t.proxy = nil
+	t.c.setProxyHeader(h)
+	t.lock.Lock()
+	t.remoteAddr = tlsAddr(t.c.GetAddr())
+	t.lock.Unlock()
+	return b, nil
+}
+
+// setNonBlock
+// after handshake, read returns would block err if no ciphertext buffered
+func (t *tlsTransport) setNonBlock() {
+	t.lock.Lock()
+	t.nonBlock = true
+	t.lock.Unlock()
+}
+
+func (t *tlsTransport) closeWithError(err error) {
+	t.lock.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.lock.Unlock()
+	t.cond.Broadcast()
+}
+
+func (t *tlsTransport) Read(b []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for t.in.Len() == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		if t.nonBlock {
+			return 0, errTLSWouldBlock
+		}
+		t.cond.Wait()
+	}
+	return t.in.Read(b)
+}
+
+func (t *tlsTransport) Write(b []byte) (n int, err error) {
+	if t.c.IsClosed() {
+		// close notify alert when closing, best effort
+		return writeFD(t.fd, b)
+	}
+	return t.c.write(b, false)
+}
+
+func (t *tlsTransport) Close() error {
+	t.closeWithError(ErrConnClosed)
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr { return t.localAddr }
+
+// RemoteAddr
+// real client address after PROXY header parsed
+func (t *tlsTransport) RemoteAddr() net.Addr {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.remoteAddr
+}
+
+func (t *tlsTransport) SetDeadline(time.Time) error      { return nil }
+func (t *tlsTransport) SetReadDeadline(time.Time) error  { return nil }
+func (t *tlsTransport) SetWriteDeadline(time.Time) error { return nil }
+
+// tlsAddr connect peer address
+type tlsAddr string
+
+func (a tlsAddr) Network() string { return "tcp" }
+func (a tlsAddr) String() string  { return string(a) }
+
+// acquireTLSHandshake
+// count handshake of accepted connect fd, close fd and release admission if exceed handshake limit
+func (s *Server) acquireTLSHandshake(cfd int, addr, ip string) bool {
+	n := atomic.AddInt64(s.tlsHandshakes, 1)
+	if limit := s.options.tlsHandshakeLimit; limit <= 0 || n <= int64(limit) {
+		return true
+	}
+
+	atomic.AddInt64(s.tlsHandshakes, -1)
+	atomic.AddInt64(&s.stats.rejected, 1)
+	if s.limits != nil {
+		s.limits.release(ip)
+	}
+	syscall.Close(cfd)
+	log.Warnf("reject connect fd %d addr %s, exceed max tls handshakes %d", cfd, addr, s.options.tlsHandshakeLimit)
+	return false
+}
@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTLSConfig self signed server cert of 127.0.0.1
+func testTLSConfig(t testing.TB) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "poller test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestTLSEcho(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	startServer(t, addr, h, WithTLSConfig(testTLSConfig(t)))
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	recv(t, h.connects)
+
+	data := []byte("hello tls")
+	conn.Write(data)
+	buf := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(data) {
+		t.Fatalf("echo %q; want %q", buf, data)
+	}
+}
+
+func TestTLSHandshakeLimit(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	s := startServer(t, addr, h, WithTLSConfig(testTLSConfig(t)), WithTLSHandshakeLimit(1))
+
+	// handshaking connect holds the only handshake slot
+	held, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for s.GetConnsNum() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("handshaking connect is not accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rejected, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connect exceed handshake limit read err %v; want EOF", err)
+	}
+
+	// slot is released after handshake failed
+	held.Close()
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("handshake slot is not released: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,671 @@
+package poller
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// spliceChunkLen splice file bytes to pipe once, default pipe capacity
+	spliceChunkLen = 64 * 1024
+	// sendfileChunkLen max sendfile bytes once
+	sendfileChunkLen = 1 << 30
+)
+
+// writeChunk
+// queued bytes or file region of SendFile
+type writeChunk struct {
+	buf    []byte // queued bytes not written, copied from Write
+	fileFD int    // dup file fd of SendFile, -1: bytes chunk
+	off    int64  // file offset not written
+	n      int64  // file bytes num not written
+	piped  int    // io_uring spliced file bytes in pipe not sent
+}
+
+// len
+// bytes num of chunk not written
+func (ch *writeChunk) len() int64 {
+	if ch.fileFD < 0 {
+		return int64(len(ch.buf))
+	}
+	return ch.n
+}
+
+// writeQueue
+// bounded outbound bytes and file regions of connect,
+// flushed on EPOLLOUT ready event or io_uring send/splice complete event
+type writeQueue struct {
+	chunks    []*writeChunk
+	size      int   // queued bytes num not written, bounded by write queue len
+	fileSize  int64 // queued file bytes num not written
+	sending   bool  // io_uring send/splice op in flight
+	pollOut   bool  // io_uring poll out op in flight, wait connect writable
+	aboveHigh bool  // reached high watermark, wait drain to low watermark
+	pipe      []int // io_uring splice pipe, create on first file chunk
+}
+
+// push
+// copy and queue bytes, caller can reuse bytes after Write return;
+// shared bytes (eg: broadcast payload) are read only, queued without copy
+func (q *writeQueue) push(bytes []byte, shared bool) {
+	buf := bytes
+	if !shared {
+		buf = make([]byte, len(bytes))
+		copy(buf, bytes)
+	}
+	q.chunks = append(q.chunks, &writeChunk{buf: buf, fileFD: -1})
+	q.size += len(buf)
+}
+
+// pushFile
+// queue file region, own the file fd until written
+func (q *writeQueue) pushFile(fileFD int, off, n int64) {
+	q.chunks = append(q.chunks, &writeChunk{fileFD: fileFD, off: off, n: n})
+	q.fileSize += n
+}
+
+// empty
+// nothing queued
+func (q *writeQueue) empty() bool {
+	return len(q.chunks) == 0
+}
+
+// pending
+// queued bytes and file bytes num not written
+func (q *writeQueue) pending() int64 {
+	return int64(q.size) + q.fileSize
+}
+
+// head
+// the first queued chunk not written
+func (q *writeQueue) head() *writeChunk {
+	return q.chunks[0]
+}
+
+// advance
+// n bytes of head written, pop head if all written
+func (q *writeQueue) advance(n int) {
+	ch := q.chunks[0]
+	if ch.fileFD < 0 {
+		ch.buf = ch.buf[n:]
+		q.size -= n
+	} else {
+		ch.off += int64(n)
+		ch.n -= int64(n)
+		q.fileSize -= int64(n)
+	}
+	if ch.len() > 0 {
+		return
+	}
+
+	if ch.fileFD >= 0 {
+		syscall.Close(ch.fileFD)
+	}
+	q.chunks[0] = nil
+	q.chunks = q.chunks[1:]
+	if len(q.chunks) == 0 {
+		q.chunks = nil
+	}
+}
+
+// release
+// drop queued chunks, close file fds and splice pipe when connect closed
+func (q *writeQueue) release() {
+	for _, ch := range q.chunks {
+		if ch.fileFD >= 0 {
+			syscall.Close(ch.fileFD)
+		}
+	}
+	q.chunks = nil
+	q.size = 0
+	q.fileSize = 0
+	for _, fd := range q.pipe {
+		syscall.Close(fd)
+	}
+	q.pipe = nil
+}
+
+// write
+// write bytes directly if nothing queued, queue the left bytes until fd writable;
+// return ErrWriteQueueFull if queued bytes exceed write queue len, nothing is written;
+// the left bytes of direct write are bounded too: the peer got a partial frame,
+// close the connect (OnClose with ErrWriteQueueFull), return written num with ErrWriteQueueFull;
+// return written num with err if write fd err;
+// shared bytes are not copied when queued, must not be modified
+func (c *Conn) write(bytes []byte, shared bool) (int, error) {
+	if len(bytes) == 0 {
+		return 0, nil
+	}
+	if c.IsClosed() {
+		return 0, ErrConnClosed
+	}
+
+	c.lock.Lock()
+	// io_uring mode queue all bytes
+	direct := c.server.iourings == nil && c.wq.empty()
+	if !direct && c.wq.size+len(bytes) > c.server.options.writeQueueLen {
+		c.lock.Unlock()
+		return 0, ErrWriteQueueFull
+	}
+
+	var err error
+	n := 0
+	if c.server.iourings != nil {
+		c.wq.push(bytes, shared)
+		if !c.wq.sending {
+			c.wq.sending = true
+			err = c.asyncSend()
+		}
+	} else {
+		if direct {
+			n, err = writeFD(c.fd, bytes)
+			if n > 0 {
+				c.lastWriteTime = time.Now()
+				c.addBytesOut(n)
+			}
+		}
+		if err == nil && len(bytes)-n > c.server.options.writeQueueLen {
+			c.lock.Unlock()
+			// the rest of frame is dropped, stream is broken
+			if c.close() {
+				c.server.handler.OnClose(c, ErrWriteQueueFull)
+			}
+			return n, ErrWriteQueueFull
+		}
+		if err == nil && n < len(bytes) {
+			c.wq.push(bytes[n:], shared)
+			if direct {
+				// wait writable
+				err = c.modEvents()
+			}
+		}
+	}
+	high := c.reachHighWatermark()
+	c.lock.Unlock()
+
+	if err != nil {
+		if c.server.iourings != nil {
+			c.closeOnSendErr(err)
+		}
+		return n, err
+	}
+	if high {
+		if h, ok := c.server.handler.(WatermarkHandler); ok {
+			h.OnHighWatermark(c)
+		}
+	}
+
+	return len(bytes), nil
+}
+
+// SendFile
+// zero copy send n bytes of file from offset off after queued bytes,
+// sendfile in epoll mode, splice by pipe in io_uring mode; tls connect copy to Write.
+// file fd is dup, caller can close file after SendFile return;
+// file bytes count to write watermarks, but not bounded by write queue len
+func (c *Conn) SendFile(f *os.File, off, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	if c.tls != nil {
+		// tls record must be encrypted in user space
+		_, err := io.Copy(c, io.NewSectionReader(f, off, n))
+		return err
+	}
+
+	fileFD, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fileFD)
+
+	c.lock.Lock()
+	if c.server.iourings != nil {
+		c.wq.pushFile(fileFD, off, n)
+		if !c.wq.sending {
+			c.wq.sending = true
+			err = c.asyncSend()
+		}
+	} else {
+		var w int
+		empty := c.wq.empty()
+		if empty {
+			w, err = sendFileFD(c.fd, fileFD, off, n)
+			if w > 0 {
+				c.lastWriteTime = time.Now()
+				c.addBytesOut(w)
+			}
+		}
+		if err == nil && int64(w) < n {
+			c.wq.pushFile(fileFD, off+int64(w), n-int64(w))
+			if empty {
+				// wait writable
+				err = c.modEvents()
+			}
+		} else {
+			syscall.Close(fileFD)
+		}
+	}
+	high := c.reachHighWatermark()
+	c.lock.Unlock()
+
+	if err != nil {
+		if c.server.iourings != nil {
+			c.closeOnSendErr(err)
+		}
+		return err
+	}
+	c.addMsgOut()
+	if high {
+		if h, ok := c.server.handler.(WatermarkHandler); ok {
+			h.OnHighWatermark(c)
+		}
+	}
+
+	return nil
+}
+
+// flush
+// write queued chunks on EPOLLOUT until EAGAIN, stop waiting writable if drained
+func (c *Conn) flush() (err error) {
+	c.lock.Lock()
+	for !c.wq.empty() {
+		ch := c.wq.head()
+		want := ch.len()
+		var n int
+		if ch.fileFD < 0 {
+			n, err = writeFD(c.fd, ch.buf)
+		} else {
+			n, err = sendFileFD(c.fd, ch.fileFD, ch.off, ch.n)
+		}
+		if n > 0 {
+			c.lastWriteTime = time.Now()
+			c.addBytesOut(n)
+			c.wq.advance(n)
+		}
+		if err != nil || int64(n) < want {
+			break
+		}
+	}
+	if err == nil && c.wq.empty() {
+		err = c.modEvents()
+	}
+	c.lock.Unlock()
+	if err != nil {
+		return
+	}
+
+	c.afterFlush()
+	return
+}
+
+// asyncSend
+// add io_uring send op (write fixed op if has free fixed buffer) for the head bytes chunk,
+// splice op file -> pipe or pipe -> connect for the head file chunk, hold write lock;
+// op is not added (eg: ring closed): sending is reset, no write complete event
+func (c *Conn) asyncSend() (err error) {
+	ch := c.wq.head()
+	ring := c.server.GetIoUring(c.fd)
+	defer func() {
+		if err != nil {
+			c.wq.sending = false
+		}
+	}()
+	if ch.fileFD < 0 {
+		if ring.fixedBufs != nil {
+			if idx, ok := ring.fixedBufs.get(); ok {
+				n := copy(ring.fixedBufs.buf(idx), ch.buf)
+				err = ring.addWriteFixedSqe(noOpsEventCb, c.fd, idx, n)
+				if err != nil {
+					ring.fixedBufs.put(idx)
+				}
+				return
+			}
+		}
+		buf := ch.buf
+		return ring.addSendSqe(func(e *eventInfo) error {
+			// keep the buffer alive until send complete
+			runtime.KeepAlive(buf)
+			return nil
+		}, c.fd, buf, len(buf), 0)
+	}
+
+	if c.wq.pipe == nil {
+		p := make([]int, 2)
+		if err = syscall.Pipe2(p, syscall.O_CLOEXEC); err != nil {
+			return
+		}
+		c.wq.pipe = p
+	}
+	if ch.piped > 0 {
+		return ring.addSpliceSqe(noOpsEventCb, c.fd, c.wq.pipe[0], -1, c.fd, -1, ch.piped, unix.SPLICE_F_MOVE)
+	}
+	n := ch.n
+	if n > spliceChunkLen {
+		n = spliceChunkLen
+	}
+	return ring.addSpliceSqe(noOpsEventCb, c.fd, ch.fileFD, ch.off, c.wq.pipe[1], -1, int(n), unix.SPLICE_F_MOVE)
+}
+
+// closeOnSendErr
+// io_uring send op is not added or failed, queued chunks can't be sent in order, close connect
+func (c *Conn) closeOnSendErr(err error) {
+	if c.close() {
+		c.server.handler.OnClose(c, err)
+	}
+}
+
+// processWirteEvent
+// io_uring send/splice complete, send the left queued chunks
+func (c *Conn) processWirteEvent(e *eventInfo) (err error) {
+	// bytes in fixed buffer are sent
+	c.server.GetIoUring(c.fd).releaseBuffer(e)
+	err = e.cb(e)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	if c.IsClosed() {
+		// queued chunks released
+		c.wq.sending = false
+		c.lock.Unlock()
+		return
+	}
+	ch := c.wq.head()
+	if e.cqe.Res == -int32(syscall.EAGAIN) && ch.fileFD >= 0 && ch.piped > 0 {
+		// pipe -> non-blocking connect, wait writable then splice again
+		c.wq.pollOut = true
+		err = c.server.GetIoUring(c.fd).addPollOutSqe(noOpsEventCb, c.fd)
+		if err != nil {
+			c.wq.sending = false
+			c.wq.pollOut = false
+		}
+		c.lock.Unlock()
+		return
+	}
+	if e.cqe.Res < 0 {
+		c.wq.sending = false
+		c.wq.pollOut = false
+		c.lock.Unlock()
+		return ErrIOUringWriteFail
+	}
+
+	if c.wq.pollOut {
+		// connect writable
+		c.wq.pollOut = false
+	} else if ch.fileFD >= 0 && ch.piped == 0 {
+		// file -> pipe complete
+		if e.cqe.Res == 0 {
+			c.wq.sending = false
+			c.lock.Unlock()
+			return io.ErrUnexpectedEOF
+		}
+		ch.piped = int(e.cqe.Res)
+	} else {
+		if ch.fileFD >= 0 {
+			ch.piped -= int(e.cqe.Res)
+		}
+		c.lastWriteTime = time.Now()
+		c.addBytesOut(int(e.cqe.Res))
+		c.wq.advance(int(e.cqe.Res))
+	}
+	if !c.wq.empty() {
+		err = c.asyncSend()
+	} else {
+		c.wq.sending = false
+	}
+	c.lock.Unlock()
+	if err != nil {
+		return
+	}
+
+	c.afterFlush()
+	return
+}
+
+// afterFlush
+// notify low watermark, close connect if CloseAfterFlush and drained
+func (c *Conn) afterFlush() {
+	c.lock.Lock()
+	low := c.reachLowWatermark()
+	closing := c.closeAfterFlush && c.wq.empty()
+	c.lock.Unlock()
+
+	if low {
+		if h, ok := c.server.handler.(WatermarkHandler); ok {
+			h.OnLowWatermark(c)
+		}
+	}
+	if closing {
+		c.Close()
+	}
+}
+
+// reachHighWatermark
+// queued bytes reach high watermark first time, hold write lock
+func (c *Conn) reachHighWatermark() bool {
+	if c.wq.aboveHigh || c.wq.pending() < int64(c.server.options.highWatermark) {
+		return false
+	}
+	c.wq.aboveHigh = true
+	return true
+}
+
+// reachLowWatermark
+// queued bytes drain to low watermark after reach high watermark, hold write lock
+func (c *Conn) reachLowWatermark() bool {
+	if !c.wq.aboveHigh || c.wq.pending() > int64(c.server.options.lowWatermark) {
+		return false
+	}
+	c.wq.aboveHigh = false
+	return true
+}
+
+// Flush
+// write queued bytes when connect is writable, event loop flushes on EPOLLOUT ready event;
+// drive by caller if event loops are not running (eg: pollertest), no-op in io_uring mode
+func (c *Conn) Flush() error {
+	if c.server.iourings != nil || c.IsClosed() {
+		return nil
+	}
+	return c.flush()
+}
+
+// modEvents
+// modify poller events by read paused and write queue, hold write lock
+func (c *Conn) modEvents() error {
+	return modEventFD(c.pollerFD, c.fd, c.readPause == 0, !c.wq.empty())
+}
+
+// readPauseReason
+// reason of connect read paused, read is resumed after all reasons are cleared
+type readPauseReason uint8
+
+const (
+	readPauseByHandler  readPauseReason = 1 << iota // PauseRead of handler, eg: OnHighWatermark
+	readPauseByThrottle                             // msg/byte rate limit buckets are empty
+	readPauseByNetConn                              // net.Conn read buffer exceeds max
+)
+
+// PauseRead
+// stop read from connect until ResumeRead, eg: OnHighWatermark of slow client
+func (c *Conn) PauseRead() error {
+	return c.pauseRead(readPauseByHandler)
+}
+
+// ResumeRead
+// resume read from connect, eg: OnLowWatermark; still paused by rate limit until buckets are refilled
+func (c *Conn) ResumeRead() error {
+	return c.resumeRead(readPauseByHandler)
+}
+
+// pauseRead
+// add pause reason, stop read from connect when the first reason is added
+func (c *Conn) pauseRead(reason readPauseReason) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	paused := c.readPause != 0
+	c.readPause |= reason
+	if paused {
+		return nil
+	}
+	if c.server.iourings != nil {
+		// read complete event don't add read op again
+		return nil
+	}
+	return c.modEvents()
+}
+
+// resumeRead
+// clear pause reason, resume read from connect when all reasons are cleared
+func (c *Conn) resumeRead(reason readPauseReason) error {
+	c.lock.Lock()
+	if c.readPause&reason == 0 {
+		c.lock.Unlock()
+		return nil
+	}
+	c.readPause &^= reason
+	if c.readPause != 0 {
+		c.lock.Unlock()
+		return nil
+	}
+	if c.server.iourings == nil {
+		err := c.modEvents()
+		c.lock.Unlock()
+		if c.tls != nil {
+			c.resumeTLSRead()
+		}
+		return err
+	}
+
+	stopped := c.readStopped
+	c.readStopped = false
+	c.lock.Unlock()
+	if stopped {
+		c.AsyncBlockRead()
+	}
+	return nil
+}
+
+// IsReadPaused
+// connect read is paused or not, by handler, rate limit or net.Conn read buffer
+func (c *Conn) IsReadPaused() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.readPause != 0
+}
+
+// stopReadIfPaused
+// io_uring read complete, don't add read op again if paused
+func (c *Conn) stopReadIfPaused() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.readPause != 0 {
+		c.readStopped = true
+	}
+	return c.readPause != 0
+}
+
+// CloseAfterFlush
+// close connect after queued bytes written, eg: response with "Connection: close"
+func (c *Conn) CloseAfterFlush() {
+	c.lock.Lock()
+	if !c.wq.empty() {
+		c.closeAfterFlush = true
+		c.lock.Unlock()
+		return
+	}
+	c.lock.Unlock()
+	c.Close()
+}
+
+// HasPendingWrite
+// connect has queued bytes or file bytes not written
+func (c *Conn) HasPendingWrite() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return !c.wq.empty()
+}
+
+// GetPendingWriteLen
+// connect queued bytes and file bytes num not written
+func (c *Conn) GetPendingWriteLen() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return int(c.wq.pending())
+}
+
+// GetLastWriteTime
+// time of last bytes written to connect
+func (c *Conn) GetLastWriteTime() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lastWriteTime
+}
+
+// writeFD
+// non block write, return written bytes num, EAGAIN is not err
+func writeFD(fd int, bytes []byte) (n int, err error) {
+	for n < len(bytes) {
+		var wn int
+		wn, err = syscall.Write(fd, bytes[n:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return n, nil
+		}
+		if err != nil {
+			return
+		}
+		n += wn
+	}
+	return
+}
+
+// getLastActiveTime
+// the later of last read time and last write time, long transfer to a silent peer is not timeout
+func (c *Conn) getLastActiveTime() time.Time {
+	t := c.GetLastWriteTime()
+	if c.lastReadTime.After(t) {
+		return c.lastReadTime
+	}
+	return t
+}
+
+// sendFileFD
+// non block sendfile n bytes of file from offset off, return written bytes num, EAGAIN is not err
+func sendFileFD(fd, fileFD int, off, n int64) (written int, err error) {
+	for int64(written) < n {
+		count := n - int64(written)
+		if count > sendfileChunkLen {
+			count = sendfileChunkLen
+		}
+		var wn int
+		wn, err = syscall.Sendfile(fd, fileFD, &off, int(count))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return written, nil
+		}
+		if err != nil {
+			return
+		}
+		if wn == 0 {
+			// file is shorter than off+n
+			return written, io.ErrUnexpectedEOF
+		}
+		written += wn
+	}
+	return
+}
@@ -0,0 +1,338 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// watermarkRecorder recorder with watermark backpressure, pause read of slow peer
+type watermarkRecorder struct {
+	recorder
+	highs, lows int
+}
+
+func (h *watermarkRecorder) OnHighWatermark(c *poller.Conn) {
+	h.highs++
+	c.PauseRead()
+}
+
+func (h *watermarkRecorder) OnLowWatermark(c *poller.Conn) {
+	h.lows++
+	c.ResumeRead()
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestWriteQueueSlowPeer(t *testing.T) {
+	resp := payload(256 * 1024)
+	h := &recorder{onMessage: func(c *poller.Conn, bytes []byte) {
+		if _, err := c.Write(resp); err != nil {
+			t.Errorf("Write() err %v", err)
+		}
+	}}
+	c, err := pollertest.New(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	c.Write([]byte("get"))
+	if !c.PollerConn().HasPendingWrite() {
+		t.Fatal("bytes are not queued for slow peer")
+	}
+	// slow peer reads a few bytes, then the rest
+	head, err := c.Recv(1024)
+	if err != nil || len(head) != 1024 {
+		t.Fatalf("Recv(1024) = %d bytes err %v", len(head), err)
+	}
+	rest, err := c.Recv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := append(head, rest...); !bytes.Equal(got, resp) {
+		t.Fatalf("received %d bytes not equal to written %d bytes", len(got), len(resp))
+	}
+	if c.PollerConn().HasPendingWrite() {
+		t.Error("write queue is not drained")
+	}
+}
+
+func TestWriteQueueFull(t *testing.T) {
+	h := &recorder{}
+	c, err := pollertest.New(h, poller.WithWriteQueueLen(64*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	conn := c.PollerConn()
+	chunk := payload(8 * 1024)
+	var written int
+	for i := 0; i < 64; i++ {
+		_, err = conn.Write(chunk)
+		if err != nil {
+			break
+		}
+		written += len(chunk)
+	}
+	if err != poller.ErrWriteQueueFull {
+		t.Fatalf("Write() err %v; want %v", err, poller.ErrWriteQueueFull)
+	}
+
+	// written bytes are kept in order, queue accepts writes after drain
+	out, err := c.Recv(0)
+	if err != nil || len(out) != written {
+		t.Fatalf("Recv(0) = %d bytes err %v; want %d", len(out), err, written)
+	}
+	if _, err = conn.Write(chunk); err != nil {
+		t.Fatalf("Write() after drain err %v", err)
+	}
+}
+
+func TestWriteLeftBytesBounded(t *testing.T) {
+	h := &recorder{}
+	c, err := pollertest.New(h, poller.WithWriteQueueLen(16*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	// the left bytes of the first direct write exceed write queue len
+	resp := payload(1024 * 1024)
+	n, err := c.PollerConn().Write(resp)
+	if err != poller.ErrWriteQueueFull {
+		t.Fatalf("Write() = %d err %v; want %v", n, err, poller.ErrWriteQueueFull)
+	}
+	if n == 0 || n >= len(resp) || c.PollerConn().HasPendingWrite() {
+		t.Fatalf("Write() = %d, pending %v; left bytes must not be queued", n, c.PollerConn().HasPendingWrite())
+	}
+	// partial frame is written, connect is closed
+	if !c.PollerConn().IsClosed() || len(h.closes) != 1 || h.closes[0] != poller.ErrWriteQueueFull {
+		t.Fatalf("closed %v closes %v", c.PollerConn().IsClosed(), h.closes)
+	}
+	if out, err := c.Recv(0); err != nil || !bytes.Equal(out, resp[:n]) {
+		t.Fatalf("Recv(0) = %d bytes err %v; want written %d bytes", len(out), err, n)
+	}
+	if _, err = c.PollerConn().Write(resp[:1]); err != poller.ErrConnClosed {
+		t.Fatalf("Write() after close err %v; want %v", err, poller.ErrConnClosed)
+	}
+}
+
+func TestWriteQueueFullNothingWritten(t *testing.T) {
+	c, err := pollertest.New(&recorder{}, poller.WithWriteQueueLen(16*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	conn := c.PollerConn()
+	var written []byte
+	for !conn.HasPendingWrite() {
+		chunk := payload(4 * 1024)
+		if _, err = conn.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, chunk...)
+	}
+	// queued connect rejects the whole frame, connect is kept
+	if n, err := conn.Write(payload(32 * 1024)); n != 0 || err != poller.ErrWriteQueueFull {
+		t.Fatalf("Write() = %d err %v; want 0 %v", n, err, poller.ErrWriteQueueFull)
+	}
+	if conn.IsClosed() {
+		t.Fatal("connect is closed by rejected write")
+	}
+	if out, err := c.Recv(0); err != nil || !bytes.Equal(out, written) {
+		t.Fatalf("Recv(0) = %d bytes err %v; want %d bytes", len(out), err, len(written))
+	}
+}
+
+// tempFile temp file of content, removed on test cleanup
+func tempFile(t *testing.T, content []byte) *os.File {
+	f, err := ioutil.TempFile("", "poller-sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSendFile(t *testing.T) {
+	content := payload(256 * 1024)
+	f := tempFile(t, content)
+	c, err := pollertest.New(&recorder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// range of file, the whole file
+	conn := c.PollerConn()
+	if err = conn.SendFile(f, 1000, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.SendFile(f, 0, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{}, content[1000:6000]...), content...)
+	if out, err := c.Recv(0); err != nil || !bytes.Equal(out, want) {
+		t.Fatalf("Recv(0) = %d bytes err %v; want %d bytes", len(out), err, len(want))
+	}
+}
+
+func TestSendFileQueuedWithWrite(t *testing.T) {
+	content := payload(256 * 1024)
+	f := tempFile(t, content)
+	c, err := pollertest.New(&recorder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	// file chunk is queued between bytes chunks, sent in write order
+	conn := c.PollerConn()
+	head, tail := []byte("head"), payload(64*1024)
+	conn.Write(payload(64 * 1024))
+	if !conn.HasPendingWrite() {
+		t.Fatal("bytes are not queued for slow peer")
+	}
+	if err = conn.SendFile(f, 0, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	// caller closes file after SendFile return
+	f.Close()
+	conn.Write(head)
+	conn.Write(tail)
+
+	want := append(append(append(payload(64*1024), content...), head...), tail...)
+	if got := conn.GetPendingWriteLen(); got >= len(want) || got == 0 {
+		t.Fatalf("pending %d bytes; want part of %d bytes", got, len(want))
+	}
+	if out, err := c.Recv(0); err != nil || !bytes.Equal(out, want) {
+		t.Fatalf("Recv(0) = %d bytes err %v; want %d bytes", len(out), err, len(want))
+	}
+	if conn.HasPendingWrite() {
+		t.Fatal("write queue is not drained")
+	}
+}
+
+func TestWriteWatermarkPauseRead(t *testing.T) {
+	h := &watermarkRecorder{}
+	resp := payload(64 * 1024)
+	h.onMessage = func(c *poller.Conn, bytes []byte) {
+		c.Write(resp)
+	}
+	c, err := pollertest.New(h, poller.WithWriteWatermark(32*1024, 8*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	c.Write([]byte("a"))
+	if h.highs != 1 || !c.PollerConn().IsReadPaused() {
+		t.Fatalf("high watermark %d paused %v", h.highs, c.PollerConn().IsReadPaused())
+	}
+
+	// paused connect doesn't read requests of the peer
+	c.Write([]byte("b"))
+	if len(h.msgs) != 1 {
+		t.Fatalf("paused connect got %d messages", len(h.msgs))
+	}
+
+	// drain to low watermark resumes read
+	if _, err = c.Recv(len(resp)); err != nil {
+		t.Fatal(err)
+	}
+	if h.lows != 1 || c.PollerConn().IsReadPaused() {
+		t.Fatalf("low watermark %d paused %v", h.lows, c.PollerConn().IsReadPaused())
+	}
+	if err = c.ReadEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.msgs) != 2 || string(h.msgs[1]) != "b" {
+		t.Fatalf("messages after resume %q", h.msgs)
+	}
+}
+
+func TestPauseReadInHandler(t *testing.T) {
+	h := &recorder{}
+	h.onMessage = func(c *poller.Conn, bytes []byte) {
+		c.PauseRead()
+	}
+	c, err := pollertest.New(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("first"))
+	c.Write([]byte("second"))
+	if len(h.msgs) != 1 {
+		t.Fatalf("paused connect got %d messages; want 1", len(h.msgs))
+	}
+
+	c.PollerConn().ResumeRead()
+	if err = c.ReadEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.msgs) != 2 || string(h.msgs[1]) != "second" {
+		t.Fatalf("messages after resume %q", h.msgs)
+	}
+}
+
+func TestConnCloseFaults(t *testing.T) {
+	t.Run("hangup", func(t *testing.T) {
+		h := &recorder{}
+		c, err := pollertest.New(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Hangup()
+		if len(h.closes) != 1 || c.Err() == nil || !c.PollerConn().IsClosed() {
+			t.Fatalf("hangup OnClose %v err %v", h.closes, c.Err())
+		}
+	})
+	t.Run("reset with queued bytes", func(t *testing.T) {
+		h := &recorder{}
+		c, err := pollertest.New(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.SetWriteBuffer(4096)
+		c.PollerConn().Write(payload(64 * 1024))
+		c.Reset()
+		if len(h.closes) != 1 || !c.PollerConn().IsClosed() {
+			t.Fatalf("reset OnClose %v", h.closes)
+		}
+		if _, err = c.PollerConn().Write([]byte("x")); err != poller.ErrConnClosed {
+			t.Fatalf("Write() after close err %v; want %v", err, poller.ErrConnClosed)
+		}
+	})
+}
@@ -3,12 +3,30 @@ package poller
 import (
 	"errors"
 	"io"
+	"syscall"
 	"time"
 )
 
 var (
 	ErrReadTimeout     = errors.New("tcp read timeout")
 	ErrBufferNotEnough = errors.New("buffer not enough")
+	ErrBufferFull      = errors.New("buffer full")
+	ErrDialTimeout     = errors.New("tcp dial timeout")
+	ErrConnClosed      = errors.New("connect closed")
+	ErrPoolExhausted   = errors.New("conn pool exhausted")
+	ErrPoolClosed      = errors.New("conn pool closed")
+	ErrServerStopped   = errors.New("server stopped")
+	ErrServerShutdown  = errors.New("server shutdown")
+	ErrWriteTimeout    = errors.New("tcp write timeout")
+	ErrIdleTimeout     = errors.New("tcp idle timeout")
+	ErrFrameTooLarge   = errors.New("frame too large")
+	ErrCodecNotMatch   = errors.New("codec magic not match")
+	ErrWriteQueueFull  = errors.New("write queue full")
+	ErrReactorListen   = errors.New("reactors need tcp listen address for SO_REUSEPORT")
+	ErrProxyProtocol   = errors.New("invalid proxy protocol header")
+
+	ErrTLSUnsupportedIOMode = errors.New("tls is not supported in io_uring io mode")
+	ErrTLSHandshakeTimeout  = errors.New("tls handshake timeout")
 
 	ErrIOUringFeaturesUnAvailable = errors.New("required IORING_FEAT_SINGLE_MMAP | IORING_FEAT_FAST_POLL | IORING_FEAT_NODROP not available in the kernel")
 	ErrIOUringRegisterFDFail      = errors.New("iouring register fd failed")
@@ -16,8 +34,11 @@ var (
 	ErrIOUringSubmitFail          = errors.New("iouring submit failed")
 	ErrIOUringSubmitedNoFull      = errors.New("iouring submited no full")
 	ErrIOUringWaitCqeFail         = errors.New("iouring wait cqe failed")
+	ErrIOUringClosed              = errors.New("iouring closed")
 	ErrIOUringReadFail            = errors.New("iouring read event op failed")
 	ErrIOUringWriteFail           = errors.New("iouring write event op failed")
+	ErrIOUringSQPollUnsupported   = errors.New("iouring sq poll thread (IORING_SETUP_SQPOLL with IORING_FEAT_SQPOLL_NONFIXED) not available")
+	ErrIOUringAttachWQUnsupported = errors.New("iouring attach async worker queue (IORING_SETUP_ATTACH_WQ) not available")
 )
 
 // Handler Server for biz logic
@@ -27,6 +48,26 @@ type Handler interface {
 	OnClose(c *Conn, err error)
 }
 
+// ShutdownHandler optional Handler hook,
+// notify each connect when server graceful shutdown before drain and close
+type ShutdownHandler interface {
+	OnShutdown(c *Conn)
+}
+
+// WatermarkHandler optional Handler hook for write queue backpressure
+type WatermarkHandler interface {
+	// OnHighWatermark queued bytes reach high watermark, eg: PauseRead the peer of slow client
+	OnHighWatermark(c *Conn)
+	// OnLowWatermark queued bytes drain to low watermark, eg: ResumeRead
+	OnLowWatermark(c *Conn)
+}
+
+// UDPHandler UDP Server for biz logic, dispatch per datagram with peer address
+// notice: bytes is only valid during OnDatagram, reply by UDPServer.WriteTo
+type UDPHandler interface {
+	OnDatagram(s *UDPServer, addr syscall.Sockaddr, bytes []byte)
+}
+
 // Decoder
 type Decoder interface {
 	Decode(buffer *Buffer) ([]byte, error)
@@ -37,11 +78,22 @@ type Encoder interface {
 	EncodeToWriter(w io.Writer, bytes []byte) error
 }
 
+// Dialer dial out connect, register connect fd in event loop
+type Dialer interface {
+	Dial(address string) (*Conn, error)
+}
+
 // defaultTCPKeepAlive is a default constant value for TCPKeepAlive times
 // See golang.org/issue/31510
 const (
 	defaultTCPKeepAlive = 15 * time.Second
 )
 
+// accept retry backoff when out of fd or kernel memory, connects stay in accept queue
+const (
+	acceptRetryMinDelay = 5 * time.Millisecond
+	acceptRetryMaxDelay = time.Second
+)
+
 type Poller interface {
 }
@@ -14,11 +14,13 @@ const (
 	// man epoll_ctl  see EPOLL_EVENTS detail
 	//1 2 8 16 8192 2147483648
 	//EpollReadEvents = unix.EPOLLIN | unix.EPOLLPRI | unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP | unix.EPOLLET
-	EpollReadEvents = unix.EPOLLIN | unix.EPOLLET
+	EpollReadEvents = unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLET
 	EpollPeerClose  = unix.EPOLLIN | unix.EPOLLRDHUP
 	EpollRead       = unix.EPOLLIN
 	EpollErr        = unix.EPOLLIN | unix.EPOLLERR
 	EpollReadErr    = unix.EPOLLIN | unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP
+	// EpollReadReady read until EAGAIN, peer close (EOF) and socket err are got by read
+	EpollReadReady = unix.EPOLLIN | unix.EPOLLRDHUP | unix.EPOLLHUP | unix.EPOLLERR
 )
 
 func createPoller() (pollFD int, err error) {
@@ -38,6 +40,17 @@ func addReadEventFD(pollFD, fd int) (err error) {
 	return
 }
 
+// addWriteEventFD
+// edge triggered write event of non block connecting fd
+func addWriteEventFD(pollFD, fd int) (err error) {
+	err = unix.EpollCtl(pollFD, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
+		Events: unix.EPOLLOUT | unix.EPOLLET,
+		Fd:     int32(fd),
+	})
+
+	return
+}
+
 func delEventFD(pollFD, fd int) error {
 	err := unix.EpollCtl(pollFD, unix.EPOLL_CTL_DEL, fd, nil)
 	if err != nil {
@@ -47,6 +60,23 @@ func delEventFD(pollFD, fd int) error {
 	return nil
 }
 
+// modEventFD
+// modify edge triggered read/write events of fd, eg: wait EPOLLOUT when write queue is not empty
+func modEventFD(pollFD, fd int, read, write bool) error {
+	var events uint32 = unix.EPOLLET
+	if read {
+		events |= unix.EPOLLIN | unix.EPOLLRDHUP
+	}
+	if write {
+		events |= unix.EPOLLOUT
+	}
+
+	return unix.EpollCtl(pollFD, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
+		Events: events,
+		Fd:     int32(fd),
+	})
+}
+
 func getEvents(pollFD int) ([]eventInfo, error) {
 	epollEvents := make([]unix.EpollEvent, 100)
 	n, err := unix.EpollWait(pollFD, epollEvents, -1)
@@ -54,26 +84,20 @@ func getEvents(pollFD int) ([]eventInfo, error) {
 		return nil, err
 	}
 
-	events := make([]eventInfo, 0, len(epollEvents))
+	events := make([]eventInfo, 0, 2*n)
 	for i := 0; i < n; i++ {
-		event := eventInfo{
-			fd: int(epollEvents[i].Fd),
+		ev := epollEvents[i].Events
+		fd := int(epollEvents[i].Fd)
+		if ev&EpollReadReady != 0 { // likely
+			if ev&unix.EPOLLERR != 0 {
+				log.Debugf("epoll wait err event %v", epollEvents[i])
+			}
+			events = append(events, eventInfo{fd: fd, etype: ETypeIn})
+		}
+		if ev&unix.EPOLLOUT != 0 {
+			events = append(events, eventInfo{fd: fd, etype: ETypeOut})
 		}
-		if epollEvents[i].Events == EpollRead { // likely
-			event.etype = ETypeIn
-			events = append(events, event)
-		} else if epollEvents[i].Events == EpollPeerClose { // likely
-			event.etype = ETypeClose
-			events = append(events, event)
-		} else if epollEvents[i].Events == EpollErr { // unlikely
-			log.Errorf("epoll wait err event %v", epollEvents[i])
-			event.etype = ETypeClose
-			events = append(events, event)
-		} else if epollEvents[i].Events == EpollReadErr { // unlikely
-			log.Errorf("epoll wait read err event %v", epollEvents[i])
-			event.etype = ETypeClose
-			events = append(events, event)
-		} else { // unlikely
+		if ev&(EpollReadReady|unix.EPOLLOUT) == 0 { // unlikely
 			log.Errorf("epoll wait other event %v", epollEvents[i])
 		}
 	}
@@ -12,6 +12,7 @@ type eventInfo struct {
 	etype   EventType          // event type
 	bid     uint16             // buff id in pool group
 	gid     uint16             // buff group id
+	fixed   bool               // bid is registered fixed buff index
 	cb      EventCallBack      // callback
 	cqe     gouring.IoUringCqe // iouring complete queue entry for reap event
 	timeOut time.Duration
@@ -24,13 +25,19 @@ const (
 	ETypeUnknow  EventType = iota
 	ETypeIn                // event stream ready to read
 	ETypeClose             // close connect
-	ETypeTimeout           // connect timeout
+	ETypeTimeout           // connect read timeout
 
-	ETypeAccept     // accept event op completed
+	ETypeAccept     // accept event op completed, reactor accept retry
 	ETypeRead       // read event op completed
 	ETypeWrite      // write event op completed
 	ETypeProvidBuff // provide buff ok
 	ETypePollInRead // kenerl poll in event ready
+	ETypeOut        // event stream ready to write
+
+	ETypeWriteTimeout // connect write timeout
+	ETypeIdleTimeout  // connect idle timeout
+
+	ETypeConnect // non block connect writable or dial timeout
 )
 
 var noOpsEventCb = func(info *eventInfo) error { return nil }
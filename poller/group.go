@@ -0,0 +1,181 @@
+package poller
+
+import (
+	"bytes"
+	"sync"
+)
+
+// connGroups
+// named connect groups of server (shared by reactors), connect leaves all groups when closed
+type connGroups struct {
+	lock   sync.RWMutex
+	groups map[string]map[*Conn]struct{}
+	joined map[*Conn]map[string]struct{}
+}
+
+// newConnGroups
+func newConnGroups() *connGroups {
+	return &connGroups{
+		groups: make(map[string]map[*Conn]struct{}),
+		joined: make(map[*Conn]map[string]struct{}),
+	}
+}
+
+// join
+func (g *connGroups) join(group string, c *Conn) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	members, ok := g.groups[group]
+	if !ok {
+		members = make(map[*Conn]struct{})
+		g.groups[group] = members
+	}
+	members[c] = struct{}{}
+
+	names, ok := g.joined[c]
+	if !ok {
+		names = make(map[string]struct{})
+		g.joined[c] = names
+	}
+	names[group] = struct{}{}
+}
+
+// leave
+// remove connect from group, remove empty group
+func (g *connGroups) leave(group string, c *Conn) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.remove(group, c)
+	if names, ok := g.joined[c]; ok {
+		delete(names, group)
+		if len(names) == 0 {
+			delete(g.joined, c)
+		}
+	}
+}
+
+// leaveAll
+// remove connect from all joined groups
+func (g *connGroups) leaveAll(c *Conn) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for group := range g.joined[c] {
+		g.remove(group, c)
+	}
+	delete(g.joined, c)
+}
+
+// remove
+// hold lock
+func (g *connGroups) remove(group string, c *Conn) {
+	members, ok := g.groups[group]
+	if !ok {
+		return
+	}
+	delete(members, c)
+	if len(members) == 0 {
+		delete(g.groups, group)
+	}
+}
+
+// members
+// snapshot of group connects, write to them without lock
+func (g *connGroups) members(group string) []*Conn {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	members := g.groups[group]
+	conns := make([]*Conn, 0, len(members))
+	for c := range members {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// names
+// joined groups of connect
+func (g *connGroups) names(c *Conn) []string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	names := make([]string, 0, len(g.joined[c]))
+	for name := range g.joined[c] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// size
+func (g *connGroups) size(group string) int {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return len(g.groups[group])
+}
+
+// Join
+// join named group to receive Server.Broadcast, leave all groups when connect closed
+func (c *Conn) Join(group string) error {
+	if c.IsClosed() {
+		return ErrConnClosed
+	}
+	c.server.groups.join(group, c)
+	// closed concurrently, release may have left all groups before join
+	if c.IsClosed() {
+		c.server.groups.leave(group, c)
+		return ErrConnClosed
+	}
+	return nil
+}
+
+// Leave
+// leave named group
+func (c *Conn) Leave(group string) {
+	c.server.groups.leave(group, c)
+}
+
+// Groups
+// joined group names
+func (c *Conn) Groups() []string {
+	return c.server.groups.names(c)
+}
+
+// GroupSize
+// connects num of group
+func (s *Server) GroupSize(group string) int {
+	return s.groups.size(group)
+}
+
+// Broadcast
+// write bytes to all connects of group, return written connects num;
+// bytes are shared by write queues of connects without copy (tls connect is encrypted one by one),
+// caller must not modify bytes after Broadcast; slow connect (ErrWriteQueueFull) is skipped
+func (s *Server) Broadcast(group string, bytes []byte) (n int) {
+	if len(bytes) == 0 {
+		return
+	}
+	for _, c := range s.groups.members(group) {
+		var err error
+		if c.tls != nil {
+			_, err = c.Write(bytes)
+		} else {
+			_, err = c.write(bytes, true)
+			if err == nil {
+				c.addMsgOut()
+			}
+		}
+		if err != nil {
+			continue
+		}
+		n++
+	}
+	return
+}
+
+// BroadcastWithEncoder
+// encode bytes once by server encoder option, then Broadcast the encoded frame
+func (s *Server) BroadcastWithEncoder(group string, b []byte) (n int, err error) {
+	var buf bytes.Buffer
+	err = s.options.encoder.EncodeToWriter(&buf, b)
+	if err != nil {
+		return
+	}
+	return s.Broadcast(group, buf.Bytes()), nil
+}
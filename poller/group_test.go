@@ -0,0 +1,133 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// newGroupConns loopback connects of the same poller client
+func newGroupConns(t *testing.T, h poller.Handler, n int, opts ...poller.Option) []*pollertest.Conn {
+	first, err := pollertest.New(h, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := []*pollertest.Conn{first}
+	for i := 1; i < n; i++ {
+		c, err := first.NewConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+	t.Cleanup(func() {
+		for i := len(conns) - 1; i >= 0; i-- {
+			conns[i].Close()
+		}
+	})
+	return conns
+}
+
+func TestBroadcast(t *testing.T) {
+	conns := newGroupConns(t, &recorder{}, 3)
+	client := conns[0].Client()
+	a, b, other := conns[0], conns[1], conns[2]
+	a.PollerConn().Join("room")
+	b.PollerConn().Join("room")
+	b.PollerConn().Join("lobby")
+	if client.GroupSize("room") != 2 {
+		t.Fatalf("room size %d; want 2", client.GroupSize("room"))
+	}
+	groups := b.PollerConn().Groups()
+	sort.Strings(groups)
+	if !reflect.DeepEqual(groups, []string{"lobby", "room"}) {
+		t.Fatalf("groups %q", groups)
+	}
+
+	if n := client.Broadcast("room", []byte("hi")); n != 2 {
+		t.Fatalf("Broadcast() = %d; want 2", n)
+	}
+	for _, c := range []*pollertest.Conn{a, b} {
+		if out, _ := c.Recv(0); string(out) != "hi" {
+			t.Fatalf("member received %q", out)
+		}
+	}
+	if out, _ := other.Recv(0); len(out) != 0 {
+		t.Fatalf("not member received %q", out)
+	}
+
+	b.PollerConn().Leave("room")
+	if n := client.Broadcast("room", []byte("bye")); n != 1 || client.GroupSize("room") != 1 {
+		t.Fatalf("Broadcast() after leave = %d size %d", n, client.GroupSize("room"))
+	}
+	if n := client.Broadcast("nobody", []byte("x")); n != 0 {
+		t.Fatalf("Broadcast() to empty group = %d", n)
+	}
+}
+
+func TestBroadcastWithEncoder(t *testing.T) {
+	conns := newGroupConns(t, &recorder{}, 2, poller.WithEncoder(poller.NewLineEncoder()))
+	for _, c := range conns {
+		c.PollerConn().Join("room")
+	}
+	n, err := conns[0].Client().BroadcastWithEncoder("room", []byte("hello"))
+	if err != nil || n != 2 {
+		t.Fatalf("BroadcastWithEncoder() = %d err %v", n, err)
+	}
+	for _, c := range conns {
+		if out, _ := c.Recv(0); string(out) != "hello\n" {
+			t.Fatalf("member received %q", out)
+		}
+	}
+}
+
+func TestBroadcastSkipSlowMember(t *testing.T) {
+	conns := newGroupConns(t, &recorder{}, 2, poller.WithWriteQueueLen(64*1024))
+	slow, fast := conns[0], conns[1]
+	slow.SetWriteBuffer(4096)
+	for _, c := range conns {
+		c.PollerConn().Join("room")
+	}
+	client := slow.Client()
+
+	// shared payload is queued for the slow member without copy, the next one overflows its queue
+	msg := payload(40 * 1024)
+	if n := client.Broadcast("room", msg); n != 2 {
+		t.Fatalf("Broadcast() = %d; want 2", n)
+	}
+	if out, _ := fast.Recv(0); !bytes.Equal(out, msg) {
+		t.Fatalf("fast member received %d bytes", len(out))
+	}
+	if n := client.Broadcast("room", msg); n != 1 {
+		t.Fatalf("Broadcast() = %d; want 1, slow member is skipped", n)
+	}
+	if out, _ := fast.Recv(0); !bytes.Equal(out, msg) {
+		t.Fatalf("fast member received %d bytes", len(out))
+	}
+	if out, _ := slow.Recv(0); !bytes.Equal(out, msg) {
+		t.Fatalf("slow member received %d bytes; want one message", len(out))
+	}
+}
+
+func TestGroupLeaveOnClose(t *testing.T) {
+	conns := newGroupConns(t, &recorder{}, 2)
+	client := conns[0].Client()
+	c := conns[1]
+	c.PollerConn().Join("a")
+	c.PollerConn().Join("b")
+
+	c.Hangup()
+	if client.GroupSize("a") != 0 || client.GroupSize("b") != 0 {
+		t.Fatalf("closed connect is in groups a %d b %d", client.GroupSize("a"), client.GroupSize("b"))
+	}
+	if err := c.PollerConn().Join("a"); err != poller.ErrConnClosed {
+		t.Fatalf("Join() after close err %v; want %v", err, poller.ErrConnClosed)
+	}
+}
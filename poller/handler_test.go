@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"github.com/weedge/lib/poller"
+	"google.golang.org/protobuf/proto"
+)
+
+// recorder handler records callbacks of loopback connect stepped by pollertest
+type recorder struct {
+	connects  int
+	msgs      [][]byte
+	pbMsgs    []proto.Message
+	closes    []error
+	onMessage func(c *poller.Conn, bytes []byte)
+}
+
+func (h *recorder) OnConnect(c *poller.Conn) {
+	h.connects++
+}
+
+func (h *recorder) OnMessage(c *poller.Conn, bytes []byte) {
+	h.msgs = append(h.msgs, append([]byte{}, bytes...))
+	if h.onMessage != nil {
+		h.onMessage(c, bytes)
+	}
+}
+
+func (h *recorder) OnClose(c *poller.Conn, err error) {
+	h.closes = append(h.closes, err)
+}
+
+// pbRecorder recorder with OnPBMessage
+type pbRecorder struct {
+	recorder
+}
+
+func (h *pbRecorder) OnPBMessage(c *poller.Conn, msg proto.Message) {
+	h.pbMsgs = append(h.pbMsgs, msg)
+}
@@ -5,8 +5,75 @@ package poller
 
 import (
 	"syscall"
-	_ "unsafe"
+	"unsafe"
 )
 
-//go:linkname anyToSockaddr syscall.anyToSockaddr
-func anyToSockaddr(rsa *syscall.RawSockaddrAny) (syscall.Sockaddr, error)
+// anyToSockaddr
+// convert raw socket address (accept/recvmsg/recvmmsg) to syscall.Sockaddr
+func anyToSockaddr(rsa *syscall.RawSockaddrAny) (syscall.Sockaddr, error) {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := new(syscall.SockaddrInet4)
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		sa.Addr = pp.Addr
+		return sa, nil
+
+	case syscall.AF_INET6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := new(syscall.SockaddrInet6)
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		sa.ZoneId = pp.Scope_id
+		sa.Addr = pp.Addr
+		return sa, nil
+
+	case syscall.AF_UNIX:
+		pp := (*syscall.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		sa := new(syscall.SockaddrUnix)
+		path := make([]byte, 0, len(pp.Path))
+		for i := 0; i < len(pp.Path); i++ {
+			// abstract unix domain socket, rewrite leading NUL as @ for textual display
+			if i == 0 && pp.Path[0] == 0 && pp.Path[1] != 0 {
+				path = append(path, '@')
+				continue
+			}
+			if pp.Path[i] == 0 {
+				break
+			}
+			path = append(path, byte(pp.Path[i]))
+		}
+		sa.Name = string(path)
+		return sa, nil
+	}
+
+	return nil, syscall.EAFNOSUPPORT
+}
+
+// sockaddrToAny
+// convert syscall.Sockaddr to raw socket address (sendmsg/sendmmsg), return raw address len
+func sockaddrToAny(sa syscall.Sockaddr, rsa *syscall.RawSockaddrAny) (uint32, error) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		pp.Family = syscall.AF_INET
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0] = byte(sa.Port >> 8)
+		p[1] = byte(sa.Port)
+		pp.Addr = sa.Addr
+		return syscall.SizeofSockaddrInet4, nil
+
+	case *syscall.SockaddrInet6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		pp.Family = syscall.AF_INET6
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0] = byte(sa.Port >> 8)
+		p[1] = byte(sa.Port)
+		pp.Scope_id = sa.ZoneId
+		pp.Addr = sa.Addr
+		return syscall.SizeofSockaddrInet6, nil
+	}
+
+	return 0, syscall.EAFNOSUPPORT
+}
@@ -0,0 +1,309 @@
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/weedge/lib/poller"
+)
+
+var (
+	ErrBadRequest          = errors.New("http1: bad request")
+	ErrHeaderTooLarge      = errors.New("http1: request header too large")
+	ErrBodyTooLarge        = errors.New("http1: request body too large")
+	ErrUnsupportedEncoding = errors.New("http1: unsupported transfer encoding")
+)
+
+var crlf = []byte("\r\n")
+
+type parseState int
+
+const (
+	stateHeader       parseState = iota // request line and headers
+	stateBody                           // content-length body
+	stateChunkSize                      // chunk size line
+	stateChunkData                      // chunk data and CRLF
+	stateChunkTrailer                   // trailer headers until empty line
+	stateDone                           // request is complete
+)
+
+// Parser
+// incremental HTTP/1.x request parser on poller.Buffer, one parser per connect;
+// parsed state is kept between reads, bytes of the request are not consumed until it is complete,
+// pipelined requests are parsed one by one.
+// Parser is poller.Decoder, Decode returns the raw bytes of the complete request,
+// then get the parsed request by Request.
+// notice: the whole request must fit in the connect read buffer (WithReadBufferLen)
+type Parser struct {
+	maxHeaderLen int
+	maxBodyLen   int
+
+	state     parseState
+	offset    int // parsed bytes of unread buffer bytes
+	scanned   int // scanned bytes to find header end
+	chunkSize int
+	req       *Request
+	err       error
+}
+
+// NewParser
+// Creates a request parser with max header and body length
+func NewParser(maxHeaderLen, maxBodyLen int) *Parser {
+	if maxHeaderLen <= 0 || maxBodyLen < 0 {
+		panic("maxHeaderLen must greater than 0, maxBodyLen must not less than 0")
+	}
+	return &Parser{maxHeaderLen: maxHeaderLen, maxBodyLen: maxBodyLen}
+}
+
+// Request
+// get the last complete request, nil if none
+func (p *Parser) Request() *Request {
+	return p.req
+}
+
+// Err
+// get the parse error, the connect should response error status and close
+func (p *Parser) Err() error {
+	return p.err
+}
+
+// Decode
+// parse buffered bytes, return the raw bytes of one complete request, empty if not complete;
+// on parse error, all buffered bytes are discarded and returned, the error is got by Err
+func (p *Parser) Decode(buffer *poller.Buffer) (value []byte, err error) {
+	value = []byte{}
+	if p.err != nil || buffer.Len() == 0 {
+		return
+	}
+	if p.state == stateDone {
+		p.reset()
+	}
+
+	buf, _ := buffer.Seek(buffer.Len())
+	p.err = p.parse(buf)
+	if p.err != nil {
+		p.req = nil
+		return buffer.Read(0, buffer.Len())
+	}
+	if p.state != stateDone {
+		return
+	}
+
+	return buffer.Read(0, p.offset)
+}
+
+// reset
+// reset state for the next pipelined request
+func (p *Parser) reset() {
+	p.state = stateHeader
+	p.offset = 0
+	p.scanned = 0
+	p.chunkSize = 0
+	p.req = nil
+}
+
+// parse
+// continue to parse from the last state until request is complete or need more bytes
+func (p *Parser) parse(buf []byte) (err error) {
+	for {
+		switch p.state {
+		case stateHeader:
+			if !p.parseHeader(buf) {
+				if len(buf) > p.maxHeaderLen {
+					return ErrHeaderTooLarge
+				}
+				return
+			}
+			err = p.startBody()
+			if err != nil {
+				return
+			}
+
+		case stateBody:
+			n := int(p.req.ContentLength)
+			if len(buf)-p.offset < n {
+				return
+			}
+			p.req.Body = append([]byte(nil), buf[p.offset:p.offset+n]...)
+			p.offset += n
+			p.state = stateDone
+
+		case stateChunkSize:
+			i := bytes.Index(buf[p.offset:], crlf)
+			if i < 0 {
+				if len(buf)-p.offset > 1024 {
+					return ErrBadRequest
+				}
+				return
+			}
+			line := string(buf[p.offset : p.offset+i])
+			if j := strings.IndexByte(line, ';'); j >= 0 {
+				line = line[:j]
+			}
+			size, perr := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+			if perr != nil || size < 0 {
+				return ErrBadRequest
+			}
+			// compare before add, huge size overflows
+			if size > int64(p.maxBodyLen-len(p.req.Body)) {
+				return ErrBodyTooLarge
+			}
+			p.offset += i + 2
+			p.chunkSize = int(size)
+			p.state = stateChunkData
+			if size == 0 {
+				p.state = stateChunkTrailer
+			}
+
+		case stateChunkData:
+			if len(buf)-p.offset-2 < p.chunkSize {
+				return
+			}
+			end := p.offset + p.chunkSize
+			if !bytes.Equal(buf[end:end+2], crlf) {
+				return ErrBadRequest
+			}
+			p.req.Body = append(p.req.Body, buf[p.offset:end]...)
+			p.offset = end + 2
+			p.state = stateChunkSize
+
+		case stateChunkTrailer:
+			i := bytes.Index(buf[p.offset:], crlf)
+			if i < 0 {
+				if len(buf)-p.offset > p.maxHeaderLen {
+					return ErrHeaderTooLarge
+				}
+				return
+			}
+			line := buf[p.offset : p.offset+i]
+			p.offset += i + 2
+			if len(line) == 0 {
+				p.state = stateDone
+				continue
+			}
+			err = addHeaderLine(p.req.Header, line)
+			if err != nil {
+				return
+			}
+
+		case stateDone:
+			return
+		}
+	}
+}
+
+// parseHeader
+// find header end from the last scanned position, parse request line and headers
+func (p *Parser) parseHeader(buf []byte) bool {
+	start := p.scanned - 3
+	if start < 0 {
+		start = 0
+	}
+	i := bytes.Index(buf[start:], []byte("\r\n\r\n"))
+	if i < 0 {
+		p.scanned = len(buf)
+		return false
+	}
+	end := start + i
+	if end > p.maxHeaderLen {
+		p.err = ErrHeaderTooLarge
+		return true
+	}
+
+	p.req, p.err = parseRequestHeader(buf[:end])
+	p.offset = end + 4
+	return true
+}
+
+// startBody
+// body framing by Transfer-Encoding: chunked or Content-Length
+func (p *Parser) startBody() error {
+	if p.err != nil {
+		return p.err
+	}
+	req := p.req
+
+	if te := req.Header.Get("Transfer-Encoding"); te != "" {
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return ErrUnsupportedEncoding
+		}
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+		p.state = stateChunkSize
+		return nil
+	}
+
+	if cl := req.Header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+		if err != nil || n < 0 {
+			return ErrBadRequest
+		}
+		if n > int64(p.maxBodyLen) {
+			return ErrBodyTooLarge
+		}
+		req.ContentLength = n
+		p.state = stateBody
+		return nil
+	}
+
+	p.state = stateDone
+	return nil
+}
+
+// parseRequestHeader
+// parse request line and header lines
+func parseRequestHeader(b []byte) (req *Request, err error) {
+	lines := bytes.Split(b, crlf)
+	parts := strings.Split(string(lines[0]), " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return nil, ErrBadRequest
+	}
+
+	req = &Request{
+		Method:     parts[0],
+		RequestURI: parts[1],
+		Proto:      parts[2],
+		Header:     make(http.Header),
+	}
+	var ok bool
+	req.ProtoMajor, req.ProtoMinor, ok = http.ParseHTTPVersion(req.Proto)
+	if !ok || req.ProtoMajor != 1 {
+		return nil, ErrBadRequest
+	}
+	req.URL, err = url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		return nil, ErrBadRequest
+	}
+
+	for _, line := range lines[1:] {
+		err = addHeaderLine(req.Header, line)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req.Host = req.Header.Get("Host")
+	if req.ProtoMinor == 1 && req.Host == "" {
+		return nil, ErrBadRequest
+	}
+	req.Close = req.wantsClose()
+
+	return
+}
+
+// addHeaderLine
+// add "Key: value" header line
+func addHeaderLine(h http.Header, line []byte) error {
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 {
+		return ErrBadRequest
+	}
+	key := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(line[:i])))
+	h.Add(key, string(bytes.TrimSpace(line[i+1:])))
+	return nil
+}
@@ -0,0 +1,204 @@
+package http1
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+)
+
+// decodeAll feed bytes to buffer by chunks of size (0: all at once), decode requests after each feed
+func decodeAll(p *Parser, b []byte, size int) (reqs []*Request, err error) {
+	if size <= 0 {
+		size = len(b)
+	}
+	buffer := poller.NewBuffer(make([]byte, 64*1024))
+	for len(b) > 0 {
+		n := size
+		if n > len(b) {
+			n = len(b)
+		}
+		buffer.ReadFromReader(bytes.NewReader(b[:n]))
+		b = b[n:]
+		for buffer.Len() > 0 {
+			l := buffer.Len()
+			p.Decode(buffer)
+			if p.Err() != nil {
+				return reqs, p.Err()
+			}
+			if buffer.Len() == l {
+				break
+			}
+			reqs = append(reqs, p.Request())
+		}
+	}
+	return
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		bodies []string
+		err    error
+	}{
+		{
+			name:   "get",
+			input:  "GET /metrics?x=1 HTTP/1.1\r\nHost: a\r\n\r\n",
+			bodies: []string{""},
+		},
+		{
+			name:   "content length",
+			input:  "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello",
+			bodies: []string{"hello"},
+		},
+		{
+			name:   "chunked with extension and trailer",
+			input:  "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: 1\r\n\r\n",
+			bodies: []string{"hello world"},
+		},
+		{
+			name: "pipelined",
+			input: "GET /a HTTP/1.1\r\nHost: a\r\n\r\n" +
+				"POST /b HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\n\r\nx" +
+				"POST /c HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\ny\r\n0\r\n\r\n",
+			bodies: []string{"", "x", "y"},
+		},
+		{
+			name:   "http/1.0 without host",
+			input:  "GET / HTTP/1.0\r\n\r\n",
+			bodies: []string{""},
+		},
+		{
+			name:  "missing host",
+			input: "GET / HTTP/1.1\r\n\r\n",
+			err:   ErrBadRequest,
+		},
+		{
+			name:  "bad request line",
+			input: "GET /\r\nHost: a\r\n\r\n",
+			err:   ErrBadRequest,
+		},
+		{
+			name:  "bad header line",
+			input: "GET / HTTP/1.1\r\nHost a\r\n\r\n",
+			err:   ErrBadRequest,
+		},
+		{
+			name:  "unsupported transfer encoding",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip\r\n\r\n",
+			err:   ErrUnsupportedEncoding,
+		},
+		{
+			name:  "content length too large",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1025\r\n\r\n",
+			err:   ErrBodyTooLarge,
+		},
+		{
+			name:  "negative content length",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n",
+			err:   ErrBadRequest,
+		},
+		{
+			name:  "header too large",
+			input: "GET / HTTP/1.1\r\nHost: a\r\nX: " + strings.Repeat("x", 512) + "\r\n\r\n",
+			err:   ErrHeaderTooLarge,
+		},
+		{
+			name:  "chunk size too large",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n401\r\n",
+			err:   ErrBodyTooLarge,
+		},
+		{
+			name:  "chunk sizes sum too large",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n200\r\n" + strings.Repeat("x", 512) + "\r\n201\r\n",
+			err:   ErrBodyTooLarge,
+		},
+		{
+			name:  "chunk size overflows int64 add",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nx\r\n7fffffffffffffff\r\n",
+			err:   ErrBodyTooLarge,
+		},
+		{
+			name:  "chunk size overflows int64",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffffff\r\n",
+			err:   ErrBadRequest,
+		},
+		{
+			name:  "negative chunk size",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\n",
+			err:   ErrBadRequest,
+		},
+		{
+			name:  "chunk data without crlf",
+			input: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nxyz\r\n",
+			err:   ErrBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		// whole input, split reads of 1 and 7 bytes
+		for _, size := range []int{0, 1, 7} {
+			reqs, err := decodeAll(NewParser(256, 1024), []byte(tt.input), size)
+			if err != tt.err {
+				t.Errorf("%s: read by %d err %v; want %v", tt.name, size, err, tt.err)
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			if len(reqs) != len(tt.bodies) {
+				t.Errorf("%s: read by %d got %d requests; want %d", tt.name, size, len(reqs), len(tt.bodies))
+				continue
+			}
+			for i, req := range reqs {
+				if string(req.Body) != tt.bodies[i] {
+					t.Errorf("%s: read by %d request %d body %q; want %q", tt.name, size, i, req.Body, tt.bodies[i])
+				}
+			}
+		}
+	}
+}
+
+func TestParserRequest(t *testing.T) {
+	input := "POST /upload?id=1 HTTP/1.1\r\nHost: example.com\r\ncontent-type: text/plain\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n" +
+		"3\r\nabc\r\n0\r\nX-Checksum: 9\r\n\r\n"
+	reqs, err := decodeAll(NewParser(1024, 1024), []byte(input), 0)
+	if err != nil || len(reqs) != 1 {
+		t.Fatalf("decode %d requests err %v", len(reqs), err)
+	}
+	req := reqs[0]
+	if req.Method != "POST" || req.RequestURI != "/upload?id=1" || req.URL.Query().Get("id") != "1" {
+		t.Errorf("request line %s %s", req.Method, req.RequestURI)
+	}
+	if req.Host != "example.com" || req.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("headers %v", req.Header)
+	}
+	if req.ContentLength != -1 || req.Header.Get("X-Checksum") != "9" {
+		t.Errorf("chunked content length %d trailer %v", req.ContentLength, req.Header)
+	}
+	if !req.Close {
+		t.Error("Connection: close is not set")
+	}
+}
+
+func TestParserKeepAlive(t *testing.T) {
+	tests := []struct {
+		input string
+		close bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", false},
+		{"GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n", true},
+		{"GET / HTTP/1.0\r\n\r\n", true},
+		{"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", false},
+	}
+	for _, tt := range tests {
+		reqs, err := decodeAll(NewParser(1024, 0), []byte(tt.input), 0)
+		if err != nil || len(reqs) != 1 {
+			t.Fatalf("%q: decode %d requests err %v", tt.input, len(reqs), err)
+		}
+		if reqs[0].Close != tt.close {
+			t.Errorf("%q: Close %v; want %v", tt.input, reqs[0].Close, tt.close)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package http1
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request HTTP/1.x request parsed from connect buffer
+type Request struct {
+	Method        string      // GET, POST ...
+	RequestURI    string      // unmodified request target of request line
+	URL           *url.URL    // parsed request target
+	Proto         string      // "HTTP/1.1"
+	ProtoMajor    int         // 1
+	ProtoMinor    int         // 0 or 1
+	Header        http.Header // canonical header key, chunked trailer is added too
+	Host          string      // Host header
+	ContentLength int64       // body length, -1 if chunked
+	Body          []byte      // body bytes, chunked body is decoded
+	Close         bool        // close connect after response
+	RemoteAddr    string      // peer address
+}
+
+// wantsClose
+// HTTP/1.1 keep-alive by default unless "Connection: close",
+// HTTP/1.0 close by default unless "Connection: keep-alive"
+func (r *Request) wantsClose() bool {
+	conn := strings.ToLower(r.Header.Get("Connection"))
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 {
+		return !strings.Contains(conn, "keep-alive")
+	}
+	return strings.Contains(conn, "close")
+}
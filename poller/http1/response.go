@@ -0,0 +1,107 @@
+package http1
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ResponseWriter
+// same as net/http ResponseWriter, response is buffered and written when handler returns
+type ResponseWriter interface {
+	Header() http.Header
+	Write([]byte) (int, error)
+	WriteHeader(statusCode int)
+}
+
+// response
+// buffered response of one request
+type response struct {
+	req         *Request
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponse(req *Request) *response {
+	return &response{req: req, header: make(http.Header)}
+}
+
+func (w *response) Header() http.Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+}
+
+func (w *response) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if !bodyAllowed(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	return w.body.Write(b)
+}
+
+// bytes
+// status line, headers and body of response
+func (w *response) bytes() []byte {
+	w.WriteHeader(http.StatusOK)
+
+	h := w.header
+	if w.req.Close {
+		h.Set("Connection", "close")
+	} else if w.req.ProtoMinor == 0 {
+		h.Set("Connection", "keep-alive")
+	}
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if bodyAllowed(w.status) {
+		if h.Get("Content-Type") == "" && w.body.Len() > 0 {
+			h.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+		}
+		h.Set("Content-Length", strconv.Itoa(w.body.Len()))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.%d %03d %s\r\n", w.req.ProtoMinor, w.status, http.StatusText(w.status))
+	h.Write(&buf)
+	buf.WriteString("\r\n")
+	if w.req.Method != http.MethodHead {
+		buf.Write(w.body.Bytes())
+	}
+
+	return buf.Bytes()
+}
+
+// bodyAllowed 1xx, 204, 304 response has no body
+func bodyAllowed(status int) bool {
+	if status >= 100 && status <= 199 {
+		return false
+	}
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// errorResponse
+// response for parse error, then close connect
+func errorResponse(err error) []byte {
+	status := http.StatusBadRequest
+	switch err {
+	case ErrHeaderTooLarge:
+		status = http.StatusRequestHeaderFieldsTooLarge
+	case ErrBodyTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case ErrUnsupportedEncoding:
+		status = http.StatusNotImplemented
+	}
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n",
+		status, http.StatusText(status)))
+}
@@ -0,0 +1,154 @@
+package http1
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/poller"
+)
+
+const (
+	defaultMaxHeaderLen = 8 * 1024
+	defaultMaxBodyLen   = 1024 * 1024
+)
+
+// Handler http handler like net/http Handler, run on poller io goroutine, don't block
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapter to use ordinary function as Handler
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeHTTP calls f(w, r)
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// FromHTTPHandler
+// run net/http Handler (eg: promhttp metrics handler) as Handler
+func FromHTTPHandler(h http.Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		req, err := http.NewRequest(r.Method, r.RequestURI, bytes.NewReader(r.Body))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.RequestURI = r.RequestURI
+		req.Proto, req.ProtoMajor, req.ProtoMinor = r.Proto, r.ProtoMajor, r.ProtoMinor
+		req.Header = r.Header
+		req.Host = r.Host
+		req.RemoteAddr = r.RemoteAddr
+		req.Close = r.Close
+		req.ContentLength = int64(len(r.Body))
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Adapter
+// poller.Handler to serve HTTP/1.x requests by Handler,
+// keep-alive and pipelined requests are served in order.
+// notice: connect data (Conn.SetData) is used by adapter for request parser
+type Adapter struct {
+	handler      Handler
+	maxHeaderLen int
+	maxBodyLen   int
+}
+
+// AdapterOption Adapter opt config
+type AdapterOption func(a *Adapter)
+
+// WithMaxHeaderLen max request line and headers length, default 8KB
+func WithMaxHeaderLen(n int) AdapterOption {
+	return func(a *Adapter) {
+		if n <= 0 {
+			panic("max header len must greater than 0")
+		}
+		a.maxHeaderLen = n
+	}
+}
+
+// WithMaxBodyLen max request body length, default 1MB
+func WithMaxBodyLen(n int) AdapterOption {
+	return func(a *Adapter) {
+		if n < 0 {
+			panic("max body len must not less than 0")
+		}
+		a.maxBodyLen = n
+	}
+}
+
+// NewAdapter
+// Creates poller.Handler serving HTTP/1.x by handler
+func NewAdapter(handler Handler, opts ...AdapterOption) *Adapter {
+	a := &Adapter{
+		handler:      handler,
+		maxHeaderLen: defaultMaxHeaderLen,
+		maxBodyLen:   defaultMaxBodyLen,
+	}
+	for _, o := range opts {
+		o(a)
+	}
+	return a
+}
+
+// NewServer
+// Creates poller server serving HTTP/1.x on address by adapter (NewAdapter with AdapterOption),
+// read buffer len default is max header len + max body len of adapter, it's allocated per connect,
+// lower WithMaxBodyLen for many connects
+func NewServer(address string, a *Adapter, opts ...poller.Option) (*poller.Server, error) {
+	opts = append([]poller.Option{poller.WithReadBufferLen(a.maxHeaderLen + a.maxBodyLen)}, opts...)
+	return poller.NewServer(address, a, opts...)
+}
+
+// OnConnect
+// init connect request parser
+func (a *Adapter) OnConnect(c *poller.Conn) {
+	p := NewParser(a.maxHeaderLen, a.maxBodyLen)
+	c.SetData(p)
+	c.SetDecoder(p)
+}
+
+// OnMessage
+// serve one complete request, or response parse error and close connect
+func (a *Adapter) OnMessage(c *poller.Conn, bytes []byte) {
+	p, ok := c.GetData().(*Parser)
+	if !ok {
+		return
+	}
+
+	if err := p.Err(); err != nil {
+		log.Warnf("http1 connect %s parse request err %s", c.GetAddr(), err.Error())
+		c.Write(errorResponse(err))
+		c.CloseAfterFlush()
+		return
+	}
+
+	req := p.Request()
+	if req == nil {
+		return
+	}
+	req.RemoteAddr = c.GetAddr()
+
+	w := newResponse(req)
+	a.handler.ServeHTTP(w, req)
+	_, err := c.Write(w.bytes())
+	if err != nil {
+		log.Warnf("http1 connect %s write response err %s", c.GetAddr(), err.Error())
+		c.Close()
+		return
+	}
+
+	if req.Close {
+		c.CloseAfterFlush()
+	}
+}
+
+// OnClose
+func (a *Adapter) OnClose(c *poller.Conn, err error) {
+	if err != nil && err != io.EOF {
+		log.Debugf("http1 connect %s close err %s", c.GetAddr(), err.Error())
+	}
+}
@@ -0,0 +1,103 @@
+package http1
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// readResponses parse responses of requests with methods from bytes
+func readResponses(t *testing.T, b []byte, n int) []*http.Response {
+	r := bufio.NewReader(bytes.NewReader(b))
+	var resps []*http.Response
+	for i := 0; i < n; i++ {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatalf("read response %d err %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resps = append(resps, resp)
+	}
+	return resps
+}
+
+func TestAdapterPipelined(t *testing.T) {
+	a := NewAdapter(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.URL.Path + ":"))
+		w.Write(r.Body)
+	}), WithMaxBodyLen(16))
+	c, err := pollertest.New(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	reqs := "GET /a HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n"
+	if err = c.WriteChunks([]byte(reqs), 5); err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.Recv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resps := readResponses(t, out, 2)
+	for i, want := range []string{"/a:", "/b:hi"} {
+		body, _ := io.ReadAll(resps[i].Body)
+		if resps[i].StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("response %d: %d %q; want 200 %q", i, resps[i].StatusCode, body, want)
+		}
+	}
+	if c.PollerConn().IsClosed() {
+		t.Error("keep-alive connect is closed")
+	}
+}
+
+func TestAdapterParseError(t *testing.T) {
+	a := NewAdapter(HandlerFunc(func(w ResponseWriter, r *Request) {}), WithMaxBodyLen(16))
+	c, err := pollertest.New(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// chunk size overflows body length
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n7fffffffffffffff\r\n"))
+	out, _ := c.Recv(0)
+	resps := readResponses(t, out, 1)
+	if resps[0].StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d; want %d", resps[0].StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if !c.PollerConn().IsClosed() {
+		t.Error("connect is not closed after parse error response")
+	}
+}
+
+func TestAdapterConnectionClose(t *testing.T) {
+	a := NewAdapter(FromHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})))
+	c, err := pollertest.New(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("POST / HTTP/1.0\r\nContent-Length: 4\r\n\r\nping"))
+	out, _ := c.Recv(0)
+	resps := readResponses(t, out, 1)
+	body, _ := io.ReadAll(resps[0].Body)
+	if string(body) != "ping" || !resps[0].Close {
+		t.Fatalf("response body %q close %v", body, resps[0].Close)
+	}
+	if !c.PollerConn().IsClosed() {
+		t.Error("HTTP/1.0 connect is not closed after response")
+	}
+}
@@ -6,9 +6,11 @@ package poller
 import (
 	"errors"
 	"fmt"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/ii64/gouring"
@@ -28,6 +30,16 @@ type ioUring struct {
 	userDataEventLock sync.RWMutex                    // rwlock for mapUserDataEvent
 	subLock           sync.Mutex
 	cqeSignCh         chan struct{}
+	bufRing           *bufRing               // provided buffer ring for recv, nil: connect read buffer
+	fixedBufs         *fixedBuffers          // registered fixed buffers for send, nil: send op
+	multishotAccept   bool                   // use multishot accept op
+	multishotRecv     bool                   // use multishot recv op with provided buffer ring
+	multishotPoll     bool                   // use multishot poll op
+	closed            bool                   // ring closed, don't submit, hold subLock
+	backlog           []sqeOp                // ops wait for sq space, hold subLock
+	backlogRetry      bool                   // backlog retry timer is armed, hold subLock
+	busyPoll          bool                   // busy poll cq in user space, IOModeIouK
+	params            *gouring.IoUringParams // setup params written back by kernel: ring offsets, entries
 }
 
 // newIoUring
@@ -65,14 +77,27 @@ func newIoUring(entries uint32, params *gouring.IoUringParams) (iouring *ioUring
 		mapUserDataEvent: make(map[gouring.UserData]*eventInfo),
 		cqeSignCh:        make(chan struct{}, 1),
 	}
+	if params != nil {
+		p := *params
+		iouring.params = &p
+	}
 
 	return
 }
 
 func (m *ioUring) CloseRing() {
+	m.subLock.Lock()
+	m.closed = true
+	m.subLock.Unlock()
 	if m.ring != nil {
 		m.ring.Close()
 	}
+	if m.bufRing != nil {
+		m.bufRing.unmap()
+	}
+	if m.fixedBufs != nil {
+		syscall.Munmap(m.fixedBufs.bufs)
+	}
 }
 
 func (m *ioUring) RegisterEventFd() (err error) {
@@ -154,124 +179,309 @@ func (m *ioUring) getEventInfos(infos []*eventInfo, err error) {
 	return
 }
 
+// getEventInfo
+// peek ready cqe for reap event, wait eventfd notify if cq is empty;
+// one notify may be for multi cqes, so drain cq before wait again.
+// return nil info after notified, caller check stop and get again
 func (m *ioUring) getEventInfo() (info *eventInfo, err error) {
-	for {
-		select {
-		case <-m.cqeSignCh:
-			var cqe *gouring.IoUringCqe
-			err = m.ring.PeekCqe(&cqe)
-			if err != nil {
-				continue
-			}
-			if cqe == nil {
-				log.Warnf("cqe is nil")
-				continue
-			}
-
-			m.userDataEventLock.Lock()
-			info, ok := m.mapUserDataEvent[cqe.UserData]
-			if !ok {
-				errStr := fmt.Sprintf("cqe %+v userData %d get event info: %s empty", cqe, cqe.UserData, info)
-				m.userDataEventLock.Unlock()
-				//panic(errStr)
-				log.Error(errStr)
-				// commit cqe is seen
-				m.cqeDone(*cqe)
-				continue
-			}
-			//info = (*eventInfo)(cqe.UserData.GetUnsafe())
-			if info != nil && (info.cb == nil || info.etype == ETypeUnknow) {
-				m.userDataEventLock.Unlock()
-				log.Error("error event infoPtr")
-				// commit cqe is seen
-				m.cqeDone(*cqe)
-				continue
-			}
-			//https://github.com/golang/go/issues/20135
-			delete(m.mapUserDataEvent, cqe.UserData)
-			log.Debugf("userData %d get event info: %s", cqe.UserData, info)
-			info.cqe = *cqe
-			m.userDataEventLock.Unlock()
-
-			return info, nil
-		}
+	var cqe *gouring.IoUringCqe
+	if m.ring.PeekCqe(&cqe) != nil || cqe == nil {
+		<-m.cqeSignCh
+		return
 	}
+
+	info = m.eventInfoFromCqe(cqe)
+	return
 }
 
-func (m *ioUring) addAcceptSqe(cb EventCallBack, lfd int,
-	clientAddr *syscall.RawSockaddrAny, clientAddrLen uint32, flags uint8) {
+// wakeUp
+// wake up the dispatcher blocked in getEventInfo/waitEventInfo, eg: stop
+func (m *ioUring) wakeUp() {
+	select {
+	case m.cqeSignCh <- struct{}{}:
+	default:
+	}
+
 	m.subLock.Lock()
 	defer m.subLock.Unlock()
-	sqe := m.ring.GetSqe()
-	gouring.PrepAccept(sqe, lfd, clientAddr, (*uintptr)(unsafe.Pointer(&clientAddrLen)), 0)
-	sqe.Flags = flags
+	sqe := m.getSqe()
+	if sqe == nil {
+		return
+	}
+	gouring.PrepNop(sqe)
+	sqe.UserData = 0
+	m.ring.Submit()
+}
 
-	eventInfo := &eventInfo{
-		fd:    lfd,
-		etype: ETypeAccept,
-		cb:    cb,
+// waitEventInfo
+// block wait cqe (io_uring_enter GETEVENTS) for reap event, without eventfd notify
+// return nil info when interrupted or cqe user data is unknown
+func (m *ioUring) waitEventInfo() (info *eventInfo, err error) {
+	var cqe *gouring.IoUringCqe
+	err = m.ring.WaitCqe(&cqe)
+	if err != nil {
+		if errors.Is(err, syscall.ETIME) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+			err = nil
+		}
+		return
+	}
+	if cqe == nil {
+		return
+	}
+
+	info = m.eventInfoFromCqe(cqe)
+	return
+}
+
+// eventInfoFromCqe
+// get the event info submitted with sqe by cqe user data,
+// unknown user data cqe is committed seen and return nil
+func (m *ioUring) eventInfoFromCqe(cqe *gouring.IoUringCqe) *eventInfo {
+	if cqe.UserData == 0 {
+		// own op without event info, eg: cancel, wake up nop
+		m.cqeDone(*cqe)
+		return nil
 	}
 
-	sqe.UserData = gouring.UserData(uintptr(unsafe.Pointer(eventInfo)))
 	m.userDataEventLock.Lock()
-	m.mapUserDataEvent[sqe.UserData] = eventInfo
+	info, ok := m.mapUserDataEvent[cqe.UserData]
+	if !ok {
+		errStr := fmt.Sprintf("cqe %+v userData %d get event info: %s empty", cqe, cqe.UserData, info)
+		m.userDataEventLock.Unlock()
+		//panic(errStr)
+		log.Error(errStr)
+		// commit cqe is seen
+		m.cqeDone(*cqe)
+		return nil
+	}
+	//info = (*eventInfo)(cqe.UserData.GetUnsafe())
+	if info != nil && (info.cb == nil || info.etype == ETypeUnknow) {
+		m.userDataEventLock.Unlock()
+		log.Error("error event infoPtr")
+		// commit cqe is seen
+		m.cqeDone(*cqe)
+		return nil
+	}
+	if cqe.Flags&gouring.IORING_CQE_F_MORE != 0 {
+		// multishot op will post more cqe
+		info = multishotEventInfo(info, cqe)
+	} else {
+		//https://github.com/golang/go/issues/20135
+		delete(m.mapUserDataEvent, cqe.UserData)
+		info.cqe = *cqe
+	}
+	log.Debugf("userData %d get event info: %s", cqe.UserData, info)
+	if cqe.Flags&gouring.IORING_CQE_F_BUFFER != 0 {
+		info.bid = uint16(cqe.Flags >> gouring.IORING_CQE_BUFFER_SHIFT)
+	}
 	m.userDataEventLock.Unlock()
-	log.Debugf("addAcceptSqe userData %d eventInfo:%s", sqe.UserData, eventInfo)
-	m.ring.Submit()
+
+	return info
+}
+
+// getSqe
+// get sqe to submit op, hold subLock;
+// nil if ring is closed (eg: events are still consumed after stop) or sq is still full after retry
+func (m *ioUring) getSqe() *gouring.IoUringSqe {
+	if m.closed {
+		return nil
+	}
+	sqe := m.ring.GetSqe()
+	for i := 0; sqe == nil && i < sqFullRetry; i++ {
+		// sq poll thread hasn't consumed sqes yet, wake it up if need
+		m.ring.Submit()
+		runtime.Gosched()
+		sqe = m.ring.GetSqe()
+	}
+	return sqe
+}
+
+// sqeOp
+// op to submit: event info mapped by user data until complete (nil: own op without cqe user data, eg: cancel)
+// and prep of sqe
+type sqeOp struct {
+	info *eventInfo
+	prep func(sqe *gouring.IoUringSqe)
 }
 
-func (m *ioUring) addRecvSqe(cb EventCallBack, cfd int, buff []byte, size int, flags uint8) {
+// submitOp
+// prep sqe of op and submit; if sq is still full after retry, op is queued in backlog
+// and submitted in order by retry timer, so op isn't dropped and callback is called when complete;
+// return op user data (to cancel), ErrIOUringClosed if ring is closed
+func (m *ioUring) submitOp(info *eventInfo, prep func(sqe *gouring.IoUringSqe)) (gouring.UserData, error) {
+	var userData gouring.UserData
+	if info != nil {
+		userData = gouring.UserData(uintptr(unsafe.Pointer(info)))
+	}
+
 	m.subLock.Lock()
 	defer m.subLock.Unlock()
-	var buf *byte
-	if len(buff) > 0 {
-		buf = &buff[0]
+	if m.closed {
+		return 0, ErrIOUringClosed
 	}
-	sqe := m.ring.GetSqe()
-	gouring.PrepRecv(sqe, cfd, buf, size, uint(flags))
-	sqe.Flags = flags
+	op := sqeOp{info: info, prep: prep}
+	if len(m.backlog) == 0 && m.prepOp(op) {
+		m.ring.Submit()
+		return userData, nil
+	}
+	// keep submit order, eg: cancel op after the op to cancel
+	m.backlog = append(m.backlog, op)
+	m.submitBacklog()
+	return userData, nil
+}
 
-	eventInfo := &eventInfo{
-		fd:    cfd,
-		etype: ETypeRead,
-		cb:    cb,
+// prepOp
+// get sqe and prep op, map event info by user data before submit; false if sq is full, hold subLock
+func (m *ioUring) prepOp(op sqeOp) bool {
+	sqe := m.getSqe()
+	if sqe == nil {
+		return false
+	}
+	op.prep(sqe)
+	if op.info == nil {
+		sqe.UserData = 0
+		return true
 	}
 
-	//sqe.UserData.SetUnsafe(unsafe.Pointer(eventInfo))
-	sqe.UserData = gouring.UserData(uintptr(unsafe.Pointer(eventInfo)))
+	sqe.UserData = gouring.UserData(uintptr(unsafe.Pointer(op.info)))
+	// log before the info is seen by dispatcher, the cqe is written to it
+	log.Debugf("submit op userData %d eventInfo:%s", sqe.UserData, op.info)
 	m.userDataEventLock.Lock()
-	m.mapUserDataEvent[sqe.UserData] = eventInfo
+	m.mapUserDataEvent[sqe.UserData] = op.info
 	m.userDataEventLock.Unlock()
-	log.Debugf("addRecvSqe userData %d eventInfo:%s", sqe.UserData, eventInfo)
-	m.ring.Submit()
+	return true
 }
 
-func (m *ioUring) addSendSqe(cb EventCallBack, cfd int, buff []byte, msgSize int, flags uint8) {
-	m.subLock.Lock()
-	defer m.subLock.Unlock()
+// submitBacklog
+// submit queued ops in order while sq has space, retry after sqBacklogRetryDelay if sq is still full;
+// hold subLock
+func (m *ioUring) submitBacklog() {
+	n := 0
+	for n < len(m.backlog) && m.prepOp(m.backlog[n]) {
+		n++
+	}
+	if n > 0 {
+		m.backlog = append(m.backlog[:0], m.backlog[n:]...)
+		m.ring.Submit()
+	}
+	if len(m.backlog) == 0 || m.backlogRetry {
+		return
+	}
+	log.Warnf("io_uring sq is full, %d ops wait to submit", len(m.backlog))
+	m.backlogRetry = true
+	time.AfterFunc(sqBacklogRetryDelay, func() {
+		m.subLock.Lock()
+		defer m.subLock.Unlock()
+		m.backlogRetry = false
+		if m.closed {
+			m.backlog = nil
+			return
+		}
+		m.submitBacklog()
+	})
+}
+
+// addAcceptSqe
+// kernel writes peer address and len when accept completes,
+// so clientAddr and clientAddrLen must be alive (heap) until complete
+func (m *ioUring) addAcceptSqe(cb EventCallBack, lfd int,
+	clientAddr *syscall.RawSockaddrAny, clientAddrLen *uint32, flags uint8) (gouring.UserData, error) {
+	return m.submitOp(&eventInfo{fd: lfd, etype: ETypeAccept, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepAccept(sqe, lfd, clientAddr, (*uintptr)(unsafe.Pointer(clientAddrLen)), syscall.SOCK_CLOEXEC)
+		sqe.Flags = flags
+	})
+}
+
+func (m *ioUring) addRecvSqe(cb EventCallBack, cfd int, buff []byte, size int, flags uint8) error {
 	var buf *byte
 	if len(buff) > 0 {
 		buf = &buff[0]
 	}
-	sqe := m.ring.GetSqe()
-	gouring.PrepSend(sqe, cfd, buf, msgSize, uint(flags))
-	sqe.Flags = flags
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeRead, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRecv(sqe, cfd, buf, size, uint(flags))
+		sqe.Flags = flags
+	})
+	return err
+}
 
-	eventInfo := &eventInfo{
-		fd:    cfd,
-		etype: ETypeWrite,
-		cb:    cb,
+func (m *ioUring) addSendSqe(cb EventCallBack, cfd int, buff []byte, msgSize int, flags uint8) error {
+	var buf *byte
+	if len(buff) > 0 {
+		buf = &buff[0]
 	}
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeWrite, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepSend(sqe, cfd, buf, msgSize, uint(flags))
+		sqe.Flags = flags
+	})
+	return err
+}
 
-	//sqe.UserData.SetUnsafe(unsafe.Pointer(eventInfo))
-	sqe.UserData = gouring.UserData(uintptr(unsafe.Pointer(eventInfo)))
-	m.userDataEventLock.Lock()
-	m.mapUserDataEvent[sqe.UserData] = eventInfo
-	m.userDataEventLock.Unlock()
-	log.Debugf("addSendSqe userData %d eventInfo:%s", sqe.UserData, eventInfo)
-	m.ring.Submit()
+// addSpliceSqe
+// add splice op move bytes between fdIn and fdOut, one of them must be pipe;
+// offset -1 means use (pipe) file position, complete event is connect cfd write event
+func (m *ioUring) addSpliceSqe(cb EventCallBack, cfd int, fdIn int, offIn int64, fdOut int, offOut int64, nbytes int, spliceFlags uint32) error {
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeWrite, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRW(gouring.IORING_OP_SPLICE, sqe, fdOut, nil, nbytes, uint64(offOut))
+		sqe.IoUringSqe_Union2.SetSpliceOffsetIn(uint64(offIn))
+		sqe.IoUringSqe_Union3.SetSpliceFlags(spliceFlags)
+		sqe.IoUringSqe_Union5.SetSpliceFdIn(int32(fdIn))
+	})
+	return err
+}
+
+// addPollAddSqe
+// add poll event mask ready op (one shot) for fd, eg: POLLIN
+func (m *ioUring) addPollAddSqe(cb EventCallBack, fd int, pollMask uint32, flags uint8) error {
+	_, err := m.submitOp(&eventInfo{fd: fd, etype: ETypePollInRead, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepPollAdd(sqe, fd, pollMask)
+		sqe.Flags = flags
+	})
+	return err
+}
+
+// addPollOutSqe
+// add poll out ready op (one shot) for connect fd, complete event is connect write event,
+// eg: splice to non-blocking socket return EAGAIN
+func (m *ioUring) addPollOutSqe(cb EventCallBack, cfd int) error {
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeWrite, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepPollAdd(sqe, cfd, unix.POLLOUT)
+	})
+	return err
+}
+
+// addConnectPollSqe
+// add poll out ready op (one shot) for non block connecting fd, complete event is connect event
+func (m *ioUring) addConnectPollSqe(cb EventCallBack, cfd int) error {
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeConnect, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepPollAdd(sqe, cfd, unix.POLLOUT)
+	})
+	return err
 }
 
 func (m *ioUring) cqeDone(cqe gouring.IoUringCqe) {
 	m.ring.SeenCqe(&cqe)
 }
+
+// stats
+// sq/cq ring occupancy by kernel shared ring head/tail, in flight ops
+func (m *ioUring) stats() (st IoUringStats) {
+	m.userDataEventLock.RLock()
+	st.InFlight = len(m.mapUserDataEvent)
+	m.userDataEventLock.RUnlock()
+
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	st.Backlog = len(m.backlog)
+	if m.closed || m.params == nil {
+		return
+	}
+	p := m.params
+	st.SQEntries, st.CQEntries = p.SqEntries, p.CqEntries
+	sqHead := (*uint32)(unsafe.Pointer(uintptr(m.ring.Sq.RingPtr) + uintptr(p.SqOff.Head)))
+	cqHead := (*uint32)(unsafe.Pointer(uintptr(m.ring.Cq.RingPtr) + uintptr(p.CqOff.Head)))
+	cqTail := (*uint32)(unsafe.Pointer(uintptr(m.ring.Cq.RingPtr) + uintptr(p.CqOff.Tail)))
+	// sqes got but not submitted are counted too, like io_uring_sq_ready
+	st.SQReady = m.ring.Sq.SqeTail - atomic.LoadUint32(sqHead)
+	st.CQReady = atomic.LoadUint32(cqTail) - atomic.LoadUint32(cqHead)
+	return
+}
@@ -0,0 +1,254 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/ii64/gouring"
+	"github.com/weedge/lib/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// bufRingGroupID provided buffer ring group id of each io_uring ring
+	bufRingGroupID = 1
+	// bufRingEntrySize sizeof struct io_uring_buf
+	bufRingEntrySize = int(unsafe.Sizeof(gouring.IoUringBuf{}))
+)
+
+// bufRing
+// kernel provided buffer ring (IORING_REGISTER_PBUF_RING),
+// recv op with IOSQE_BUFFER_SELECT pick a buffer when bytes arrive,
+// so pending reads of idle connect don't pin buffers
+type bufRing struct {
+	lock    sync.Mutex
+	gid     uint16
+	entries uint16 // power of 2
+	mask    uint16
+	tail    uint16 // local tail, published to kernel after buffer added
+	bufLen  int
+	ring    []byte // mmap io_uring_buf entries, page aligned, tail overlays entry 0 resv
+	bufs    []byte // mmap buffers, entries * bufLen
+}
+
+// newBufRing
+// mmap entries and buffers, register buffer ring to io_uring, add all buffers
+func newBufRing(ring *gouring.IoUring, gid uint16, entries, bufLen int) (br *bufRing, err error) {
+	if entries <= 0 || entries > 1<<15 || entries&(entries-1) != 0 {
+		return nil, syscall.EINVAL
+	}
+
+	br = &bufRing{gid: gid, entries: uint16(entries), mask: uint16(entries - 1), bufLen: bufLen}
+	br.ring, err = syscall.Mmap(-1, 0, entries*bufRingEntrySize,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	br.bufs, err = syscall.Mmap(-1, 0, entries*bufLen,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE)
+	if err != nil {
+		syscall.Munmap(br.ring)
+		return nil, err
+	}
+
+	reg := &gouring.IoUringBufReg{
+		RingAddr:    uint64(uintptr(unsafe.Pointer(&br.ring[0]))),
+		RingEntries: uint32(entries),
+		Bgid:        gid,
+	}
+	_, _, errno := syscall.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ring.RingFd),
+		gouring.IORING_REGISTER_PBUF_RING, uintptr(unsafe.Pointer(reg)), 1, 0, 0)
+	if errno != 0 {
+		br.unmap()
+		return nil, errno
+	}
+
+	br.lock.Lock()
+	for i := 0; i < entries; i++ {
+		br.add(uint16(i))
+	}
+	br.publish()
+	br.lock.Unlock()
+
+	return br, nil
+}
+
+// entry
+// io_uring_buf entry of ring index
+func (br *bufRing) entry(idx uint16) *gouring.IoUringBuf {
+	return (*gouring.IoUringBuf)(unsafe.Pointer(&br.ring[int(idx&br.mask)*bufRingEntrySize]))
+}
+
+// add
+// add buffer bid at local tail, hold lock
+func (br *bufRing) add(bid uint16) {
+	e := br.entry(br.tail)
+	e.Addr = uint64(uintptr(unsafe.Pointer(&br.bufs[int(bid)*br.bufLen])))
+	e.Len = uint32(br.bufLen)
+	e.Bid = bid
+	br.tail++
+}
+
+// publish
+// store local tail to ring tail (overlays entry 0 resv) with release semantic,
+// entry 0 bid shares the same 32 bits word, hold lock
+func (br *bufRing) publish() {
+	word := (*uint32)(unsafe.Pointer(&br.ring[12]))
+	bid := uint32(br.entry(0).Bid)
+	atomic.StoreUint32(word, bid|uint32(br.tail)<<16)
+}
+
+// get
+// bytes of buffer bid selected by kernel
+func (br *bufRing) get(bid uint16, n int) []byte {
+	off := int(bid) * br.bufLen
+	return br.bufs[off : off+n : off+br.bufLen]
+}
+
+// recycle
+// give buffer bid back to kernel after bytes consumed
+func (br *bufRing) recycle(bid uint16) {
+	br.lock.Lock()
+	br.add(bid)
+	br.publish()
+	br.lock.Unlock()
+}
+
+func (br *bufRing) unmap() {
+	syscall.Munmap(br.ring)
+	syscall.Munmap(br.bufs)
+}
+
+// fixedBuffers
+// registered fixed buffers (IORING_REGISTER_BUFFERS) for IORING_OP_WRITE_FIXED send,
+// kernel pins pages once, don't map user pages each send op
+type fixedBuffers struct {
+	lock   sync.Mutex
+	bufLen int
+	bufs   []byte   // mmap buffers, num * bufLen
+	free   []uint16 // free buffer index
+}
+
+// newFixedBuffers
+// mmap and register num fixed buffers of bufLen to io_uring
+func newFixedBuffers(ring *gouring.IoUring, num, bufLen int) (fb *fixedBuffers, err error) {
+	if num <= 0 || num > 1<<14 {
+		return nil, syscall.EINVAL
+	}
+
+	fb = &fixedBuffers{bufLen: bufLen}
+	fb.bufs, err = syscall.Mmap(-1, 0, num*bufLen,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+
+	iovs := make([]syscall.Iovec, num)
+	for i := range iovs {
+		iovs[i].Base = &fb.bufs[i*bufLen]
+		iovs[i].SetLen(bufLen)
+		fb.free = append(fb.free, uint16(i))
+	}
+	_, _, errno := syscall.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(ring.RingFd),
+		gouring.IORING_REGISTER_BUFFERS, uintptr(unsafe.Pointer(&iovs[0])), uintptr(num), 0, 0)
+	if errno != 0 {
+		syscall.Munmap(fb.bufs)
+		return nil, errno
+	}
+
+	return fb, nil
+}
+
+// get
+// get a free fixed buffer index, false if all in flight
+func (fb *fixedBuffers) get() (idx uint16, ok bool) {
+	fb.lock.Lock()
+	defer fb.lock.Unlock()
+	if len(fb.free) == 0 {
+		return
+	}
+	idx = fb.free[len(fb.free)-1]
+	fb.free = fb.free[:len(fb.free)-1]
+	return idx, true
+}
+
+// put
+// fixed buffer write op complete
+func (fb *fixedBuffers) put(idx uint16) {
+	fb.lock.Lock()
+	fb.free = append(fb.free, idx)
+	fb.lock.Unlock()
+}
+
+// buf
+// bytes of fixed buffer index
+func (fb *fixedBuffers) buf(idx uint16) []byte {
+	off := int(idx) * fb.bufLen
+	return fb.bufs[off : off+fb.bufLen]
+}
+
+// setupBuffers
+// register provided buffer ring and fixed buffers if configured,
+// fall back to per connect buffers if the kernel doesn't support
+func (m *ioUring) setupBuffers(opts *options) {
+	if opts.bufRingEntries > 0 {
+		br, err := newBufRing(m.ring, bufRingGroupID, opts.bufRingEntries, opts.readBufferLen)
+		if err != nil {
+			log.Warnf("register provided buffer ring err %s, use connect read buffer", err.Error())
+		} else {
+			m.bufRing = br
+		}
+	}
+	if opts.fixedBufferNum > 0 {
+		fb, err := newFixedBuffers(m.ring, opts.fixedBufferNum, opts.fixedBufferLen)
+		if err != nil {
+			log.Warnf("register fixed buffers err %s, use send op", err.Error())
+		} else {
+			m.fixedBufs = fb
+		}
+	}
+}
+
+// releaseBuffer
+// give back provided buffer or fixed buffer held by completed event,
+// eg: event of closed connect is dropped
+func (m *ioUring) releaseBuffer(e *eventInfo) {
+	if e.fixed {
+		m.fixedBufs.put(e.bid)
+		e.fixed = false
+		return
+	}
+	if m.bufRing != nil && e.cqe.Flags&gouring.IORING_CQE_F_BUFFER != 0 {
+		m.bufRing.recycle(e.bid)
+		e.cqe.Flags &^= gouring.IORING_CQE_F_BUFFER
+	}
+}
+
+// addRecvSelectSqe
+// add recv op select buffer from provided buffer ring when bytes arrive,
+// selected buffer id is in cqe flags
+func (m *ioUring) addRecvSelectSqe(cb EventCallBack, cfd int) error {
+	gid, bufLen := m.bufRing.gid, m.bufRing.bufLen
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeRead, gid: gid, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRecv(sqe, cfd, nil, bufLen, 0)
+		sqe.Flags = gouring.IOSQE_BUFFER_SELECT
+		sqe.IoUringSqe_Union4.SetBufGroup(gid)
+	})
+	return err
+}
+
+// addWriteFixedSqe
+// add write op from registered fixed buffer idx, fixed buffer is given back when complete
+func (m *ioUring) addWriteFixedSqe(cb EventCallBack, cfd int, idx uint16, n int) error {
+	buf := m.fixedBufs.buf(idx)
+	_, err := m.submitOp(&eventInfo{fd: cfd, etype: ETypeWrite, bid: idx, fixed: true, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRW(gouring.IORING_OP_WRITE_FIXED, sqe, cfd, unsafe.Pointer(&buf[0]), n, 0)
+		sqe.IoUringSqe_Union4.SetBufIndex(idx)
+	})
+	return err
+}
@@ -0,0 +1,200 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ii64/gouring"
+)
+
+// newBufTestRing io_uring ring with provided buffer ring and fixed buffers of opts, closed on test cleanup;
+// skip if the kernel doesn't support
+func newBufTestRing(t *testing.T, opts *options) *ioUring {
+	m, err := newIoUring(16, &gouring.IoUringParams{})
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	t.Cleanup(m.CloseRing)
+	m.setupBuffers(opts)
+	if opts.bufRingEntries > 0 && m.bufRing == nil {
+		t.Skip("provided buffer ring is not supported")
+	}
+	if opts.fixedBufferNum > 0 && m.fixedBufs == nil {
+		t.Skip("fixed buffers are not supported")
+	}
+	return m
+}
+
+// socketPair connected unix stream sockets, closed on test cleanup
+func socketPair(t *testing.T) [2]int {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	return fds
+}
+
+// recvSelect write msg to peer, recv it by select buffer op, return complete event
+func recvSelect(t *testing.T, m *ioUring, fds [2]int, msg string) *eventInfo {
+	t.Helper()
+	if _, err := syscall.Write(fds[1], []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.addRecvSelectSqe(noOpsEventCb, fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	return waitEventInfos(t, m, 1)[0]
+}
+
+func TestBufRingRecycle(t *testing.T) {
+	m := newBufTestRing(t, &options{bufRingEntries: 4, readBufferLen: 64})
+	fds := socketPair(t)
+
+	// each recv selects a buffer until all are in use
+	var held []*eventInfo
+	bids := map[uint16]bool{}
+	for i := 0; i < 4; i++ {
+		msg := fmt.Sprintf("msg %d", i)
+		e := recvSelect(t, m, fds, msg)
+		if e.cqe.Res != int32(len(msg)) || e.cqe.Flags&gouring.IORING_CQE_F_BUFFER == 0 {
+			t.Fatalf("recv res %d flags %x", e.cqe.Res, e.cqe.Flags)
+		}
+		if got := string(m.bufRing.get(e.bid, int(e.cqe.Res))); got != msg || bids[e.bid] {
+			t.Fatalf("buffer %d bytes %q; want %q in a free buffer", e.bid, got, msg)
+		}
+		bids[e.bid] = true
+		held = append(held, e)
+	}
+
+	// ring exhaustion
+	e := recvSelect(t, m, fds, "no buffer")
+	if e.cqe.Res != -int32(syscall.ENOBUFS) {
+		t.Fatalf("recv res %d; want -ENOBUFS", e.cqe.Res)
+	}
+	m.releaseBuffer(e)
+
+	// recycled buffer is selected again, bytes left in socket are received
+	m.releaseBuffer(held[0])
+	if held[0].cqe.Flags&gouring.IORING_CQE_F_BUFFER != 0 {
+		t.Fatal("buffer flag is not cleared after recycle")
+	}
+	e = recvSelect(t, m, fds, "")
+	if got := string(m.bufRing.get(e.bid, int(e.cqe.Res))); e.bid != held[0].bid || got != "no buffer" {
+		t.Fatalf("buffer %d bytes %q; want recycled buffer %d", e.bid, got, held[0].bid)
+	}
+}
+
+func TestFixedBuffersExhaustion(t *testing.T) {
+	m := newBufTestRing(t, &options{fixedBufferNum: 2, fixedBufferLen: 64})
+	fds := socketPair(t)
+
+	var idxs []uint16
+	for i := 0; i < 2; i++ {
+		idx, ok := m.fixedBufs.get()
+		if !ok {
+			t.Fatalf("get fixed buffer %d failed", i)
+		}
+		idxs = append(idxs, idx)
+	}
+	if _, ok := m.fixedBufs.get(); ok {
+		t.Fatal("get fixed buffer when all are in flight")
+	}
+
+	// write fixed op sends buffer bytes, buffer is given back when complete
+	msg := []byte("fixed buffer")
+	n := copy(m.fixedBufs.buf(idxs[0]), msg)
+	if err := m.addWriteFixedSqe(noOpsEventCb, fds[0], idxs[0], n); err != nil {
+		t.Fatal(err)
+	}
+	e := waitEventInfos(t, m, 1)[0]
+	if e.cqe.Res != int32(n) {
+		t.Fatalf("write fixed res %d; want %d", e.cqe.Res, n)
+	}
+	buf := make([]byte, 64)
+	if rn, err := syscall.Read(fds[1], buf); err != nil || !bytes.Equal(buf[:rn], msg) {
+		t.Fatalf("peer read %q err %v", buf[:rn], err)
+	}
+	m.releaseBuffer(e)
+	if idx, ok := m.fixedBufs.get(); !ok || idx != idxs[0] {
+		t.Fatalf("get fixed buffer %d %v; want released %d", idx, ok, idxs[0])
+	}
+}
+
+func TestServerBufRingExhaustion(t *testing.T) {
+	// more connects with pending bytes than provided buffers, recv falls back to connect read buffer
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	s, err := NewServer(addr, h, WithIoMode(IOModeUring), WithIoUringBufRing(2), WithIoUringFixedBuffers(2, 64))
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	if s.iourings[0].bufRing == nil || s.iourings[0].fixedBufs == nil {
+		s.Stop()
+		t.Skip("provided buffer ring or fixed buffers are not supported")
+	}
+	go s.Run()
+	defer s.Stop()
+
+	var conns []net.Conn
+	for i := 0; i < 8; i++ {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		recv(t, h.connects)
+		conns = append(conns, conn)
+	}
+	for round := 0; round < 3; round++ {
+		for i, conn := range conns {
+			conn.Write([]byte(fmt.Sprintf("conn %d round %d", i, round)))
+		}
+		for i, conn := range conns {
+			want := fmt.Sprintf("conn %d round %d", i, round)
+			buf := make([]byte, len(want))
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != want {
+				t.Fatalf("echo %q err %v; want %q", buf, err, want)
+			}
+		}
+	}
+}
+
+func TestCloseInFlightRecvBuffer(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(false)
+	s, err := NewServer(addr, h, WithIoMode(IOModeUring))
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	go s.Run()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := recv(t, h.connects).(*Conn)
+	// recv op to connect read buffer is in flight
+	time.Sleep(20 * time.Millisecond)
+	buf := c.buffer.buf
+
+	// closed by other goroutine, read buffer isn't put back to pool under the recv op
+	c.Close()
+	if c.startRead() || c.buffer.buf == nil || &c.buffer.buf[0] != &buf[0] {
+		t.Fatal("read buffer of in flight recv op is dropped")
+	}
+}
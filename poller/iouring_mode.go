@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ii64/gouring"
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// busyPollSpins empty cq peek times before yield in IOModeIouK
+	busyPollSpins = 64
+	// sqFullRetry submit and get sqe again times when sq is full, eg: sq thread is busy
+	sqFullRetry = 1024
+	// sqBacklogRetryDelay submit ops queued in backlog again after delay when sq is still full
+	sqBacklogRetryDelay = time.Millisecond
+)
+
+// newModeIoUring
+// new io uring for io mode:
+// IOModeUringSQPoll/IOModeIouK kernel sq poll thread submit sqe (bind cpu, idle timeout),
+// IOModeUringWQ attach async worker queue of wqFd ring (wqFd >= 0)
+func newModeIoUring(opts *options, wqFd int) (iouring *ioUring, err error) {
+	// kernel writes back params features, copy for each ring
+	params := &gouring.IoUringParams{}
+	if opts.ioUringParams != nil {
+		*params = *opts.ioUringParams
+	}
+
+	sqPoll := opts.ioMode == IOModeUringSQPoll || opts.ioMode == IOModeIouK
+	attachWQ := opts.ioMode == IOModeUringWQ && wqFd >= 0
+	if sqPoll {
+		params.Flags |= gouring.IORING_SETUP_SQPOLL
+		params.SqThreadIdle = uint32(opts.sqThreadIdle.Milliseconds())
+		if opts.sqThreadCPU >= 0 {
+			params.Flags |= gouring.IORING_SETUP_SQ_AFF
+			params.SqThreadCpu = uint32(opts.sqThreadCPU)
+		}
+	}
+	if attachWQ {
+		params.Flags |= gouring.IORING_SETUP_ATTACH_WQ
+		params.WqFd = uint32(wqFd)
+	}
+
+	iouring, err = newIoUring(opts.ioUringEntries, params)
+	if err != nil {
+		// unknown setup flag or no privilege;
+		// gouring doesn't check io_uring_setup errno, the negative ret is mmaped as ring fd with EBADF
+		setupErr := errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EBADF)
+		if sqPoll && (setupErr || errors.Is(err, syscall.EPERM)) {
+			return nil, ErrIOUringSQPollUnsupported
+		}
+		if attachWQ && setupErr {
+			return nil, ErrIOUringAttachWQUnsupported
+		}
+		return
+	}
+
+	// connect fd isn't registered file, sq poll thread need IORING_FEAT_SQPOLL_NONFIXED (linux 5.11)
+	if sqPoll && params.Features&gouring.IORING_FEAT_SQPOLL_NONFIXED == 0 {
+		iouring.CloseRing()
+		return nil, ErrIOUringSQPollUnsupported
+	}
+	iouring.busyPoll = opts.ioMode == IOModeIouK
+	log.Infof("io_uring mode %d setup flags %d features %d", opts.ioMode, params.Flags, params.Features)
+
+	return
+}
+
+// newModeIoUrings
+// new io uring rings for io mode, IOModeUringWQ rings share the first ring async worker queue;
+// close created rings if one fails
+func newModeIoUrings(opts *options) (rings []*ioUring, err error) {
+	rings = make([]*ioUring, 0, opts.ioUringNum)
+	wqFd := -1
+	for i := 0; i < opts.ioUringNum; i++ {
+		ring, err := newModeIoUring(opts, wqFd)
+		if err != nil {
+			log.Errorf("newIoUring %d err %s", i, err.Error())
+			closeIoUrings(rings)
+			return nil, err
+		}
+		rings = append(rings, ring)
+		if opts.ioMode == IOModeUringWQ && wqFd < 0 {
+			wqFd = int(ring.ring.RingFd)
+		}
+	}
+	return
+}
+
+// closeIoUrings
+func closeIoUrings(rings []*ioUring) {
+	for _, ring := range rings {
+		ring.CloseRing()
+	}
+}
+
+// pollEventInfo
+// IOModeIouK busy poll cq in user space without io_uring_enter syscall,
+// yield after busyPollSpins empty peek; return nil info if cq is empty, caller check stop and poll again
+func (m *ioUring) pollEventInfo() (info *eventInfo, err error) {
+	var cqe *gouring.IoUringCqe
+	if m.ring.PeekCqe(&cqe) != nil || cqe == nil {
+		if atomic.AddInt64(&m.spins, 1) >= busyPollSpins {
+			atomic.StoreInt64(&m.spins, 0)
+			runtime.Gosched()
+		}
+		return
+	}
+
+	atomic.StoreInt64(&m.spins, 0)
+	info = m.eventInfoFromCqe(cqe)
+	return
+}
@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ii64/gouring"
+)
+
+// openFDs open fd num of test process
+func openFDs(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("open fds are not available: %v", err)
+	}
+	return len(fds)
+}
+
+// skipNoIoUring skip if io_uring is not available
+func skipNoIoUring(t *testing.T) {
+	m, err := newIoUring(4, &gouring.IoUringParams{})
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	m.CloseRing()
+}
+
+// startModeEcho run echo server of io_uring mode with 2 rings, skip if the mode is unsupported
+func startModeEcho(t *testing.T, mode IOMode, opts ...Option) (*Server, net.Conn) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	opts = append([]Option{WithIoMode(mode), WithIoUringNum(2)}, opts...)
+	s, err := NewServer(addr, h, opts...)
+	if err == ErrIOUringSQPollUnsupported || err == ErrIOUringAttachWQUnsupported {
+		t.Skipf("io mode %d is not supported: %v", mode, err)
+	}
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	go s.Run()
+	t.Cleanup(s.Stop)
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	recv(t, h.connects)
+	echoRoundTrip(t, conn, "mode echo")
+	return s, conn
+}
+
+func TestIoUringSQPollMode(t *testing.T) {
+	s, conn := startModeEcho(t, IOModeUringSQPoll, WithIoUringSQPoll(0, 10*time.Millisecond))
+	for _, ring := range s.iourings {
+		if ring.params.Flags&gouring.IORING_SETUP_SQPOLL == 0 || ring.params.Flags&gouring.IORING_SETUP_SQ_AFF == 0 {
+			t.Fatalf("ring setup flags %x; want sq poll thread bound cpu", ring.params.Flags)
+		}
+		if ring.busyPoll {
+			t.Fatal("busy poll cq in sq poll mode")
+		}
+	}
+	// sq poll thread sleeps after idle, woken up by next submit
+	time.Sleep(50 * time.Millisecond)
+	echoRoundTrip(t, conn, "after sq thread idle")
+}
+
+func TestIoUringAttachWQMode(t *testing.T) {
+	s, conn := startModeEcho(t, IOModeUringWQ)
+	first := s.iourings[0]
+	if first.params.Flags&gouring.IORING_SETUP_ATTACH_WQ != 0 {
+		t.Fatal("the first ring attaches async worker queue")
+	}
+	for _, ring := range s.iourings[1:] {
+		if ring.params.Flags&gouring.IORING_SETUP_ATTACH_WQ == 0 || ring.params.WqFd != uint32(first.ring.RingFd) {
+			t.Fatalf("ring setup flags %x wq fd %d; want attach ring fd %d", ring.params.Flags, ring.params.WqFd, first.ring.RingFd)
+		}
+	}
+	echoRoundTrip(t, conn, "attach wq")
+}
+
+func TestIoUringIouKMode(t *testing.T) {
+	s, conn := startModeEcho(t, IOModeIouK)
+	for _, ring := range s.iourings {
+		if ring.params.Flags&gouring.IORING_SETUP_SQPOLL == 0 || !ring.busyPoll {
+			t.Fatalf("ring setup flags %x busy poll %v; want sq poll and busy poll cq", ring.params.Flags, ring.busyPoll)
+		}
+	}
+	echoRoundTrip(t, conn, "busy poll")
+}
+
+func TestIoUringSQPollUnsupported(t *testing.T) {
+	skipNoIoUring(t)
+	// sq poll thread bound to cpu out of range is rejected by kernel
+	opts := &options{ioMode: IOModeUringSQPoll, ioUringEntries: 8, ioUringNum: 2, sqThreadCPU: 1 << 20}
+	n := openFDs(t)
+	rings, err := newModeIoUrings(opts)
+	if err != ErrIOUringSQPollUnsupported || rings != nil {
+		t.Fatalf("newModeIoUrings() = %d rings err %v; want %v", len(rings), err, ErrIOUringSQPollUnsupported)
+	}
+	if m := openFDs(t); m != n {
+		t.Fatalf("open fds %d; want %d, half-built ring is left", m, n)
+	}
+}
+
+func TestIoUringAttachWQUnsupported(t *testing.T) {
+	skipNoIoUring(t)
+	// wq fd isn't a ring fd
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+	opts := &options{ioMode: IOModeUringWQ, ioUringEntries: 8}
+	n := openFDs(t)
+	ring, err := newModeIoUring(opts, p[0])
+	if err != ErrIOUringAttachWQUnsupported || ring != nil {
+		t.Fatalf("newModeIoUring() = %v err %v; want %v", ring, err, ErrIOUringAttachWQUnsupported)
+	}
+	if m := openFDs(t); m != n {
+		t.Fatalf("open fds %d; want %d, half-built ring is left", m, n)
+	}
+}
+
+func TestNewServerModeUnsupported(t *testing.T) {
+	skipNoIoUring(t)
+	addr := freeAddr(t)
+	// sq poll thread cpu out of range, the option panics for it
+	badCPU := newFuncServerOption(func(o *options) { o.sqThreadCPU = 1 << 20 })
+	n := openFDs(t)
+	s, err := NewServer(addr, newTestHandler(false), WithIoMode(IOModeIouK), WithIoUringNum(2), badCPU)
+	if err != ErrIOUringSQPollUnsupported || s != nil {
+		t.Fatalf("NewServer() = %v err %v; want %v", s, err, ErrIOUringSQPollUnsupported)
+	}
+	// listen fd, poller fd and created rings are closed
+	if m := openFDs(t); m != n {
+		t.Fatalf("open fds %d; want %d", m, n)
+	}
+}
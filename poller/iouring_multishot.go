@@ -0,0 +1,212 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/ii64/gouring"
+	"github.com/weedge/lib/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ioringRecvMultishot IORING_RECV_MULTISHOT recv op flag in sqe ioprio
+	ioringRecvMultishot = 1 << 1
+	// multishotProbeTimeout wait probe op cqe timeout (us)
+	multishotProbeTimeout = 100 * 1000
+)
+
+// multishotOps multishot ops supported by running kernel, probed once for all rings
+var (
+	multishotProbeOnce sync.Once
+	multishotOps       struct{ accept, recv, poll bool }
+)
+
+// probeMultishot
+// use multishot accept, recv (need provided buffer ring) and poll if the kernel supports,
+// backported kernel complete op with -EINVAL as well, fall back to one-shot op at runtime
+func (m *ioUring) probeMultishot(mode IOMode) {
+	if !mode.isIoUring() || mode == IOModeEpollUring {
+		return
+	}
+	multishotProbeOnce.Do(probeMultishotOps)
+	m.multishotAccept = multishotOps.accept
+	m.multishotRecv = m.bufRing != nil && multishotOps.recv
+	m.multishotPoll = multishotOps.poll
+	log.Infof("io_uring multishot accept %t recv %t poll %t", m.multishotAccept, m.multishotRecv, m.multishotPoll)
+}
+
+// probeMultishotOps
+// multishot is op flag, not opcode, IORING_REGISTER_PROBE can't tell it;
+// add each multishot op to a probe ring with ready fd, supported if the cqe has IORING_CQE_F_MORE,
+// kernel without the flag (poll before 5.13, accept before 5.19, recv before 6.0) complete op with -EINVAL;
+// in flight probe ops are cancelled by closing the probe ring
+func probeMultishotOps() {
+	p, err := newIoUring(8, &gouring.IoUringParams{})
+	if err != nil {
+		log.Warnf("new io_uring probe ring err %s, multishot is disabled", err.Error())
+		return
+	}
+	defer p.CloseRing()
+
+	// poll and recv fd is readable
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		log.Warnf("probe multishot socketpair err %s, multishot is disabled", err.Error())
+		return
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	if _, err = syscall.Write(fds[1], []byte("probe")); err != nil {
+		log.Warnf("probe multishot write err %s, multishot is disabled", err.Error())
+		return
+	}
+
+	p.multishotPoll = true
+	multishotOps.poll = p.probeMultishotOp(func() (gouring.UserData, error) {
+		return p.addPollInSqe(fds[0])
+	}).hasMore()
+	p.setupBuffers(&options{bufRingEntries: 2, readBufferLen: 64})
+	if p.bufRing != nil {
+		multishotOps.recv = p.probeMultishotOp(func() (gouring.UserData, error) {
+			return p.addMultishotRecvSqe(noOpsEventCb, fds[0])
+		}).hasMore()
+	}
+	multishotOps.accept = p.probeMultishotAccept()
+}
+
+// probeMultishotAccept
+// multishot accept op of listen socket with pending connect, the accepted fd is closed
+func (m *ioUring) probeMultishotAccept() bool {
+	flags := syscall.SOCK_NONBLOCK | syscall.SOCK_CLOEXEC
+	lfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|flags, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(lfd)
+	if err = syscall.Bind(lfd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		return false
+	}
+	if err = syscall.Listen(lfd, 1); err != nil {
+		return false
+	}
+	sa, err := syscall.Getsockname(lfd)
+	if err != nil {
+		return false
+	}
+	cfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|flags, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(cfd)
+	if err = syscall.Connect(cfd, sa); err != nil && err != syscall.EINPROGRESS {
+		return false
+	}
+
+	e := m.probeMultishotOp(func() (gouring.UserData, error) {
+		return m.addMultishotAcceptSqe(noOpsEventCb, lfd, uint(flags))
+	})
+	if e != nil && e.cqe.Res >= 0 {
+		syscall.Close(int(e.cqe.Res))
+	}
+	return e.hasMore()
+}
+
+// probeMultishotOp
+// add probe op, wait the first cqe, nil if add err or timeout
+func (m *ioUring) probeMultishotOp(add func() (gouring.UserData, error)) *eventInfo {
+	if _, err := add(); err != nil {
+		return nil
+	}
+	var cqe *gouring.IoUringCqe
+	err := m.ring.SubmitAndWaitTimeOut(&cqe, 1, multishotProbeTimeout, nil)
+	if err != nil || cqe == nil {
+		return nil
+	}
+	e := m.eventInfoFromCqe(cqe)
+	if e == nil {
+		return nil
+	}
+	m.cqeDone(e.cqe)
+	m.releaseBuffer(e)
+	return e
+}
+
+// disableMultishotRecv
+// multishot recv op completed with -EINVAL, kernel doesn't support the flag
+func (m *ioUring) disableMultishotRecv() {
+	log.Warnf("multishot recv is not supported, fall back to one-shot recv")
+	m.multishotRecv = false
+}
+
+// disableMultishotPoll
+// multishot poll op completed with -EINVAL, kernel doesn't support the flag
+func (m *ioUring) disableMultishotPoll() {
+	log.Warnf("multishot poll is not supported, fall back to one-shot poll")
+	m.multishotPoll = false
+}
+
+// addMultishotAcceptSqe
+// one accept op post a cqe for each accepted connect until cqe without IORING_CQE_F_MORE,
+// peer address is got by getpeername, return the op user data to cancel
+func (m *ioUring) addMultishotAcceptSqe(cb EventCallBack, lfd int, flags uint) (gouring.UserData, error) {
+	return m.submitOp(&eventInfo{fd: lfd, etype: ETypeAccept, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepMultishotAccept(sqe, lfd, nil, nil, flags)
+	})
+}
+
+// addMultishotRecvSqe
+// one recv op select provided buffer and post a cqe each time bytes arrive,
+// until cqe without IORING_CQE_F_MORE, return the op user data to cancel
+func (m *ioUring) addMultishotRecvSqe(cb EventCallBack, cfd int) (gouring.UserData, error) {
+	gid := m.bufRing.gid
+	return m.submitOp(&eventInfo{fd: cfd, etype: ETypeRead, gid: gid, cb: cb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRecv(sqe, cfd, nil, 0, 0)
+		sqe.IoPrio |= ioringRecvMultishot
+		sqe.Flags = gouring.IOSQE_BUFFER_SELECT
+		sqe.IoUringSqe_Union4.SetBufGroup(gid)
+	})
+}
+
+// addPollInSqe
+// add poll in ready op for connect fd, multishot if supported,
+// connect read bytes from fd on complete event, return the op user data to cancel
+func (m *ioUring) addPollInSqe(cfd int) (gouring.UserData, error) {
+	multishot := m.multishotPoll
+	return m.submitOp(&eventInfo{fd: cfd, etype: ETypePollInRead, cb: noOpsEventCb}, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepPollAdd(sqe, cfd, unix.POLLIN|unix.POLLRDHUP)
+		if multishot {
+			// poll add flags in sqe len
+			sqe.Len = gouring.IORING_POLL_ADD_MULTI
+		}
+	})
+}
+
+// addCancelSqe
+// cancel the in flight op of user data, eg: multishot op;
+// the canceled op complete with -ECANCELED, cancel op own cqe has no user data
+func (m *ioUring) addCancelSqe(userData gouring.UserData) error {
+	_, err := m.submitOp(nil, func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRW(gouring.IORING_OP_ASYNC_CANCEL, sqe, -1, nil, 0, 0)
+		sqe.IoUringSqe_Union2.SetAddr_Value(uint64(userData))
+	})
+	return err
+}
+
+// multishotEventInfo
+// the event info of multishot op is still mapped while cqe has IORING_CQE_F_MORE,
+// each cqe get a copy, so queued events don't share cqe
+func multishotEventInfo(info *eventInfo, cqe *gouring.IoUringCqe) *eventInfo {
+	e := *info
+	e.cqe = *cqe
+	return &e
+}
+
+// hasMore
+// multishot op will post more cqe, false for nil event
+func (e *eventInfo) hasMore() bool {
+	return e != nil && e.cqe.Flags&gouring.IORING_CQE_F_MORE != 0
+}
@@ -0,0 +1,141 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ii64/gouring"
+)
+
+// kernelAtLeast running kernel release is at least major.minor
+func kernelAtLeast(t *testing.T, major, minor int) bool {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		t.Fatal(err)
+	}
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	var ma, mi int
+	if _, err := fmt.Sscanf(string(release), "%d.%d", &ma, &mi); err != nil {
+		t.Fatalf("kernel release %q: %v", release, err)
+	}
+	return ma > major || (ma == major && mi >= minor)
+}
+
+func TestProbeMultishot(t *testing.T) {
+	m, err := newIoUring(8, &gouring.IoUringParams{})
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	defer m.CloseRing()
+	m.setupBuffers(&options{bufRingEntries: 2, readBufferLen: 64})
+
+	// probed by multishot op, not by opcodes of the same release
+	m.probeMultishot(IOModeUring)
+	if kernelAtLeast(t, 5, 13) && !m.multishotPoll {
+		t.Error("multishot poll is not probed since 5.13")
+	}
+	if kernelAtLeast(t, 5, 19) && !m.multishotAccept {
+		t.Error("multishot accept is not probed since 5.19")
+	}
+	if kernelAtLeast(t, 6, 0) && m.bufRing != nil && !m.multishotRecv {
+		t.Error("multishot recv is not probed since 6.0")
+	}
+	if !kernelAtLeast(t, 5, 13) && (m.multishotPoll || m.multishotAccept || m.multishotRecv) {
+		t.Error("multishot is probed before 5.13")
+	}
+
+	// epoll io_uring mode doesn't use multishot op
+	m.multishotAccept, m.multishotRecv, m.multishotPoll = false, false, false
+	m.probeMultishot(IOModeEpollUring)
+	if m.multishotAccept || m.multishotRecv || m.multishotPoll {
+		t.Error("multishot is used in epoll io_uring mode")
+	}
+}
+
+// startMultishotEcho run io_uring echo server with multishot read, return the server side connect of dialed peer
+func startMultishotEcho(t *testing.T, opts ...Option) (*Server, *Conn, net.Conn) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	s, err := NewServer(addr, h, opts...)
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	go s.Run()
+	t.Cleanup(s.Stop)
+
+	peer, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return s, recv(t, h.connects).(*Conn), peer
+}
+
+// echoRoundTrip write msg to peer, read the echo back
+func echoRoundTrip(t *testing.T, peer net.Conn, msg string) {
+	peer.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := peer.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != msg {
+		t.Fatalf("echo %q err %v; want %q", buf, err, msg)
+	}
+}
+
+// waitDisabled wait multishot flag is cleared by the consume goroutine
+func waitDisabled(t *testing.T, enabled func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for enabled() {
+		if time.Now().After(deadline) {
+			t.Fatal("multishot is not disabled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMultishotPollFallback(t *testing.T) {
+	s, c, peer := startMultishotEcho(t, WithIoMode(IOModeUringPoll))
+	ring := s.GetIoUring(c.fd)
+	if !ring.multishotPoll {
+		t.Skip("multishot poll is not supported")
+	}
+	echoRoundTrip(t, peer, "multishot")
+
+	// kernel without multishot poll flag complete the op with -EINVAL
+	s.handleEvent(&eventInfo{fd: c.fd, etype: ETypePollInRead, cqe: gouring.IoUringCqe{Res: -int32(syscall.EINVAL)}})
+	waitDisabled(t, func() bool { return ring.multishotPoll })
+	echoRoundTrip(t, peer, "one-shot")
+	if c.IsClosed() {
+		t.Fatal("connect is closed by -EINVAL poll complete")
+	}
+}
+
+func TestMultishotRecvFallback(t *testing.T) {
+	s, c, peer := startMultishotEcho(t, WithIoMode(IOModeUring), WithIoUringBufRing(64))
+	ring := s.GetIoUring(c.fd)
+	if !ring.multishotRecv {
+		t.Skip("multishot recv is not supported")
+	}
+	echoRoundTrip(t, peer, "multishot")
+
+	s.handleEvent(&eventInfo{fd: c.fd, etype: ETypeRead, cb: c.getBufRingReadCallback(ring), cqe: gouring.IoUringCqe{Res: -int32(syscall.EINVAL)}})
+	waitDisabled(t, func() bool { return ring.multishotRecv })
+	echoRoundTrip(t, peer, "one-shot")
+	if c.IsClosed() {
+		t.Fatal("connect is closed by -EINVAL recv complete")
+	}
+}
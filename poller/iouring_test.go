@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ii64/gouring"
+)
+
+// waitEventInfos wait n complete event infos of ring
+func waitEventInfos(t *testing.T, m *ioUring, n int) (infos []*eventInfo) {
+	t.Helper()
+	for i := 0; len(infos) < n && i < 100; i++ {
+		info, err := m.waitEventInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info != nil {
+			m.cqeDone(info.cqe)
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) != n {
+		t.Fatalf("complete events %d; want %d", len(infos), n)
+	}
+	return
+}
+
+func TestIoUringSubmitBacklog(t *testing.T) {
+	m, err := newIoUring(4, &gouring.IoUringParams{})
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	nop := func(sqe *gouring.IoUringSqe) { gouring.PrepNop(sqe) }
+
+	// op queued when sq was full is submitted before later op
+	queued := &eventInfo{fd: 1, etype: ETypeWrite, cb: noOpsEventCb}
+	m.subLock.Lock()
+	m.backlog = append(m.backlog, sqeOp{info: queued, prep: nop})
+	m.subLock.Unlock()
+	later := &eventInfo{fd: 2, etype: ETypeWrite, cb: noOpsEventCb}
+	if _, err = m.submitOp(later, nop); err != nil {
+		t.Fatal(err)
+	}
+	infos := waitEventInfos(t, m, 2)
+	if infos[0].fd != 1 || infos[1].fd != 2 {
+		t.Fatalf("complete fds %d %d; want submit order 1 2", infos[0].fd, infos[1].fd)
+	}
+	if st := m.stats(); st.Backlog != 0 || st.InFlight != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	// more ops than sq entries are all submitted
+	for i := 0; i < 16; i++ {
+		if _, err = m.submitOp(&eventInfo{fd: i, etype: ETypeWrite, cb: noOpsEventCb}, nop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitEventInfos(t, m, 16)
+
+	m.CloseRing()
+	if _, err = m.submitOp(later, nop); err != ErrIOUringClosed {
+		t.Fatalf("submit after close err %v; want %v", err, ErrIOUringClosed)
+	}
+}
+
+func TestSendFileSplice(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	f, err := ioutil.TempFile("", "poller-splice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	head, tail := []byte("head"), []byte("tail")
+	want := append(append(append(append([]byte{}, head...), content...), content[100:200]...), tail...)
+	errs := make(chan error, 1)
+	h := &funcHandler{onMessage: func(c *Conn, b []byte) {
+		// bytes and file chunks are queued, sent by send and splice ops in order
+		c.Write(head)
+		err := c.SendFile(f, 0, int64(len(content)))
+		if err == nil {
+			err = c.SendFile(f, 100, 100)
+		}
+		c.Write(tail)
+		errs <- err
+	}}
+	addr := freeAddr(t)
+	s, err := NewServer(addr, h, WithIoMode(IOModeUring))
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	go s.Run()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("get"))
+	if e := recv(t, errs); e != nil {
+		t.Fatal(e)
+	}
+	out := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = io.ReadFull(conn, out); err != nil || !bytes.Equal(out, want) {
+		t.Fatalf("read err %v, equal %v; want %d bytes", err, bytes.Equal(out, want), len(want))
+	}
+}
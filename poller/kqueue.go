@@ -155,6 +155,32 @@ func addReadEventFD(pollerFD, fd int) (err error) {
 	return
 }
 
+// modEventFD
+// enable/disable read and write filter of fd
+func addWriteEventFD(pollerFD, fd int) (err error) {
+	_, err = unix.Kevent(pollerFD, []unix.Kevent_t{
+		{Ident: uint64(fd), Filter: int16(EVFILT_WRITE), Flags: EV_ADD | EV_CLEAR | EV_ENABLE},
+	}, nil, nil)
+
+	return
+}
+
+func modEventFD(pollerFD, fd int, read, write bool) (err error) {
+	readFlags, writeFlags := uint16(EV_ADD|EV_CLEAR|EV_DISABLE), uint16(EV_ADD|EV_CLEAR|EV_DISABLE)
+	if read {
+		readFlags = EV_ADD | EV_CLEAR | EV_ENABLE
+	}
+	if write {
+		writeFlags = EV_ADD | EV_CLEAR | EV_ENABLE
+	}
+	_, err = unix.Kevent(pollerFD, []unix.Kevent_t{
+		{Ident: uint64(fd), Filter: int16(EVFILT_READ), Flags: readFlags},
+		{Ident: uint64(fd), Filter: int16(EVFILT_WRITE), Flags: writeFlags},
+	}, nil, nil)
+
+	return
+}
+
 func delEventFD(pollerFD, fd int) (err error) {
 	_, err = unix.Kevent(pollerFD,
 		[]unix.Kevent_t{{Ident: uint64(fd), Flags: EV_DELETE}},
@@ -177,6 +203,8 @@ func getEvents(pollerFD int) ([]event, error) {
 		}
 		if kEvents[i].Flags == EV_EOF {
 			event.Type = ETypeClose
+		} else if kEvents[i].Filter == EVFILT_WRITE {
+			event.Type = ETypeOut
 		} else {
 			event.Type = ETypeIn
 		}
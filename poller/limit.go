@@ -0,0 +1,182 @@
+package poller
+
+import (
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/log"
+)
+
+// connLimits
+// connect admission of server (shared by reactors): max connects and max connects per source ip
+type connLimits struct {
+	lock     sync.Mutex
+	maxConns int
+	maxPerIP int
+	conns    int
+	perIP    map[string]int
+}
+
+// newConnLimits
+func newConnLimits(maxConns, maxPerIP int) *connLimits {
+	return &connLimits{
+		maxConns: maxConns,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// newServerConnLimits
+// nil if no admission limit option
+func newServerConnLimits(o *options) *connLimits {
+	if o.maxConns <= 0 && o.maxConnsPerIP <= 0 {
+		return nil
+	}
+	return newConnLimits(o.maxConns, o.maxConnsPerIP)
+}
+
+// admit
+// count connect from ip (empty for unix socket peer), false if exceed limits
+func (l *connLimits) admit(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		return false
+	}
+	if l.maxPerIP > 0 && ip != "" && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.conns++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	return true
+}
+
+// release
+// admitted connect closed
+func (l *connLimits) release(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.conns--
+	if ip == "" {
+		return
+	}
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+		return
+	}
+	l.perIP[ip]--
+}
+
+// admit
+// check accepted connect fd by admission limits, rejected fd is closed after reject response written;
+// return admitted source ip for release
+func (s *Server) admit(cfd int, sa syscall.Sockaddr) (ip string, ok bool) {
+	if s.limits == nil {
+		return "", true
+	}
+	ip = sockaddrIP(sa)
+	if s.limits.admit(ip) {
+		return ip, true
+	}
+
+	atomic.AddInt64(&s.stats.rejected, 1)
+	if resp := s.options.rejectResponse; len(resp) > 0 {
+		// best effort, accepted socket send buffer is empty
+		syscall.Write(cfd, resp)
+	}
+	syscall.Close(cfd)
+	log.Warnf("reject connect fd %d addr %s, exceed max connects %d or max connects per ip %d",
+		cfd, getAddr(sa), s.options.maxConns, s.options.maxConnsPerIP)
+	return "", false
+}
+
+// sockaddrIP
+// source ip of tcp peer, empty for unix socket peer
+func sockaddrIP(sa syscall.Sockaddr) string {
+	switch addr := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(addr.Addr[:]).String()
+	case *syscall.SockaddrInet6:
+		return net.IP(addr.Addr[:]).String()
+	}
+	return ""
+}
+
+// tokenBucket
+// token bucket refilled by elapsed time; tokens may go negative when frames of one read are taken at once,
+// the debt is paid by pausing read
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64 // max tokens
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// take
+// take n tokens, return wait duration until tokens are not negative
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// initRateLimit
+// per connect message and byte token buckets by server options
+func (c *Conn) initRateLimit(o *options) {
+	if o.msgRate > 0 {
+		c.msgBucket = newTokenBucket(o.msgRate, o.msgBurst)
+	}
+	if o.byteRate > 0 {
+		c.byteBucket = newTokenBucket(o.byteRate, o.byteBurst)
+	}
+}
+
+// throttle
+// take tokens of filtered messages and bytes, pause read until the buckets are refilled,
+// peer is throttled by tcp flow control; called in connect event goroutine
+func (c *Conn) throttle(msgs, bytes int) {
+	if (msgs == 0 && bytes == 0) || c.IsClosed() {
+		return
+	}
+	now := time.Now()
+	var wait time.Duration
+	if c.msgBucket != nil && msgs > 0 {
+		wait = c.msgBucket.take(msgs, now)
+	}
+	if c.byteBucket != nil && bytes > 0 {
+		if d := c.byteBucket.take(bytes, now); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return
+	}
+
+	c.pauseRead(readPauseByThrottle)
+	c.lock.Lock()
+	if c.throttleTimer != nil {
+		c.throttleTimer.Stop()
+	}
+	c.throttleTimer = c.server.timingWheel.AfterFunc(wait, func() {
+		if c.IsClosed() {
+			return
+		}
+		c.resumeRead(readPauseByThrottle)
+	})
+	c.lock.Unlock()
+}
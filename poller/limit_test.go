@@ -0,0 +1,180 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 5)
+	now := b.last
+	tests := []struct {
+		elapsed time.Duration
+		take    int
+		wait    time.Duration
+	}{
+		{0, 5, 0},                                          // burst
+		{0, 1, 100 * time.Millisecond},                     // debt of 1 token
+		{100 * time.Millisecond, 0, 0},                     // debt paid
+		{50 * time.Millisecond, 3, 250 * time.Millisecond}, // refilled 0.5 token
+		{time.Hour, 5, 0},                                  // refill is capped by burst
+		{0, 10, time.Second},                               // frames of one read taken at once
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.elapsed)
+		wait := b.take(tt.take, now)
+		if d := wait - tt.wait; d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("%d: take(%d) after %s wait %s; want %s", i, tt.take, tt.elapsed, wait, tt.wait)
+		}
+	}
+}
+
+func TestConnLimits(t *testing.T) {
+	l := newConnLimits(3, 2)
+	for i, ip := range []string{"10.0.0.1", "10.0.0.1", ""} {
+		if !l.admit(ip) {
+			t.Fatalf("%d: admit(%q) = false", i, ip)
+		}
+	}
+	if l.admit("10.0.0.2") {
+		t.Fatal("admit() over max connects")
+	}
+	l.release("")
+	if l.admit("10.0.0.1") {
+		t.Fatal("admit() over max connects per ip")
+	}
+	// unix socket peer is not limited per ip
+	if !l.admit("") {
+		t.Fatal("admit() unix socket peer = false")
+	}
+
+	l.release("10.0.0.1")
+	l.release("10.0.0.1")
+	if _, ok := l.perIP["10.0.0.1"]; ok || l.conns != 1 {
+		t.Fatalf("after release conns %d per ip %v", l.conns, l.perIP)
+	}
+}
+
+// dialFrom dial address from local ip
+func dialFrom(t *testing.T, ip, address string) net.Conn {
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}, Timeout: time.Second}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readRejected read rejected connect until closed by server
+func readRejected(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("rejected connect read err %v; want closed", err)
+	}
+	return b
+}
+
+func TestMaxConns(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	resp := []byte("busy\n")
+	s := startServer(t, addr, h, WithMaxConns(1), WithRejectResponse(resp))
+
+	first := dialFrom(t, "127.0.0.1", addr)
+	recv(t, h.connects)
+	if b := readRejected(t, dialFrom(t, "127.0.0.1", addr)); !bytes.Equal(b, resp) {
+		t.Fatalf("rejected connect read %q; want %q", b, resp)
+	}
+	if st := s.Stats(); st.Rejected != 1 || st.ConnsNum != 1 {
+		t.Fatalf("stats rejected %d conns %d", st.Rejected, st.ConnsNum)
+	}
+
+	// closed connect is released
+	first.Close()
+	recv(t, h.closes)
+	dialFrom(t, "127.0.0.1", addr)
+	recv(t, h.connects)
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	s := startServer(t, addr, h, WithMaxConnsPerIP(1))
+
+	dialFrom(t, "127.0.0.1", addr)
+	recv(t, h.connects)
+	// closed immediately without reject response
+	if b := readRejected(t, dialFrom(t, "127.0.0.1", addr)); len(b) != 0 {
+		t.Fatalf("rejected connect read %q", b)
+	}
+	dialFrom(t, "127.0.0.2", addr)
+	recv(t, h.connects)
+	if st := s.Stats(); st.Rejected != 1 || st.ConnsNum != 2 {
+		t.Fatalf("stats rejected %d conns %d", st.Rejected, st.ConnsNum)
+	}
+}
+
+// recvBytes wait handler messages until n bytes received
+func recvBytes(t *testing.T, h *testHandler, n int) {
+	t.Helper()
+	for got := 0; got < n; {
+		got += len(recv(t, h.msgs).([]byte))
+	}
+}
+
+func TestMsgRateLimit(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(false)
+	startServer(t, addr, h, WithDecoder(NewLineDecoder(64)), WithMsgRateLimit(20, 5))
+
+	conn := dialFrom(t, "127.0.0.1", addr)
+	recv(t, h.connects)
+	start := time.Now()
+	// 5 by burst, the debt of 15 more lines pauses read 0.75s
+	conn.Write(bytes.Repeat([]byte("ping\n"), 20))
+	for i := 0; i < 20; i++ {
+		recv(t, h.msgs)
+	}
+	conn.Write([]byte("ping\n"))
+	recv(t, h.msgs)
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("21 messages handled in %s; want throttled to 20/s", elapsed)
+	}
+}
+
+func TestByteRateLimit(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		addr := freeAddr(t)
+		h := newTestHandler(false)
+		opts := []Option{WithByteRateLimit(10000, 1000)}
+		if useTLS {
+			opts = append(opts, WithTLSConfig(testTLSConfig(t)))
+		}
+		startServer(t, addr, h, opts...)
+
+		var conn net.Conn = dialFrom(t, "127.0.0.1", addr)
+		if useTLS {
+			conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		}
+		start := time.Now()
+		// 1000 bytes by burst, the debt of 5000 bytes pauses read 0.5s
+		if _, err := conn.Write(bytes.Repeat([]byte("x"), 6000)); err != nil {
+			t.Fatal(err)
+		}
+		recv(t, h.connects)
+		recvBytes(t, h, 6000)
+		if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+			t.Fatalf("tls %v: 6000 bytes read in %s; want throttled to 10000 bytes/s", useTLS, elapsed)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package poller
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// listenFDsStart the first passed file descriptor of socket activation (SD_LISTEN_FDS_START)
+	listenFDsStart = 3
+)
+
+var (
+	ErrNotListenSocket = errors.New("fd is not a listening stream socket")
+
+	listenFDsOnce sync.Once
+	listenFDs     []int
+)
+
+// ListenFDs
+// get the listen fds passed by socket activation (systemd LISTEN_PID/LISTEN_FDS)
+// or parent process hand off, fds start from 3;
+// LISTEN_PID is optional, if set it must be the current pid.
+// env is unset after the first call, so child processes don't inherit it again.
+func ListenFDs() []int {
+	listenFDsOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+
+		if pid := os.Getenv("LISTEN_PID"); pid != "" {
+			p, err := strconv.Atoi(pid)
+			if err != nil || p != os.Getpid() {
+				return
+			}
+		}
+
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return
+		}
+
+		for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+			syscall.CloseOnExec(fd)
+			listenFDs = append(listenFDs, fd)
+		}
+		log.Infof("inherited listen fds %v", listenFDs)
+	})
+
+	return listenFDs
+}
+
+// getListenFD
+// adopt explicit listen fd, or the first socket activation listen fd,
+// otherwise listen address
+func getListenFD(address string, o *options) (int, error) {
+	fd := o.listenFD
+	if fd < 0 && o.socketActivation {
+		if fds := ListenFDs(); len(fds) > 0 {
+			fd = fds[0]
+		}
+	}
+
+	if fd >= 0 {
+		return adoptListenFD(fd)
+	}
+
+	return listen(address, o.listenBacklog, false)
+}
+
+// adoptListenFD
+// check the already open fd is a listening stream socket, keep the listen queue
+func adoptListenFD(fd int) (int, error) {
+	acceptConn, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	if err != nil {
+		return -1, err
+	}
+	soType, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return -1, err
+	}
+	if acceptConn != 1 || soType != syscall.SOCK_STREAM {
+		return -1, ErrNotListenSocket
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return -1, err
+	}
+	log.Infof("server adopt listen %s fd %d", getAddr(sa), fd)
+
+	return fd, nil
+}
@@ -0,0 +1,453 @@
+package poller
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// netConnWriteChunkLen max bytes of one poller connect write from net.Conn Write
+	netConnWriteChunkLen = 64 * 1024
+	// netConnReadBufferMax read is paused if net.Conn buffered bytes not read exceed
+	netConnReadBufferMax = 1024 * 1024
+)
+
+// Listener
+// net.Listener adapter, accept connect by poller server event loop, return net.Conn,
+// eg: http.Server.Serve, grpc.Server.Serve;
+// bytes read by event loop are buffered for net.Conn Read, net.Conn Write queues bytes to the event loop,
+// blocked Read/Write wait event loop notify, no goroutine per connect for io
+type Listener struct {
+	server    *Server
+	addr      net.Addr
+	acceptCh  chan *netConn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen
+// listen address (tcp or unix:) by poller server with options, run server event loop;
+// decoder option is ignored, net.Conn Read gets raw bytes;
+// connects accepted more than listen backlog and not returned by Accept are closed;
+// high watermark is lowered to leave room for a write chunk in write queue
+func Listen(address string, opts ...Option) (*Listener, error) {
+	l := &Listener{
+		closeCh: make(chan struct{}),
+	}
+	opts = append(opts, WithDecoder(nil), newFuncServerOption(limitNetConnWatermark))
+	s, err := NewServer(address, &listenerHandler{l: l}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l.server = s
+	l.acceptCh = make(chan *netConn, s.options.listenBacklog)
+	l.addr = s.listenAddr()
+
+	go s.Run()
+	return l, nil
+}
+
+// Accept
+// wait the next connect accepted by event loop
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case nc := <-l.acceptCh:
+		return nc, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close
+// stop accept, accepted connects are still served,
+// server stops when all accepted connects closed
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		l.server.closeListener()
+		// connects accepted but not returned by Accept
+		for {
+			select {
+			case nc := <-l.acceptCh:
+				nc.Close()
+				continue
+			default:
+			}
+			break
+		}
+		go l.stopAfterDrained()
+	})
+	return nil
+}
+
+// Addr
+// listen address
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Server
+// poller server of listener, eg: Shutdown
+func (l *Listener) Server() *Server {
+	return l.server
+}
+
+// stopAfterDrained
+// stop server after all accepted connects closed (by net.Conn Close or peer)
+func (l *Listener) stopAfterDrained() {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for l.server.GetConnsNum() > 0 {
+		<-ticker.C
+	}
+	l.server.Stop()
+}
+
+// listenerHandler
+// poller Handler of Listener, bridge poller connect to net.Conn
+type listenerHandler struct {
+	l *Listener
+}
+
+// OnConnect
+// don't block event loop if Accept is slow, close the connect if accept queue is full
+func (h *listenerHandler) OnConnect(c *Conn) {
+	nc := newNetConn(c)
+	c.SetData(nc)
+	select {
+	case <-h.l.closeCh:
+		c.Close()
+		return
+	default:
+	}
+	select {
+	case h.l.acceptCh <- nc:
+	default:
+		log.Warnf("listener accept queue is full, close connect %s", c.GetAddr())
+		atomic.AddInt64(&c.server.stats.rejected, 1)
+		c.Close()
+	}
+}
+
+func (h *listenerHandler) OnMessage(c *Conn, bytes []byte) {
+	if nc, ok := c.GetData().(*netConn); ok {
+		nc.feed(bytes)
+	}
+}
+
+func (h *listenerHandler) OnClose(c *Conn, err error) {
+	if nc, ok := c.GetData().(*netConn); ok {
+		nc.closeWithErr(err)
+	}
+}
+
+func (h *listenerHandler) OnHighWatermark(c *Conn) {}
+
+func (h *listenerHandler) OnLowWatermark(c *Conn) {
+	if nc, ok := c.GetData().(*netConn); ok {
+		nc.notify(nc.writable)
+	}
+}
+
+// netConn
+// net.Conn of poller connect
+type netConn struct {
+	c          *Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	lock          sync.Mutex
+	buf           bytes.Buffer  // bytes read by event loop, not read by Read
+	err           error         // connect closed err, Read returns after buffered bytes
+	closed        bool          // closed by Close
+	readPaused    bool          // poller connect read paused, buffered bytes exceed max
+	readDeadline  time.Time     // Read deadline, zero: none
+	writeDeadline time.Time     // Write deadline, zero: none
+	readable      chan struct{} // notify blocked Read
+	writable      chan struct{} // notify blocked Write
+}
+
+// newNetConn
+func newNetConn(c *Conn) *netConn {
+	nc := &netConn{
+		c:        c,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+	if sa, err := syscall.Getsockname(c.fd); err == nil {
+		nc.localAddr = sockaddrToNetAddr(sa)
+	}
+	if h := c.ProxyHeader(); h != nil && h.SrcAddr != nil {
+		nc.remoteAddr = h.SrcAddr
+	} else if sa, err := syscall.Getpeername(c.fd); err == nil {
+		nc.remoteAddr = sockaddrToNetAddr(sa)
+	}
+	return nc
+}
+
+// notify
+// non block notify waiting Read/Write
+func (nc *netConn) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// feed
+// bytes read by event loop, pause read if buffered bytes exceed max until Read
+func (nc *netConn) feed(bytes []byte) {
+	nc.lock.Lock()
+	nc.buf.Write(bytes)
+	pause := !nc.readPaused && nc.buf.Len() > netConnReadBufferMax
+	if pause {
+		nc.readPaused = true
+	}
+	nc.lock.Unlock()
+
+	if pause {
+		nc.c.pauseRead(readPauseByNetConn)
+	}
+	nc.notify(nc.readable)
+}
+
+// closeWithErr
+// poller connect closed, peer close is io.EOF
+func (nc *netConn) closeWithErr(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	nc.lock.Lock()
+	if nc.err == nil {
+		nc.err = err
+	}
+	nc.lock.Unlock()
+	nc.notify(nc.readable)
+	nc.notify(nc.writable)
+}
+
+// Read
+// read buffered bytes, wait event loop feed if empty
+func (nc *netConn) Read(b []byte) (n int, err error) {
+	for {
+		nc.lock.Lock()
+		if nc.closed {
+			nc.lock.Unlock()
+			return 0, net.ErrClosed
+		}
+		if nc.buf.Len() > 0 {
+			n, _ = nc.buf.Read(b)
+			resume := nc.readPaused && nc.buf.Len() <= netConnReadBufferMax/2
+			if resume {
+				nc.readPaused = false
+			}
+			nc.lock.Unlock()
+			if resume {
+				nc.c.resumeRead(readPauseByNetConn)
+			}
+			return
+		}
+		if nc.err != nil {
+			err = nc.err
+			nc.lock.Unlock()
+			return 0, err
+		}
+		deadline := nc.readDeadline
+		nc.lock.Unlock()
+
+		err = nc.wait(nc.readable, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write
+// queue bytes to poller connect by chunk, wait drain to low watermark if reach high watermark
+// or write queue is full (high watermark is reached before)
+func (nc *netConn) Write(b []byte) (n int, err error) {
+	chunkLen := netConnWriteChunk(nc.c.server.options)
+	for n < len(b) {
+		nc.lock.Lock()
+		closed, connErr, deadline := nc.closed, nc.err, nc.writeDeadline
+		nc.lock.Unlock()
+		if closed {
+			return n, net.ErrClosed
+		}
+		if connErr != nil {
+			return n, connErr
+		}
+
+		if nc.c.GetPendingWriteLen() >= nc.c.server.options.highWatermark {
+			err = nc.wait(nc.writable, deadline)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		end := n + chunkLen
+		if end > len(b) {
+			end = len(b)
+		}
+		_, err = nc.c.Write(b[n:end])
+		if err == ErrWriteQueueFull {
+			// concurrent Write fill the queue
+			err = nc.wait(nc.writable, deadline)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+		n = end
+	}
+	return
+}
+
+// wait
+// wait notify until deadline
+func (nc *netConn) wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// Close
+// close poller connect after queued bytes written
+func (nc *netConn) Close() error {
+	nc.lock.Lock()
+	if nc.closed {
+		nc.lock.Unlock()
+		return net.ErrClosed
+	}
+	nc.closed = true
+	nc.lock.Unlock()
+	nc.notify(nc.readable)
+	nc.notify(nc.writable)
+
+	nc.c.CloseAfterFlush()
+	return nil
+}
+
+func (nc *netConn) LocalAddr() net.Addr {
+	return nc.localAddr
+}
+
+func (nc *netConn) RemoteAddr() net.Addr {
+	return nc.remoteAddr
+}
+
+// SetDeadline
+// Read/Write return os.ErrDeadlineExceeded after t, connect is not closed; zero time cancel
+func (nc *netConn) SetDeadline(t time.Time) error {
+	nc.lock.Lock()
+	nc.readDeadline = t
+	nc.writeDeadline = t
+	nc.lock.Unlock()
+	nc.notify(nc.readable)
+	nc.notify(nc.writable)
+	return nil
+}
+
+func (nc *netConn) SetReadDeadline(t time.Time) error {
+	nc.lock.Lock()
+	nc.readDeadline = t
+	nc.lock.Unlock()
+	nc.notify(nc.readable)
+	return nil
+}
+
+func (nc *netConn) SetWriteDeadline(t time.Time) error {
+	nc.lock.Lock()
+	nc.writeDeadline = t
+	nc.lock.Unlock()
+	nc.notify(nc.writable)
+	return nil
+}
+
+// netConnWriteChunk
+// write chunk len of net.Conn Write, at most a quarter of write queue len
+func netConnWriteChunk(o *options) int {
+	if n := (o.writeQueueLen + 3) / 4; n < netConnWriteChunkLen {
+		return n
+	}
+	return netConnWriteChunkLen
+}
+
+// limitNetConnWatermark
+// net.Conn Write waits low watermark notify at high watermark, a chunk written under high watermark
+// must fit in write queue, otherwise ErrWriteQueueFull without watermark notify
+func limitNetConnWatermark(o *options) {
+	max := o.writeQueueLen - netConnWriteChunk(o) + 1
+	if o.highWatermark <= max {
+		return
+	}
+	o.highWatermark = max
+	if o.lowWatermark >= o.highWatermark {
+		o.lowWatermark = o.highWatermark / 2
+	}
+}
+
+// closeListener
+// stop accept and close listen fd of server or each reactor, accepted connects are still served
+func (s *Server) closeListener() {
+	if s.reactors != nil {
+		for _, r := range s.reactors {
+			r.closeListener()
+		}
+		return
+	}
+	s.stopAccept()
+	s.closeListenFD()
+}
+
+// listenAddr
+// address of listen fd
+func (s *Server) listenAddr() net.Addr {
+	lfd := s.listenFD
+	if len(s.reactors) != 0 {
+		lfd = s.reactors[0].listenFD
+	}
+	sa, err := syscall.Getsockname(lfd)
+	if err != nil {
+		return nil
+	}
+	return sockaddrToNetAddr(sa)
+}
+
+// sockaddrToNetAddr
+func sockaddrToNetAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+//go:build linux
+// +build linux
+
+package poller_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/weedge/lib/poller"
+)
+
+// listen poller listener on loopback until test cleanup
+func listen(t *testing.T, opts ...poller.Option) *poller.Listener {
+	l, err := poller.Listen("127.0.0.1:0", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestListenerHTTPServe(t *testing.T) {
+	// high watermark is not under write queue len, Write must wait instead of spin
+	l := listen(t, poller.WithWriteQueueLen(128*1024), poller.WithWriteWatermark(1024*1024, 256*1024))
+	big := payload(4 * 1024 * 1024)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/big" {
+			w.Write(big)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	url := "http://" + l.Addr().String()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post(url+"/echo", "text/plain", bytes.NewReader([]byte("ping")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ping" {
+			t.Fatalf("response %d %q", resp.StatusCode, body)
+		}
+	}
+
+	resp, err := client.Get(url + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(body, big) {
+		t.Fatalf("big response %d bytes err %v; want %d", len(body), err, len(big))
+	}
+}
+
+// acceptPair dial listener, return accepted server side net.Conn and the peer
+func acceptPair(t *testing.T, l *poller.Listener) (net.Conn, net.Conn) {
+	peer, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, peer
+}
+
+func TestNetConnDeadline(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		c, peer := acceptPair(t, listen(t))
+		buf := make([]byte, 16)
+
+		c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		if _, err := c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Read() err %v; want %v", err, os.ErrDeadlineExceeded)
+		}
+
+		// connect is usable after deadline reset
+		c.SetReadDeadline(time.Time{})
+		peer.Write([]byte("late"))
+		if n, err := c.Read(buf); err != nil || string(buf[:n]) != "late" {
+			t.Fatalf("Read() %q err %v", buf[:n], err)
+		}
+	})
+	t.Run("deadline in past", func(t *testing.T) {
+		c, _ := acceptPair(t, listen(t))
+		c.SetDeadline(time.Now().Add(-time.Second))
+		if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Read() err %v; want %v", err, os.ErrDeadlineExceeded)
+		}
+		if _, err := c.Write(payload(8 * 1024 * 1024)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Write() err %v; want %v", err, os.ErrDeadlineExceeded)
+		}
+	})
+	t.Run("write to slow peer", func(t *testing.T) {
+		c, _ := acceptPair(t, listen(t, poller.WithWriteQueueLen(256*1024)))
+		c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		n, err := c.Write(payload(64 * 1024 * 1024))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Write() = %d err %v; want %v", n, err, os.ErrDeadlineExceeded)
+		}
+	})
+}
+
+func TestListenerAcceptQueueFull(t *testing.T) {
+	l := listen(t, poller.WithListenBacklog(1))
+	addr := l.Addr().String()
+
+	// accept queue holds one connect, the others are closed without Accept
+	var peers []net.Conn
+	for i := 0; i < 3; i++ {
+		peer, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		peers = append(peers, peer)
+	}
+	closed := 0
+	for _, peer := range peers {
+		peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, err := peer.Read(make([]byte, 1)); err == io.EOF {
+			closed++
+		}
+	}
+	if closed != 2 {
+		t.Fatalf("%d connects closed; want 2", closed)
+	}
+	if _, err := l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,14 +2,21 @@ package poller
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/weedge/lib/log"
+	"golang.org/x/sys/unix"
 )
 
-func listen(address string, backlog int) (listenFD int, err error) {
-	listenFD, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+func listen(address string, backlog int, reusePort bool) (listenFD int, err error) {
+	if strings.HasPrefix(address, UnixAddrPrefix) {
+		return listenUnix(strings.TrimPrefix(address, UnixAddrPrefix), backlog)
+	}
+
+	listenFD, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		log.Error(err)
 		return
@@ -21,6 +28,15 @@ func listen(address string, backlog int) (listenFD int, err error) {
 		return
 	}
 
+	// each reactor listen the same address, kernel balances new connects
+	if reusePort {
+		err = syscall.SetsockoptInt(listenFD, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
 	addr, port, err := GetIPPort(address)
 	if err != nil {
 		return
@@ -46,20 +62,147 @@ func listen(address string, backlog int) (listenFD int, err error) {
 }
 
 func accept(listenFD int, d time.Duration) (nfd int, sa syscall.Sockaddr, err error) {
-	// block accept
-	nfd, sa, err = syscall.Accept(listenFD)
+	// block accept, close on exec so hand off child does not hold connects
+	nfd, sa, err = syscall.Accept4(listenFD, syscall.SOCK_CLOEXEC)
+	if err != nil {
+		return
+	}
+
+	err = setConnectOptionByAddr(nfd, sa, d)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// startConnect
+// non block connect to address, connect is in progress if connected is false,
+// wait connect fd writable in event loop then check connect result by connectResult
+func startConnect(address string, d time.Duration) (fd int, connected bool, err error) {
+	domain, sa, err := getDialSockaddr(address)
+	if err != nil {
+		return
+	}
+
+	fd, err = syscall.Socket(domain, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			syscall.Close(fd)
+		}
+	}()
 
-	err = setConnectOption(nfd, d)
+	err = setConnectOptionByAddr(fd, sa, d)
 	if err != nil {
 		return
 	}
 
+	err = syscall.Connect(fd, sa)
+	if err == nil {
+		connected = true
+		return
+	}
+	if err == syscall.EINPROGRESS || err == syscall.EAGAIN {
+		err = nil
+	}
 	return
 }
 
+// connectResult
+// check connect result of writable connect fd
+func connectResult(fd int) error {
+	soErr, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if soErr != 0 {
+		return syscall.Errno(soErr)
+	}
+	return nil
+}
+
+// getDialSockaddr
+// resolve dial address to socket domain and address, support unix:// address
+func getDialSockaddr(address string) (domain int, sa syscall.Sockaddr, err error) {
+	if strings.HasPrefix(address, UnixAddrPrefix) {
+		return syscall.AF_UNIX, &syscall.SockaddrUnix{Name: strings.TrimPrefix(address, UnixAddrPrefix)}, nil
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return
+	}
+	sa4 := &syscall.SockaddrInet4{Port: tcpAddr.Port}
+	copy(sa4.Addr[:], tcpAddr.IP.To4())
+
+	return syscall.AF_INET, sa4, nil
+}
+
+// waitAcceptable
+// poll listen fd readable (new connect) or wake up pipe readable (stop accept)
+func waitAcceptable(listenFD, wakeFD int) (err error) {
+	fds := []unix.PollFd{
+		{Fd: int32(listenFD), Events: unix.POLLIN},
+		{Fd: int32(wakeFD), Events: unix.POLLIN},
+	}
+	_, err = unix.Poll(fds, -1)
+	return
+}
+
+// newWakePipe
+// non block pipe, write end to wake up poller looper/acceptor wait read end readable
+func newWakePipe(fds *[2]int) (err error) {
+	err = syscall.Pipe(fds[:])
+	if err != nil {
+		return
+	}
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+		err = syscall.SetNonblock(fd, true)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// waitWritable
+// poll fd writable event until timeout (ETIMEDOUT), timeout <= 0 wait forever
+func waitWritable(fd int, timeout time.Duration) (err error) {
+	msec := -1
+	if timeout > 0 {
+		msec = int(timeout / time.Millisecond)
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	for {
+		var n int
+		n, err = unix.Poll(fds, msec)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			return syscall.ETIMEDOUT
+		}
+		return
+	}
+}
+
+// setConnectOptionByAddr
+// unix domain socket connect just set non block, no tcp options
+func setConnectOptionByAddr(nfd int, sa syscall.Sockaddr, d time.Duration) (err error) {
+	if _, ok := sa.(*syscall.SockaddrUnix); ok {
+		return syscall.SetNonblock(nfd, true)
+	}
+
+	return setConnectOption(nfd, d)
+}
+
 func setConnectOption(nfd int, d time.Duration) (err error) {
 	// set connect fd non bolock
 	err = syscall.SetNonblock(nfd, true)
@@ -96,27 +239,33 @@ func addReadEvent(pollerFD, fd int) (err error) {
 
 func closeFD(pollerFD, fd int) (err error) {
 	if pollerFD > 0 {
+		// io_uring mode connect fd is not in poller, still close fd
 		err = delEventFD(pollerFD, fd)
-		if err != nil {
-			return
-		}
 	}
 
-	err = syscall.Close(fd)
-	if err != nil {
-		return
+	closeErr := syscall.Close(fd)
+	if closeErr != nil {
+		err = closeErr
 	}
 
 	return
 }
 
 func getAddr(sa syscall.Sockaddr) string {
-	addr, ok := sa.(*syscall.SockaddrInet4)
-	if !ok {
-		return ""
+	switch addr := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return fmt.Sprintf("%d.%d.%d.%d:%d", addr.Addr[0], addr.Addr[1], addr.Addr[2], addr.Addr[3], addr.Port)
+	case *syscall.SockaddrInet6:
+		return net.JoinHostPort(net.IP(addr.Addr[:]).String(), fmt.Sprint(addr.Port))
+	case *syscall.SockaddrUnix:
+		// unnamed peer socket, syscall rewrite leading NUL as @
+		if addr.Name == "@" || addr.Name == "" {
+			return unnamedUnixAddr()
+		}
+		return UnixAddrPrefix + addr.Name
 	}
 
-	return fmt.Sprintf("%d.%d.%d.%d:%d", addr.Addr[0], addr.Addr[1], addr.Addr[2], addr.Addr[3], addr.Port)
+	return ""
 }
 
 func closeFDRead(fd int) error {
@@ -0,0 +1,82 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/weedge/lib/log"
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr struct mmsghdr for recvmmsg/sendmmsg
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32 // Number of received/sent bytes for header
+}
+
+func listenUDP(address string) (fd int, err error) {
+	fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			syscall.Close(fd)
+		}
+	}()
+
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	addr, port, err := GetIPPort(address)
+	if err != nil {
+		return
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrInet4{
+		Port: port,
+		Addr: addr,
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = syscall.SetNonblock(fd, true)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Infof("udp server bind port %d fd %d", port, fd)
+
+	return
+}
+
+// recvmmsg
+// receive multiple datagrams from socket fd by one syscall
+func recvmmsg(fd int, hdrs []mmsghdr, flags int) (int, error) {
+	n, _, e := syscall.Syscall6(unix.SYS_RECVMMSG, uintptr(fd),
+		uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), uintptr(flags), 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
+
+// sendmmsg
+// send multiple datagrams to socket fd by one syscall
+func sendmmsg(fd int, hdrs []mmsghdr, flags int) (int, error) {
+	n, _, e := syscall.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+		uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), uintptr(flags), 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
@@ -0,0 +1,94 @@
+package poller
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// UnixAddrPrefix unix domain socket address prefix,
+	// eg: unix:///tmp/sidecar.sock, abstract namespace unix://@sidecar,
+	// unnamed peer socket unix://#1 with process wide seq
+	UnixAddrPrefix = "unix://"
+)
+
+// unnamedUnixSeq unnamed unix peer socket seq
+var unnamedUnixSeq uint64
+
+// unnamedUnixAddr
+// unnamed peers (client not bind) have no address, number them to be distinguishable
+func unnamedUnixAddr() string {
+	return fmt.Sprintf("%s#%d", UnixAddrPrefix, atomic.AddUint64(&unnamedUnixSeq, 1))
+}
+
+// listenUnix
+// listen unix domain socket path, path with @ prefix is abstract namespace address
+func listenUnix(path string, backlog int) (listenFD int, err error) {
+	if len(path) == 0 {
+		err = syscall.EINVAL
+		return
+	}
+
+	listenFD, err = syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			syscall.Close(listenFD)
+		}
+	}()
+
+	// remove stale socket file left by last process, keep the live one
+	if path[0] != '@' {
+		if fi, statErr := os.Stat(path); statErr == nil && fi.Mode()&os.ModeSocket != 0 {
+			err = removeStaleUnix(path)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		}
+	}
+
+	// syscall.SockaddrUnix rewrite leading @ as NUL for abstract namespace
+	err = syscall.Bind(listenFD, &syscall.SockaddrUnix{Name: path})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = syscall.Listen(listenFD, backlog)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Infof("server listen unix %s fd %d", path, listenFD)
+
+	return
+}
+
+// removeStaleUnix
+// remove socket file only if no one listens on it (connect refused),
+// return EADDRINUSE if it is served by a live process
+func removeStaleUnix(path string) error {
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	err = syscall.Connect(fd, &syscall.SockaddrUnix{Name: path})
+	syscall.Close(fd)
+	switch err {
+	case syscall.ECONNREFUSED:
+		return os.Remove(path)
+	case nil, syscall.EAGAIN:
+		// accepted or backlog is full
+		return syscall.EADDRINUSE
+	}
+	// eg: EACCES, let bind report it
+	return nil
+}
@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenUnixStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	// socket file left without listener
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	fd, err := listenUnix(path, 16)
+	if err != nil {
+		t.Fatalf("listen stale socket file err %v", err)
+	}
+	syscall.Close(fd)
+}
+
+func TestListenUnixLive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "live.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if fd, err := listenUnix(path, 16); err != syscall.EADDRINUSE {
+		syscall.Close(fd)
+		t.Fatalf("listen live socket err %v; want %v", err, syscall.EADDRINUSE)
+	}
+	// live listener is kept
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("live listener is removed: %v", err)
+	}
+	c.Close()
+}
+
+func TestServerUnixUnnamedPeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.sock")
+	h := newTestHandler(false)
+	startServer(t, UnixAddrPrefix+path, h)
+
+	addrs := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		addr := recv(t, h.connects).(*Conn).GetAddr()
+		if addr == UnixAddrPrefix || addrs[addr] {
+			t.Fatalf("unnamed peer addr %q is not distinguishable", addr)
+		}
+		addrs[addr] = true
+	}
+}
@@ -0,0 +1,106 @@
+package netpoll
+
+import (
+	"sync"
+
+	"github.com/weedge/lib/pool/workerpool"
+)
+
+// dispatchPoller implements Poller interface over os-dependent poller,
+// callbacks of ready descriptors are dispatched to the worker pool instead of
+// running on the goroutine waiting for events.
+//
+// Descriptors are observed with EventOneShot and resumed after the callback
+// returns, so callbacks of one descriptor never run concurrently and a slow
+// callback does not stall other descriptors of the poller.
+type dispatchPoller struct {
+	Poller
+	pool    *workerpool.WorkerPool
+	onError func(error)
+
+	mu    sync.Mutex
+	descs map[*Desc]struct{}
+}
+
+// dispatchEvent is the worker pool task param of one ready descriptor.
+type dispatchEvent struct {
+	desc  *Desc
+	cb    CallbackFn
+	event Event
+}
+
+func newDispatchPoller(p Poller, pool *workerpool.WorkerPool, onError func(error)) *dispatchPoller {
+	return &dispatchPoller{
+		Poller:  p,
+		pool:    pool,
+		onError: onError,
+		descs:   make(map[*Desc]struct{}),
+	}
+}
+
+// Start implements Poller.Start() method.
+//
+// Note that desc is switched to EventOneShot mode, level-triggered and
+// edge-triggered descriptors are resumed automatically after cb returns.
+func (p *dispatchPoller) Start(desc *Desc, cb CallbackFn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, has := p.descs[desc]; has {
+		return ErrRegistered
+	}
+
+	desc.event |= EventOneShot
+	err := p.Poller.Start(desc, func(event Event) {
+		p.dispatch(&dispatchEvent{desc: desc, cb: cb, event: event})
+	})
+	if err != nil {
+		return err
+	}
+	p.descs[desc] = struct{}{}
+	return nil
+}
+
+// Stop implements Poller.Stop() method.
+// Callback running in the worker pool is not resumed after Stop.
+func (p *dispatchPoller) Stop(desc *Desc) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.descs, desc)
+	return p.Poller.Stop(desc)
+}
+
+// dispatch adds ready descriptor task to the worker pool without blocking
+// the goroutine waiting for events, callback is called inline if the poller
+// is closed, the pool is not running or the pool task queue is full.
+// Otherwise the one-shot descriptor would never be resumed.
+func (p *dispatchPoller) dispatch(ev *dispatchEvent) {
+	if ev.event&EventPollerClosed != 0 {
+		ev.cb(ev.event)
+		return
+	}
+
+	ok := p.pool.TryAddTask(&workerpool.Task{
+		Do:       p.do,
+		InParam:  ev,
+		OutParam: ev,
+	})
+	if !ok {
+		p.do(ev, nil)
+	}
+}
+
+// do runs callback of ready descriptor then resumes it if not stopped.
+func (p *dispatchPoller) do(in interface{}, _ interface{}) bool {
+	ev := in.(*dispatchEvent)
+	ev.cb(ev.event)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, has := p.descs[ev.desc]; !has {
+		return true
+	}
+	if err := p.Poller.Resume(ev.desc); err != nil && err != ErrClosed && err != ErrNotRegistered {
+		p.onError(err)
+	}
+	return true
+}
@@ -41,6 +41,18 @@ The Poller describes os-dependent network poller:
 		}
 	})
 
+Slow callbacks could be dispatched to a bounded worker pool, so they do not
+stall other descriptors of the poller:
+
+	pool := workerpool.NewWorkerPool(8, 64, 1024)
+	pool.Run()
+
+	poller, err := netpoll.New(&netpoll.Config{WorkerPool: pool})
+
+On linux, io_uring based Poller could be created with the same Poller API:
+
+	poller, err := netpoll.New(&netpoll.Config{IoUring: true})
+
 Currently, Poller is implemented only for Linux.
 */
 package netpoll
@@ -48,6 +60,8 @@ package netpoll
 import (
 	"fmt"
 	"log"
+
+	"github.com/weedge/lib/pool/workerpool"
 )
 
 var (
@@ -168,6 +182,17 @@ type CallbackFn func(Event)
 type Config struct {
 	// OnWaitError will be called from goroutine, waiting for events.
 	OnWaitError func(error)
+
+	// IoUring if set, Poller is implemented by io_uring IORING_OP_POLL_ADD
+	// ops instead of epoll, linux only.
+	IoUring bool
+
+	// WorkerPool if not nil, callbacks are dispatched to the running pool
+	// instead of the goroutine waiting for events. Descriptors are observed
+	// with EventOneShot and resumed automatically after callback returns, so
+	// callback should not call Resume(). Callback runs on the goroutine
+	// waiting for events if the pool is stopped or its task queue is full.
+	WorkerPool *workerpool.WorkerPool
 }
 
 func (c *Config) withDefaults() (config Config) {
@@ -180,6 +205,14 @@ func (c *Config) withDefaults() (config Config) {
 	return config
 }
 
+// wrap returns poller dispatching callbacks to the worker pool if configured.
+func (c *Config) wrap(p Poller) Poller {
+	if c.WorkerPool == nil {
+		return p
+	}
+	return newDispatchPoller(p, c.WorkerPool, c.OnWaitError)
+}
+
 func defaultOnWaitError(err error) {
 	log.Printf("netpoll: wait loop error: %s", err)
 }
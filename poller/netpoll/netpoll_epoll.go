@@ -6,9 +6,13 @@ import (
 	"os"
 )
 
-// New creates new epoll-based Poller instance with given config.
+// New creates new epoll-based Poller instance with given config,
+// or io_uring-based if config.IoUring is set.
 func New(c *Config) (Poller, error) {
 	cfg := c.withDefaults()
+	if cfg.IoUring {
+		return newUringPoller(&cfg)
+	}
 
 	epoll, err := EpollCreate(&EpollConfig{
 		OnWaitError: cfg.OnWaitError,
@@ -17,7 +21,7 @@ func New(c *Config) (Poller, error) {
 		return nil, err
 	}
 
-	return poller{epoll}, nil
+	return cfg.wrap(poller{epoll}), nil
 }
 
 // poller implements Poller interface.
@@ -29,28 +33,7 @@ type poller struct {
 func (ep poller) Start(desc *Desc, cb CallbackFn) error {
 	err := ep.Add(desc.fd(), toEpollEvent(desc.event),
 		func(ep EpollEvent) {
-			var event Event
-
-			if ep&EPOLLHUP != 0 {
-				event |= EventHup
-			}
-			if ep&EPOLLRDHUP != 0 {
-				event |= EventReadHup
-			}
-			if ep&EPOLLIN != 0 {
-				event |= EventRead
-			}
-			if ep&EPOLLOUT != 0 {
-				event |= EventWrite
-			}
-			if ep&EPOLLERR != 0 {
-				event |= EventErr
-			}
-			if ep&_EPOLLCLOSED != 0 {
-				event |= EventPollerClosed
-			}
-
-			cb(event)
+			cb(fromEpollEvent(ep))
 		},
 	)
 	if err == nil {
@@ -71,6 +54,28 @@ func (ep poller) Resume(desc *Desc) error {
 	return ep.Mod(desc.fd(), toEpollEvent(desc.event))
 }
 
+func fromEpollEvent(ep EpollEvent) (event Event) {
+	if ep&EPOLLHUP != 0 {
+		event |= EventHup
+	}
+	if ep&EPOLLRDHUP != 0 {
+		event |= EventReadHup
+	}
+	if ep&EPOLLIN != 0 {
+		event |= EventRead
+	}
+	if ep&EPOLLOUT != 0 {
+		event |= EventWrite
+	}
+	if ep&EPOLLERR != 0 {
+		event |= EventErr
+	}
+	if ep&_EPOLLCLOSED != 0 {
+		event |= EventPollerClosed
+	}
+	return event
+}
+
 func toEpollEvent(event Event) (ep EpollEvent) {
 	if event&EventRead != 0 {
 		ep |= EPOLLIN | EPOLLRDHUP
@@ -17,7 +17,7 @@ func New(c *Config) (Poller, error) {
 		return nil, err
 	}
 
-	return poller{kq}, nil
+	return cfg.wrap(poller{kq}), nil
 }
 
 type poller struct {
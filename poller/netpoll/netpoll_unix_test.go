@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/weedge/lib/pool/workerpool"
 	"golang.org/x/sys/unix"
 )
 
@@ -207,6 +208,208 @@ func TestPollerWriteOnce(t *testing.T) {
 	}
 }
 
+func TestPollerDispatch(t *testing.T) {
+	pool := workerpool.NewWorkerPool(2, 4, 16)
+	pool.Run()
+	defer pool.Stop()
+
+	cfg := config(t)
+	cfg.WorkerPool = pool
+	poller, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := func(slow bool, received chan<- []byte) (w int, desc *Desc) {
+		r, w, err := socketPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.FileConn(os.NewFile(uintptr(r), "|0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Level-triggered descriptor is resumed after callback returns.
+		desc = Must(Handle(conn, EventRead))
+		var running int32
+		err = poller.Start(desc, func(event Event) {
+			if atomic.AddInt32(&running, 1) != 1 {
+				t.Errorf("concurrent callbacks of one descriptor")
+			}
+			defer atomic.AddInt32(&running, -1)
+			if event&EventRead == 0 {
+				return
+			}
+
+			bts := make([]byte, 128)
+			n, err := conn.Read(bts)
+			if err != nil {
+				return
+			}
+			if slow {
+				time.Sleep(200 * time.Millisecond)
+			}
+			received <- bts[:n]
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w, desc
+	}
+
+	slowCh, fastCh := make(chan []byte, 16), make(chan []byte, 16)
+	slowW, slowDesc := start(true, slowCh)
+	fastW, fastDesc := start(false, fastCh)
+	defer poller.Stop(slowDesc)
+	defer poller.Stop(fastDesc)
+
+	if _, err = unix.Write(slowW, []byte("slow")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// Slow callback of other descriptor does not stall this one.
+	for i := 0; i < 3; i++ {
+		if _, err = unix.Write(fastW, []byte("fast")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case bts := <-fastCh:
+			if string(bts) != "fast" {
+				t.Errorf("received %q; want %q", bts, "fast")
+			}
+		case <-slowCh:
+			t.Fatalf("fast descriptor stalled by slow callback")
+		case <-time.After(time.Second):
+			t.Fatalf("fast descriptor is not resumed")
+		}
+	}
+
+	select {
+	case bts := <-slowCh:
+		if string(bts) != "slow" {
+			t.Errorf("received %q; want %q", bts, "slow")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("slow callback is not done")
+	}
+
+	if _, err = unix.Write(slowW, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case bts := <-slowCh:
+		if string(bts) != "again" {
+			t.Errorf("received %q; want %q", bts, "again")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("slow descriptor is not resumed")
+	}
+}
+
+// startRead starts level-triggered read descriptor of socket pair,
+// cb is called with bytes read, returns write end.
+func startRead(t *testing.T, poller Poller, cb func([]byte)) int {
+	r, w, err := socketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.FileConn(os.NewFile(uintptr(r), "|0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := Must(Handle(conn, EventRead))
+	err = poller.Start(desc, func(event Event) {
+		if event&EventRead == 0 {
+			return
+		}
+		bts := make([]byte, 128)
+		n, err := conn.Read(bts)
+		if err != nil {
+			return
+		}
+		cb(bts[:n])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		poller.Stop(desc)
+		conn.Close()
+		unix.Close(w)
+	})
+	return w
+}
+
+// expectRead writes bts to w and waits them from received.
+func expectRead(t *testing.T, w int, received <-chan []byte, bts string) {
+	t.Helper()
+	if _, err := unix.Write(w, []byte(bts)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if string(got) != bts {
+			t.Errorf("received %q; want %q", got, bts)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%q is not received", bts)
+	}
+}
+
+func TestPollerDispatchPoolStopped(t *testing.T) {
+	pool := workerpool.NewWorkerPool(1, 2, 4)
+	pool.Run()
+
+	cfg := config(t)
+	cfg.WorkerPool = pool
+	poller, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 4)
+	w := startRead(t, poller, func(bts []byte) { received <- bts })
+	expectRead(t, w, received, "pool")
+
+	// Task is refused by stopped pool, callback runs inline and descriptor is resumed.
+	pool.Stop()
+	expectRead(t, w, received, "inline")
+	expectRead(t, w, received, "resumed")
+}
+
+func TestPollerDispatchPoolFull(t *testing.T) {
+	pool := workerpool.NewWorkerPool(1, 1, 1)
+	pool.Run()
+	defer pool.Stop()
+
+	cfg := config(t)
+	cfg.WorkerPool = pool
+	poller, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The only worker is busy and the task queue is full.
+	started, release := make(chan []byte, 2), make(chan struct{})
+	defer close(release)
+	slow := func(bts []byte) {
+		started <- bts
+		<-release
+	}
+	expectRead(t, startRead(t, poller, slow), started, "busy")
+	if _, err = unix.Write(startRead(t, poller, slow), []byte("queued")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Poll goroutine is not blocked by full pool, callback runs inline.
+	received := make(chan []byte, 4)
+	w := startRead(t, poller, func(bts []byte) { received <- bts })
+	expectRead(t, w, received, "inline")
+	expectRead(t, w, received, "resumed")
+}
+
 func emptyRecvBuffer(fd int, k int) (n int, err error) {
 	for eagain := 0; eagain < 10; {
 		var x int
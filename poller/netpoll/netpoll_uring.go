@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+)
+
+// newUringPoller creates new io_uring-based Poller instance with given config.
+func newUringPoller(cfg *Config) (Poller, error) {
+	u, err := UringCreate(&UringConfig{
+		OnWaitError: cfg.OnWaitError,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.wrap(uringPoller{u}), nil
+}
+
+// uringPoller implements Poller interface.
+type uringPoller struct {
+	*Uring
+}
+
+// Start implements Poller.Start() method.
+func (u uringPoller) Start(desc *Desc, cb CallbackFn) error {
+	err := u.Add(desc.fd(), toEpollEvent(desc.event),
+		func(ep EpollEvent) {
+			cb(fromEpollEvent(ep))
+		},
+	)
+	if err == nil {
+		if err = setNonblock(desc.fd(), true); err != nil {
+			return os.NewSyscallError("setnonblock", err)
+		}
+	}
+	return err
+}
+
+// Stop implements Poller.Stop() method.
+func (u uringPoller) Stop(desc *Desc) error {
+	return u.Del(desc.fd())
+}
+
+// Resume implements Poller.Resume() method.
+func (u uringPoller) Resume(desc *Desc) error {
+	return u.Mod(desc.fd(), toEpollEvent(desc.event))
+}
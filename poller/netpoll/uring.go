@@ -0,0 +1,395 @@
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ii64/gouring"
+	"golang.org/x/sys/unix"
+)
+
+// user_data of io_uring ops which are not polls of registered fds.
+const (
+	uringCloseOp  uint64 = 1 // poll of eventfd, signal to close
+	uringIgnoreOp uint64 = 2 // poll remove op, its complete event is ignored
+	uringFirstOp  uint64 = 3
+)
+
+// uringPollMask is the poll events mask which could be passed to POLL_ADD,
+// EPOLLHUP and EPOLLERR are always reported.
+const uringPollMask = EPOLLIN | EPOLLOUT | EPOLLRDHUP | EPOLLPRI
+
+// uringSubmitRetries is max times of submit interrupted or rejected
+// temporarily (EINTR, EAGAIN), retried with backoff from uringSubmitBackoff.
+const (
+	uringSubmitRetries = 8
+	uringSubmitBackoff = 10 * time.Microsecond
+)
+
+// uringPoll is poll state of registered fd.
+type uringPoll struct {
+	fd     int
+	events EpollEvent
+	cb     func(EpollEvent)
+	op     uint64 // user_data of in flight POLL_ADD op, 0: not armed
+}
+
+// Uring represents single io_uring instance which observes fds by
+// IORING_OP_POLL_ADD ops, the ring is gouring wrapper as in poller package.
+//
+// POLL_ADD op completes once, so fd without EPOLLONESHOT is armed again after
+// its callback returns: level-triggered fd gets event again if it is still
+// ready; edge-triggered fd callback should read/write until EAGAIN as with
+// epoll. Fd with EPOLLONESHOT is armed again only by Mod.
+type Uring struct {
+	mu sync.Mutex
+
+	ring     *gouring.IoUring // nil after wait loop exits
+	eventFd  int
+	closed   bool
+	waitDone chan struct{}
+
+	polls  map[int]*uringPoll    // fd -> poll
+	ops    map[uint64]*uringPoll // user_data of in flight POLL_ADD op -> poll
+	nextOp uint64
+}
+
+// UringConfig contains options for Uring instance configuration.
+type UringConfig struct {
+	// OnWaitError will be called from goroutine, waiting for events.
+	OnWaitError func(error)
+
+	// Entries is submission queue entries, default 1024.
+	Entries uint32
+}
+
+func (c *UringConfig) withDefaults() (config UringConfig) {
+	if c != nil {
+		config = *c
+	}
+	if config.OnWaitError == nil {
+		config.OnWaitError = defaultOnWaitError
+	}
+	if config.Entries == 0 {
+		config.Entries = 1024
+	}
+	return config
+}
+
+// UringCreate creates new io_uring instance.
+// It starts the wait loop in separate goroutine.
+func UringCreate(c *UringConfig) (*Uring, error) {
+	config := c.withDefaults()
+
+	ring, err := gouring.NewWithParams(config.Entries, &gouring.IoUringParams{})
+	if err != nil {
+		return nil, err
+	}
+	u := &Uring{
+		ring:     ring,
+		polls:    make(map[int]*uringPoll),
+		ops:      make(map[uint64]*uringPoll),
+		nextOp:   uringFirstOp,
+		waitDone: make(chan struct{}),
+	}
+
+	u.eventFd, err = unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		ring.Close()
+		return nil, err
+	}
+
+	err = u.prepare(func(sqe *gouring.IoUringSqe) {
+		gouring.PrepPollAdd(sqe, u.eventFd, unix.POLLIN)
+		sqe.UserData = gouring.UserData(uringCloseOp)
+	})
+	if err == nil {
+		err = u.submit()
+	}
+	if err != nil {
+		ring.Close()
+		unix.Close(u.eventFd)
+		return nil, err
+	}
+
+	// Run wait loop.
+	go u.wait(config.OnWaitError)
+
+	return u, nil
+}
+
+// Close stops wait loop and closes all underlying resources.
+func (u *Uring) Close() (err error) {
+	u.mu.Lock()
+	{
+		if u.closed {
+			u.mu.Unlock()
+			return ErrClosed
+		}
+		u.closed = true
+
+		if _, err = unix.Write(u.eventFd, closeBytes); err != nil {
+			u.mu.Unlock()
+			return
+		}
+	}
+	u.mu.Unlock()
+
+	<-u.waitDone
+
+	if err = unix.Close(u.eventFd); err != nil {
+		return
+	}
+
+	u.mu.Lock()
+	// Setting polls to nil is safe here because no one should read after
+	// closed flag is true.
+	polls := u.polls
+	u.polls, u.ops = nil, nil
+	u.mu.Unlock()
+
+	for _, p := range polls {
+		if p.cb != nil {
+			p.cb(_EPOLLCLOSED)
+		}
+	}
+
+	return
+}
+
+// Add adds fd to io_uring poll set with given events.
+// Callback will be called on each received event from io_uring.
+// Note that _EPOLLCLOSED is triggered for every cb when io_uring closed.
+func (u *Uring) Add(fd int, events EpollEvent, cb func(EpollEvent)) (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed || u.ring == nil {
+		return ErrClosed
+	}
+	if _, has := u.polls[fd]; has {
+		return ErrRegistered
+	}
+	p := &uringPoll{fd: fd, events: events, cb: cb}
+	u.polls[fd] = p
+
+	if err = u.arm(p); err == nil {
+		err = u.submit()
+	}
+	if err != nil {
+		u.disarm(p)
+		delete(u.polls, fd)
+	}
+	return
+}
+
+// Del removes fd from io_uring poll set, in flight poll is removed.
+func (u *Uring) Del(fd int) (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed || u.ring == nil {
+		return ErrClosed
+	}
+	p, ok := u.polls[fd]
+	if !ok {
+		return ErrNotRegistered
+	}
+
+	delete(u.polls, fd)
+	if p.op == 0 {
+		return nil
+	}
+	if err = u.remove(p); err != nil {
+		return
+	}
+	return u.submit()
+}
+
+// Mod sets to listen events on fd, in flight poll is replaced.
+func (u *Uring) Mod(fd int, events EpollEvent) (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed || u.ring == nil {
+		return ErrClosed
+	}
+	p, ok := u.polls[fd]
+	if !ok {
+		return ErrNotRegistered
+	}
+
+	p.events = events
+	if p.op != 0 {
+		if err = u.remove(p); err != nil {
+			return
+		}
+	}
+	if err = u.arm(p); err != nil {
+		return
+	}
+	return u.submit()
+}
+
+// arm prepares POLL_ADD op of fd, holding mu.
+func (u *Uring) arm(p *uringPoll) error {
+	op := u.nextOp
+	err := u.prepare(func(sqe *gouring.IoUringSqe) {
+		gouring.PrepPollAdd(sqe, p.fd, uint32(p.events&uringPollMask))
+		sqe.UserData = gouring.UserData(op)
+	})
+	if err != nil {
+		return err
+	}
+	u.nextOp++
+	p.op = op
+	u.ops[op] = p
+	return nil
+}
+
+// disarm forgets in flight POLL_ADD op of fd, holding mu.
+func (u *Uring) disarm(p *uringPoll) {
+	delete(u.ops, p.op)
+	p.op = 0
+}
+
+// remove prepares POLL_REMOVE op of in flight POLL_ADD op, holding mu.
+func (u *Uring) remove(p *uringPoll) error {
+	op := p.op
+	err := u.prepare(func(sqe *gouring.IoUringSqe) {
+		gouring.PrepRW(gouring.IORING_OP_POLL_REMOVE, sqe, -1, nil, 0, 0)
+		sqe.IoUringSqe_Union2.SetAddr_Value(op)
+		sqe.UserData = gouring.UserData(uringIgnoreOp)
+	})
+	if err != nil {
+		return err
+	}
+	u.disarm(p)
+	return nil
+}
+
+// prepare fills next sqe, holding mu (or before wait loop started).
+// Pending sqes are submitted first if submission queue is full.
+func (u *Uring) prepare(prep func(sqe *gouring.IoUringSqe)) error {
+	sqe := u.ring.GetSqe()
+	if sqe == nil {
+		if err := u.submit(); err != nil {
+			return err
+		}
+		if sqe = u.ring.GetSqe(); sqe == nil {
+			return unix.EBUSY
+		}
+	}
+	prep(sqe)
+	return nil
+}
+
+// submit submits pending sqes, holding mu (or before wait loop started).
+// Temporary error is retried with backoff up to uringSubmitRetries times,
+// sqes left are submitted by next submit.
+func (u *Uring) submit() (err error) {
+	backoff := uringSubmitBackoff
+	for i := 0; ; i++ {
+		if _, err = u.ring.Submit(); err == nil || !temporaryErr(err) || i == uringSubmitRetries {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// uringEvent is completed poll of registered fd.
+type uringEvent struct {
+	p  *uringPoll
+	ev EpollEvent
+}
+
+func (u *Uring) wait(onError func(error)) {
+	defer func() {
+		// Ring is unmapped under mu, Add/Del/Mod get ErrClosed after wait
+		// loop exits on error.
+		u.mu.Lock()
+		u.ring.Close()
+		u.ring = nil
+		u.mu.Unlock()
+		close(u.waitDone)
+	}()
+
+	cqes := make([]*gouring.IoUringCqe, maxWaitEventsBegin)
+	events := make([]uringEvent, 0, maxWaitEventsBegin)
+
+	for {
+		// Ring is used by Add/Del/Mod under mu, waiting only reads cq.
+		var cqe *gouring.IoUringCqe
+		if err := u.ring.WaitCqe(&cqe); err != nil {
+			if temporaryErr(err) {
+				continue
+			}
+			onError(err)
+			return
+		}
+
+		events = events[:0]
+
+		u.mu.Lock()
+		n := u.ring.PeekBatchCqe(cqes, uint32(len(cqes)))
+		for i := uint32(0); i < n; i++ {
+			userData, res := uint64(cqes[i].UserData), cqes[i].Res
+			if userData == uringCloseOp { // signal to close
+				u.ring.Advance(i + 1)
+				u.mu.Unlock()
+				return
+			}
+
+			// Removed or replaced poll.
+			p, ok := u.ops[userData]
+			if !ok {
+				continue
+			}
+			u.disarm(p)
+
+			ev := EpollEvent(res)
+			if res < 0 {
+				ev = EPOLLERR
+			}
+			events = append(events, uringEvent{p: p, ev: ev})
+		}
+		u.ring.Advance(n)
+		if int(n) == len(cqes) && len(cqes)*2 <= maxWaitEventsStop {
+			cqes = make([]*gouring.IoUringCqe, len(cqes)*2)
+		}
+		u.mu.Unlock()
+
+		for _, e := range events {
+			if e.p.cb != nil {
+				e.p.cb(e.ev)
+			}
+		}
+
+		// Arm polls again after callbacks, except one shot and removed fds.
+		u.mu.Lock()
+		if !u.closed {
+			var err error
+			for _, e := range events {
+				p := e.p
+				if p.events&EPOLLONESHOT == 0 && p.op == 0 && u.polls[p.fd] == p && err == nil {
+					err = u.arm(p)
+				}
+			}
+			if err == nil {
+				err = u.submit()
+			}
+			if err != nil {
+				onError(err)
+			}
+		}
+		u.mu.Unlock()
+
+		for i := range events {
+			events[i] = uringEvent{}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+//go:build linux
+// +build linux
+
+package netpoll
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestUringCreate(t *testing.T) {
+	s := uringCreate(t)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUringAddClosed(t *testing.T) {
+	s := uringCreate(t)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(42, 0, nil); err != ErrClosed {
+		t.Fatalf("Add() = %s; want %s", err, ErrClosed)
+	}
+}
+
+func TestUringDel(t *testing.T) {
+	r, w, err := socketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(r)
+	defer unix.Close(w)
+
+	s := uringCreate(t)
+
+	var events uint32
+	err = s.Add(r, EPOLLIN, func(evt EpollEvent) {
+		if evt&_EPOLLCLOSED == 0 {
+			atomic.AddUint32(&events, 1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Del(r); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err = s.Del(r); err != ErrNotRegistered {
+		t.Errorf("Del() = %v; want %s", err, ErrNotRegistered)
+	}
+
+	// Removed poll does not get event.
+	if _, err = unix.Write(w, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadUint32(&events); n != 0 {
+		t.Errorf("events after Del(): %d; want 0", n)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUringServer(t *testing.T) {
+	u := uringCreate(t)
+
+	// Create listener on port 4445.
+	ln, err := listen(4445)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(ln)
+
+	var received bytes.Buffer
+	done := make(chan struct{})
+
+	// Add listener fd to io_uring instance to know when there are new
+	// incoming connections.
+	u.Add(ln, EPOLLIN, func(evt EpollEvent) {
+		if evt&_EPOLLCLOSED != 0 {
+			return
+		}
+
+		conn, _, err := unix.Accept(ln)
+		if err != nil {
+			t.Fatalf("could not accept: %s", err)
+		}
+		unix.SetNonblock(conn, true)
+
+		u.Add(conn, EPOLLIN|EPOLLET|EPOLLRDHUP, func(evt EpollEvent) {
+			if evt&_EPOLLCLOSED != 0 {
+				return
+			}
+
+			var buf [128]byte
+			for {
+				n, _ := unix.Read(conn, buf[:])
+				if n == 0 {
+					u.Del(conn)
+					close(done)
+				}
+				if n <= 0 {
+					break
+				}
+				received.Write(buf[:n])
+			}
+		})
+	})
+
+	conn, err := dial(4445)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write some data bytes one by one to the conn.
+	data := []byte("hello, io_uring!")
+	for i := 0; i < len(data); i++ {
+		if _, err := unix.Write(conn, data[i:i+1]); err != nil {
+			t.Fatalf("could not make %d-th write (%v): %s", i, string(data[i]), err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	unix.Close(conn)
+	<-done
+
+	if err = u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Errorf("bytes not equal")
+	}
+}
+
+func TestUringOneShot(t *testing.T) {
+	r, w, err := socketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(r)
+	defer unix.Close(w)
+
+	u := uringCreate(t)
+	defer u.Close()
+
+	events := make(chan EpollEvent, 16)
+	err = u.Add(r, EPOLLIN|EPOLLONESHOT, func(evt EpollEvent) {
+		if evt&_EPOLLCLOSED == 0 {
+			events <- evt
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Data is not read, one shot poll is not armed again until Mod.
+	if _, err = unix.Write(w, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case evt := <-events:
+			if evt&EPOLLIN == 0 {
+				t.Fatalf("event %s; want EPOLLIN", evt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event of #%d poll", i)
+		}
+		select {
+		case evt := <-events:
+			t.Fatalf("unexpected event %s before Mod()", evt)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err = u.Mod(r, EPOLLIN|EPOLLONESHOT); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUringSubmissionQueueFull(t *testing.T) {
+	u, err := UringCreate(&UringConfig{
+		OnWaitError: func(err error) { t.Error(err) },
+		Entries:     4,
+	})
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	defer u.Close()
+
+	// More polls than submission queue entries, pending sqes are submitted
+	// before next one is prepared.
+	const n = 16
+	events := make(chan int, n)
+	var ws []int
+	for i := 0; i < n; i++ {
+		r, w, err := socketPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(r)
+		defer unix.Close(w)
+		ws = append(ws, w)
+		i := i
+		err = u.Add(r, EPOLLIN|EPOLLONESHOT, func(evt EpollEvent) {
+			if evt&EPOLLIN != 0 {
+				events <- i
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range ws {
+		if _, err = unix.Write(w, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[int]bool)
+	for len(seen) < n {
+		select {
+		case i := <-events:
+			seen[i] = true
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d polls are reported", len(seen), n)
+		}
+	}
+}
+
+func TestPollerUringReadOnce(t *testing.T) {
+	cfg := config(t)
+	cfg.IoUring = true
+	poller, err := New(cfg)
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+
+	r, w, err := socketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(w)
+
+	conn, err := net.FileConn(os.NewFile(uintptr(r), "|0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		data     = []byte("hello")
+		done     = make(chan struct{})
+		received = make([]byte, 0, len(data))
+	)
+	desc := Must(HandleReadOnce(conn))
+	err = poller.Start(desc, func(event Event) {
+		if event&EventRead == 0 {
+			return
+		}
+
+		bts := make([]byte, 128)
+		n, err := conn.Read(bts)
+		if n == 0 || err != nil {
+			poller.Stop(desc)
+			close(done)
+			return
+		}
+		received = append(received, bts[:n]...)
+		if err := poller.Resume(desc); err != nil {
+			t.Errorf("poller.Resume() error: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err = unix.Write(w, data[i:i+1]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	unix.Close(w)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("no read hup event")
+	}
+	if !bytes.Equal(data, received) {
+		t.Errorf("bytes are not equal:\ngot:  %v\nwant: %v\n", received, data)
+	}
+}
+
+func uringCreate(tb testing.TB) *Uring {
+	u, err := UringCreate(&UringConfig{
+		OnWaitError: func(err error) {
+			tb.Fatal(err)
+		},
+	})
+	if err != nil {
+		tb.Skipf("io_uring is not available: %v", err)
+	}
+	return u
+}
@@ -1,6 +1,7 @@
 package poller
 
 import (
+	"crypto/tls"
 	"runtime"
 	"time"
 
@@ -21,6 +22,11 @@ const (
 	IOModeDefaultPoll = (1 << 30) // epoll/kqueue event
 )
 
+// isIoUring io event use io_uring ring
+func (m IOMode) isIoUring() bool {
+	return m > IOModeUnkonw && m < IOModeDefaultPoll
+}
+
 // options Server opt config
 type options struct {
 	// readBufferLen
@@ -30,8 +36,8 @@ type options struct {
 	acceptGNum        int                    // Number of Goroutines processed for accepted requests
 	ioGNum            int                    // Number of goroutines to process I/OS
 	ioEventQueueLen   int                    // I/O event queue length
-	timeoutTicker     time.Duration          // Timeout check interval
-	timeout           time.Duration          // Timeout period
+	timeoutTicker     time.Duration          // Timeout check interval, timing wheel tick
+	timeout           time.Duration          // Timeout period, default connect idle timeout
 	reportTicker      time.Duration          // report server statics interval
 	decoder           Decoder                // decoder
 	encoder           Encoder                // Encoder
@@ -41,6 +47,32 @@ type options struct {
 	ioUringNum        int                    // init io_uring ring num
 	ioUringParams     *gouring.IoUringParams // io_uring_setup params
 	ioUringEntries    uint32                 // io_uring setup sqe entry array size
+	dialTimeout       time.Duration          // client non block connect timeout
+	udpBatchSize      int                    // udp recvmmsg/sendmmsg batch datagram num
+	listenFD          int                    // adopt already open listen fd, -1 listen address
+	socketActivation  bool                   // adopt listen fd from LISTEN_FDS if passed
+	tlsConfig         *tls.Config            // tls config, nil: plaintext
+	tlsHandshakeLimit int                    // max concurrent tls handshakes of accepted connect, 0: no limit
+	codecRegistry     *CodecRegistry         // negotiate codec per connect by magic prefix
+	writeQueueLen     int                    // max queued write bytes of connect
+	highWatermark     int                    // queued write bytes high watermark
+	lowWatermark      int                    // queued write bytes low watermark
+	bufRingEntries    int                    // io_uring provided buffer ring entries, 0: connect read buffer
+	fixedBufferNum    int                    // io_uring registered fixed buffer num, 0: send op
+	fixedBufferLen    int                    // io_uring registered fixed buffer len
+	sqThreadCPU       int                    // io_uring sq poll thread bind cpu, -1: no affinity
+	sqThreadIdle      time.Duration          // io_uring sq poll thread idle time before sleep
+	reactorNum        int                    // independent reactors with SO_REUSEPORT listen, 0: one server
+	lockOSThread      bool                   // reactor event looper locked to OS thread
+	connStats         bool                   // count io stats per connect
+	proxyProtocol     bool                   // parse PROXY protocol header of accepted connect
+	maxConns          int                    // max connects, 0: no limit
+	maxConnsPerIP     int                    // max connects per source ip, 0: no limit
+	rejectResponse    []byte                 // written to rejected connect before close, nil: close immediately
+	msgRate           float64                // per connect messages per second, 0: no limit
+	msgBurst          int                    // per connect messages burst
+	byteRate          float64                // per connect read bytes per second, 0: no limit
+	byteBurst         int                    // per connect read bytes burst
 }
 
 type Option interface {
@@ -97,13 +129,19 @@ func WithIOEventQueueLen(num int) Option {
 	})
 }
 
+// WithTimeout
+// timeoutTicker is timing wheel tick (timeout precision) of connect deadline and idle timeout,
+// timeout is default connect idle timeout, closed with ErrIdleTimeout;
+// notice: changed from the ticker scan of all connects: default tick is 100ms (was 3s),
+// timeout closes connect without read and write during it with ErrIdleTimeout
+// (was without read, ErrReadTimeout), each connect holds one timer per deadline instead of the scan
 func WithTimeout(timeoutTicker, timeout time.Duration) Option {
 	return newFuncServerOption(func(o *options) {
 		if timeoutTicker <= 0 {
 			panic("timeoutTicker must greater than 0")
 		}
 		if timeout <= 0 {
-			panic("timeoutTicker must greater than 0")
+			panic("timeout must greater than 0")
 		}
 
 		o.timeoutTicker = timeoutTicker
@@ -132,6 +170,17 @@ func WithEncoder(encoder Encoder) Option {
 	})
 }
 
+// WithCodecRegistry
+// negotiate raw/protobuf/line etc. registered codec per connect from magic prefix
+func WithCodecRegistry(registry *CodecRegistry) Option {
+	return newFuncServerOption(func(o *options) {
+		if registry == nil {
+			panic("codec registry must not be nil")
+		}
+		o.codecRegistry = registry
+	})
+}
+
 func WithKeepAliveInterval(d time.Duration) Option {
 	return newFuncServerOption(func(o *options) {
 		if d <= 0 {
@@ -177,6 +226,228 @@ func WithIoUringNum(ioUringNum int) Option {
 	})
 }
 
+func WithDialTimeout(d time.Duration) Option {
+	return newFuncServerOption(func(o *options) {
+		if d <= 0 {
+			panic("dial timeout must greater than 0")
+		}
+		o.dialTimeout = d
+	})
+}
+
+func WithUDPBatchSize(size int) Option {
+	return newFuncServerOption(func(o *options) {
+		if size <= 0 {
+			panic("udp batch size must greater than 0")
+		}
+		o.udpBatchSize = size
+	})
+}
+
+// WithListenFD
+// adopt an already open listening socket fd instead of listen address
+func WithListenFD(fd int) Option {
+	return newFuncServerOption(func(o *options) {
+		if fd < 0 {
+			panic("listen fd must greater than or equal to 0")
+		}
+		o.listenFD = fd
+	})
+}
+
+// WithSocketActivation
+// adopt the first listen fd passed by LISTEN_FDS (socket activation / hand off),
+// listen address if no fd is passed
+func WithSocketActivation() Option {
+	return newFuncServerOption(func(o *options) {
+		o.socketActivation = true
+	})
+}
+
+// WithTLSConfig
+// tls handshake and record layer over the non-blocking epoll connect,
+// OnConnect after handshake done, OnMessage with decoded plaintext;
+// each handshake runs in its own goroutine blocked on ciphertext fed by io events
+// (a goroutine stack and tls state per handshaking connect), bounded by WithTLSHandshakeLimit;
+// io_uring io mode is not supported
+func WithTLSConfig(config *tls.Config) Option {
+	return newFuncServerOption(func(o *options) {
+		if config == nil {
+			panic("tls config must not be nil")
+		}
+		o.tlsConfig = config
+	})
+}
+
+// WithTLSHandshakeLimit
+// max concurrent tls handshakes of accepted connects (shared by reactors), default 1024,
+// accepted connect exceed limit is closed; 0: no limit
+func WithTLSHandshakeLimit(n int) Option {
+	return newFuncServerOption(func(o *options) {
+		if n < 0 {
+			panic("tls handshake limit must not be negative")
+		}
+		o.tlsHandshakeLimit = n
+	})
+}
+
+// WithWriteQueueLen
+// max queued write bytes of connect, Write return ErrWriteQueueFull if exceed
+func WithWriteQueueLen(len int) Option {
+	return newFuncServerOption(func(o *options) {
+		if len <= 0 {
+			panic("write queue len must greater than 0")
+		}
+		o.writeQueueLen = len
+	})
+}
+
+// WithWriteWatermark
+// queued write bytes high/low watermark to notify WatermarkHandler
+func WithWriteWatermark(high, low int) Option {
+	return newFuncServerOption(func(o *options) {
+		if high <= 0 || low < 0 || low >= high {
+			panic("write watermark must 0 <= low < high")
+		}
+		o.highWatermark = high
+		o.lowWatermark = low
+	})
+}
+
+// WithIoUringBufRing
+// io_uring recv select buffer (read buffer len) from kernel provided buffer ring,
+// connect holds a read buffer only for partial frame, memory of idle connects is bounded by ring;
+// entries must be power of 2, fall back to connect read buffer if the kernel doesn't support
+func WithIoUringBufRing(entries int) Option {
+	return newFuncServerOption(func(o *options) {
+		if entries <= 0 || entries > 1<<15 || entries&(entries-1) != 0 {
+			panic("buf ring entries must be power of 2 and not greater than 32768")
+		}
+		o.bufRingEntries = entries
+	})
+}
+
+// WithIoUringFixedBuffers
+// io_uring send queued bytes by write fixed op from num registered buffers of len,
+// use send op if all fixed buffers are in flight
+func WithIoUringFixedBuffers(num, len int) Option {
+	return newFuncServerOption(func(o *options) {
+		if num <= 0 || num > 1<<14 || len <= 0 {
+			panic("fixed buffer num must in (0, 16384] and len must greater than 0")
+		}
+		o.fixedBufferNum = num
+		o.fixedBufferLen = len
+	})
+}
+
+// WithIoUringSQPoll
+// IOModeUringSQPoll/IOModeIouK kernel sq poll thread bind cpu (-1: no affinity),
+// thread sleeps after idle time without sqe, 0: kernel default 1s
+func WithIoUringSQPoll(cpu int, idle time.Duration) Option {
+	return newFuncServerOption(func(o *options) {
+		if cpu >= runtime.NumCPU() {
+			panic("sq poll thread cpu must less than cpu num")
+		}
+		if idle < 0 {
+			panic("sq poll thread idle must not less than 0")
+		}
+		o.sqThreadCPU = cpu
+		o.sqThreadIdle = idle
+	})
+}
+
+// WithReactors
+// run num independent reactors, each has own SO_REUSEPORT listen fd, epoll/io_uring ring and connect table,
+// events are handled in reactor event looper goroutine (locked to OS thread if lockOSThread),
+// without channel hop to io goroutines; kernel balances new connects across reactors
+func WithReactors(num int, lockOSThread bool) Option {
+	return newFuncServerOption(func(o *options) {
+		if num <= 0 {
+			panic("reactor num must greater than 0")
+		}
+		o.reactorNum = num
+		o.lockOSThread = lockOSThread
+	})
+}
+
+// WithConnStats
+// count io stats per connect besides server total, get by Conn.Stats, exported by Collector
+func WithConnStats() Option {
+	return newFuncServerOption(func(o *options) {
+		o.connStats = true
+	})
+}
+
+// WithProxyProtocol
+// accepted connects behind load balancer begin with HAProxy PROXY v1/v2 header,
+// header is consumed before decoder (or tls handshake), OnConnect after header parsed,
+// GetAddr reports the real client address, Conn.ProxyHeader gets addresses and TLVs;
+// connect without valid header is closed
+func WithProxyProtocol() Option {
+	return newFuncServerOption(func(o *options) {
+		o.proxyProtocol = true
+	})
+}
+
+// WithMaxConns
+// max connects of server (all reactors), new accepted connect exceeds it is rejected
+func WithMaxConns(num int) Option {
+	return newFuncServerOption(func(o *options) {
+		if num <= 0 {
+			panic("max connects must greater than 0")
+		}
+		o.maxConns = num
+	})
+}
+
+// WithMaxConnsPerIP
+// max connects from one source ip (socket peer, not PROXY header address),
+// new accepted connect exceeds it is rejected
+func WithMaxConnsPerIP(num int) Option {
+	return newFuncServerOption(func(o *options) {
+		if num <= 0 {
+			panic("max connects per ip must greater than 0")
+		}
+		o.maxConnsPerIP = num
+	})
+}
+
+// WithRejectResponse
+// canned response written to rejected connect before close, eg: "HTTP/1.1 503 Service Unavailable\r\n\r\n";
+// default close rejected connect immediately
+func WithRejectResponse(resp []byte) Option {
+	return newFuncServerOption(func(o *options) {
+		o.rejectResponse = resp
+	})
+}
+
+// WithMsgRateLimit
+// per connect token bucket of decoded messages handled by OnMessage, rate messages per second with burst;
+// read is paused until tokens refilled (precision is timing wheel tick), peer is throttled by tcp flow control;
+// io_uring multishot recv completions in flight are still handled after pause, limit is kept on average
+func WithMsgRateLimit(rate float64, burst int) Option {
+	return newFuncServerOption(func(o *options) {
+		if rate <= 0 || burst <= 0 {
+			panic("msg rate and burst must greater than 0")
+		}
+		o.msgRate = rate
+		o.msgBurst = burst
+	})
+}
+
+// WithByteRateLimit
+// per connect token bucket of read bytes (plaintext of tls), rate bytes per second with burst;
+// read is paused until tokens refilled like WithMsgRateLimit
+func WithByteRateLimit(rate float64, burst int) Option {
+	return newFuncServerOption(func(o *options) {
+		if rate <= 0 || burst <= 0 {
+			panic("byte rate and burst must greater than 0")
+		}
+		o.byteRate = rate
+		o.byteBurst = burst
+	})
+}
+
 func getOptions(opts ...Option) *options {
 	cpuNum := runtime.NumCPU()
 	options := &options{
@@ -185,13 +456,22 @@ func getOptions(opts ...Option) *options {
 		ioGNum:          cpuNum,
 		ioEventQueueLen: 1024,
 		listenBacklog:   1024,
-		timeoutTicker:   3 * time.Second,
+		timeoutTicker:   100 * time.Millisecond,
 		timeout:         1 * time.Hour,
 		//reportTicker:    3 * time.Second,
 		ioMode:         IOModeUnkonw,
 		ioUringNum:     1,
 		ioUringEntries: 1024,
 		ioUringParams:  &gouring.IoUringParams{},
+		dialTimeout:    3 * time.Second,
+		udpBatchSize:   32,
+		listenFD:       -1,
+		writeQueueLen:  4 * 1024 * 1024,
+		highWatermark:  1024 * 1024,
+		lowWatermark:   256 * 1024,
+		sqThreadCPU:    -1,
+
+		tlsHandshakeLimit: 1024,
 	}
 
 	for _, o := range opts {
@@ -0,0 +1,299 @@
+// Package pollertest
+// deterministic in memory loopback transport to test poller.Handler without binding a port:
+// server end of unix socketpair is a poller.Conn attached to poller client event loops which are not running,
+// test plays the peer on the other end and steps read/write ready events on the calling goroutine,
+// so bytes pass the real Decoder/Encoder pipeline and handler callbacks run in test order.
+//
+// faults: partial reads (WriteChunks), EAGAIN (ReadEvent without written bytes),
+// reset (Reset), hangup (Hangup), slow peer (SetWriteBuffer and Recv a few bytes),
+// deadline and idle timeout (TimerEvents).
+//
+// eg:
+//
+//	c, err := pollertest.New(handler, poller.WithDecoder(decoder))
+//	defer c.Close()
+//	c.WriteChunks(req, 1)
+//	resp, err := c.Recv(0)
+package pollertest
+
+import (
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/poller"
+)
+
+// Addr address of loopback connect
+const Addr = "pollertest"
+
+var (
+	ErrClosed = errors.New("pollertest: connect closed")
+)
+
+// Conn
+// loopback connect of handler; not goroutine safe, step on one goroutine
+type Conn struct {
+	client  *poller.Client
+	handler poller.Handler
+	conn    *poller.Conn
+	peer    int   // peer end of socketpair, -1: closed
+	err     error // err of OnClose called by transport
+	owner   bool  // created by New, stop poller client when closed
+}
+
+// New
+// Creates loopback connect of handler with poller options (default poll io mode, plaintext),
+// OnConnect is called before return, or after peer writes PROXY header with poller.WithProxyProtocol
+func New(handler poller.Handler, opts ...poller.Option) (*Conn, error) {
+	opts = append(opts, poller.WithIoMode(poller.IOModeDefaultPoll))
+	client, err := poller.NewClient(handler, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := attach(client, handler)
+	if err != nil {
+		client.Stop()
+		return nil, err
+	}
+	c.owner = true
+	return c, nil
+}
+
+// NewConn
+// Creates another loopback connect of the same poller client (handler, options, groups),
+// eg: Broadcast to connects of group; close it before the connect created by New
+func (c *Conn) NewConn() (*Conn, error) {
+	return attach(c.client, c.handler)
+}
+
+// attach
+// attach server end of socketpair to poller client
+func attach(client *poller.Client, handler poller.Handler) (*Conn, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.SetNonblock(fds[1], true)
+	if err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	conn, err := client.AttachFD(fds[0], Addr)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, err
+	}
+	return &Conn{client: client, handler: handler, conn: conn, peer: fds[1]}, nil
+}
+
+// PollerConn
+// server end connect passed to handler
+func (c *Conn) PollerConn() *poller.Conn {
+	return c.conn
+}
+
+// Client
+// poller client of connect, eg: Broadcast, Stats
+func (c *Conn) Client() *poller.Client {
+	return c.client
+}
+
+// Err
+// err of OnClose called by transport (read/flush err, io.EOF of hangup), nil if not called
+func (c *Conn) Err() error {
+	return c.err
+}
+
+// Write
+// peer writes bytes, then read ready event; bytes not fit in socket buffer are written after read
+func (c *Conn) Write(bytes []byte) error {
+	for len(bytes) > 0 {
+		if c.peer < 0 || c.conn.IsClosed() {
+			return ErrClosed
+		}
+		n, err := syscall.Write(c.peer, bytes)
+		if err != nil && err != syscall.EAGAIN {
+			return err
+		}
+		if n > 0 {
+			bytes = bytes[n:]
+		}
+		if err = c.ReadEvent(); err != nil {
+			return err
+		}
+		if n <= 0 && c.conn.IsReadPaused() {
+			// paused server end doesn't drain socket buffer
+			return syscall.EAGAIN
+		}
+	}
+	return nil
+}
+
+// WriteChunks
+// peer writes bytes by chunks of size with read ready event after each chunk,
+// frames are decoded from partial reads (eg: size 1, slow writer)
+func (c *Conn) WriteChunks(bytes []byte, size int) error {
+	if size <= 0 {
+		size = len(bytes)
+	}
+	for len(bytes) > 0 {
+		n := size
+		if n > len(bytes) {
+			n = len(bytes)
+		}
+		if err := c.Write(bytes[:n]); err != nil {
+			return err
+		}
+		bytes = bytes[n:]
+	}
+	return nil
+}
+
+// ReadEvent
+// read ready event of server end: read all available bytes, decode and OnMessage;
+// without written bytes it's spurious, read returns EAGAIN and nothing is filtered;
+// no-op if read paused. read err closes connect and OnClose, the err is returned
+func (c *Conn) ReadEvent() error {
+	if c.conn.IsClosed() {
+		return ErrClosed
+	}
+	if c.conn.IsReadPaused() {
+		return nil
+	}
+	err := c.conn.Read()
+	if err != nil {
+		c.onClose(err)
+	}
+	return err
+}
+
+// WriteEvent
+// write ready event of server end: flush queued bytes, flush err closes connect and OnClose
+func (c *Conn) WriteEvent() error {
+	if c.conn.IsClosed() {
+		return ErrClosed
+	}
+	err := c.conn.Flush()
+	if err != nil {
+		c.onClose(err)
+	}
+	return err
+}
+
+// Recv
+// peer reads at most n bytes (n <= 0: all written by handler, queued bytes are flushed),
+// then write ready event; read a few bytes to be a slow peer
+func (c *Conn) Recv(n int) ([]byte, error) {
+	if c.peer < 0 {
+		return nil, ErrClosed
+	}
+	var out []byte
+	buf := make([]byte, 64*1024)
+	for n <= 0 || len(out) < n {
+		b := buf
+		if n > 0 && n-len(out) < len(b) {
+			b = b[:n-len(out)]
+		}
+		m, err := syscall.Read(c.peer, b)
+		if m > 0 {
+			out = append(out, b[:m]...)
+		}
+		if err == syscall.EAGAIN || m == 0 {
+			// drained, the rest may be queued on server end
+			if c.conn.IsClosed() || !c.conn.HasPendingWrite() {
+				break
+			}
+			if err = c.WriteEvent(); err != nil {
+				return out, err
+			}
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+	if !c.conn.IsClosed() && c.conn.HasPendingWrite() {
+		return out, c.WriteEvent()
+	}
+	return out, nil
+}
+
+// TimerEvents
+// wait d for connect deadline and idle timers, then handle timeout events posted by timers as event loop does:
+// expired connect is closed and OnClose with ErrReadTimeout, ErrWriteTimeout or ErrIdleTimeout;
+// set tick precision by poller.WithTimeout
+func (c *Conn) TimerEvents(d time.Duration) error {
+	if c.conn.IsClosed() {
+		return ErrClosed
+	}
+	time.Sleep(d)
+	c.client.ProcessPostedEvents()
+	return nil
+}
+
+// SetWriteBuffer
+// set socket send buffer of server end, handler writes are queued when peer doesn't Recv
+func (c *Conn) SetWriteBuffer(n int) error {
+	return syscall.SetsockoptInt(c.conn.GetFd(), syscall.SOL_SOCKET, syscall.SO_SNDBUF, n)
+}
+
+// Hangup
+// peer discards unread bytes and closes, read ready event gets io.EOF
+// (unix socket closed with unread bytes is reset, see Reset)
+func (c *Conn) Hangup() error {
+	if c.peer < 0 {
+		return ErrClosed
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(c.peer, buf)
+		if n <= 0 || err != nil {
+			break
+		}
+	}
+	syscall.Close(c.peer)
+	c.peer = -1
+	return c.ReadEvent()
+}
+
+// Reset
+// peer closes with unread bytes, read ready event gets ECONNRESET after available bytes
+func (c *Conn) Reset() error {
+	if c.peer < 0 {
+		return ErrClosed
+	}
+	if !c.conn.IsClosed() {
+		// unix socket peer closed with unread bytes is reset
+		syscall.Write(c.conn.GetFd(), []byte{0})
+	}
+	syscall.Close(c.peer)
+	c.peer = -1
+	return c.ReadEvent()
+}
+
+// Close
+// free connect (without OnClose if open), and poller client if created by New
+func (c *Conn) Close() error {
+	if c.peer >= 0 {
+		syscall.Close(c.peer)
+		c.peer = -1
+	}
+	c.conn.Close()
+	if c.owner {
+		c.client.Stop()
+	}
+	return nil
+}
+
+// onClose
+// close connect and OnClose as event loop does
+func (c *Conn) onClose(err error) {
+	if c.conn.IsClosed() {
+		return
+	}
+	c.conn.Close()
+	c.err = err
+	c.handler.OnClose(c.conn, err)
+}
@@ -0,0 +1,214 @@
+package pollertest_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// lineEcho echo lines handler records callbacks
+type lineEcho struct {
+	connects int
+	msgs     []string
+	closes   []error
+}
+
+func (h *lineEcho) OnConnect(c *poller.Conn) {
+	h.connects++
+}
+
+func (h *lineEcho) OnMessage(c *poller.Conn, bytes []byte) {
+	h.msgs = append(h.msgs, string(bytes))
+	c.Write(append(append([]byte{}, bytes...), '\n'))
+}
+
+func (h *lineEcho) OnClose(c *poller.Conn, err error) {
+	h.closes = append(h.closes, err)
+}
+
+// newLineConn loopback connect of lineEcho with line decoder
+func newLineConn(t *testing.T, opts ...poller.Option) (*pollertest.Conn, *lineEcho) {
+	h := &lineEcho{}
+	opts = append([]poller.Option{poller.WithDecoder(poller.NewLineDecoder(1024))}, opts...)
+	c, err := pollertest.New(h, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if h.connects != 1 {
+		t.Fatalf("OnConnect called %d times before New return", h.connects)
+	}
+	return c, h
+}
+
+func TestWriteChunksPartialFrames(t *testing.T) {
+	for _, size := range []int{0, 1, 3, 7} {
+		c, h := newLineConn(t)
+		if err := c.WriteChunks([]byte("hello\nworld\r\nand more\n"), size); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(h.msgs) != "[hello world and more]" {
+			t.Fatalf("chunk size %d messages %q", size, h.msgs)
+		}
+		if out, err := c.Recv(0); err != nil || string(out) != "hello\nworld\nand more\n" {
+			t.Fatalf("chunk size %d recv %q err %v", size, out, err)
+		}
+
+		// incomplete line is buffered until the rest is written
+		c.WriteChunks([]byte("tail"), 1)
+		if len(h.msgs) != 3 {
+			t.Fatalf("chunk size %d incomplete line decoded %q", size, h.msgs)
+		}
+		c.Write([]byte("\n"))
+		if h.msgs[3] != "tail" {
+			t.Fatalf("chunk size %d messages %q", size, h.msgs)
+		}
+	}
+}
+
+func TestReadEventEAGAIN(t *testing.T) {
+	c, h := newLineConn(t)
+	for i := 0; i < 3; i++ {
+		if err := c.ReadEvent(); err != nil {
+			t.Fatalf("spurious read event err %v", err)
+		}
+	}
+	if len(h.msgs) != 0 || len(h.closes) != 0 || c.PollerConn().IsClosed() {
+		t.Fatalf("spurious read events messages %q closes %v", h.msgs, h.closes)
+	}
+	if out, err := c.Recv(0); err != nil || len(out) != 0 {
+		t.Fatalf("recv %q err %v", out, err)
+	}
+	if err := c.Write([]byte("ping\n")); err != nil || len(h.msgs) != 1 {
+		t.Fatalf("write after spurious events messages %q err %v", h.msgs, err)
+	}
+}
+
+func TestHangup(t *testing.T) {
+	c, h := newLineConn(t)
+	c.Write([]byte("ping\npartial"))
+	if err := c.Hangup(); err != io.EOF {
+		t.Fatalf("Hangup() err %v; want %v", err, io.EOF)
+	}
+	if fmt.Sprint(h.msgs) != "[ping]" || len(h.closes) != 1 || h.closes[0] != io.EOF || c.Err() != io.EOF {
+		t.Fatalf("messages %q closes %v err %v", h.msgs, h.closes, c.Err())
+	}
+
+	if err := c.Write([]byte("x\n")); err != pollertest.ErrClosed {
+		t.Fatalf("Write() after hangup err %v; want %v", err, pollertest.ErrClosed)
+	}
+	if err := c.Hangup(); err != pollertest.ErrClosed {
+		t.Fatalf("Hangup() twice err %v; want %v", err, pollertest.ErrClosed)
+	}
+	if len(h.closes) != 1 {
+		t.Fatalf("OnClose called %d times", len(h.closes))
+	}
+}
+
+func TestReset(t *testing.T) {
+	c, h := newLineConn(t)
+	c.Write([]byte("ping\n"))
+	// echoed line is unread by peer
+	if err := c.Reset(); err != syscall.ECONNRESET {
+		t.Fatalf("Reset() err %v; want %v", err, syscall.ECONNRESET)
+	}
+	if len(h.closes) != 1 || h.closes[0] != syscall.ECONNRESET || !c.PollerConn().IsClosed() {
+		t.Fatalf("closes %v", h.closes)
+	}
+	if err := c.ReadEvent(); err != pollertest.ErrClosed {
+		t.Fatalf("ReadEvent() after reset err %v; want %v", err, pollertest.ErrClosed)
+	}
+}
+
+func TestSlowReader(t *testing.T) {
+	resp := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	h := &lineEcho{}
+	c, err := pollertest.New(&writeOnMessage{lineEcho: h, resp: resp},
+		poller.WithDecoder(poller.NewLineDecoder(1024)), poller.WithWriteQueueLen(len(resp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetWriteBuffer(4096)
+
+	c.Write([]byte("get\n"))
+	if !c.PollerConn().HasPendingWrite() {
+		t.Fatal("response is written without queue to slow peer")
+	}
+	// peer reads a few bytes at a time, queued bytes are flushed by write events
+	var out []byte
+	for i := 0; i < 8; i++ {
+		b, err := c.Recv(1000)
+		if err != nil || len(b) != 1000 {
+			t.Fatalf("recv %d bytes err %v", len(b), err)
+		}
+		out = append(out, b...)
+	}
+	if !c.PollerConn().HasPendingWrite() {
+		t.Fatal("queue is drained by 8000 bytes read")
+	}
+	rest, err := c.Recv(0)
+	if err != nil || !bytes.Equal(append(out, rest...), resp) || c.PollerConn().HasPendingWrite() {
+		t.Fatalf("recv %d bytes err %v; want %d bytes", len(out)+len(rest), err, len(resp))
+	}
+}
+
+// writeOnMessage handler writes resp for each message
+type writeOnMessage struct {
+	*lineEcho
+	resp []byte
+}
+
+func (h *writeOnMessage) OnMessage(c *poller.Conn, bytes []byte) {
+	h.msgs = append(h.msgs, string(bytes))
+	c.Write(h.resp)
+}
+
+func TestNewConnSharedClient(t *testing.T) {
+	first, h := newLineConn(t)
+	second, err := first.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Client() != first.Client() || h.connects != 2 {
+		t.Fatalf("shared client %v connects %d", second.Client() == first.Client(), h.connects)
+	}
+	second.Write([]byte("second\n"))
+	first.Write([]byte("first\n"))
+	if out, _ := second.Recv(0); string(out) != "second\n" {
+		t.Fatalf("second recv %q", out)
+	}
+	if out, _ := first.Recv(0); string(out) != "first\n" {
+		t.Fatalf("first recv %q", out)
+	}
+	second.Close()
+	// OnClose is not called by Close
+	if len(h.closes) != 0 || !second.PollerConn().IsClosed() || first.PollerConn().IsClosed() {
+		t.Fatalf("closes %v", h.closes)
+	}
+}
+
+func ExampleNew() {
+	h := &lineEcho{}
+	c, err := pollertest.New(h, poller.WithDecoder(poller.NewLineDecoder(1024)))
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	// line is split by partial reads, decoded once complete
+	c.WriteChunks([]byte("hello\n"), 2)
+	resp, _ := c.Recv(0)
+	fmt.Printf("%q %q\n", h.msgs, resp)
+
+	c.Hangup()
+	fmt.Println(c.Err())
+	// Output:
+	// ["hello"] "hello\n"
+	// EOF
+}
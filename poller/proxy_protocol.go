@@ -0,0 +1,258 @@
+package poller
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// proxyV1MaxLen max PROXY v1 header line length include CRLF
+	proxyV1MaxLen = 107
+	// proxyV2HeaderLen PROXY v2 fixed header: signature, ver_cmd, fam, len
+	proxyV2HeaderLen = 16
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// PROXY v2 TLV types
+const (
+	ProxyTLVTypeALPN      byte = 0x01
+	ProxyTLVTypeAuthority byte = 0x02
+	ProxyTLVTypeCRC32C    byte = 0x03
+	ProxyTLVTypeNoop      byte = 0x04
+	ProxyTLVTypeUniqueID  byte = 0x05
+	ProxyTLVTypeSSL       byte = 0x20
+	ProxyTLVTypeNetNS     byte = 0x30
+)
+
+// ProxyTLV PROXY v2 type-length-value extension
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader
+// HAProxy PROXY protocol header received before connect bytes
+type ProxyHeader struct {
+	Version int        // 1 or 2
+	Local   bool       // LOCAL command (eg: balancer health check), addresses are not proxied
+	SrcAddr net.Addr   // real client address, nil if LOCAL/UNKNOWN/unspec
+	DstAddr net.Addr   // address client connected to on the balancer
+	TLVs    []ProxyTLV // v2 extensions
+}
+
+// TLV
+// value of the first TLV with type
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyHeader
+// PROXY protocol header of accepted connect, nil if WithProxyProtocol option is not set
+func (c *Conn) ProxyHeader() *ProxyHeader {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.proxyHeader
+}
+
+// processProxyHeader
+// parse PROXY header from the first connect bytes, consume it, GetAddr reports the real client address;
+// return false if the header is not complete or invalid (connect is closed)
+func (c *Conn) processProxyHeader(buffer *Buffer) bool {
+	b, _ := buffer.Seek(buffer.Len())
+	h, n, err := parseProxyHeader(b)
+	if err != nil {
+		log.Warnf("connect fd %d addr %s proxy protocol err %s", c.fd, c.GetAddr(), err.Error())
+		c.Close()
+		return false
+	}
+	if n == 0 {
+		return false
+	}
+
+	buffer.Read(n, 0)
+	c.setProxyHeader(h)
+	return true
+}
+
+// setProxyHeader
+// proxied connect address is the real client address;
+// tls connect sets it in io goroutine while handshake goroutine (OnConnect) may read address
+func (c *Conn) setProxyHeader(h *ProxyHeader) {
+	c.proxyPending = false
+	c.lock.Lock()
+	c.proxyHeader = h
+	if h.SrcAddr != nil {
+		c.addr = h.SrcAddr.String()
+	}
+	c.lock.Unlock()
+}
+
+// parseProxyHeader
+// parse PROXY v1 or v2 header, return header and its length, 0 if need more bytes
+func parseProxyHeader(b []byte) (h *ProxyHeader, n int, err error) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		if !hasPrefixOrWait(b, proxyV1Prefix) {
+			return nil, 0, ErrProxyProtocol
+		}
+		return parseProxyV1(b)
+	case proxyV2Signature[0]:
+		if !hasPrefixOrWait(b, proxyV2Signature) {
+			return nil, 0, ErrProxyProtocol
+		}
+		return parseProxyV2(b)
+	}
+	return nil, 0, ErrProxyProtocol
+}
+
+// hasPrefixOrWait
+// b has prefix, or b is the beginning of prefix
+func hasPrefixOrWait(b, prefix []byte) bool {
+	if len(b) < len(prefix) {
+		return bytes.HasPrefix(prefix, b)
+	}
+	return bytes.HasPrefix(b, prefix)
+}
+
+// parseProxyV1
+// "PROXY TCP4|TCP6 src dst sport dport\r\n" or "PROXY UNKNOWN ...\r\n"
+func parseProxyV1(b []byte) (h *ProxyHeader, n int, err error) {
+	i := bytes.Index(b, []byte("\r\n"))
+	if i < 0 {
+		if len(b) >= proxyV1MaxLen {
+			return nil, 0, ErrProxyProtocol
+		}
+		return
+	}
+	if i+2 > proxyV1MaxLen {
+		return nil, 0, ErrProxyProtocol
+	}
+
+	h = &ProxyHeader{Version: 1}
+	fields := strings.Split(string(b[:i]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, i + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ErrProxyProtocol
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, perr1 := strconv.ParseUint(fields[4], 10, 16)
+	dport, perr2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || perr1 != nil || perr2 != nil ||
+		(fields[1] == "TCP4") != (src.To4() != nil && dst.To4() != nil) {
+		return nil, 0, ErrProxyProtocol
+	}
+	h.SrcAddr = &net.TCPAddr{IP: src, Port: int(sport)}
+	h.DstAddr = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return h, i + 2, nil
+}
+
+// parseProxyV2
+// binary header: signature, version/command, family/protocol, length, addresses, TLVs
+func parseProxyV2(b []byte) (h *ProxyHeader, n int, err error) {
+	if len(b) < proxyV2HeaderLen {
+		return
+	}
+	verCmd, fam := b[12], b[13]
+	n = proxyV2HeaderLen + int(binary.BigEndian.Uint16(b[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, 0, ErrProxyProtocol
+	}
+	if len(b) < n {
+		return nil, 0, nil
+	}
+
+	h = &ProxyHeader{Version: 2}
+	payload := b[proxyV2HeaderLen:n]
+	switch verCmd & 0x0f {
+	case 0x0:
+		// LOCAL, skip address block and TLVs
+		h.Local = true
+		return h, n, nil
+	case 0x1:
+		// PROXY
+	default:
+		return nil, 0, ErrProxyProtocol
+	}
+
+	var addrLen int
+	switch fam {
+	case 0x11, 0x12:
+		// TCP/UDP over IPv4
+		addrLen = 12
+		if len(payload) < addrLen {
+			return nil, 0, ErrProxyProtocol
+		}
+		h.SrcAddr = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[0:4]...)), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		h.DstAddr = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[4:8]...)), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x21, 0x22:
+		// TCP/UDP over IPv6
+		addrLen = 36
+		if len(payload) < addrLen {
+			return nil, 0, ErrProxyProtocol
+		}
+		h.SrcAddr = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[0:16]...)), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		h.DstAddr = &net.TCPAddr{IP: net.IP(append([]byte{}, payload[16:32]...)), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	case 0x31, 0x32:
+		// unix stream/datagram
+		addrLen = 216
+		if len(payload) < addrLen {
+			return nil, 0, ErrProxyProtocol
+		}
+		h.SrcAddr = &net.UnixAddr{Name: cString(payload[0:108]), Net: "unix"}
+		h.DstAddr = &net.UnixAddr{Name: cString(payload[108:216]), Net: "unix"}
+	default:
+		// unspec, addresses are ignored
+		addrLen = len(payload)
+	}
+
+	h.TLVs, err = parseProxyTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return h, n, nil
+}
+
+// parseProxyTLVs
+// copy TLVs, connect buffer bytes are reused
+func parseProxyTLVs(b []byte) (tlvs []ProxyTLV, err error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrProxyProtocol
+		}
+		l := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+l {
+			return nil, ErrProxyProtocol
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: b[0], Value: append([]byte{}, b[3:3+l]...)})
+		b = b[3+l:]
+	}
+	return
+}
+
+// cString
+// bytes before the first NUL
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,193 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// proxyV2 PROXY v2 header of version/command, family/protocol and payload
+func proxyV2(verCmd, fam byte, payload ...[]byte) []byte {
+	p := bytes.Join(payload, nil)
+	b := append([]byte{}, proxyV2Signature...)
+	b = append(b, verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(len(p)))
+	return append(b, p...)
+}
+
+// proxyTLV encoded TLV
+func proxyTLV(typ byte, value string) []byte {
+	b := []byte{typ, 0, 0}
+	binary.BigEndian.PutUint16(b[1:3], uint16(len(value)))
+	return append(b, value...)
+}
+
+// inetAddrs v2 address block of src/dst ip and port
+func inetAddrs(src, dst net.IP, sport, dport uint16) []byte {
+	b := append(append([]byte{}, src...), dst...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+// unixAddrs v2 address block of 108 bytes src/dst path
+func unixAddrs(src, dst string) []byte {
+	b := make([]byte, 216)
+	copy(b, src)
+	copy(b[108:], dst)
+	return b
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	ip4src, ip4dst := net.ParseIP("192.168.0.1").To4(), net.ParseIP("10.0.0.1").To4()
+	ip6src, ip6dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	tests := []struct {
+		name  string
+		input []byte
+		want  *ProxyHeader
+	}{
+		{
+			name:  "v1 tcp4",
+			input: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"),
+			want: &ProxyHeader{Version: 1,
+				SrcAddr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324},
+				DstAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}},
+		},
+		{
+			name:  "v1 tcp6",
+			input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 443\r\n"),
+			want: &ProxyHeader{Version: 1,
+				SrcAddr: &net.TCPAddr{IP: ip6src, Port: 1000},
+				DstAddr: &net.TCPAddr{IP: ip6dst, Port: 443}},
+		},
+		{
+			name:  "v1 unknown",
+			input: []byte("PROXY UNKNOWN\r\n"),
+			want:  &ProxyHeader{Version: 1},
+		},
+		{
+			name:  "v1 unknown with addresses",
+			input: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"),
+			want:  &ProxyHeader{Version: 1},
+		},
+		{
+			name:  "v2 local",
+			input: proxyV2(0x20, 0x11, inetAddrs(ip4src, ip4dst, 1, 2)),
+			want:  &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name:  "v2 proxy inet with tlvs",
+			input: proxyV2(0x21, 0x11, inetAddrs(ip4src, ip4dst, 56324, 443), proxyTLV(ProxyTLVTypeALPN, "h2"), proxyTLV(ProxyTLVTypeAuthority, "example.com")),
+			want: &ProxyHeader{Version: 2,
+				SrcAddr: &net.TCPAddr{IP: ip4src, Port: 56324},
+				DstAddr: &net.TCPAddr{IP: ip4dst, Port: 443},
+				TLVs: []ProxyTLV{
+					{Type: ProxyTLVTypeALPN, Value: []byte("h2")},
+					{Type: ProxyTLVTypeAuthority, Value: []byte("example.com")},
+				}},
+		},
+		{
+			name:  "v2 proxy inet6",
+			input: proxyV2(0x21, 0x21, inetAddrs(ip6src, ip6dst, 1000, 443)),
+			want: &ProxyHeader{Version: 2,
+				SrcAddr: &net.TCPAddr{IP: ip6src, Port: 1000},
+				DstAddr: &net.TCPAddr{IP: ip6dst, Port: 443}},
+		},
+		{
+			name:  "v2 proxy unix",
+			input: proxyV2(0x21, 0x31, unixAddrs("/tmp/client.sock", "/tmp/server.sock")),
+			want: &ProxyHeader{Version: 2,
+				SrcAddr: &net.UnixAddr{Name: "/tmp/client.sock", Net: "unix"},
+				DstAddr: &net.UnixAddr{Name: "/tmp/server.sock", Net: "unix"}},
+		},
+		{
+			name:  "v2 proxy unspec",
+			input: proxyV2(0x21, 0x00, []byte("ignored")),
+			want:  &ProxyHeader{Version: 2},
+		},
+	}
+	for _, tt := range tests {
+		// bytes after header are not consumed
+		h, n, err := parseProxyHeader(append(append([]byte{}, tt.input...), "GET /"...))
+		if err != nil || n != len(tt.input) {
+			t.Errorf("%s: n %d err %v; want %d", tt.name, n, err, len(tt.input))
+			continue
+		}
+		if !reflect.DeepEqual(h, tt.want) {
+			t.Errorf("%s: header %+v; want %+v", tt.name, h, tt.want)
+		}
+
+		// partial header needs more bytes
+		for i := 0; i < len(tt.input); i++ {
+			if h, n, err := parseProxyHeader(tt.input[:i]); h != nil || n != 0 || err != nil {
+				t.Errorf("%s: partial %d bytes = %v %d err %v; want more bytes", tt.name, i, h, n, err)
+			}
+		}
+	}
+}
+
+func TestParseProxyHeaderErrors(t *testing.T) {
+	ip4 := net.ParseIP("192.168.0.1").To4()
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"not proxied", []byte("GET / HTTP/1.1\r\n")},
+		{"v1 bad prefix", []byte("PROXx TCP4")},
+		{"v1 bad protocol", []byte("PROXY UDP4 192.168.0.1 10.0.0.1 1 2\r\n")},
+		{"v1 missing port", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 1\r\n")},
+		{"v1 bad ip", []byte("PROXY TCP4 192.168.0 10.0.0.1 1 2\r\n")},
+		{"v1 tcp4 with ipv6", []byte("PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n")},
+		{"v1 port overflow", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 65536 2\r\n")},
+		{"v1 line too long", append([]byte("PROXY UNKNOWN "), bytes.Repeat([]byte("x"), proxyV1MaxLen)...)},
+		{"v1 crlf beyond max len", append(append([]byte("PROXY UNKNOWN "), bytes.Repeat([]byte("x"), proxyV1MaxLen)...), "\r\n"...)},
+		{"v2 bad signature", []byte("\r\n\r\n\x00\r\nQUIx\n\x21\x11\x00\x00")},
+		{"v2 bad version", proxyV2(0x11, 0x11, inetAddrs(ip4, ip4, 1, 2))},
+		{"v2 bad command", proxyV2(0x22, 0x11, inetAddrs(ip4, ip4, 1, 2))},
+		{"v2 short inet addresses", proxyV2(0x21, 0x11, ip4, ip4)},
+		{"v2 short inet6 addresses", proxyV2(0x21, 0x21, inetAddrs(ip4, ip4, 1, 2))},
+		{"v2 short unix addresses", proxyV2(0x21, 0x31, make([]byte, 108))},
+		{"v2 truncated tlv header", proxyV2(0x21, 0x11, inetAddrs(ip4, ip4, 1, 2), []byte{ProxyTLVTypeNoop, 0})},
+		{"v2 truncated tlv value", proxyV2(0x21, 0x11, inetAddrs(ip4, ip4, 1, 2), proxyTLV(ProxyTLVTypeUniqueID, "id")[:4])},
+	}
+	for _, tt := range tests {
+		if h, n, err := parseProxyHeader(tt.input); err != ErrProxyProtocol {
+			t.Errorf("%s: = %+v %d err %v; want %v", tt.name, h, n, err, ErrProxyProtocol)
+		}
+	}
+}
+
+func TestProxyProtocolTLS(t *testing.T) {
+	addr := freeAddr(t)
+	addrs := make(chan string, 1)
+	h := &funcHandler{onConnect: func(c *Conn) {
+		// OnConnect in handshake goroutine reads the address set by io goroutine
+		addrs <- c.GetAddr()
+	}}
+	startServer(t, addr, h, WithTLSConfig(testTLSConfig(t)), WithProxyProtocol())
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\n"))
+	tc := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err = tc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-addrs:
+		if got != "203.0.113.7:40000" {
+			t.Fatalf("GetAddr() = %s; want proxied client address", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait OnConnect timeout")
+	}
+}
@@ -0,0 +1,193 @@
+package resp
+
+import (
+	"bytes"
+
+	"github.com/weedge/lib/poller"
+)
+
+type commandState int
+
+const (
+	stateStart     commandState = iota // multibulk array header or inline command
+	stateArgHeader                     // bulk string header of argument
+	stateArgData                       // bulk string data of argument
+	stateDone                          // command is complete
+)
+
+// CommandDecoder
+// incremental RESP command decoder on poller.Buffer, one decoder per connect,
+// parse multibulk ("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n") and inline ("GET k\r\n") commands;
+// parsed args are kept between reads, bytes of command are not consumed until it is complete.
+// Decode returns the raw bytes of the complete command, then get the args by Args.
+// notice: the whole command must fit in the connect read buffer (WithReadBufferLen)
+type CommandDecoder struct {
+	maxArgs   int
+	maxArgLen int
+
+	state   commandState
+	offset  int
+	argc    int
+	bulkLen int
+	args    [][]byte
+	err     error
+}
+
+// NewCommandDecoder
+// Creates command decoder with max args num and max arg length
+func NewCommandDecoder(maxArgs, maxArgLen int) *CommandDecoder {
+	if maxArgs <= 0 || maxArgLen <= 0 {
+		panic("maxArgs or maxArgLen must greater than 0")
+	}
+	return &CommandDecoder{maxArgs: maxArgs, maxArgLen: maxArgLen}
+}
+
+// Args
+// get the last complete command args, nil if empty command (blank inline line)
+func (d *CommandDecoder) Args() [][]byte {
+	return d.args
+}
+
+// Err
+// get the protocol error, the connect should reply error and close
+func (d *CommandDecoder) Err() error {
+	return d.err
+}
+
+// Decode
+// parse buffered bytes, return the raw bytes of one complete command, empty if not complete;
+// on protocol error, all buffered bytes are discarded and returned, the error is got by Err
+func (d *CommandDecoder) Decode(buffer *poller.Buffer) (value []byte, err error) {
+	value = []byte{}
+	if d.err != nil || buffer.Len() == 0 {
+		return
+	}
+	if d.state == stateDone {
+		d.reset()
+	}
+
+	b, _ := buffer.Seek(buffer.Len())
+	d.err = d.parse(b)
+	if d.err != nil {
+		d.args = nil
+		return buffer.Read(0, buffer.Len())
+	}
+	if d.state != stateDone {
+		return
+	}
+
+	return buffer.Read(0, d.offset)
+}
+
+func (d *CommandDecoder) reset() {
+	d.state = stateStart
+	d.offset = 0
+	d.argc = 0
+	d.bulkLen = 0
+	d.args = nil
+}
+
+// parse
+// continue to parse from the last state until command is complete or need more bytes
+func (d *CommandDecoder) parse(b []byte) error {
+	for {
+		switch d.state {
+		case stateStart:
+			if b[0] != byte(Array) {
+				return d.parseInline(b)
+			}
+			line, n, err := readLine(b)
+			if err == ErrIncomplete {
+				return d.checkLineLen(b)
+			}
+			argc, err := parseInt(line)
+			if err != nil {
+				return err
+			}
+			if argc > int64(d.maxArgs) {
+				return ErrTooLarge
+			}
+			d.offset = n
+			if argc <= 0 {
+				d.state = stateDone
+				return nil
+			}
+			d.argc = int(argc)
+			pre := d.argc
+			if pre > maxPreAllocElems {
+				pre = maxPreAllocElems
+			}
+			d.args = make([][]byte, 0, pre)
+			d.state = stateArgHeader
+
+		case stateArgHeader:
+			if d.offset >= len(b) {
+				return nil
+			}
+			if b[d.offset] != byte(BulkString) {
+				return ErrProtocol
+			}
+			line, n, err := readLine(b[d.offset:])
+			if err == ErrIncomplete {
+				return d.checkLineLen(b[d.offset:])
+			}
+			l, err := parseInt(line)
+			if err != nil || l < 0 {
+				return ErrProtocol
+			}
+			if l > int64(d.maxArgLen) {
+				return ErrTooLarge
+			}
+			d.offset += n
+			d.bulkLen = int(l)
+			d.state = stateArgData
+
+		case stateArgData:
+			if len(b)-d.offset < d.bulkLen+2 {
+				return nil
+			}
+			end := d.offset + d.bulkLen
+			if !bytes.Equal(b[end:end+2], crlf) {
+				return ErrProtocol
+			}
+			d.args = append(d.args, append([]byte(nil), b[d.offset:end]...))
+			d.offset = end + 2
+			d.state = stateArgHeader
+			if len(d.args) == d.argc {
+				d.state = stateDone
+			}
+
+		case stateDone:
+			return nil
+		}
+	}
+}
+
+// parseInline
+// inline command splited by whitespace, blank line is empty command
+func (d *CommandDecoder) parseInline(b []byte) error {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return d.checkLineLen(b)
+	}
+
+	fields := bytes.Fields(b[:i])
+	if len(fields) > d.maxArgs {
+		return ErrTooLarge
+	}
+	for _, f := range fields {
+		d.args = append(d.args, append([]byte(nil), f...))
+	}
+	d.offset = i + 1
+	d.state = stateDone
+	return nil
+}
+
+// checkLineLen
+// incomplete line must not be longer than max arg len
+func (d *CommandDecoder) checkLineLen(b []byte) error {
+	if len(b) > d.maxArgLen {
+		return ErrTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package resp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+// decodeCommands feed bytes to buffer by chunks of size (0: all at once), decode commands after each feed
+func decodeCommands(d *CommandDecoder, b []byte, size int) (cmds [][]string, err error) {
+	if size <= 0 {
+		size = len(b)
+	}
+	buffer := poller.NewBuffer(make([]byte, 1024))
+	for len(b) > 0 {
+		n := size
+		if n > len(b) {
+			n = len(b)
+		}
+		buffer.ReadFromReader(bytes.NewReader(b[:n]))
+		b = b[n:]
+		for buffer.Len() > 0 {
+			l := buffer.Len()
+			d.Decode(buffer)
+			if d.Err() != nil {
+				return cmds, d.Err()
+			}
+			if buffer.Len() == l {
+				break
+			}
+			var args []string
+			for _, arg := range d.Args() {
+				args = append(args, string(arg))
+			}
+			cmds = append(cmds, args)
+		}
+	}
+	return
+}
+
+func TestCommandDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		cmds  [][]string
+		err   error
+	}{
+		{"multibulk", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", [][]string{{"GET", "k"}}, nil},
+		{"empty arg", "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", [][]string{{"ECHO", ""}}, nil},
+		{"inline", "SET k  v\r\nPING\n", [][]string{{"SET", "k", "v"}, {"PING"}}, nil},
+		{"pipelined", "*1\r\n$4\r\nPING\r\nPING\r\n*1\r\n$4\r\nQUIT\r\n", [][]string{{"PING"}, {"PING"}, {"QUIT"}}, nil},
+		{"empty multibulk", "*0\r\n", [][]string{nil}, nil},
+		{"bad argc", "*x\r\n", nil, ErrProtocol},
+		{"arg is not bulk", "*1\r\n:1\r\n", nil, ErrProtocol},
+		{"negative bulk length", "*1\r\n$-1\r\n", nil, ErrProtocol},
+		{"bulk without crlf", "*1\r\n$1\r\nabc\r\n", nil, ErrProtocol},
+		{"too many args", "*4\r\n", nil, ErrTooLarge},
+		{"arg too large", "*1\r\n$17\r\n", nil, ErrTooLarge},
+		{"max int64 bulk length", "*1\r\n$9223372036854775807\r\n", nil, ErrTooLarge},
+		{"line too long", "*1\r\n$12345678901234567", nil, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		for _, size := range []int{0, 1, 3} {
+			cmds, err := decodeCommands(NewCommandDecoder(3, 16), []byte(tt.input), size)
+			if err != tt.err {
+				t.Errorf("%s: read by %d err %v; want %v", tt.name, size, err, tt.err)
+				continue
+			}
+			if err == nil && !reflect.DeepEqual(cmds, tt.cmds) {
+				t.Errorf("%s: read by %d commands %q; want %q", tt.name, size, cmds, tt.cmds)
+			}
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter(WithMaxArgLen(32)).Handle("echo", func(w *ReplyWriter, cmd *Command) {
+		w.WriteBulk(cmd.Args[1])
+	})
+	c, err := pollertest.New(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.WriteChunks([]byte("*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\nPING\r\nNOPE a\r\nHELLO 3\r\n"), 4)
+	out, err := c.Recv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replies []Value
+	for len(out) > 0 {
+		v, n, err := ReadValue(out)
+		if err != nil {
+			t.Fatalf("reply %q err %v", out, err)
+		}
+		replies = append(replies, v)
+		out = out[n:]
+	}
+	if len(replies) != 4 {
+		t.Fatalf("got %d replies; want 4", len(replies))
+	}
+	if string(replies[0].Str) != "hi" || string(replies[1].Str) != "PONG" || replies[2].Type != Error {
+		t.Errorf("replies %+v", replies[:3])
+	}
+	if replies[3].Type != Map {
+		t.Errorf("HELLO 3 reply type %c; want map", replies[3].Type)
+	}
+
+	// protocol error reply and close
+	c.Write([]byte("*1\r\n$99\r\n"))
+	out, _ = c.Recv(0)
+	if v, _, err := ReadValue(out); err != nil || v.Type != Error {
+		t.Fatalf("protocol error reply %q err %v", out, err)
+	}
+	if !c.PollerConn().IsClosed() {
+		t.Error("connect is not closed after protocol error")
+	}
+}
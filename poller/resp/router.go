@@ -0,0 +1,255 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/poller"
+)
+
+const (
+	defaultMaxArgs   = 1024
+	defaultMaxArgLen = 64 * 1024
+)
+
+// Session
+// connect session of RESP server
+type Session struct {
+	ID      int64        // session id, HELLO reply id
+	Conn    *poller.Conn // connect
+	Proto   int          // RESP2 or RESP3 (switched by HELLO 3)
+	Data    interface{}  // Business custom data, eg: selected db
+	decoder *CommandDecoder
+	closing bool
+}
+
+// Close close connect after replies of current command written, eg: QUIT
+func (s *Session) Close() {
+	s.closing = true
+}
+
+// Command one decoded command
+type Command struct {
+	Args    [][]byte // command name and args
+	Session *Session
+}
+
+// Name lower case command name
+func (c *Command) Name() string {
+	return strings.ToLower(string(c.Args[0]))
+}
+
+// HandlerFunc
+// command handler, write replies to w, run on poller io goroutine, don't block
+type HandlerFunc func(w *ReplyWriter, cmd *Command)
+
+// Router
+// poller.Handler dispatch RESP commands to registered handlers by command name (case insensitive),
+// builtin PING, HELLO, QUIT can be replaced;
+// notice: connect data (Conn.SetData) is used by router for Session
+type Router struct {
+	handlers  map[string]HandlerFunc
+	maxArgs   int
+	maxArgLen int
+	sessionID int64
+}
+
+// RouterOption Router opt config
+type RouterOption func(r *Router)
+
+// WithMaxArgs max args num of one command, default 1024
+func WithMaxArgs(n int) RouterOption {
+	return func(r *Router) {
+		if n <= 0 {
+			panic("max args must greater than 0")
+		}
+		r.maxArgs = n
+	}
+}
+
+// WithMaxArgLen max length of one arg, default 64KB
+func WithMaxArgLen(n int) RouterOption {
+	return func(r *Router) {
+		if n <= 0 {
+			panic("max arg len must greater than 0")
+		}
+		r.maxArgLen = n
+	}
+}
+
+// NewRouter
+// Creates command router with builtin PING, HELLO, QUIT
+func NewRouter(opts ...RouterOption) *Router {
+	r := &Router{
+		handlers:  map[string]HandlerFunc{},
+		maxArgs:   defaultMaxArgs,
+		maxArgLen: defaultMaxArgLen,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+
+	r.Handle("ping", ping)
+	r.Handle("hello", hello)
+	r.Handle("quit", quit)
+	return r
+}
+
+// Handle
+// register command handler, register before server run
+func (r *Router) Handle(name string, h HandlerFunc) *Router {
+	r.handlers[strings.ToLower(name)] = h
+	return r
+}
+
+// NewServer
+// Creates poller server serving RESP commands on address by router,
+// read buffer len default is 2 * max arg len
+func NewServer(address string, router *Router, opts ...poller.Option) (*poller.Server, error) {
+	opts = append([]poller.Option{poller.WithReadBufferLen(2 * router.maxArgLen)}, opts...)
+	return poller.NewServer(address, router, opts...)
+}
+
+// OnConnect
+// init connect session and command decoder
+func (r *Router) OnConnect(c *poller.Conn) {
+	s := &Session{
+		ID:      atomic.AddInt64(&r.sessionID, 1),
+		Conn:    c,
+		Proto:   RESP2,
+		decoder: NewCommandDecoder(r.maxArgs, r.maxArgLen),
+	}
+	c.SetData(s)
+	c.SetDecoder(s.decoder)
+}
+
+// OnMessage
+// dispatch one complete command, or reply protocol error and close connect
+func (r *Router) OnMessage(c *poller.Conn, bytes []byte) {
+	s, ok := c.GetData().(*Session)
+	if !ok {
+		return
+	}
+
+	w := NewReplyWriter(s.Proto)
+	if err := s.decoder.Err(); err != nil {
+		log.Warnf("resp connect %s decode command err %s", c.GetAddr(), err.Error())
+		w.WriteError("ERR Protocol error: " + strings.TrimPrefix(err.Error(), "resp: "))
+		c.Write(w.Bytes())
+		c.CloseAfterFlush()
+		return
+	}
+
+	args := s.decoder.Args()
+	if len(args) == 0 {
+		return
+	}
+
+	cmd := &Command{Args: args, Session: s}
+	h, ok := r.handlers[cmd.Name()]
+	if !ok {
+		w.WriteError(unknownCommandError(args))
+	} else {
+		h(w, cmd)
+	}
+
+	_, err := c.Write(w.Bytes())
+	if err != nil {
+		log.Warnf("resp connect %s write reply err %s", c.GetAddr(), err.Error())
+		c.Close()
+		return
+	}
+
+	if s.closing {
+		c.CloseAfterFlush()
+	}
+}
+
+// OnClose
+func (r *Router) OnClose(c *poller.Conn, err error) {
+	if err != nil && err != io.EOF {
+		log.Debugf("resp connect %s close err %s", c.GetAddr(), err.Error())
+	}
+}
+
+func unknownCommandError(args [][]byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ERR unknown command '%s', with args beginning with:", args[0])
+	for _, arg := range args[1:] {
+		fmt.Fprintf(&b, " '%s'", arg)
+	}
+	return b.String()
+}
+
+// ping
+// PING [message]
+func ping(w *ReplyWriter, cmd *Command) {
+	switch len(cmd.Args) {
+	case 1:
+		w.WriteSimpleString("PONG")
+	case 2:
+		w.WriteBulk(cmd.Args[1])
+	default:
+		w.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// quit
+// QUIT, reply OK and close connect
+func quit(w *ReplyWriter, cmd *Command) {
+	w.WriteOK()
+	cmd.Session.Close()
+}
+
+// hello
+// HELLO [protover [AUTH username password] [SETNAME clientname]], switch RESP2/RESP3
+func hello(w *ReplyWriter, cmd *Command) {
+	s := cmd.Session
+	if len(cmd.Args) > 1 {
+		proto, err := strconv.Atoi(string(cmd.Args[1]))
+		if err != nil {
+			w.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != RESP2 && proto != RESP3 {
+			w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		for i := 2; i < len(cmd.Args); i++ {
+			opt := cmd.Args[i]
+			if bytes.EqualFold(opt, []byte("auth")) && i+2 < len(cmd.Args) {
+				i += 2
+				continue
+			}
+			if bytes.EqualFold(opt, []byte("setname")) && i+1 < len(cmd.Args) {
+				i++
+				continue
+			}
+			w.WriteError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", opt))
+			return
+		}
+		s.Proto = proto
+	}
+
+	w.proto = s.Proto
+	w.WriteMapLen(7)
+	w.WriteBulkString("server")
+	w.WriteBulkString("poller")
+	w.WriteBulkString("version")
+	w.WriteBulkString("1.0.0")
+	w.WriteBulkString("proto")
+	w.WriteInt(int64(s.Proto))
+	w.WriteBulkString("id")
+	w.WriteInt(s.ID)
+	w.WriteBulkString("mode")
+	w.WriteBulkString("standalone")
+	w.WriteBulkString("role")
+	w.WriteBulkString("master")
+	w.WriteBulkString("modules")
+	w.WriteArrayLen(0)
+}
@@ -0,0 +1,206 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/weedge/lib/poller"
+)
+
+var (
+	ErrIncomplete = errors.New("resp: incomplete value")
+	ErrProtocol   = errors.New("resp: protocol error")
+	ErrTooLarge   = errors.New("resp: value too large")
+)
+
+var crlf = []byte("\r\n")
+
+// Type RESP2/RESP3 value type, the first byte of value
+type Type byte
+
+const (
+	SimpleString   Type = '+'
+	Error          Type = '-'
+	Integer        Type = ':'
+	BulkString     Type = '$'
+	Array          Type = '*'
+	Null           Type = '_' // RESP3
+	Boolean        Type = '#' // RESP3
+	Double         Type = ',' // RESP3
+	BigNumber      Type = '(' // RESP3
+	BulkError      Type = '!' // RESP3
+	VerbatimString Type = '=' // RESP3
+	Map            Type = '%' // RESP3
+	Attribute      Type = '|' // RESP3
+	Set            Type = '~' // RESP3
+	Push           Type = '>' // RESP3
+)
+
+const (
+	// maxPreAllocElems don't trust aggregate length to pre alloc elems
+	maxPreAllocElems = 1024
+)
+
+// Value RESP2/RESP3 value
+type Value struct {
+	Type   Type
+	Str    []byte  // SimpleString, Error, BulkString, BigNumber, BulkError, VerbatimString ("txt:" prefix)
+	Int    int64   // Integer
+	Float  float64 // Double
+	Bool   bool    // Boolean
+	Elems  []Value // Array, Set, Push; Map, Attribute with flattened key value pairs
+	IsNull bool    // RESP2 null bulk string ("$-1") / null array ("*-1"), RESP3 Null
+}
+
+// ReadValue
+// parse one complete RESP2/RESP3 value from b, return consumed bytes num;
+// ErrIncomplete if b is not complete, streamed aggregate is not supported
+func ReadValue(b []byte) (v Value, n int, err error) {
+	if len(b) == 0 {
+		return v, 0, ErrIncomplete
+	}
+	line, n, err := readLine(b)
+	if err != nil {
+		return
+	}
+
+	v.Type = Type(b[0])
+	switch v.Type {
+	case SimpleString, Error, BigNumber:
+		v.Str = append([]byte(nil), line...)
+
+	case Integer:
+		v.Int, err = parseInt(line)
+
+	case Null:
+		v.IsNull = true
+
+	case Boolean:
+		if len(line) != 1 || (line[0] != 't' && line[0] != 'f') {
+			err = ErrProtocol
+			return
+		}
+		v.Bool = line[0] == 't'
+
+	case Double:
+		v.Float, err = strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			err = ErrProtocol
+		}
+
+	case BulkString, BulkError, VerbatimString:
+		var l int64
+		l, err = parseInt(line)
+		if err != nil {
+			return
+		}
+		if l < 0 {
+			v.IsNull = true
+			return
+		}
+		// compare before add, huge length overflows
+		if l > int64(len(b)-n) || int64(len(b)-n)-l < 2 {
+			err = ErrIncomplete
+			return
+		}
+		end := n + int(l)
+		if !bytes.Equal(b[end:end+2], crlf) {
+			err = ErrProtocol
+			return
+		}
+		v.Str = append([]byte(nil), b[n:end]...)
+		n = end + 2
+
+	case Array, Set, Push, Map, Attribute:
+		var l int64
+		l, err = parseInt(line)
+		if err != nil {
+			return
+		}
+		if l < 0 {
+			v.IsNull = true
+			return
+		}
+		// each elem has one byte at least, huge length overflows when doubled
+		if l > int64(len(b)-n) {
+			err = ErrIncomplete
+			return
+		}
+		if v.Type == Map || v.Type == Attribute {
+			l *= 2
+		}
+		pre := l
+		if pre > maxPreAllocElems {
+			pre = maxPreAllocElems
+		}
+		v.Elems = make([]Value, 0, pre)
+		for i := int64(0); i < l; i++ {
+			elem, en, eerr := ReadValue(b[n:])
+			if eerr != nil {
+				err = eerr
+				return
+			}
+			v.Elems = append(v.Elems, elem)
+			n += en
+		}
+
+	default:
+		err = ErrProtocol
+	}
+
+	return
+}
+
+// readLine
+// read line without type byte and CRLF, return next position
+func readLine(b []byte) (line []byte, next int, err error) {
+	i := bytes.Index(b, crlf)
+	if i < 0 {
+		return nil, 0, ErrIncomplete
+	}
+	return b[1:i], i + 2, nil
+}
+
+func parseInt(b []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrProtocol
+	}
+	return n, nil
+}
+
+// valueDecoder
+type valueDecoder struct {
+	maxLen int
+}
+
+// NewValueDecoder
+// Creates RESP value decoder, eg: proxy read replies from dialed redis connect;
+// Decode returns the raw bytes of one complete value, parse it by ReadValue
+// maxLen max raw bytes length of one value, must less than read buffer len
+func NewValueDecoder(maxLen int) poller.Decoder {
+	if maxLen <= 0 {
+		panic("maxLen must greater than 0")
+	}
+	return &valueDecoder{maxLen: maxLen}
+}
+
+// Decode
+// decode one complete value raw bytes, empty if value is not complete
+func (d *valueDecoder) Decode(buffer *poller.Buffer) (value []byte, err error) {
+	value = []byte{}
+	b, _ := buffer.Seek(buffer.Len())
+	_, n, err := ReadValue(b)
+	if err == ErrIncomplete {
+		if len(b) > d.maxLen {
+			return value, ErrTooLarge
+		}
+		return value, nil
+	}
+	if err != nil {
+		return
+	}
+
+	return buffer.Read(0, n)
+}
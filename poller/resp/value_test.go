@@ -0,0 +1,120 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+)
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Value
+	}{
+		{"+OK\r\n", Value{Type: SimpleString, Str: []byte("OK")}},
+		{"-ERR bad\r\n", Value{Type: Error, Str: []byte("ERR bad")}},
+		{":-42\r\n", Value{Type: Integer, Int: -42}},
+		{"$5\r\nhello\r\n", Value{Type: BulkString, Str: []byte("hello")}},
+		{"$0\r\n\r\n", Value{Type: BulkString}},
+		{"$-1\r\n", Value{Type: BulkString, IsNull: true}},
+		{"*-1\r\n", Value{Type: Array, IsNull: true}},
+		{"_\r\n", Value{Type: Null, IsNull: true}},
+		{"#t\r\n", Value{Type: Boolean, Bool: true}},
+		{",1.5\r\n", Value{Type: Double, Float: 1.5}},
+		{"(12345678901234567890\r\n", Value{Type: BigNumber, Str: []byte("12345678901234567890")}},
+		{"!3\r\nerr\r\n", Value{Type: BulkError, Str: []byte("err")}},
+		{"=8\r\ntxt:text\r\n", Value{Type: VerbatimString, Str: []byte("txt:text")}},
+		{"*0\r\n", Value{Type: Array, Elems: []Value{}}},
+		{"*2\r\n:1\r\n$1\r\na\r\n", Value{Type: Array, Elems: []Value{{Type: Integer, Int: 1}, {Type: BulkString, Str: []byte("a")}}}},
+		{"%1\r\n+k\r\n:1\r\n", Value{Type: Map, Elems: []Value{{Type: SimpleString, Str: []byte("k")}, {Type: Integer, Int: 1}}}},
+		{"~1\r\n#f\r\n", Value{Type: Set, Elems: []Value{{Type: Boolean}}}},
+		{">1\r\n+msg\r\n", Value{Type: Push, Elems: []Value{{Type: SimpleString, Str: []byte("msg")}}}},
+	}
+	for _, tt := range tests {
+		v, n, err := ReadValue([]byte(tt.input + "+next\r\n"))
+		if err != nil || n != len(tt.input) {
+			t.Errorf("ReadValue(%q) n %d err %v; want %d", tt.input, n, err, len(tt.input))
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("ReadValue(%q) = %+v; want %+v", tt.input, v, tt.want)
+		}
+		// round trip
+		if b := AppendValue(nil, v); tt.want.Type != Double && string(b) != tt.input {
+			t.Errorf("AppendValue(%+v) = %q; want %q", v, b, tt.input)
+		}
+
+		// every prefix is incomplete
+		for i := 0; i < len(tt.input); i++ {
+			if _, _, err := ReadValue([]byte(tt.input[:i])); err != ErrIncomplete {
+				t.Errorf("ReadValue(%q) err %v; want %v", tt.input[:i], err, ErrIncomplete)
+			}
+		}
+	}
+}
+
+func TestReadValueErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"unknown type", "?x\r\n", ErrProtocol},
+		{"bad integer", ":1x\r\n", ErrProtocol},
+		{"bad boolean", "#x\r\n", ErrProtocol},
+		{"bad double", ",x\r\n", ErrProtocol},
+		{"bad bulk length", "$x\r\n", ErrProtocol},
+		{"bulk without crlf", "$1\r\nabc\r\n", ErrProtocol},
+		{"bad elem", "*1\r\n?\r\n", ErrProtocol},
+		// huge lengths must not overflow length checks
+		{"max int64 bulk length", "$9223372036854775807\r\nab\r\n", ErrIncomplete},
+		{"max int64 bulk error length", "!9223372036854775807\r\n", ErrIncomplete},
+		{"max int64 verbatim length", "=9223372036854775806\r\nxx\r\n", ErrIncomplete},
+		{"max int64 array length", "*9223372036854775807\r\n:1\r\n", ErrIncomplete},
+		{"max int64 map length", "%9223372036854775807\r\n:1\r\n:1\r\n", ErrIncomplete},
+		{"bulk length overflows int64", "$9223372036854775808\r\n", ErrProtocol},
+	}
+	for _, tt := range tests {
+		if _, _, err := ReadValue([]byte(tt.input)); err != tt.err {
+			t.Errorf("%s: ReadValue(%q) err %v; want %v", tt.name, tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestAppendDouble(t *testing.T) {
+	for _, f := range []float64{0, -1.25, math.Inf(1), math.Inf(-1)} {
+		v, _, err := ReadValue(appendDouble(nil, f))
+		if err != nil || v.Float != f {
+			t.Errorf("double %v round trip %v err %v", f, v.Float, err)
+		}
+	}
+}
+
+func TestValueDecoder(t *testing.T) {
+	d := NewValueDecoder(16)
+	buffer := poller.NewBuffer(make([]byte, 64))
+	stream := []byte("*1\r\n$1\r\na\r\n:1\r\n")
+	var values []string
+	for _, c := range stream {
+		buffer.ReadFromReader(bytes.NewReader([]byte{c}))
+		v, err := d.Decode(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(v) > 0 {
+			values = append(values, string(v))
+		}
+	}
+	if want := []string{"*1\r\n$1\r\na\r\n", ":1\r\n"}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("decoded %q; want %q", values, want)
+	}
+
+	// incomplete value exceed max len
+	buffer.ReadFromReader(bytes.NewReader([]byte("$9223372036854775807\r\n")))
+	if _, err := d.Decode(buffer); err != ErrTooLarge {
+		t.Fatalf("Decode() err %v; want %v", err, ErrTooLarge)
+	}
+}
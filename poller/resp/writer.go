@@ -0,0 +1,259 @@
+package resp
+
+import (
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/weedge/lib/poller"
+)
+
+const (
+	// RESP2 default protocol version
+	RESP2 = 2
+	// RESP3 protocol version switched by HELLO 3
+	RESP3 = 3
+)
+
+// AppendSimpleString append "+s\r\n"
+func AppendSimpleString(dst []byte, s string) []byte {
+	dst = append(dst, byte(SimpleString))
+	dst = append(dst, s...)
+	return append(dst, crlf...)
+}
+
+// AppendError append "-msg\r\n", msg with error code prefix, eg: "ERR syntax error"
+func AppendError(dst []byte, msg string) []byte {
+	dst = append(dst, byte(Error))
+	dst = append(dst, msg...)
+	return append(dst, crlf...)
+}
+
+// AppendInt append ":n\r\n"
+func AppendInt(dst []byte, n int64) []byte {
+	return appendHeader(dst, Integer, n)
+}
+
+// AppendBulk append "$len\r\nb\r\n"
+func AppendBulk(dst []byte, b []byte) []byte {
+	dst = appendHeader(dst, BulkString, int64(len(b)))
+	dst = append(dst, b...)
+	return append(dst, crlf...)
+}
+
+// AppendBulkString append "$len\r\ns\r\n"
+func AppendBulkString(dst []byte, s string) []byte {
+	dst = appendHeader(dst, BulkString, int64(len(s)))
+	dst = append(dst, s...)
+	return append(dst, crlf...)
+}
+
+// AppendArrayLen append "*n\r\n", then append n elems
+func AppendArrayLen(dst []byte, n int) []byte {
+	return appendHeader(dst, Array, int64(n))
+}
+
+// AppendValue append RESP value as it is
+func AppendValue(dst []byte, v Value) []byte {
+	if v.IsNull {
+		if v.Type == Null {
+			return append(dst, "_\r\n"...)
+		}
+		return appendHeader(dst, v.Type, -1)
+	}
+
+	switch v.Type {
+	case SimpleString, Error, BigNumber:
+		dst = append(dst, byte(v.Type))
+		dst = append(dst, v.Str...)
+		dst = append(dst, crlf...)
+	case Integer:
+		dst = AppendInt(dst, v.Int)
+	case Boolean:
+		dst = appendBool(dst, v.Bool)
+	case Double:
+		dst = appendDouble(dst, v.Float)
+	case BulkString, BulkError, VerbatimString:
+		dst = appendHeader(dst, v.Type, int64(len(v.Str)))
+		dst = append(dst, v.Str...)
+		dst = append(dst, crlf...)
+	case Map, Attribute:
+		dst = appendHeader(dst, v.Type, int64(len(v.Elems)/2))
+		for _, e := range v.Elems {
+			dst = AppendValue(dst, e)
+		}
+	case Array, Set, Push:
+		dst = appendHeader(dst, v.Type, int64(len(v.Elems)))
+		for _, e := range v.Elems {
+			dst = AppendValue(dst, e)
+		}
+	}
+	return dst
+}
+
+func appendHeader(dst []byte, t Type, n int64) []byte {
+	dst = append(dst, byte(t))
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, crlf...)
+}
+
+func appendBool(dst []byte, b bool) []byte {
+	if b {
+		return append(dst, "#t\r\n"...)
+	}
+	return append(dst, "#f\r\n"...)
+}
+
+func appendDouble(dst []byte, f float64) []byte {
+	dst = append(dst, byte(Double))
+	switch {
+	case math.IsInf(f, 1):
+		dst = append(dst, "inf"...)
+	case math.IsInf(f, -1):
+		dst = append(dst, "-inf"...)
+	case math.IsNaN(f):
+		dst = append(dst, "nan"...)
+	default:
+		dst = strconv.AppendFloat(dst, f, 'g', -1, 64)
+	}
+	return append(dst, crlf...)
+}
+
+// ReplyWriter
+// buffer replies of one command by connect protocol version,
+// RESP3 types are downgraded to RESP2 types for RESP2 connect
+type ReplyWriter struct {
+	buf   []byte
+	proto int
+}
+
+// NewReplyWriter Creates reply writer with protocol version RESP2/RESP3
+func NewReplyWriter(proto int) *ReplyWriter {
+	return &ReplyWriter{proto: proto}
+}
+
+// Bytes gets buffered replies
+func (w *ReplyWriter) Bytes() []byte {
+	return w.buf
+}
+
+// Reset resets buffered replies
+func (w *ReplyWriter) Reset() {
+	w.buf = w.buf[:0]
+}
+
+// Proto gets protocol version
+func (w *ReplyWriter) Proto() int {
+	return w.proto
+}
+
+func (w *ReplyWriter) WriteSimpleString(s string) {
+	w.buf = AppendSimpleString(w.buf, s)
+}
+
+func (w *ReplyWriter) WriteOK() {
+	w.buf = append(w.buf, "+OK\r\n"...)
+}
+
+func (w *ReplyWriter) WriteError(msg string) {
+	w.buf = AppendError(w.buf, msg)
+}
+
+func (w *ReplyWriter) WriteInt(n int64) {
+	w.buf = AppendInt(w.buf, n)
+}
+
+func (w *ReplyWriter) WriteBulk(b []byte) {
+	w.buf = AppendBulk(w.buf, b)
+}
+
+func (w *ReplyWriter) WriteBulkString(s string) {
+	w.buf = AppendBulkString(w.buf, s)
+}
+
+func (w *ReplyWriter) WriteArrayLen(n int) {
+	w.buf = AppendArrayLen(w.buf, n)
+}
+
+// WriteNull RESP3 null, RESP2 null bulk string
+func (w *ReplyWriter) WriteNull() {
+	if w.proto == RESP3 {
+		w.buf = append(w.buf, "_\r\n"...)
+		return
+	}
+	w.buf = append(w.buf, "$-1\r\n"...)
+}
+
+// WriteNullArray RESP3 null, RESP2 null array
+func (w *ReplyWriter) WriteNullArray() {
+	if w.proto == RESP3 {
+		w.buf = append(w.buf, "_\r\n"...)
+		return
+	}
+	w.buf = append(w.buf, "*-1\r\n"...)
+}
+
+// WriteMapLen RESP3 map, RESP2 array with 2*n elems, then write n key value pairs
+func (w *ReplyWriter) WriteMapLen(n int) {
+	if w.proto == RESP3 {
+		w.buf = appendHeader(w.buf, Map, int64(n))
+		return
+	}
+	w.buf = AppendArrayLen(w.buf, 2*n)
+}
+
+// WriteSetLen RESP3 set, RESP2 array, then write n elems
+func (w *ReplyWriter) WriteSetLen(n int) {
+	if w.proto == RESP3 {
+		w.buf = appendHeader(w.buf, Set, int64(n))
+		return
+	}
+	w.buf = AppendArrayLen(w.buf, n)
+}
+
+// WriteBool RESP3 boolean, RESP2 integer 1/0
+func (w *ReplyWriter) WriteBool(b bool) {
+	if w.proto == RESP3 {
+		w.buf = appendBool(w.buf, b)
+		return
+	}
+	if b {
+		w.buf = AppendInt(w.buf, 1)
+		return
+	}
+	w.buf = AppendInt(w.buf, 0)
+}
+
+// WriteDouble RESP3 double, RESP2 bulk string
+func (w *ReplyWriter) WriteDouble(f float64) {
+	if w.proto == RESP3 {
+		w.buf = appendDouble(w.buf, f)
+		return
+	}
+	w.buf = AppendBulk(w.buf, strconv.AppendFloat(nil, f, 'g', -1, 64))
+}
+
+// WriteValue write RESP value as it is
+func (w *ReplyWriter) WriteValue(v Value) {
+	w.buf = AppendValue(w.buf, v)
+}
+
+// WriteRaw write encoded RESP bytes, eg: proxy forward replies
+func (w *ReplyWriter) WriteRaw(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+type bulkEncoder struct{}
+
+// NewEncoder
+// Creates RESP encoder, encode bytes as bulk string for Conn.WriteWithEncoder
+func NewEncoder() poller.Encoder {
+	return &bulkEncoder{}
+}
+
+// EncodeToWriter
+// Encodes bytes as bulk string and writes it to Writer
+func (e *bulkEncoder) EncodeToWriter(w io.Writer, bytes []byte) error {
+	_, err := w.Write(AppendBulk(make([]byte, 0, len(bytes)+16), bytes))
+	return err
+}
@@ -0,0 +1,283 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/poller"
+)
+
+// Client
+// rpc client event loops, dialed connects are registered in the same poller client loops
+type Client struct {
+	client      *poller.Client
+	maxFrameLen int
+
+	lock   sync.Mutex
+	conns  map[*ClientConn]struct{}
+	closed bool
+}
+
+// NewClient
+// Creates and runs rpc client event loops, max frame len is DefaultMaxFrameLen
+func NewClient(opts ...poller.Option) (*Client, error) {
+	c := &Client{
+		maxFrameLen: DefaultMaxFrameLen,
+		conns:       map[*ClientConn]struct{}{},
+	}
+	opts = append([]poller.Option{poller.WithReadBufferLen(c.maxFrameLen)}, opts...)
+	opts = append(opts, poller.WithDecoder(NewFrameDecoder(c.maxFrameLen)))
+	pc, err := poller.NewClient(&clientHandler{}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.client = pc
+	go pc.Run()
+	return c, nil
+}
+
+// Dial
+// dial rpc server, calls are multiplexed over the connect
+func (c *Client) Dial(address string) (*ClientConn, error) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.lock.Unlock()
+
+	conn, err := c.client.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	cc := &ClientConn{
+		client: c,
+		conn:   conn,
+		calls:  map[uint32]chan *result{},
+	}
+	conn.SetData(cc)
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		cc.Close()
+		return nil, ErrClientClosed
+	}
+	c.conns[cc] = struct{}{}
+	c.lock.Unlock()
+	return cc, nil
+}
+
+// Close
+// close dialed connects (in flight calls get ErrClientClosed) and stop event loops
+func (c *Client) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrClientClosed
+	}
+	c.closed = true
+	conns := c.conns
+	c.conns = nil
+	c.lock.Unlock()
+
+	for cc := range conns {
+		cc.closeWithErr(ErrClientClosed)
+	}
+	c.client.Stop()
+	return nil
+}
+
+// remove
+func (c *Client) remove(cc *ClientConn) {
+	c.lock.Lock()
+	delete(c.conns, cc)
+	c.lock.Unlock()
+}
+
+// result
+// response or error of call
+type result struct {
+	resp []byte
+	err  error
+}
+
+// ClientConn
+// rpc connect, Call is goroutine safe
+type ClientConn struct {
+	client *Client
+	conn   *poller.Conn
+
+	lock   sync.Mutex
+	nextID uint32
+	calls  map[uint32]chan *result // in flight calls by stream id
+	err    error                   // closed err
+}
+
+// Call
+// call method with request payload, wait response until ctx done;
+// ctx deadline is sent as request timeout, canceled call is canceled on server
+func (cc *ClientConn) Call(ctx context.Context, method string, req []byte) ([]byte, error) {
+	f := &Frame{Type: TypeRequest, Method: method, Payload: req}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		f.Timeout = uint32((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	ch := make(chan *result, 1)
+	cc.lock.Lock()
+	if cc.err != nil {
+		cc.lock.Unlock()
+		return nil, cc.err
+	}
+	f.StreamID = cc.newStreamID()
+	cc.calls[f.StreamID] = ch
+	cc.lock.Unlock()
+
+	b, err := encodeFrame(f, cc.client.maxFrameLen)
+	if err == nil {
+		_, err = cc.conn.Write(b)
+	}
+	if err != nil {
+		cc.remove(f.StreamID)
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.resp, r.err
+	case <-ctx.Done():
+		if cc.remove(f.StreamID) {
+			cc.write(&Frame{StreamID: f.StreamID, Type: TypeCancel})
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// newStreamID
+// next stream id not in flight, 0 is skipped; hold lock
+func (cc *ClientConn) newStreamID() uint32 {
+	for {
+		cc.nextID++
+		if _, ok := cc.calls[cc.nextID]; cc.nextID != 0 && !ok {
+			return cc.nextID
+		}
+	}
+}
+
+// remove
+// remove in flight call, false if response is received
+func (cc *ClientConn) remove(streamID uint32) bool {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	if _, ok := cc.calls[streamID]; !ok {
+		return false
+	}
+	delete(cc.calls, streamID)
+	return true
+}
+
+// write
+func (cc *ClientConn) write(f *Frame) error {
+	b, err := encodeFrame(f, cc.client.maxFrameLen)
+	if err != nil {
+		return err
+	}
+	_, err = cc.conn.Write(b)
+	return err
+}
+
+// deliver
+// response frame of in flight call, copy payload from read buffer
+func (cc *ClientConn) deliver(f *Frame) {
+	cc.lock.Lock()
+	ch, ok := cc.calls[f.StreamID]
+	delete(cc.calls, f.StreamID)
+	cc.lock.Unlock()
+	if !ok {
+		// canceled call
+		return
+	}
+
+	r := &result{}
+	if f.Type == TypeError {
+		r.err = RemoteError(f.Payload)
+	} else {
+		r.resp = append([]byte{}, f.Payload...)
+	}
+	ch <- r
+}
+
+// RemoteAddr server address
+func (cc *ClientConn) RemoteAddr() string {
+	return cc.conn.GetAddr()
+}
+
+// PollerConn
+// underlying poller connect, eg: SetIdleTimeout
+func (cc *ClientConn) PollerConn() *poller.Conn {
+	return cc.conn
+}
+
+// Close
+// close connect, in flight calls get ErrConnClosed
+func (cc *ClientConn) Close() error {
+	if !cc.closeWithErr(ErrConnClosed) {
+		return ErrConnClosed
+	}
+	cc.client.remove(cc)
+	return nil
+}
+
+// closeWithErr
+// close connect once and fail in flight calls with err
+func (cc *ClientConn) closeWithErr(err error) bool {
+	cc.lock.Lock()
+	if cc.err != nil {
+		cc.lock.Unlock()
+		return false
+	}
+	cc.err = err
+	calls := cc.calls
+	cc.calls = map[uint32]chan *result{}
+	cc.lock.Unlock()
+
+	cc.conn.Close()
+	for _, ch := range calls {
+		ch <- &result{err: err}
+	}
+	return true
+}
+
+// clientHandler
+// poller.Handler of client connects, deliver response frames to ClientConn
+type clientHandler struct{}
+
+func (h *clientHandler) OnConnect(c *poller.Conn) {}
+
+func (h *clientHandler) OnMessage(c *poller.Conn, bytes []byte) {
+	cc, ok := c.GetData().(*ClientConn)
+	if !ok {
+		return
+	}
+	f, err := ParseFrame(bytes)
+	if err != nil || (f.Type != TypeResponse && f.Type != TypeError) {
+		log.Warnf("rpc client connect %s bad frame", c.GetAddr())
+		cc.Close()
+		return
+	}
+	cc.deliver(f)
+}
+
+func (h *clientHandler) OnClose(c *poller.Conn, err error) {
+	if cc, ok := c.GetData().(*ClientConn); ok {
+		if cc.closeWithErr(ErrConnClosed) {
+			cc.client.remove(cc)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer run rpc server of router on loopback free port until test cleanup, return address
+func startServer(t *testing.T, router *Router) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s, err := NewServer(addr, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Run()
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		wg.Wait()
+	})
+	return addr
+}
+
+// dial rpc client connect to address, client is closed on test cleanup
+func dial(t *testing.T, addr string) *ClientConn {
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	cc, err := c.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+// wait err from channel
+func wait(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait timeout")
+	}
+	return nil
+}
+
+func echo(call *Call, req []byte) {
+	call.Reply(req, nil)
+}
+
+func TestCallReply(t *testing.T) {
+	replies := make(chan error, 1)
+	router := NewRouter().
+		Handle("echo", echo).
+		Handle("fail", func(call *Call, req []byte) {
+			call.Reply(nil, errors.New("boom"))
+		}).
+		Handle("twice", func(call *Call, req []byte) {
+			call.Reply([]byte("first"), nil)
+			replies <- call.Reply([]byte("second"), nil)
+		})
+	cc := dial(t, startServer(t, router))
+	ctx := context.Background()
+
+	for _, req := range [][]byte{[]byte("hello"), {}, make([]byte, 64*1024)} {
+		resp, err := cc.Call(ctx, "echo", req)
+		if err != nil || string(resp) != string(req) {
+			t.Fatalf("echo %d bytes = %d bytes err %v", len(req), len(resp), err)
+		}
+	}
+	if _, err := cc.Call(ctx, "fail", nil); err != RemoteError("boom") {
+		t.Fatalf("fail err %v; want remote error", err)
+	}
+	if _, err := cc.Call(ctx, "nobody", nil); err != RemoteError(ErrMethodNotFound.Error()+": nobody") {
+		t.Fatalf("unknown method err %v", err)
+	}
+	if resp, err := cc.Call(ctx, "twice", nil); err != nil || string(resp) != "first" {
+		t.Fatalf("twice = %q err %v", resp, err)
+	}
+	if err := wait(t, replies); err != ErrReplied {
+		t.Fatalf("second Reply() err %v; want %v", err, ErrReplied)
+	}
+	// connect is still served
+	if resp, err := cc.Call(ctx, "echo", []byte("again")); err != nil || string(resp) != "again" {
+		t.Fatalf("echo = %q err %v", resp, err)
+	}
+}
+
+func TestCallOutOfOrder(t *testing.T) {
+	release := make(chan struct{})
+	router := NewRouter().
+		Handle("echo", echo).
+		Handle("slow", func(call *Call, req []byte) {
+			req = append([]byte{}, req...)
+			go func() {
+				<-release
+				call.Reply(req, nil)
+			}()
+		})
+	cc := dial(t, startServer(t, router))
+	ctx := context.Background()
+
+	slow := make(chan error, 8)
+	for i := 0; i < cap(slow); i++ {
+		req := fmt.Sprintf("slow %d", i)
+		go func() {
+			resp, err := cc.Call(ctx, "slow", []byte(req))
+			if string(resp) != req {
+				err = fmt.Errorf("got %q; want %q, %v", resp, req, err)
+			}
+			slow <- err
+		}()
+	}
+	// later calls are replied before in flight slow calls on the same connect
+	for i := 0; i < 10; i++ {
+		req := fmt.Sprintf("fast %d", i)
+		if resp, err := cc.Call(ctx, "echo", []byte(req)); err != nil || string(resp) != req {
+			t.Fatalf("echo = %q err %v; want %q", resp, err, req)
+		}
+	}
+	select {
+	case err := <-slow:
+		t.Fatalf("slow call replied before release, err %v", err)
+	default:
+	}
+
+	close(release)
+	for i := 0; i < cap(slow); i++ {
+		if err := wait(t, slow); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// blockRouter router of method "block" replied only after call context done, done calls errs are sent to ch
+func blockRouter(ch chan<- error) *Router {
+	return NewRouter().
+		Handle("echo", echo).
+		Handle("block", func(call *Call, req []byte) {
+			go func() {
+				<-call.Context().Done()
+				err := call.Context().Err()
+				if err == context.Canceled {
+					// dropped, canceled call is replied
+					call.Reply([]byte("late"), nil)
+				}
+				ch <- err
+			}()
+		})
+}
+
+func TestCallCancel(t *testing.T) {
+	done := make(chan error, 1)
+	cc := dial(t, startServer(t, blockRouter(done)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cc.Call(ctx, "block", nil)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := wait(t, errs); err != context.Canceled {
+		t.Fatalf("canceled call err %v; want %v", err, context.Canceled)
+	}
+	// cancel frame cancels call context on server
+	if err := wait(t, done); err != context.Canceled {
+		t.Fatalf("server call context err %v; want %v", err, context.Canceled)
+	}
+	if resp, err := cc.Call(context.Background(), "echo", []byte("next")); err != nil || string(resp) != "next" {
+		t.Fatalf("echo after cancel = %q err %v", resp, err)
+	}
+}
+
+func TestCallDeadline(t *testing.T) {
+	done := make(chan error, 1)
+	deadlines := make(chan time.Duration, 1)
+	replied := make(chan error, 1)
+	router := blockRouter(done).Handle("deadline", func(call *Call, req []byte) {
+		d, ok := call.Context().Deadline()
+		if !ok {
+			d = time.Now()
+		}
+		deadlines <- time.Until(d)
+		call.Reply(nil, nil)
+	})
+	cc := dial(t, startServer(t, router))
+
+	// deadline is sent as request timeout
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	go func() {
+		_, err := cc.Call(ctx, "deadline", nil)
+		replied <- err
+	}()
+	if err := wait(t, replied); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if d := <-deadlines; d < 50*time.Second || d > time.Minute {
+		t.Fatalf("server call deadline in %s; want about 1m", d)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cc.Call(ctx, "block", nil); err != context.DeadlineExceeded {
+		t.Fatalf("call err %v; want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("call returned in %s before deadline", elapsed)
+	}
+	// server call context expires by request timeout, or is canceled by cancel frame first
+	if err := wait(t, done); err != context.DeadlineExceeded && err != context.Canceled {
+		t.Fatalf("server call context err %v", err)
+	}
+
+	// expired deadline is not sent
+	if _, err := cc.Call(ctx, "echo", nil); err != context.DeadlineExceeded {
+		t.Fatalf("expired call err %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCallConnClosed(t *testing.T) {
+	done := make(chan error, 1)
+	cc := dial(t, startServer(t, blockRouter(done)))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cc.Call(context.Background(), "block", nil)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cc.Close()
+	if err := wait(t, errs); err != ErrConnClosed {
+		t.Fatalf("in flight call err %v; want %v", err, ErrConnClosed)
+	}
+	// server cancels in flight calls of closed connect
+	if err := wait(t, done); err != context.Canceled {
+		t.Fatalf("server call context err %v; want %v", err, context.Canceled)
+	}
+	if _, err := cc.Call(context.Background(), "echo", nil); err != ErrConnClosed {
+		t.Fatalf("call after close err %v; want %v", err, ErrConnClosed)
+	}
+}
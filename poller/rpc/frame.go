@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/weedge/lib/poller"
+)
+
+// frame layout, big endian:
+// | len uint32 | stream id uint32 | type uint8 | method len uint8 | timeout ms uint32 | method | payload |
+// len is bytes after len field
+const (
+	lenFieldLen = 4
+	headerLen   = 14
+	// DefaultMaxFrameLen default max frame length (header and payload)
+	DefaultMaxFrameLen = 1024 * 1024
+	// maxMethodLen method name length is one byte
+	maxMethodLen = 255
+)
+
+var (
+	ErrFrameTooLarge  = errors.New("rpc: frame too large")
+	ErrBadFrame       = errors.New("rpc: bad frame")
+	ErrMethodTooLong  = errors.New("rpc: method name too long")
+	ErrConnClosed     = errors.New("rpc: connect closed")
+	ErrClientClosed   = errors.New("rpc: client closed")
+	ErrReplied        = errors.New("rpc: call already replied")
+	ErrMethodNotFound = errors.New("rpc: method not found")
+)
+
+// FrameType
+type FrameType uint8
+
+const (
+	TypeRequest  FrameType = 1 // client call request
+	TypeResponse FrameType = 2 // server response of request stream
+	TypeError    FrameType = 3 // server error of request stream, payload is error message
+	TypeCancel   FrameType = 4 // client cancel request stream, eg: call context done
+)
+
+// RemoteError
+// error replied by server method handler
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+// Frame
+// one rpc frame, payload refers to connect read buffer when decoded
+type Frame struct {
+	StreamID uint32    // request id, response of the request has the same stream id
+	Type     FrameType // request, response, error or cancel
+	Method   string    // method name of request
+	Timeout  uint32    // request timeout ms, 0: none
+	Payload  []byte
+}
+
+// FrameDecoder
+// poller.Decoder of length prefixed frames, decoded value is frame bytes after len field
+type FrameDecoder struct {
+	maxFrameLen int
+}
+
+// NewFrameDecoder
+// Creates frame decoder, frame larger than maxFrameLen is decode error (connect is closed)
+func NewFrameDecoder(maxFrameLen int) *FrameDecoder {
+	if maxFrameLen <= headerLen {
+		panic("max frame len must greater than header len")
+	}
+	return &FrameDecoder{maxFrameLen: maxFrameLen}
+}
+
+// Decode poller.Decoder
+func (d *FrameDecoder) Decode(b *poller.Buffer) ([]byte, error) {
+	header, err := b.Seek(lenFieldLen)
+	if err != nil {
+		return nil, nil
+	}
+	n := int(binary.BigEndian.Uint32(header))
+	if n+lenFieldLen < headerLen {
+		return nil, ErrBadFrame
+	}
+	if n+lenFieldLen > d.maxFrameLen {
+		return nil, ErrFrameTooLarge
+	}
+	value, err := b.Read(lenFieldLen, n)
+	if err != nil {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// ParseFrame
+// parse frame bytes decoded by FrameDecoder
+func ParseFrame(b []byte) (*Frame, error) {
+	if len(b) < headerLen-lenFieldLen {
+		return nil, ErrBadFrame
+	}
+	f := &Frame{
+		StreamID: binary.BigEndian.Uint32(b[0:4]),
+		Type:     FrameType(b[4]),
+		Timeout:  binary.BigEndian.Uint32(b[6:10]),
+	}
+	methodLen := int(b[5])
+	b = b[headerLen-lenFieldLen:]
+	if len(b) < methodLen || f.Type < TypeRequest || f.Type > TypeCancel {
+		return nil, ErrBadFrame
+	}
+	f.Method = string(b[:methodLen])
+	f.Payload = b[methodLen:]
+	return f, nil
+}
+
+// AppendFrame
+// append encoded frame to dst
+func AppendFrame(dst []byte, f *Frame) []byte {
+	var header [headerLen]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(headerLen-lenFieldLen+len(f.Method)+len(f.Payload)))
+	binary.BigEndian.PutUint32(header[4:8], f.StreamID)
+	header[8] = byte(f.Type)
+	header[9] = byte(len(f.Method))
+	binary.BigEndian.PutUint32(header[10:14], f.Timeout)
+	dst = append(dst, header[:]...)
+	dst = append(dst, f.Method...)
+	return append(dst, f.Payload...)
+}
+
+// encodeFrame
+// encode frame to new bytes, check frame length
+func encodeFrame(f *Frame, maxFrameLen int) ([]byte, error) {
+	if len(f.Method) > maxMethodLen {
+		return nil, ErrMethodTooLong
+	}
+	n := headerLen + len(f.Method) + len(f.Payload)
+	if n > maxFrameLen {
+		return nil, ErrFrameTooLarge
+	}
+	return AppendFrame(make([]byte, 0, n), f), nil
+}
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+)
+
+// newBuffer read buffer of bytes
+func newBuffer(b []byte) *poller.Buffer {
+	buffer := poller.NewBuffer(make([]byte, len(b)+1))
+	buffer.ReadFromReader(bytes.NewReader(b))
+	return buffer
+}
+
+// lenPrefix bytes of len field n
+func lenPrefix(n uint32) []byte {
+	b := make([]byte, lenFieldLen)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*Frame{
+		{StreamID: 1, Type: TypeRequest, Method: "echo", Timeout: 100, Payload: []byte("hello")},
+		{StreamID: 1<<32 - 1, Type: TypeResponse, Payload: []byte{}},
+		{StreamID: 2, Type: TypeError, Payload: []byte("boom")},
+		{StreamID: 3, Type: TypeCancel, Payload: []byte{}},
+		{StreamID: 4, Type: TypeRequest, Method: strings.Repeat("m", maxMethodLen), Payload: []byte{}},
+	}
+	var b []byte
+	for _, f := range frames {
+		b = AppendFrame(b, f)
+	}
+
+	d := NewFrameDecoder(DefaultMaxFrameLen)
+	buffer := newBuffer(b)
+	for i, want := range frames {
+		value, err := d.Decode(buffer)
+		if err != nil || value == nil {
+			t.Fatalf("%d: decode %v err %v", i, value, err)
+		}
+		f, err := ParseFrame(value)
+		if err != nil || !reflect.DeepEqual(f, want) {
+			t.Fatalf("%d: frame %+v err %v; want %+v", i, f, err, want)
+		}
+	}
+	if buffer.Len() != 0 {
+		t.Fatalf("%d bytes left", buffer.Len())
+	}
+}
+
+func TestFrameDecode(t *testing.T) {
+	frame := AppendFrame(nil, &Frame{StreamID: 1, Type: TypeRequest, Method: "echo", Payload: []byte("hello")})
+	tests := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"short frame", lenPrefix(headerLen - lenFieldLen - 1), ErrBadFrame},
+		{"empty frame", lenPrefix(0), ErrBadFrame},
+		{"too large", lenPrefix(64), ErrFrameTooLarge},
+		{"max len", append(lenPrefix(64-lenFieldLen), make([]byte, 64-lenFieldLen)...), nil},
+	}
+	for _, tt := range tests {
+		_, err := NewFrameDecoder(64).Decode(newBuffer(tt.input))
+		if err != tt.err {
+			t.Errorf("%s: err %v; want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// partial frame needs more bytes
+	d := NewFrameDecoder(64)
+	for i := 0; i < len(frame); i++ {
+		if value, err := d.Decode(newBuffer(frame[:i])); value != nil || err != nil {
+			t.Errorf("partial %d bytes = %v err %v; want more bytes", i, value, err)
+		}
+	}
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	valid := AppendFrame(nil, &Frame{StreamID: 1, Type: TypeRequest, Method: "echo"})[lenFieldLen:]
+	badType := append([]byte{}, valid...)
+	badType[4] = byte(TypeCancel + 1)
+	zeroType := append([]byte{}, valid...)
+	zeroType[4] = 0
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"short header", valid[:headerLen-lenFieldLen-1]},
+		{"method truncated", valid[:len(valid)-1]},
+		{"unknown type", badType},
+		{"zero type", zeroType},
+	}
+	for _, tt := range tests {
+		if f, err := ParseFrame(tt.input); err != ErrBadFrame {
+			t.Errorf("%s: = %+v err %v; want %v", tt.name, f, err, ErrBadFrame)
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	if _, err := encodeFrame(&Frame{Type: TypeRequest, Method: strings.Repeat("m", maxMethodLen+1)}, DefaultMaxFrameLen); err != ErrMethodTooLong {
+		t.Fatalf("long method err %v; want %v", err, ErrMethodTooLong)
+	}
+	f := &Frame{Type: TypeResponse, Payload: make([]byte, 64-headerLen)}
+	if _, err := encodeFrame(f, 64); err != nil {
+		t.Fatalf("max len frame err %v", err)
+	}
+	f.Payload = append(f.Payload, 0)
+	if _, err := encodeFrame(f, 64); err != ErrFrameTooLarge {
+		t.Fatalf("too large frame err %v; want %v", err, ErrFrameTooLarge)
+	}
+}
@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/poller"
+)
+
+// HandlerFunc
+// method handler, run on poller io goroutine; reply by call.Reply in handler,
+// or later from other goroutine (eg: worker pool) so slow calls don't block others,
+// responses are multiplexed out of order by stream id;
+// req refers to connect read buffer, copy it before use after handler returns
+type HandlerFunc func(call *Call, req []byte)
+
+// Call
+// one request stream served by method handler
+type Call struct {
+	Conn     *poller.Conn // connect of request
+	StreamID uint32       // request stream id
+	Method   string       // request method
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	session *session
+	replied int32
+}
+
+// Context
+// done when client canceled the call, request timeout or connect closed
+func (c *Call) Context() context.Context {
+	return c.ctx
+}
+
+// Reply
+// write response, or error message if err is not nil; goroutine safe, reply once
+func (c *Call) Reply(resp []byte, err error) error {
+	if !atomic.CompareAndSwapInt32(&c.replied, 0, 1) {
+		return ErrReplied
+	}
+	c.session.remove(c)
+	c.cancel()
+
+	f := &Frame{StreamID: c.StreamID, Type: TypeResponse, Payload: resp}
+	if err != nil {
+		f.Type, f.Payload = TypeError, []byte(err.Error())
+	}
+	err = c.session.write(f)
+	if err == ErrFrameTooLarge {
+		// client gets error instead of waiting for the dropped response
+		c.session.write(&Frame{StreamID: c.StreamID, Type: TypeError, Payload: []byte(err.Error())})
+	}
+	return err
+}
+
+// session
+// rpc state of server connect, in flight calls by stream id
+type session struct {
+	conn        *poller.Conn
+	maxFrameLen int
+
+	lock  sync.Mutex
+	calls map[uint32]*Call
+}
+
+// add
+func (s *session) add(c *Call) {
+	s.lock.Lock()
+	s.calls[c.StreamID] = c
+	s.lock.Unlock()
+}
+
+// remove
+func (s *session) remove(c *Call) {
+	s.lock.Lock()
+	if s.calls[c.StreamID] == c {
+		delete(s.calls, c.StreamID)
+	}
+	s.lock.Unlock()
+}
+
+// cancel
+// cancel in flight call by stream id, reply is dropped
+func (s *session) cancel(streamID uint32) {
+	s.lock.Lock()
+	c, ok := s.calls[streamID]
+	delete(s.calls, streamID)
+	s.lock.Unlock()
+	if ok {
+		atomic.StoreInt32(&c.replied, 1)
+		c.cancel()
+	}
+}
+
+// cancelAll
+// connect closed, cancel all in flight calls
+func (s *session) cancelAll() {
+	s.lock.Lock()
+	calls := s.calls
+	s.calls = map[uint32]*Call{}
+	s.lock.Unlock()
+	for _, c := range calls {
+		c.cancel()
+	}
+}
+
+// write
+func (s *session) write(f *Frame) error {
+	b, err := encodeFrame(f, s.maxFrameLen)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Write(b)
+	return err
+}
+
+// Router
+// poller.Handler dispatch request frames to registered method handlers;
+// notice: connect data (Conn.SetData) is used by router for session
+type Router struct {
+	handlers    map[string]HandlerFunc
+	maxFrameLen int
+}
+
+// RouterOption Router opt config
+type RouterOption func(r *Router)
+
+// WithMaxFrameLen max length of request and response frame, default 1MB
+func WithMaxFrameLen(n int) RouterOption {
+	return func(r *Router) {
+		if n <= headerLen {
+			panic("max frame len must greater than header len")
+		}
+		r.maxFrameLen = n
+	}
+}
+
+// NewRouter
+// Creates method router
+func NewRouter(opts ...RouterOption) *Router {
+	r := &Router{
+		handlers:    map[string]HandlerFunc{},
+		maxFrameLen: DefaultMaxFrameLen,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// Handle
+// register method handler, register before server run
+func (r *Router) Handle(method string, h HandlerFunc) *Router {
+	if len(method) > maxMethodLen {
+		panic(ErrMethodTooLong)
+	}
+	r.handlers[method] = h
+	return r
+}
+
+// NewServer
+// Creates poller server serving rpc calls on address by router,
+// read buffer len is max frame len
+func NewServer(address string, router *Router, opts ...poller.Option) (*poller.Server, error) {
+	opts = append([]poller.Option{poller.WithReadBufferLen(router.maxFrameLen)}, opts...)
+	opts = append(opts, poller.WithDecoder(NewFrameDecoder(router.maxFrameLen)))
+	return poller.NewServer(address, router, opts...)
+}
+
+// OnConnect
+// init connect session
+func (r *Router) OnConnect(c *poller.Conn) {
+	c.SetData(&session{conn: c, maxFrameLen: r.maxFrameLen, calls: map[uint32]*Call{}})
+}
+
+// OnMessage
+// serve request frame, cancel frame cancels in flight call
+func (r *Router) OnMessage(c *poller.Conn, bytes []byte) {
+	s, ok := c.GetData().(*session)
+	if !ok {
+		return
+	}
+	f, err := ParseFrame(bytes)
+	if err != nil {
+		log.Warnf("rpc connect %s parse frame err %s", c.GetAddr(), err.Error())
+		c.Close()
+		s.cancelAll()
+		return
+	}
+
+	switch f.Type {
+	case TypeRequest:
+		r.serve(s, f)
+	case TypeCancel:
+		s.cancel(f.StreamID)
+	default:
+		log.Warnf("rpc connect %s unexpected frame type %d", c.GetAddr(), f.Type)
+	}
+}
+
+// serve
+// call method handler of request frame
+func (r *Router) serve(s *session, f *Frame) {
+	call := &Call{Conn: s.conn, StreamID: f.StreamID, Method: f.Method, session: s}
+	if f.Timeout > 0 {
+		call.ctx, call.cancel = context.WithTimeout(context.Background(), time.Duration(f.Timeout)*time.Millisecond)
+	} else {
+		call.ctx, call.cancel = context.WithCancel(context.Background())
+	}
+
+	h, ok := r.handlers[f.Method]
+	if !ok {
+		call.Reply(nil, RemoteError(ErrMethodNotFound.Error()+": "+f.Method))
+		return
+	}
+	s.add(call)
+	h(call, f.Payload)
+}
+
+// OnClose
+// cancel in flight calls of connect
+func (r *Router) OnClose(c *poller.Conn, err error) {
+	if s, ok := c.GetData().(*session); ok {
+		s.cancelAll()
+	}
+}
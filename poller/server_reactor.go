@@ -0,0 +1,251 @@
+package poller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/log"
+)
+
+// newReactors
+// server of independent reactors, each reactor is a server with own SO_REUSEPORT listen fd,
+// epoll/io_uring ring and connect table, handles events in its event looper goroutine
+func newReactors(address string, handler Handler, options *options) (*Server, error) {
+	if options.listenFD >= 0 || options.socketActivation || strings.HasPrefix(address, UnixAddrPrefix) {
+		return nil, ErrReactorListen
+	}
+
+	s := &Server{
+		options:       options,
+		handler:       handler,
+		stop:          make(chan struct{}),
+		listenFD:      -1,
+		pollerFD:      -1,
+		acceptStop:    make(chan struct{}),
+		groups:        newConnGroups(),
+		limits:        newServerConnLimits(options),
+		tlsHandshakes: new(int64),
+	}
+	for i := 0; i < options.reactorNum; i++ {
+		lfd, err := listen(address, options.listenBacklog, true)
+		if err != nil {
+			s.closeReactors()
+			return nil, err
+		}
+
+		// one io_uring ring per reactor, events of the reactor are handled in one goroutine
+		opts := *options
+		opts.ioUringNum = 1
+		r, err := newServer(lfd, handler, &opts)
+		if err != nil {
+			syscall.Close(lfd)
+			s.closeReactors()
+			return nil, err
+		}
+		r.inline = true
+		r.groups = s.groups
+		r.limits = s.limits
+		r.tlsHandshakes = s.tlsHandshakes
+		s.reactors = append(s.reactors, r)
+	}
+	log.Infof("server listen %s by %d reactors", address, options.reactorNum)
+
+	return s, nil
+}
+
+// closeReactors
+// free reactors created before init fail
+func (s *Server) closeReactors() {
+	for _, r := range s.reactors {
+		r.Stop()
+		r.closeListenFD()
+	}
+}
+
+// runReactors
+// run each reactor in own goroutine, wait all stop
+func (s *Server) runReactors() {
+	var wg sync.WaitGroup
+	wg.Add(len(s.reactors))
+	for _, r := range s.reactors {
+		go func(r *Server) {
+			defer wg.Done()
+			r.Run()
+		}(r)
+	}
+	wg.Wait()
+}
+
+// stopReactors
+func (s *Server) stopReactors() {
+	for _, r := range s.reactors {
+		r.Stop()
+	}
+}
+
+// shutdownReactors
+// graceful shutdown reactors concurrently with the same ctx, return the first err
+func (s *Server) shutdownReactors(ctx context.Context) (err error) {
+	errs := make([]error, len(s.reactors))
+	var wg sync.WaitGroup
+	wg.Add(len(s.reactors))
+	for i, r := range s.reactors {
+		go func(i int, r *Server) {
+			defer wg.Done()
+			errs[i] = r.Shutdown(ctx)
+		}(i, r)
+	}
+	wg.Wait()
+
+	for _, err = range errs {
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// startReactorAcceptor
+// add edge triggered listen fd readable event to reactor poller, accept in event looper
+func (s *Server) startReactorAcceptor() {
+	err := syscall.SetNonblock(s.listenFD, true)
+	if err != nil {
+		log.Errorf("set listen fd %d non block err %s", s.listenFD, err.Error())
+		return
+	}
+	err = addReadEvent(s.pollerFD, s.listenFD)
+	if err != nil {
+		log.Errorf("add listen fd %d read event err %s", s.listenFD, err.Error())
+		return
+	}
+	log.Infof("start reactor accept listen fd %d", s.listenFD)
+}
+
+// acceptReady
+// reactor listen fd readable, accept until EAGAIN
+func (s *Server) acceptReady() {
+	for {
+		select {
+		case <-s.acceptStop:
+			return
+		default:
+		}
+
+		err := s.acceptConn()
+		if err == nil {
+			s.acceptDelay = 0
+			continue
+		}
+		if err == syscall.EINTR || err == syscall.ECONNABORTED {
+			continue
+		}
+		if isAcceptResourceErr(err) {
+			s.retryAcceptReady(err)
+			return
+		}
+		if err != syscall.EAGAIN {
+			log.Error(err)
+		}
+		return
+	}
+}
+
+// retryAcceptReady
+// edge triggered listen fd is not reported again for connects left in accept queue,
+// post accept event to event looper after backoff
+func (s *Server) retryAcceptReady(err error) {
+	s.acceptDelay = nextAcceptDelay(s.acceptDelay)
+	log.Warnf("accept err %s, retry in %s", err.Error(), s.acceptDelay)
+	time.AfterFunc(s.acceptDelay, func() {
+		select {
+		case <-s.acceptStop:
+			return
+		default:
+		}
+		s.postEvent(&eventInfo{fd: s.listenFD, etype: ETypeAccept})
+	})
+}
+
+// postEvent
+// event from other goroutine (eg: timer, tls handshake), reactor queues it and wakes up event looper to handle,
+// others dispatch to io event queue; the sender doesn't block on full queue, event is dropped after stop
+func (s *Server) postEvent(event *eventInfo) {
+	queue := s.ioEventQueues[0]
+	if !s.inline {
+		queue = s.ioEventQueues[event.fd%s.ioQueueNum]
+	}
+
+	s.postLock.RLock()
+	defer s.postLock.RUnlock()
+	if s.postClosed {
+		return
+	}
+	select {
+	case queue <- event:
+		s.wakeUpPosted(event)
+	default:
+		// full queue, wait in own goroutine until queued or stopped, queues are closed after it exits
+		s.postWg.Add(1)
+		go func() {
+			defer s.postWg.Done()
+			select {
+			case queue <- event:
+				s.wakeUpPosted(event)
+			case <-s.stop:
+			}
+		}()
+	}
+}
+
+// wakeUpPosted
+// wake up reactor event looper to handle posted event
+func (s *Server) wakeUpPosted(event *eventInfo) {
+	if !s.inline {
+		return
+	}
+	if len(s.iourings) != 0 {
+		s.GetIoUring(event.fd).wakeUp()
+		return
+	}
+	s.wakeUp()
+}
+
+// handlePostedEvents
+// reactor handles queued events in event looper goroutine
+func (s *Server) handlePostedEvents() {
+	for {
+		select {
+		case event, ok := <-s.ioEventQueues[0]:
+			if !ok {
+				return
+			}
+			s.processEvent(event)
+		default:
+			return
+		}
+	}
+}
+
+// processEvent
+func (s *Server) processEvent(event *eventInfo) {
+	if s.iourings != nil {
+		s.processIOCompletionEvent(event)
+		return
+	}
+	s.processIOReadyEvent(event)
+}
+
+// drainWakePipe
+// reactor read wake up pipe until empty, edge triggered read event is reported again for next wake up
+func (s *Server) drainWakePipe() {
+	var buf [64]byte
+	for {
+		n, err := syscall.Read(s.wakeFDs[0], buf[:])
+		if n <= 0 || err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReactorEcho(t *testing.T) {
+	addr := freeAddr(t)
+	startServer(t, addr, newTestHandler(true), WithReactors(2, false))
+
+	for i := 0; i < 4; i++ {
+		peer, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		echoRoundTrip(t, peer, "reactor")
+	}
+}
+
+// exhaustFDs lower fd limit and dup fds until EMFILE, return the dup fds
+func exhaustFDs(t *testing.T) []int {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	limited := rlimit
+	limited.Cur = uint64(len(entries) + 64)
+	if err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limited); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlimit) })
+
+	var fds []int
+	for {
+		fd, err := syscall.Dup(0)
+		if err == syscall.EMFILE {
+			return fds
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds = append(fds, fd)
+	}
+}
+
+func TestReactorAcceptEMFILE(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	startServer(t, addr, h, WithReactors(1, false))
+
+	fds := exhaustFDs(t)
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	// one fd for the peer, none for the server accept
+	syscall.Close(fds[len(fds)-1])
+	fds = fds[:len(fds)-1]
+	peer, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	select {
+	case <-h.connects:
+		t.Fatal("accepted without free fd")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// connect in accept queue is accepted by retry without new connect
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+	fds = nil
+	recv(t, h.connects)
+	echoRoundTrip(t, peer, "retry")
+}
+
+func TestPostEventFullQueue(t *testing.T) {
+	for _, inline := range []bool{false, true} {
+		s, err := NewServer(freeAddr(t), newTestHandler(false), WithIOGNum(1), WithIOEventQueueLen(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.inline = inline
+		// queue isn't consumed, poster doesn't block on full queue
+		posted := make(chan struct{})
+		go func() {
+			defer close(posted)
+			for i := 0; i < 8; i++ {
+				s.postEvent(&eventInfo{fd: 3, etype: ETypeIn})
+			}
+		}()
+		recv(t, posted)
+
+		// waiting posters exit on stop before queues are closed
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			s.Stop()
+		}()
+		recv(t, stopped)
+		// dropped after stop
+		s.postEvent(&eventInfo{fd: 3, etype: ETypeIn})
+	}
+}
+
+func TestPostEventRaceStop(t *testing.T) {
+	addr := freeAddr(t)
+	s := startServer(t, addr, newTestHandler(false), WithIOEventQueueLen(1))
+	// timers and tls handshake goroutines post events while server stops
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.postEvent(&eventInfo{fd: 1 << 20, etype: ETypeIn})
+			}
+		}()
+	}
+	s.Stop()
+	wg.Wait()
+}
@@ -3,12 +3,16 @@ package poller
 import (
 	"fmt"
 	"io"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unsafe"
 
+	"github.com/ii64/gouring"
 	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/timingwheel"
 )
 
 // Server TCP server
@@ -21,18 +25,59 @@ type Server struct {
 	conns          sync.Map                    // TCP long connection management
 	connsNum       int64                       // Indicates the number of established long connections
 	stop           chan struct{}               // Indicates the server shutdown signal
+	stopOnce       sync.Once                   // stop server once
 	listenFD       int                         // listen fd
 	pollerFD       int                         // event poller fd (epoll/kqueue, poll, select)
 	iourings       []*ioUring                  // iouring async event rings
 	asyncEventCb   map[EventType]EventCallBack // async event call back register
 	looperWg       sync.WaitGroup              // main looper group wait
+	postLock       sync.RWMutex                // guard postClosed
+	postClosed     bool                        // io event queues are closed, posted event is dropped
+	postWg         sync.WaitGroup              // posting goroutines wait for full io event queue
+	wakeFDs        [2]int                      // wake up pipe for blocked acceptor/poller looper
+	acceptStop     chan struct{}               // Indicates the acceptor stop signal
+	acceptStopOnce sync.Once                   // stop accept once
+	acceptWg       sync.WaitGroup              // acceptor goroutine group wait
+	acceptLock     sync.Mutex                  // guard acceptOp
+	acceptOp       gouring.UserData            // user data of in flight io_uring accept op
+	reactors       []*Server                   // independent reactors, nil: this server handles events
+	inline         bool                        // reactor, handle events in event looper goroutine
+	timingWheel    *timingwheel.TimingWheel    // connect deadline and idle timeout timers
+	stats          serverStats                 // server io counters
+	groups         *connGroups                 // named connect groups for broadcast, shared by reactors
+	limits         *connLimits                 // connect admission limits, shared by reactors, nil: no limit
+	tlsHandshakes  *int64                      // running tls handshake goroutines, shared by reactors
+	dials          sync.Map                    // non block connecting fd -> *dialing
+	acceptDelay    time.Duration               // reactor accept retry backoff, used in event looper only
 }
 
 // NewServer
 // init server to start
 func NewServer(address string, handler Handler, opts ...Option) (*Server, error) {
 	options := getOptions(opts...)
+	if options.reactorNum > 0 {
+		return newReactors(address, handler, options)
+	}
 
+	// listen or adopt inherited listen fd
+	lfd, err := getListenFD(address, options)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	s, err := newServer(lfd, handler, options)
+	if err != nil {
+		syscall.Close(lfd)
+		return nil, err
+	}
+	return s, nil
+}
+
+// newServer
+// init read buffer pool, event poller, io_uring rings and io event queues,
+// listenFD < 0 means no acceptor (eg: client only dial out connect)
+func newServer(listenFD int, handler Handler, options *options) (*Server, error) {
 	// init read buffer pool
 	readBufferPool := &sync.Pool{
 		New: func() interface{} {
@@ -41,16 +86,12 @@ func NewServer(address string, handler Handler, opts ...Option) (*Server, error)
 		},
 	}
 
-	// listen
-	lfd, err := listen(address, options.listenBacklog)
-	if err != nil {
-		log.Error(err)
-		return nil, err
+	if options.tlsConfig != nil && options.ioMode.isIoUring() {
+		return nil, ErrTLSUnsupportedIOMode
 	}
 
 	// init poller(epoll/kqueue)
-	var pollerFD int
-	pollerFD, err = createPoller()
+	pollerFD, err := createPoller()
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -58,29 +99,43 @@ func NewServer(address string, handler Handler, opts ...Option) (*Server, error)
 
 	// init io_uring setup
 	var rings []*ioUring
-	if options.ioMode == IOModeUring || options.ioMode == IOModeEpollUring {
-		rings = make([]*ioUring, options.ioUringNum)
-		for i := 0; i < options.ioUringNum; i++ {
-			ring, err := newIoUring(options.ioUringEntries, options.ioUringParams)
-			if err != nil {
-				log.Errorf("newIoUring %d err %s", i, err.Error())
-				return nil, err
-			}
-
+	if options.ioMode.isIoUring() {
+		rings, err = newModeIoUrings(options)
+		if err != nil {
+			// no half-built server, eg: sq poll thread or attach wq unsupported
+			syscall.Close(pollerFD)
+			return nil, err
+		}
+		for i, ring := range rings {
 			// register eventfd
 			if options.ioMode == IOModeEpollUring {
 				err = ring.RegisterEventFd()
 				if err != nil {
 					log.Errorf("ring.RegisterEventFd %d err %s", i, err.Error())
-					ring.CloseRing()
+					closeIoUrings(rings)
+					syscall.Close(pollerFD)
 					return nil, err
 				}
 			}
 
-			rings[i] = ring
+			ring.setupBuffers(options)
+			ring.probeMultishot(options.ioMode)
 		} // end for
 	}
 
+	// init wake up pipe, poller looper wait pipe read end readable
+	var wakeFDs [2]int
+	err = newWakePipe(&wakeFDs)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	err = addReadEvent(pollerFD, wakeFDs[0])
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
 	// init io event channel(queue)
 	ioEventQueues := make([]chan *eventInfo, options.ioGNum)
 	for i := range ioEventQueues {
@@ -96,16 +151,27 @@ func NewServer(address string, handler Handler, opts ...Option) (*Server, error)
 		conns:          sync.Map{},
 		connsNum:       0,
 		stop:           make(chan struct{}),
-		listenFD:       lfd,
+		listenFD:       listenFD,
 		pollerFD:       pollerFD,
 		iourings:       rings,
 		asyncEventCb:   map[EventType]EventCallBack{},
+		wakeFDs:        wakeFDs,
+		acceptStop:     make(chan struct{}),
+		timingWheel:    newTimingWheel(options.timeoutTicker),
+		groups:         newConnGroups(),
+		limits:         newServerConnLimits(options),
+		tlsHandshakes:  new(int64),
 	}, nil
 }
 
 // GetConn
 // get connect by connect fd from session connect sync Map
 func (s *Server) GetConn(fd int32) (*Conn, bool) {
+	for _, r := range s.reactors {
+		if c, ok := r.GetConn(fd); ok {
+			return c, true
+		}
+	}
 	value, ok := s.conns.Load(fd)
 	if !ok {
 		return nil, false
@@ -119,13 +185,16 @@ func (s *Server) GetConn(fd int32) (*Conn, bool) {
 // ioConsumeHandler hanle event for biz logic
 // check time out connenct session,
 func (s *Server) Run() {
+	if s.reactors != nil {
+		s.runReactors()
+		return
+	}
 	log.Info("start server runing...")
 	// rigister event
 	s.rigisterEpollIouringEvent()
 
 	// monitor
 	s.report()
-	s.checkTimeout()
 
 	// start server
 	s.startAcceptor()
@@ -175,30 +244,73 @@ func (s *Server) CloseIoUring() {
 
 // Stop
 // stop server, close communication channel(queue)
-// free io uring
+// free io uring; stop again (eg: after Shutdown) is no-op
 func (s *Server) Stop() {
-	close(s.stop)
-	for _, queue := range s.ioEventQueues {
-		close(queue)
+	if s.reactors != nil {
+		s.stopReactors()
+		return
 	}
+	s.stopOnce.Do(func() {
+		s.stopAccept()
+		close(s.stop)
+		s.wakeUp()
+		// wait dispatchers exit before free rings
+		for _, ring := range s.iourings {
+			ring.wakeUp()
+		}
+		s.looperWg.Wait()
+		s.timingWheel.Stop()
+		// no more posted events from other goroutines before close queues
+		s.postLock.Lock()
+		s.postClosed = true
+		s.postLock.Unlock()
+		s.postWg.Wait()
+		for _, queue := range s.ioEventQueues {
+			close(queue)
+		}
 
-	s.CloseIoUring()
+		s.CloseIoUring()
+	})
 }
 
 // GetConnsNum
 func (s *Server) GetConnsNum() int64 {
+	if s.reactors != nil {
+		var n int64
+		for _, r := range s.reactors {
+			n += r.GetConnsNum()
+		}
+		return n
+	}
 	return atomic.LoadInt64(&s.connsNum)
 }
 
 // startAcceptor
 // setup accept connect goroutine
 func (s *Server) startAcceptor() {
+	if s.listenFD < 0 {
+		return
+	}
+
 	if len(s.iourings) != 0 {
 		go s.asyncBlockAccept()
 		log.Info("start trigger async block accept")
 		return
 	}
 
+	if s.inline {
+		s.startReactorAcceptor()
+		return
+	}
+
+	// acceptor poll listen fd readable and wake up pipe, non block accept
+	err := syscall.SetNonblock(s.listenFD, true)
+	if err != nil {
+		log.Errorf("set listen fd %d non block err %s", s.listenFD, err.Error())
+		return
+	}
+
+	s.acceptWg.Add(s.options.acceptGNum)
 	for i := 0; i < s.options.acceptGNum; i++ {
 		go s.accept()
 	}
@@ -206,37 +318,120 @@ func (s *Server) startAcceptor() {
 }
 
 // accept
-// block accept connect from listen fd
+// wait listen fd readable, non block accept connect from listen fd
 // save non block connect fd session and OnConnect logic handle
 func (s *Server) accept() {
+	defer s.acceptWg.Done()
+	var delay time.Duration
 	for {
 		select {
-		case <-s.stop:
+		case <-s.acceptStop:
 			return
 		default:
-			cfd, socketAddr, err := accept(s.listenFD, s.options.keepaliveInterval)
+			err := waitAcceptable(s.listenFD, s.wakeFDs[0])
 			if err != nil {
-				log.Error(err)
+				if err != syscall.EINTR {
+					log.Error(err)
+				}
 				continue
 			}
-			addr := getAddr(socketAddr)
-
-			conn := newConn(s.pollerFD, cfd, addr, s)
-			s.conns.Store(cfd, conn)
-			atomic.AddInt64(&s.connsNum, 1)
 
-			err = addReadEvent(s.pollerFD, cfd)
+			err = s.acceptConn()
+			if isAcceptResourceErr(err) {
+				// listen fd is still readable, back off instead of busy loop
+				delay = nextAcceptDelay(delay)
+				log.Warnf("accept err %s, retry in %s", err.Error(), delay)
+				select {
+				case <-s.acceptStop:
+				case <-time.After(delay):
+				}
+				continue
+			}
 			if err != nil {
-				log.Error(err)
-				conn.Close()
+				// accepted by other acceptor goroutine or process
+				if err != syscall.EAGAIN {
+					log.Error(err)
+				}
 				continue
 			}
-
-			s.handler.OnConnect(conn)
+			delay = 0
 		}
 	}
 }
 
+// isAcceptResourceErr
+// accept fail by process/system fd limit or kernel memory, the connect is left in accept queue
+func isAcceptResourceErr(err error) bool {
+	return err == syscall.EMFILE || err == syscall.ENFILE || err == syscall.ENOBUFS || err == syscall.ENOMEM
+}
+
+// nextAcceptDelay
+// double accept retry delay from acceptRetryMinDelay up to acceptRetryMaxDelay
+func nextAcceptDelay(d time.Duration) time.Duration {
+	if d == 0 {
+		return acceptRetryMinDelay
+	}
+	if d *= 2; d > acceptRetryMaxDelay {
+		d = acceptRetryMaxDelay
+	}
+	return d
+}
+
+// acceptConn
+// non block accept connect from listen fd,
+// save non block connect fd session, OnConnect logic handle and add read event
+func (s *Server) acceptConn() (err error) {
+	cfd, socketAddr, err := accept(s.listenFD, s.options.keepaliveInterval)
+	if err != nil {
+		return
+	}
+	ip, ok := s.admit(cfd, socketAddr)
+	if !ok {
+		return nil
+	}
+	addr := getAddr(socketAddr)
+	if s.options.tlsConfig != nil && !s.acquireTLSHandshake(cfd, addr, ip) {
+		return nil
+	}
+
+	conn := newConn(s.pollerFD, cfd, addr, s)
+	conn.proxyPending = s.options.proxyProtocol
+	conn.admitted, conn.admitIP = s.limits != nil, ip
+	// publish after init, queued event of closed connect with reused fd may load it
+	s.conns.Store(cfd, conn)
+	atomic.AddInt64(&s.connsNum, 1)
+	atomic.AddInt64(&s.stats.accepted, 1)
+
+	if s.options.tlsConfig != nil {
+		conn.initTLS(s.options.tlsConfig, false)
+	}
+
+	// OnConnect before read event added, happens before OnMessage
+	s.onConnect(conn)
+
+	err = addReadEvent(s.pollerFD, cfd)
+	if err != nil {
+		log.Error(err)
+		conn.Close()
+		s.handler.OnClose(conn, err)
+	}
+	return nil
+}
+
+// onConnect
+// OnConnect handle new connect, tls connect OnConnect after handshake done,
+// proxied connect OnConnect after PROXY header parsed
+func (s *Server) onConnect(c *Conn) {
+	if c.tls != nil {
+		go c.tls.handshake(c)
+		return
+	}
+	if c.proxyPending {
+		return
+	}
+	s.handler.OnConnect(c)
+}
+
 // nonBlockPollAccept
 // non block accept, when return EAGAIN, add/produce event poll op to sqe
 func (s *Server) nonBlockPollAccept() {
@@ -244,10 +439,47 @@ func (s *Server) nonBlockPollAccept() {
 
 // asyncBlockAccept
 // async add/produce block accept op to sqe
+// multishot accept op if supported
 func (s *Server) asyncBlockAccept() {
-	var rsa syscall.RawSockaddrAny
-	var len uint32 = syscall.SizeofSockaddrAny
-	s.GetIoUring(s.listenFD).addAcceptSqe(s.getAcceptCallback(&rsa), s.listenFD, &rsa, len, 0)
+	select {
+	case <-s.acceptStop:
+		return
+	default:
+	}
+
+	ring := s.GetIoUring(s.listenFD)
+	// hold lock until op is recorded, the op done callback clears it after
+	s.acceptLock.Lock()
+	var err error
+	if ring.multishotAccept {
+		s.acceptOp, err = ring.addMultishotAcceptSqe(s.getMultishotAcceptCallback(ring), s.listenFD, syscall.SOCK_CLOEXEC)
+	} else {
+		// peer address is kept alive by accept callback until complete
+		addr := &acceptAddr{len: syscall.SizeofSockaddrAny}
+		s.acceptOp, err = ring.addAcceptSqe(s.getAcceptCallback(addr), s.listenFD, &addr.rsa, &addr.len, 0)
+	}
+	s.acceptLock.Unlock()
+	if err != nil {
+		log.Errorf("add accept op err %s", err.Error())
+		return
+	}
+
+	// accept stopped while adding, the op isn't seen by stopAccept
+	select {
+	case <-s.acceptStop:
+		s.cancelAsyncAccept()
+	default:
+	}
+}
+
+// acceptOpDone
+// the accept op of event info is completed, clear it to not cancel a reused user data
+func (s *Server) acceptOpDone(e *eventInfo) {
+	s.acceptLock.Lock()
+	if s.acceptOp == gouring.UserData(uintptr(unsafe.Pointer(e))) {
+		s.acceptOp = 0
+	}
+	s.acceptLock.Unlock()
 }
 
 func (s *Server) GetIoUring(fd int) *ioUring {
@@ -282,38 +514,54 @@ func (s *Server) startNotifyIoUringCQEvent() {
 			}
 			for _, event := range events {
 				ring := s.GetEventIoUring(event.fd)
+				if ring == nil {
+					continue
+				}
 				ring.cqeSignCh <- struct{}{}
 			}
 		}
 	}
 }
 
-func (s *Server) getAcceptCallback(rsa *syscall.RawSockaddrAny) EventCallBack {
+// acceptAddr
+// peer address and len written by kernel when io_uring accept op completes
+type acceptAddr struct {
+	rsa syscall.RawSockaddrAny
+	len uint32
+}
+
+func (s *Server) getAcceptCallback(addr *acceptAddr) EventCallBack {
 	return func(e *eventInfo) (err error) {
+		s.acceptOpDone(e)
+		stopped := false
+		select {
+		case <-s.acceptStop:
+			stopped = true
+		default:
+		}
 		if e.cqe.Res < 0 {
+			if stopped && e.cqe.Res == -int32(syscall.ECANCELED) {
+				return
+			}
 			err = fmt.Errorf("accept err res %d", e.cqe.Res)
 			return
 		}
 
 		cfd := int(e.cqe.Res)
-		err = setConnectOption(cfd, s.options.keepaliveInterval)
-		if err != nil {
+		if stopped {
+			// accepted before cancel, don't re-add accept op
+			syscall.Close(cfd)
 			return
 		}
 
-		socketAddr, err := anyToSockaddr(rsa)
+		socketAddr, err := anyToSockaddr(&addr.rsa)
+		if err != nil {
+			return
+		}
+		err = s.serveAsyncAccepted(cfd, socketAddr)
 		if err != nil {
 			return
 		}
-		addr := getAddr(socketAddr)
-
-		conn := newConn(s.pollerFD, cfd, addr, s)
-		s.conns.Store(cfd, conn)
-		atomic.AddInt64(&s.connsNum, 1)
-		s.handler.OnConnect(conn)
-
-		// new connected client, async read data from socket
-		conn.AsyncBlockRead()
 
 		// re-add accept to monitor for new connections
 		s.asyncBlockAccept()
@@ -322,11 +570,98 @@ func (s *Server) getAcceptCallback(rsa *syscall.RawSockaddrAny) EventCallBack {
 	}
 }
 
+// getMultishotAcceptCallback
+// multishot accept op post a cqe for each accepted connect,
+// re-add accept op when cqe without IORING_CQE_F_MORE, fall back to one-shot if unsupported
+func (s *Server) getMultishotAcceptCallback(ring *ioUring) EventCallBack {
+	return func(e *eventInfo) (err error) {
+		stopped := false
+		select {
+		case <-s.acceptStop:
+			stopped = true
+		default:
+		}
+		if !e.hasMore() {
+			s.acceptOpDone(e)
+		}
+		if !e.hasMore() && !stopped {
+			if e.cqe.Res == -int32(syscall.EINVAL) {
+				log.Warnf("multishot accept is not supported, fall back to one-shot accept")
+				ring.multishotAccept = false
+			}
+			s.asyncBlockAccept()
+		}
+
+		if e.cqe.Res < 0 {
+			if stopped && e.cqe.Res == -int32(syscall.ECANCELED) {
+				return
+			}
+			err = fmt.Errorf("multishot accept err res %d", e.cqe.Res)
+			return
+		}
+
+		cfd := int(e.cqe.Res)
+		if stopped {
+			syscall.Close(cfd)
+			return
+		}
+
+		socketAddr, err := syscall.Getpeername(cfd)
+		if err != nil {
+			syscall.Close(cfd)
+			return
+		}
+		return s.serveAsyncAccepted(cfd, socketAddr)
+	}
+}
+
+// serveAsyncAccepted
+// new io_uring accepted connect, OnConnect and async read data from socket
+func (s *Server) serveAsyncAccepted(cfd int, socketAddr syscall.Sockaddr) (err error) {
+	err = setConnectOptionByAddr(cfd, socketAddr, s.options.keepaliveInterval)
+	if err != nil {
+		syscall.Close(cfd)
+		return
+	}
+	ip, ok := s.admit(cfd, socketAddr)
+	if !ok {
+		return
+	}
+	addr := getAddr(socketAddr)
+
+	conn := newConn(s.pollerFD, cfd, addr, s)
+	conn.proxyPending = s.options.proxyProtocol
+	conn.admitted, conn.admitIP = s.limits != nil, ip
+	s.conns.Store(cfd, conn)
+	atomic.AddInt64(&s.connsNum, 1)
+	atomic.AddInt64(&s.stats.accepted, 1)
+	s.onConnect(conn)
+
+	// new connected client, async read data from socket
+	conn.AsyncBlockRead()
+	return
+}
+
+// cancelAsyncAccept
+// cancel in flight io_uring (one-shot or multishot) accept op
+func (s *Server) cancelAsyncAccept() {
+	s.acceptLock.Lock()
+	op := s.acceptOp
+	s.acceptOp = 0
+	s.acceptLock.Unlock()
+	if op == 0 || len(s.iourings) == 0 {
+		return
+	}
+	s.GetIoUring(s.listenFD).addCancelSqe(op)
+}
+
 // startIOEventLooper main looper
 // from poller events or io_uring cqe event entries
 func (s *Server) startIOEventLooper() {
 	//runtime.LockOSThread()
 	if s.iourings == nil {
+		s.looperWg.Add(1)
+		defer s.looperWg.Done()
 		s.startIOEventPollDispatcher()
 		return
 	}
@@ -341,6 +676,10 @@ func (s *Server) startIOEventLooper() {
 // startIOEventPollDispatcher
 // get ready events from poller, distpatch to event channel(queue)
 func (s *Server) startIOEventPollDispatcher() {
+	if s.options.lockOSThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
 	log.Info("start io event poll dispatcher")
 	for {
 		select {
@@ -360,8 +699,21 @@ func (s *Server) startIOEventPollDispatcher() {
 
 			// dispatch
 			for i := range events {
+				if events[i].fd == s.wakeFDs[0] {
+					if s.inline {
+						s.drainWakePipe()
+					}
+					continue
+				}
+				if s.inline && events[i].fd == s.listenFD {
+					s.acceptReady()
+					continue
+				}
 				s.handleEvent(&events[i])
 			}
+			if s.inline {
+				s.handlePostedEvents()
+			}
 		}
 	} // end for
 }
@@ -373,6 +725,10 @@ func (s *Server) startIOUringPollDispatcher(id int) {
 	if s.iourings[id] == nil {
 		return
 	}
+	if s.options.lockOSThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
 	log.Infof("start io_uring event op poll dispatcher id %d", id)
 	for {
 		select {
@@ -380,7 +736,19 @@ func (s *Server) startIOUringPollDispatcher(id int) {
 			log.Infof("stop io_uring event op poll dispatcher id %d", id)
 			return
 		default:
-			event, err := s.iourings[id].getEventInfo()
+			var event *eventInfo
+			var err error
+			if s.options.ioMode == IOModeEpollUring {
+				event, err = s.iourings[id].getEventInfo()
+			} else if s.iourings[id].busyPoll {
+				event, err = s.iourings[id].pollEventInfo()
+			} else {
+				// no eventfd notify, block wait cqe
+				event, err = s.iourings[id].waitEventInfo()
+			}
+			if s.inline {
+				s.handlePostedEvents()
+			}
 			if err != nil {
 				log.Warnf("id %d iouring get events error:%s continue", id, err.Error())
 				continue
@@ -389,10 +757,11 @@ func (s *Server) startIOUringPollDispatcher(id int) {
 				continue
 			}
 
-			// dispatch
+			// dispatch, event is owned by consumer after handled, eg: provided buffer flag is cleared when recycled
+			cqe := event.cqe
 			s.handleEvent(event)
 			// commit cqe is seen
-			s.iourings[id].cqeDone(event.cqe)
+			s.iourings[id].cqeDone(cqe)
 		}
 	} // end for
 }
@@ -402,6 +771,10 @@ func (s *Server) startIOUringPollDispatcher(id int) {
 // need balance(hash fd, same connect have orderly event process)
 // golang scheduler have a good way to schedule thread in bound cpu affinity
 func (s *Server) handleEvent(event *eventInfo) {
+	if s.inline {
+		s.processEvent(event)
+		return
+	}
 	index := event.fd % s.ioQueueNum
 	s.ioEventQueues[index] <- event
 }
@@ -409,6 +782,9 @@ func (s *Server) handleEvent(event *eventInfo) {
 // startIOConsumeHandler
 // setup io event consume goroutine
 func (s *Server) startIOConsumeHandler() {
+	if s.inline {
+		return
+	}
 	for _, queue := range s.ioEventQueues {
 		go s.consumeIOEvent(queue)
 	}
@@ -426,119 +802,188 @@ func (s *Server) consumeIOEvent(queue chan *eventInfo) {
 // consumeIOCompletionEvent
 func (s *Server) consumeIOCompletionEvent(queue chan *eventInfo) {
 	for event := range queue {
-		// process async accept connect complete event
-		if event.etype == ETypeAccept {
-			err := event.cb(event)
-			if err != nil {
-				log.Errorf("accept event %s cb error:%s, continue next event", event, err.Error())
-			}
-			continue
+		s.processIOCompletionEvent(event)
+	}
+}
+
+// processIOCompletionEvent
+// handle io_uring accept, read, poll in and write complete event
+func (s *Server) processIOCompletionEvent(event *eventInfo) {
+	// process async accept connect, non block connect complete event
+	if event.etype == ETypeAccept || event.etype == ETypeConnect {
+		err := event.cb(event)
+		if err != nil {
+			log.Errorf("accept event %s cb error:%s, continue next event", event, err.Error())
 		}
+		return
+	}
 
-		// get connect from fd
-		v, ok := s.conns.Load(event.fd)
-		if !ok {
+	// get connect from fd
+	v, ok := s.conns.Load(event.fd)
+	if !ok {
+		// canceled multishot op, in flight read op completed by shutdown
+		// and timeout event of closed connect are expected
+		expected := event.cqe.Res == -int32(syscall.ECANCELED) || isTimeoutEvent(event.etype) ||
+			(event.etype == ETypeRead && event.cqe.Res == 0)
+		if !expected {
 			log.Warnf("fd %d not found in conns, event:%s , continue next event", event.fd, event)
-			continue
 		}
-		c := v.(*Conn)
+		s.GetIoUring(event.fd).releaseBuffer(event)
+		return
+	}
+	c := v.(*Conn)
 
-		// process async read complete event
-		if event.etype == ETypeRead {
-			err := c.processReadEvent(event)
-			if err != nil {
-				// notice: if next connect use closed cfd (TIME_WAIT stat between 2MSL eg:4m),
-				// read from closed cfd return EBADF
-				if err == syscall.EBADF {
-					log.Errorf("read closed connect fd %d EBADF, continue next event", event.fd)
-					continue
-				}
+	if isTimeoutEvent(event.etype) {
+		s.processTimeoutEvent(c, event)
+		return
+	}
+
+	// process async read complete event
+	if event.etype == ETypeRead {
+		err := c.processReadEvent(event)
+		if err != nil {
+			// notice: if next connect use closed cfd (TIME_WAIT stat between 2MSL eg:4m),
+			// read from closed cfd return EBADF
+			if err == syscall.EBADF {
+				log.Errorf("read closed connect fd %d EBADF, continue next event", event.fd)
+				return
+			}
 
-				// no bytes available on socket, client must be disconnected
+			// no bytes available on socket, client must be disconnected
+			if err != io.EOF {
 				log.Warnf("process read event %s err:%s , client connect must be disconnected", event, err.Error())
-				// close and free connect
-				c.CloseConnect()
-				s.handler.OnClose(c, err)
 			}
+			// close and free connect
+			c.Close()
+			s.handler.OnClose(c, err)
 		}
+	}
 
-		// async write complete event
-		if event.etype == ETypeWrite {
-			err := c.processWirteEvent(event)
-			if err != nil {
-				log.Errorf("process write event %s err:%s, continue next event", event, err.Error())
-				continue
+	// poll in ready event, read bytes from socket
+	if event.etype == ETypePollInRead {
+		err := c.processPollInEvent(event)
+		if err != nil {
+			if err != io.EOF {
+				log.Warnf("process poll in event %s err:%s , client connect must be disconnected", event, err.Error())
 			}
+			c.Close()
+			s.handler.OnClose(c, err)
 		}
-	} // end for
+	}
+
+	// async write complete event
+	if event.etype == ETypeWrite {
+		err := c.processWirteEvent(event)
+		if err != nil {
+			// queued bytes can't be sent in order
+			log.Errorf("process write event %s err:%s, close connect", event, err.Error())
+			c.closeOnSendErr(err)
+			return
+		}
+	}
 }
 
 // consumeIOReadyEvent
 // handle ready r/w, close, connect timeout etc event
 func (s *Server) consumeIOReadyEvent(queue chan *eventInfo) {
 	for event := range queue {
-		v, ok := s.conns.Load(event.fd)
-		if !ok {
+		s.processIOReadyEvent(event)
+	}
+}
+
+// processIOReadyEvent
+// handle ready r/w, close, connect timeout etc event
+func (s *Server) processIOReadyEvent(event *eventInfo) {
+	// reactor accept retry
+	if event.etype == ETypeAccept {
+		s.acceptReady()
+		return
+	}
+	// dial timeout
+	if event.etype == ETypeConnect {
+		event.cb(event)
+		return
+	}
+	v, ok := s.conns.Load(event.fd)
+	if !ok {
+		// non block connecting fd is writable
+		if s.processDialEvent(event.fd) {
+			return
+		}
+		// timeout event of closed connect is expected
+		if !isTimeoutEvent(event.etype) {
 			log.Warn("not found in conns,", event.fd, event)
-			continue
 		}
-		c := v.(*Conn)
+		return
+	}
+	c := v.(*Conn)
 
-		if event.etype == ETypeClose {
-			c.Close()
-			s.handler.OnClose(c, io.EOF)
-			continue
-		}
-		if event.etype == ETypeTimeout {
+	if event.etype == ETypeClose {
+		c.Close()
+		s.handler.OnClose(c, io.EOF)
+		return
+	}
+	if isTimeoutEvent(event.etype) {
+		s.processTimeoutEvent(c, event)
+		return
+	}
+	if event.etype == ETypeOut {
+		err := c.flush()
+		if err != nil {
+			log.Warnf("flush connect fd %d err:%s , client connect must be disconnected", c.fd, err.Error())
 			c.Close()
-			s.handler.OnClose(c, ErrReadTimeout)
-			continue
+			s.handler.OnClose(c, err)
 		}
+		return
+	}
+	// read paused, ET read event is reported again when resume
+	if c.IsReadPaused() {
+		return
+	}
 
-		err := c.Read()
-		if err != nil {
-			// notice: if next connect use closed cfd (TIME_WAIT stat between 2MSL eg:4m),
-			// read from closed cfd return EBADF
-			if err == syscall.EBADF {
-				continue
-			}
+	err := c.Read()
+	if err != nil {
+		// notice: if next connect use closed cfd (TIME_WAIT stat between 2MSL eg:4m),
+		// read from closed cfd return EBADF
+		if err == syscall.EBADF {
+			return
+		}
 
-			// no bytes available on socket, client must be disconnected
+		// no bytes available on socket, client must be disconnected
+		if err != io.EOF {
 			log.Warnf("process sync read connect fd %d err:%s , client connect must be disconnected", c.fd, err.Error())
-			// close and free connect
-			c.Close()
-			s.handler.OnClose(c, err)
+		}
+		// close and free connect
+		c.Close()
+		s.handler.OnClose(c, err)
+
+	}
+}
 
+// ProcessPostedEvents
+// handle queued events (eg: timeout events posted by timers) on the calling goroutine,
+// drive by caller if event loops are not running (eg: pollertest), return handled events num
+func (s *Server) ProcessPostedEvents() (n int) {
+	for _, queue := range s.ioEventQueues {
+		for len(queue) > 0 {
+			s.processEvent(<-queue)
+			n++
 		}
-	} // end for
+	}
+	return
 }
 
-// checkTimeout
-// tick to check connect time out
-func (s *Server) checkTimeout() {
-	if s.options.timeout == 0 || s.options.timeoutTicker == 0 {
+// processTimeoutEvent
+// close connect if read/write deadline or idle timeout is expired
+func (s *Server) processTimeoutEvent(c *Conn, event *eventInfo) {
+	err := c.processTimeoutEvent(event.etype)
+	if err == nil {
 		return
 	}
-
-	log.Infof("check timeout goroutine run,check_time:%v, timeout:%v", s.options.timeoutTicker, s.options.timeout)
-	go func() {
-		ticker := time.NewTicker(s.options.timeoutTicker)
-		for {
-			select {
-			case <-s.stop:
-				return
-			case <-ticker.C:
-				s.conns.Range(func(key, value interface{}) bool {
-					c := value.(*Conn)
-					//log.Infof("check connect %+v", c)
-					if time.Since(c.lastReadTime) > s.options.timeout {
-						s.handleEvent(&eventInfo{fd: int(c.fd), etype: ETypeTimeout})
-					}
-					return true
-				})
-			}
-		} // end for
-	}()
+	if c.close() {
+		atomic.AddInt64(&s.stats.timeouts, 1)
+		s.handler.OnClose(c, err)
+	}
 }
 
 func (s *Server) report() {
@@ -554,9 +999,10 @@ func (s *Server) report() {
 			case <-s.stop:
 				return
 			case <-ticker.C:
-				n := s.GetConnsNum()
-				if n > 0 {
-					log.Infof("current active connect num %d", n)
+				st := s.Stats()
+				if st.ConnsNum > 0 {
+					log.Infof("current active connect num %d, accepted %d, bytes in %d out %d, msgs in %d out %d",
+						st.ConnsNum, st.Accepted, st.BytesIn, st.BytesOut, st.MsgsIn, st.MsgsOut)
 				}
 			}
 		}
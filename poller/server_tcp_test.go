@@ -0,0 +1,148 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHandler echo handler records connect callbacks
+type testHandler struct {
+	echo     bool
+	msgs     chan []byte
+	connects chan *Conn
+	closes   chan error
+}
+
+func newTestHandler(echo bool) *testHandler {
+	return &testHandler{
+		echo:     echo,
+		msgs:     make(chan []byte, 1024),
+		connects: make(chan *Conn, 1024),
+		closes:   make(chan error, 1024),
+	}
+}
+
+func (h *testHandler) OnConnect(c *Conn) {
+	h.connects <- c
+}
+
+func (h *testHandler) OnMessage(c *Conn, bytes []byte) {
+	h.msgs <- append([]byte{}, bytes...)
+	if h.echo {
+		c.Write(bytes)
+	}
+}
+
+func (h *testHandler) OnClose(c *Conn, err error) {
+	h.closes <- err
+}
+
+// freeAddr loopback address of free tcp port
+func freeAddr(t testing.TB) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer run server on address until test cleanup
+func startServer(t testing.TB, address string, handler Handler, opts ...Option) *Server {
+	s, err := NewServer(address, handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Run()
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		wg.Wait()
+	})
+	return s
+}
+
+// startClient run client event loops until test cleanup
+func startClient(t testing.TB, handler Handler, opts ...Option) *Client {
+	c, err := NewClient(handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	t.Cleanup(c.Stop)
+	return c
+}
+
+// recv wait value from channel
+func recv(t testing.TB, ch interface{}) interface{} {
+	t.Helper()
+	switch ch := ch.(type) {
+	case chan []byte:
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(3 * time.Second):
+		}
+	case chan *Conn:
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(3 * time.Second):
+		}
+	case chan error:
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(3 * time.Second):
+		}
+	case chan struct{}:
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(3 * time.Second):
+		}
+	}
+	t.Fatal("wait timeout")
+	return nil
+}
+
+func TestServerEcho(t *testing.T) {
+	addr := freeAddr(t)
+	h := newTestHandler(true)
+	startServer(t, addr, h)
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv(t, h.connects)
+
+	data := []byte("hello poller")
+	if _, err = conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatalf("echo %q; want %q", buf, data)
+	}
+
+	conn.Close()
+	if err := recv(t, h.closes); err != io.EOF {
+		t.Fatalf("OnClose err %v; want EOF", err)
+	}
+}
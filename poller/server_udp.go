@@ -0,0 +1,437 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/weedge/lib/log"
+	"golang.org/x/sys/unix"
+)
+
+// UDPServer UDP server
+// poll datagram socket by epoll or io_uring, batch read by recvmmsg,
+// dispatch per datagram to UDPHandler, batch reply by sendmmsg
+type UDPServer struct {
+	options       *options         // Service parameters
+	handler       UDPHandler       // Indicates the processing of registration
+	fd            int              // bind datagram socket fd
+	pollerFD      int              // event poller fd (epoll)
+	iouring       *ioUring         // io_uring ring for poll in ready event (io_uring mode)
+	bufferPool    *sync.Pool       // datagram buffer memory pool
+	ioEventQueues []chan *datagram // IO A collection of datagram queues
+	ioQueueNum    int              // Number of I/O datagram queues
+	sendQueue     chan *datagram   // reply datagram queue for sendmmsg batch
+	stop          chan struct{}    // Indicates the server shutdown signal
+	stopOnce      sync.Once        // close stop once
+
+	// recvmmsg batch, only used by io event looper goroutine
+	recvHdrs []mmsghdr
+	recvIovs []syscall.Iovec
+	recvRsas []syscall.RawSockaddrAny
+	recvBufs [][]byte
+}
+
+// datagram received/reply datagram with peer address
+type datagram struct {
+	addr syscall.Sockaddr // peer address
+	buf  []byte           // pool buffer
+	n    int              // datagram bytes len in buf
+}
+
+// NewUDPServer
+// init udp server to start, address is ipv4 "ip:port" like tcp server (udp6 is not supported);
+// readBufferLen option is the max datagram size, larger datagram is truncated and dropped
+func NewUDPServer(address string, handler UDPHandler, opts ...Option) (*UDPServer, error) {
+	options := getOptions(opts...)
+
+	fd, err := listenUDP(address)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	s := &UDPServer{
+		options:  options,
+		handler:  handler,
+		fd:       fd,
+		pollerFD: -1,
+		bufferPool: &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, options.readBufferLen)
+				return b
+			},
+		},
+		ioEventQueues: make([]chan *datagram, options.ioGNum),
+		ioQueueNum:    options.ioGNum,
+		sendQueue:     make(chan *datagram, options.ioEventQueueLen),
+		stop:          make(chan struct{}),
+	}
+
+	if options.ioMode.isIoUring() {
+		s.iouring, err = newModeIoUring(options, -1)
+		if err != nil {
+			log.Errorf("newIoUring err %s", err.Error())
+			syscall.Close(fd)
+			return nil, err
+		}
+	} else {
+		s.pollerFD, err = createPoller()
+		if err != nil {
+			log.Error(err)
+			syscall.Close(fd)
+			return nil, err
+		}
+		err = addReadEvent(s.pollerFD, fd)
+		if err != nil {
+			log.Error(err)
+			syscall.Close(s.pollerFD)
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+
+	for i := range s.ioEventQueues {
+		s.ioEventQueues[i] = make(chan *datagram, options.ioEventQueueLen)
+	}
+
+	s.recvHdrs = make([]mmsghdr, options.udpBatchSize)
+	s.recvIovs = make([]syscall.Iovec, options.udpBatchSize)
+	s.recvRsas = make([]syscall.RawSockaddrAny, options.udpBatchSize)
+	s.recvBufs = make([][]byte, options.udpBatchSize)
+	for i := 0; i < options.udpBatchSize; i++ {
+		s.setRecvBuf(i, s.bufferPool.Get().([]byte))
+	}
+
+	return s, nil
+}
+
+// Run run udp server
+// datagram consumer handle OnDatagram biz logic,
+// sender batch reply datagrams,
+// io event looper batch read datagrams and dispatch
+func (s *UDPServer) Run() {
+	log.Info("start udp server runing...")
+	for _, queue := range s.ioEventQueues {
+		go s.consumeDatagram(queue)
+	}
+	log.Infof("start udp datagram consumer by %d goroutine handler", len(s.ioEventQueues))
+
+	go s.startSender()
+
+	if s.iouring != nil {
+		s.startIOUringPollDispatcher()
+		return
+	}
+	s.startIOEventPollDispatcher()
+}
+
+// Stop
+// stop udp server, shutdown read to wake up io event looper; stop again is no-op
+func (s *UDPServer) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		// unconnected udp socket shutdown return ENOTCONN, but wake up poller
+		syscall.Shutdown(s.fd, syscall.SHUT_RD)
+	})
+}
+
+// WriteTo
+// copy bytes to datagram, queue to send to peer address by sendmmsg batch
+func (s *UDPServer) WriteTo(bytes []byte, addr syscall.Sockaddr) error {
+	select {
+	case <-s.stop:
+		return ErrServerStopped
+	default:
+	}
+
+	var buf []byte
+	if len(bytes) <= s.options.readBufferLen {
+		buf = s.bufferPool.Get().([]byte)
+	} else {
+		buf = make([]byte, len(bytes))
+	}
+	n := copy(buf, bytes)
+
+	select {
+	case s.sendQueue <- &datagram{addr: addr, buf: buf, n: n}:
+		return nil
+	case <-s.stop:
+		s.putBuf(buf)
+		return ErrServerStopped
+	}
+}
+
+// GetFd gets the datagram socket file descriptor
+func (s *UDPServer) GetFd() int {
+	return s.fd
+}
+
+func (s *UDPServer) setRecvBuf(i int, buf []byte) {
+	s.recvBufs[i] = buf
+	s.recvIovs[i].Base = &buf[0]
+	s.recvIovs[i].SetLen(len(buf))
+	s.recvHdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&s.recvRsas[i]))
+	s.recvHdrs[i].hdr.Iov = &s.recvIovs[i]
+	s.recvHdrs[i].hdr.Iovlen = 1
+}
+
+func (s *UDPServer) putBuf(buf []byte) {
+	if len(buf) == s.options.readBufferLen {
+		s.bufferPool.Put(buf)
+	}
+}
+
+// free
+// close socket fd, poller fd, io_uring ring after io event looper stop
+func (s *UDPServer) free() {
+	if s.pollerFD > 0 {
+		syscall.Close(s.pollerFD)
+	}
+	if s.iouring != nil {
+		s.iouring.CloseRing()
+	}
+	syscall.Close(s.fd)
+}
+
+// startIOEventPollDispatcher
+// get read ready event from poller, batch read datagrams
+func (s *UDPServer) startIOEventPollDispatcher() {
+	log.Info("start udp io event poll dispatcher")
+	defer s.free()
+	for {
+		select {
+		case <-s.stop:
+			log.Infof("stop udp io event poll dispatcher")
+			return
+		default:
+			events, err := getEvents(s.pollerFD)
+			if err != nil {
+				if err != syscall.EINTR {
+					log.Error(err)
+				}
+				continue
+			}
+
+			if len(events) == 0 {
+				continue
+			}
+			err = s.recvBatch()
+			if err != nil {
+				log.Errorf("udp recv batch err %s", err.Error())
+			}
+		}
+	} // end for
+}
+
+// startIOUringPollDispatcher
+// get poll in ready completed event from io_uring cqe, batch read datagrams
+func (s *UDPServer) startIOUringPollDispatcher() {
+	log.Info("start udp io_uring poll in event dispatcher")
+	defer s.free()
+	s.addPollInSqe()
+	for {
+		select {
+		case <-s.stop:
+			log.Infof("stop udp io_uring poll in event dispatcher")
+			return
+		default:
+			event, err := s.iouring.waitEventInfo()
+			if err != nil {
+				log.Warnf("udp iouring wait events error:%s continue", err.Error())
+				continue
+			}
+			if event == nil {
+				continue
+			}
+
+			err = event.cb(event)
+			if err != nil {
+				log.Errorf("udp poll in event %s cb error:%s", event, err.Error())
+			}
+			// commit cqe is seen
+			s.iouring.cqeDone(event.cqe)
+		}
+	} // end for
+}
+
+// addPollInSqe
+// add one shot poll in ready op, re-add after batch read
+func (s *UDPServer) addPollInSqe() {
+	s.iouring.addPollAddSqe(func(e *eventInfo) (err error) {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if e.cqe.Res < 0 {
+			err = ErrIOUringReadFail
+		} else {
+			err = s.recvBatch()
+		}
+		s.addPollInSqe()
+		return
+	}, s.fd, unix.POLLIN, 0)
+}
+
+// recvBatch
+// read datagrams by recvmmsg until EAGAIN, dispatch per datagram
+func (s *UDPServer) recvBatch() error {
+	for {
+		for i := range s.recvHdrs {
+			s.recvHdrs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+			s.recvHdrs[i].hdr.Flags = 0
+		}
+
+		n, err := recvmmsg(s.fd, s.recvHdrs, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		for i := 0; i < n; i++ {
+			if s.recvHdrs[i].hdr.Flags&syscall.MSG_TRUNC != 0 {
+				log.Warnf("udp datagram truncated over read buffer len %d, drop it", s.options.readBufferLen)
+				continue
+			}
+			addr, err := anyToSockaddr(&s.recvRsas[i])
+			if err != nil {
+				log.Errorf("udp datagram peer address err %s, drop it", err.Error())
+				continue
+			}
+
+			s.dispatch(&datagram{addr: addr, buf: s.recvBufs[i], n: int(s.recvHdrs[i].len)})
+			s.setRecvBuf(i, s.bufferPool.Get().([]byte))
+		}
+	}
+}
+
+// dispatch
+// hash peer address dispatch datagram to channel(queue),
+// same peer have orderly datagram process
+func (s *UDPServer) dispatch(dg *datagram) {
+	index := int(hashSockaddr(dg.addr) % uint32(s.ioQueueNum))
+	select {
+	case s.ioEventQueues[index] <- dg:
+	case <-s.stop:
+	}
+}
+
+func (s *UDPServer) consumeDatagram(queue chan *datagram) {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case dg := <-queue:
+			s.handler.OnDatagram(s, dg.addr, dg.buf[:dg.n])
+			s.putBuf(dg.buf)
+		}
+	}
+}
+
+// startSender
+// get reply datagrams from send queue, batch send by sendmmsg
+func (s *UDPServer) startSender() {
+	size := s.options.udpBatchSize
+	batch := make([]*datagram, 0, size)
+	hdrs := make([]mmsghdr, size)
+	iovs := make([]syscall.Iovec, size)
+	rsas := make([]syscall.RawSockaddrAny, size)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case dg := <-s.sendQueue:
+			batch = append(batch[:0], dg)
+		}
+
+	drain:
+		for len(batch) < size {
+			select {
+			case dg := <-s.sendQueue:
+				batch = append(batch, dg)
+			default:
+				break drain
+			}
+		}
+
+		s.sendBatch(batch, hdrs, iovs, rsas)
+		for _, dg := range batch {
+			s.putBuf(dg.buf)
+		}
+	}
+}
+
+func (s *UDPServer) sendBatch(batch []*datagram, hdrs []mmsghdr, iovs []syscall.Iovec, rsas []syscall.RawSockaddrAny) {
+	n := 0
+	for _, dg := range batch {
+		l, err := sockaddrToAny(dg.addr, &rsas[n])
+		if err != nil {
+			log.Errorf("udp reply peer address err %s, drop it", err.Error())
+			continue
+		}
+		iovs[n].Base = &dg.buf[0]
+		iovs[n].SetLen(dg.n)
+		hdrs[n].hdr = syscall.Msghdr{
+			Name:    (*byte)(unsafe.Pointer(&rsas[n])),
+			Namelen: l,
+			Iov:     &iovs[n],
+			Iovlen:  1,
+		}
+		n++
+	}
+
+	for sent := 0; sent < n; {
+		m, err := sendmmsg(s.fd, hdrs[sent:n], 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			waitWritable(s.fd, time.Second)
+			continue
+		}
+		if err != nil {
+			// skip the first failed datagram, eg: EMSGSIZE
+			log.Errorf("udp sendmmsg err %s, drop datagram", err.Error())
+			sent++
+			continue
+		}
+		sent += m
+	}
+}
+
+// hashSockaddr
+// hash peer address for dispatch
+func hashSockaddr(sa syscall.Sockaddr) (h uint32) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		h = uint32(sa.Port)
+		for _, b := range sa.Addr {
+			h = h*31 + uint32(b)
+		}
+	case *syscall.SockaddrInet6:
+		h = uint32(sa.Port)
+		for _, b := range sa.Addr {
+			h = h*31 + uint32(b)
+		}
+	}
+	return
+}
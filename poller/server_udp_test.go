@@ -0,0 +1,215 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// udpModes io modes of udp server
+var udpModes = []struct {
+	name string
+	mode IOMode
+}{
+	{"epoll", IOModeDefaultPoll},
+	{"io_uring", IOModeUring},
+}
+
+// udpEcho echo datagrams by WriteTo
+type udpEcho struct {
+	datagrams chan []byte
+}
+
+func (h *udpEcho) OnDatagram(s *UDPServer, addr syscall.Sockaddr, b []byte) {
+	h.datagrams <- append([]byte{}, b...)
+	s.WriteTo(b, addr)
+}
+
+// startUDPServer run udp server until test cleanup, run returned is closed after Run return
+func startUDPServer(t *testing.T, h UDPHandler, opts ...Option) (s *UDPServer, addr string, run chan struct{}) {
+	addr = freeUDPAddr(t)
+	s, err := NewUDPServer(addr, h, opts...)
+	if err != nil {
+		t.Skipf("udp server is not available: %v", err)
+	}
+	run = make(chan struct{})
+	go func() {
+		defer close(run)
+		s.Run()
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		<-run
+	})
+	return
+}
+
+// freeUDPAddr loopback address of free udp port
+func freeUDPAddr(t *testing.T) string {
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.LocalAddr().String()
+}
+
+// udpRoundTrip send n datagrams from one peer, receive all echoes
+func udpRoundTrip(t *testing.T, addr string, n int) {
+	peer, err := net.Dial("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	want := map[string]bool{}
+	for i := 0; i < n; i++ {
+		msg := fmt.Sprintf("datagram %d", i)
+		want[msg] = true
+		if _, err = peer.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 1024)
+	peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for len(want) > 0 {
+		m, err := peer.Read(buf)
+		if err != nil {
+			t.Fatalf("%d echoes not received: %v", len(want), err)
+		}
+		if !want[string(buf[:m])] {
+			t.Fatalf("unexpected echo %q", buf[:m])
+		}
+		delete(want, string(buf[:m]))
+	}
+}
+
+func TestUDPServerEcho(t *testing.T) {
+	for _, m := range udpModes {
+		t.Run(m.name, func(t *testing.T) {
+			h := &udpEcho{datagrams: make(chan []byte, 1024)}
+			_, addr, _ := startUDPServer(t, h, WithIoMode(m.mode), WithUDPBatchSize(8))
+			// more datagrams than batch size
+			udpRoundTrip(t, addr, 64)
+		})
+	}
+}
+
+func TestUDPServerTruncatedDatagram(t *testing.T) {
+	h := &udpEcho{datagrams: make(chan []byte, 16)}
+	_, addr, _ := startUDPServer(t, h, WithReadBufferLen(16))
+	peer, err := net.Dial("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// datagram over read buffer len is dropped
+	peer.Write(bytes.Repeat([]byte("x"), 17))
+	peer.Write([]byte("fit"))
+	if b := recv(t, h.datagrams).([]byte); string(b) != "fit" {
+		t.Fatalf("datagram %q; want truncated one dropped", b)
+	}
+}
+
+func TestUDPServerWriteTo(t *testing.T) {
+	s, _, _ := startUDPServer(t, &udpEcho{datagrams: make(chan []byte, 16)}, WithReadBufferLen(16))
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	pa := peer.LocalAddr().(*net.UDPAddr)
+	to := &syscall.SockaddrInet4{Port: pa.Port}
+	copy(to.Addr[:], pa.IP.To4())
+
+	// datagram larger than read buffer len is not from pool
+	msgs := [][]byte{[]byte("hello"), bytes.Repeat([]byte("y"), 1000)}
+	for _, msg := range msgs {
+		if err = s.WriteTo(msg, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 2048)
+	peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for _, msg := range msgs {
+		n, _, err := peer.ReadFrom(buf)
+		if err != nil || !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("received %d bytes err %v; want %d bytes", n, err, len(msg))
+		}
+	}
+
+	s.Stop()
+	if err = s.WriteTo([]byte("late"), to); err != ErrServerStopped {
+		t.Fatalf("WriteTo() after stop err %v; want %v", err, ErrServerStopped)
+	}
+}
+
+func TestUDPServerStop(t *testing.T) {
+	for _, m := range udpModes {
+		t.Run(m.name, func(t *testing.T) {
+			s, _, run := startUDPServer(t, &udpEcho{datagrams: make(chan []byte, 16)}, WithIoMode(m.mode))
+			// looper is blocked in poller without datagram
+			time.Sleep(50 * time.Millisecond)
+			s.Stop()
+			select {
+			case <-run:
+			case <-time.After(3 * time.Second):
+				t.Fatal("Stop() doesn't wake up poller")
+			}
+		})
+	}
+}
+
+func TestRecvSendMmsg(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	const n = 8
+	send := make([]mmsghdr, n)
+	sendIovs := make([]syscall.Iovec, n)
+	for i := range send {
+		b := []byte(fmt.Sprintf("datagram %d", i))
+		sendIovs[i].Base = &b[0]
+		sendIovs[i].SetLen(len(b))
+		send[i].hdr.Iov = &sendIovs[i]
+		send[i].hdr.Iovlen = 1
+	}
+	// one syscall for the batch
+	if m, err := sendmmsg(fds[0], send, 0); err != nil || m != n {
+		t.Fatalf("sendmmsg() = %d err %v; want %d", m, err, n)
+	}
+
+	recvHdrs := make([]mmsghdr, n+1)
+	recvIovs := make([]syscall.Iovec, n+1)
+	bufs := make([][]byte, n+1)
+	for i := range recvHdrs {
+		bufs[i] = make([]byte, 64)
+		recvIovs[i].Base = &bufs[i][0]
+		recvIovs[i].SetLen(len(bufs[i]))
+		recvHdrs[i].hdr.Iov = &recvIovs[i]
+		recvHdrs[i].hdr.Iovlen = 1
+	}
+	m, err := recvmmsg(fds[1], recvHdrs, syscall.MSG_DONTWAIT)
+	if err != nil || m != n {
+		t.Fatalf("recvmmsg() = %d err %v; want %d", m, err, n)
+	}
+	for i := 0; i < m; i++ {
+		if got := string(bufs[i][:recvHdrs[i].len]); got != fmt.Sprintf("datagram %d", i) {
+			t.Fatalf("datagram %d = %q", i, got)
+		}
+	}
+	if _, err = recvmmsg(fds[1], recvHdrs, syscall.MSG_DONTWAIT); err != syscall.EAGAIN {
+		t.Fatalf("recvmmsg() of empty socket err %v; want EAGAIN", err)
+	}
+}
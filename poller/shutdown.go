@@ -0,0 +1,163 @@
+package poller
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/weedge/lib/log"
+)
+
+const (
+	// shutdownPollInterval drain connects check interval
+	shutdownPollInterval = 100 * time.Millisecond
+)
+
+// Shutdown
+// graceful shutdown server:
+// stop accept new connects, notify ShutdownHandler.OnShutdown for each connect,
+// close connect after its pending writes done,
+// force close whatever is left when the context expires, then stop server
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	if s.reactors != nil {
+		return s.shutdownReactors(ctx)
+	}
+	log.Info("server shutdown, stop accept")
+	s.stopAccept()
+	s.closeListenFD()
+
+	if h, ok := s.handler.(ShutdownHandler); ok {
+		s.conns.Range(func(key, value interface{}) bool {
+			h.OnShutdown(value.(*Conn))
+			return true
+		})
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeDrainedConns() {
+		select {
+		case <-ctx.Done():
+			log.Warnf("server shutdown ctx done, force close %d left connects", s.GetConnsNum())
+			s.closeConns(true)
+			err = ctx.Err()
+			s.Stop()
+			return
+		case <-ticker.C:
+		}
+	}
+
+	log.Info("server shutdown, all connects drained")
+	s.Stop()
+	return
+}
+
+// HandOff
+// zero downtime restart: start child process (same executable and args, or argv)
+// with the listen fd passed by LISTEN_FDS, child adopt it by WithSocketActivation option,
+// the listen queue is kept; then graceful shutdown this server.
+func (s *Server) HandOff(ctx context.Context, argv ...string) (*os.Process, error) {
+	if s.listenFD < 0 {
+		return nil, ErrNotListenSocket
+	}
+
+	// dup listen fd for child, exec.Cmd dups it again as fd 3 in child
+	fd, err := syscall.Dup(s.listenFD)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+
+	if len(argv) == 0 {
+		argv = os.Args
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if argv[0] != os.Args[0] {
+		path = argv[0]
+	}
+
+	cmd := exec.Command(path, argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "LISTEN_") {
+			continue
+		}
+		cmd.Env = append(cmd.Env, env)
+	}
+	cmd.Env = append(cmd.Env, "LISTEN_FDS=1")
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("hand off listen fd %d to child pid %d", s.listenFD, cmd.Process.Pid)
+
+	return cmd.Process, s.Shutdown(ctx)
+}
+
+// stopAccept
+// stop acceptor goroutines, wait them exit
+func (s *Server) stopAccept() {
+	s.acceptStopOnce.Do(func() {
+		close(s.acceptStop)
+		s.wakeUp()
+		s.cancelAsyncAccept()
+		s.acceptWg.Wait()
+	})
+}
+
+// wakeUp
+// wake up blocked acceptor and poller looper
+func (s *Server) wakeUp() {
+	_, err := syscall.Write(s.wakeFDs[1], []byte{1})
+	if err != nil && err != syscall.EAGAIN {
+		log.Errorf("wake up err %s", err.Error())
+	}
+}
+
+// closeListenFD
+// close this process listen fd, the socket is still open in the child (hand off)
+func (s *Server) closeListenFD() {
+	if s.listenFD < 0 {
+		return
+	}
+	err := syscall.Close(s.listenFD)
+	if err != nil {
+		log.Errorf("close listen fd %d err %s", s.listenFD, err.Error())
+	}
+	s.listenFD = -1
+}
+
+// closeDrainedConns
+// close connects without pending writes, return true if no connect left
+func (s *Server) closeDrainedConns() bool {
+	return s.closeConns(false) == 0
+}
+
+// closeConns
+// close (force: all) connects, OnClose with ErrServerShutdown, return left connect num
+func (s *Server) closeConns(force bool) (left int) {
+	s.conns.Range(func(key, value interface{}) bool {
+		c := value.(*Conn)
+		if !force && c.HasPendingWrite() {
+			left++
+			return true
+		}
+		if c.close() {
+			s.handler.OnClose(c, ErrServerShutdown)
+		}
+		return true
+	})
+	return
+}
@@ -0,0 +1,305 @@
+//go:build linux
+// +build linux
+
+package poller
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// shutdownHandler test handler records OnShutdown connects, writes resp for each message
+type shutdownHandler struct {
+	*testHandler
+	resp      []byte
+	shutdowns chan *Conn
+}
+
+func newShutdownHandler(resp []byte) *shutdownHandler {
+	return &shutdownHandler{testHandler: newTestHandler(false), resp: resp, shutdowns: make(chan *Conn, 16)}
+}
+
+func (h *shutdownHandler) OnConnect(c *Conn) {
+	// resp is queued for slow reader
+	syscall.SetsockoptInt(c.GetFd(), syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+	h.testHandler.OnConnect(c)
+}
+
+func (h *shutdownHandler) OnMessage(c *Conn, bytes []byte) {
+	h.testHandler.OnMessage(c, bytes)
+	c.Write(h.resp)
+}
+
+func (h *shutdownHandler) OnShutdown(c *Conn) {
+	h.shutdowns <- c
+}
+
+// dialPending dial server with small read buffer, request resp which is queued on server connect
+func dialPending(t *testing.T, addr string, h *shutdownHandler) (*net.TCPConn, *Conn) {
+	// receive window is bounded before connect
+	d := net.Dialer{Timeout: time.Second, Control: func(network, address string, rc syscall.RawConn) error {
+		return rc.Control(func(fd uintptr) {
+			syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4096)
+		})
+	}}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	tc := conn.(*net.TCPConn)
+	c := recv(t, h.connects).(*Conn)
+	tc.Write([]byte("get"))
+	recv(t, h.msgs)
+	for i := 0; !c.HasPendingWrite(); i++ {
+		if i == 100 {
+			t.Fatal("resp is written without queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return tc, c
+}
+
+// shutdownAsync run Shutdown in goroutine, the returned err is sent to channel
+func shutdownAsync(s *Server, ctx context.Context) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(ctx)
+	}()
+	return done
+}
+
+func TestShutdownDrain(t *testing.T) {
+	addr := freeAddr(t)
+	resp := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	h := newShutdownHandler(resp)
+	s := startServer(t, addr, h, WithWriteQueueLen(len(resp)))
+	tc, c := dialPending(t, addr, h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := shutdownAsync(s, ctx)
+	if sc := recv(t, h.shutdowns).(*Conn); sc != c {
+		t.Fatal("OnShutdown is not called with the connect")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() returned %v before pending writes drained", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Fatal("new connect is accepted while shutdown")
+	}
+
+	// peer reads the whole resp, then connect is closed
+	tc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, err := ioutil.ReadAll(tc)
+	if err != nil || !bytes.Equal(out, resp) {
+		t.Fatalf("read %d bytes err %v; want %d bytes", len(out), err, len(resp))
+	}
+	if err := recv(t, done); err != nil {
+		t.Fatalf("Shutdown() err %v", err)
+	}
+	if err := recv(t, h.closes); err != ErrServerShutdown {
+		t.Fatalf("OnClose err %v; want %v", err, ErrServerShutdown)
+	}
+
+	// stop after shutdown is no-op
+	s.Stop()
+}
+
+func TestShutdownForceClose(t *testing.T) {
+	addr := freeAddr(t)
+	resp := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	h := newShutdownHandler(resp)
+	s := startServer(t, addr, h, WithWriteQueueLen(len(resp)))
+	// idle connect is closed at once, slow reader is closed by deadline
+	idle, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	recv(t, h.connects)
+	tc, _ := dialPending(t, addr, h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	done := shutdownAsync(s, ctx)
+	if err := recv(t, h.closes); err != ErrServerShutdown {
+		t.Fatalf("idle connect OnClose err %v; want %v", err, ErrServerShutdown)
+	}
+	if err := recv(t, done); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() err %v; want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("Shutdown() returned in %s before deadline", elapsed)
+	}
+	if err := recv(t, h.closes); err != ErrServerShutdown {
+		t.Fatalf("pending connect OnClose err %v; want %v", err, ErrServerShutdown)
+	}
+	if s.GetConnsNum() != 0 {
+		t.Fatalf("%d connects left after force close", s.GetConnsNum())
+	}
+
+	// slow reader gets part of resp then EOF
+	tc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, _ := ioutil.ReadAll(tc)
+	if len(out) >= len(resp) {
+		t.Fatalf("read %d bytes; want partial resp", len(out))
+	}
+	s.Stop()
+}
+
+func TestShutdownCancelAsyncAccept(t *testing.T) {
+	for _, multishot := range []bool{false, true} {
+		name := "oneshot"
+		if multishot {
+			name = "multishot"
+		}
+		t.Run(name, func(t *testing.T) {
+			addr := freeAddr(t)
+			h := newTestHandler(false)
+			s, err := NewServer(addr, h, WithIoMode(IOModeUring))
+			if err != nil {
+				t.Skipf("io_uring is not available: %v", err)
+			}
+			for _, ring := range s.iourings {
+				if multishot && !ring.multishotAccept {
+					s.Stop()
+					t.Skip("multishot accept is not supported")
+				}
+				ring.multishotAccept = multishot
+			}
+			// listen socket shared with hand off child
+			lfd, err := syscall.Dup(s.listenFD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer syscall.Close(lfd)
+			syscall.SetNonblock(lfd, true)
+
+			run := make(chan struct{})
+			go func() {
+				defer close(run)
+				s.Run()
+			}()
+			// accept op is in flight
+			time.Sleep(50 * time.Millisecond)
+			if err = s.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			<-run
+
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// connect is left in listen queue for the other listen fd owner
+			var cfd int
+			for i := 0; ; i++ {
+				cfd, _, err = syscall.Accept(lfd)
+				if err == nil {
+					break
+				}
+				if i == 100 {
+					t.Fatalf("connect is not in listen queue: %v", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			defer syscall.Close(cfd)
+			syscall.Write(cfd, []byte("alive"))
+			buf := make([]byte, 8)
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "alive" {
+				t.Fatalf("read %q err %v", buf[:n], err)
+			}
+			select {
+			case c := <-h.connects:
+				t.Fatalf("connect %s is accepted after shutdown", c.GetAddr())
+			default:
+			}
+		})
+	}
+}
+
+// handOffChildEnv env of hand off child test process
+const handOffChildEnv = "POLLER_TEST_HAND_OFF_CHILD"
+
+// TestHandOffChild
+// echo server of hand off child process, exit after the first connect closed
+func TestHandOffChild(t *testing.T) {
+	if os.Getenv(handOffChildEnv) == "" {
+		t.Skip("run by TestHandOff")
+	}
+	h := &funcHandler{
+		onMessage: func(c *Conn, bytes []byte) { c.Write(bytes) },
+		onClose:   func(c *Conn, err error) { os.Exit(0) },
+	}
+	s, err := NewServer("127.0.0.1:0", h, WithSocketActivation())
+	if err != nil {
+		os.Exit(1)
+	}
+	s.Run()
+}
+
+func TestHandOff(t *testing.T) {
+	addr := freeAddr(t)
+	h := newShutdownHandler(nil)
+	s := startServer(t, addr, h)
+	idle, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	c := recv(t, h.connects).(*Conn)
+
+	os.Setenv(handOffChildEnv, "1")
+	defer os.Unsetenv(handOffChildEnv)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	p, err := s.HandOff(ctx, os.Args[0], "-test.run=^TestHandOffChild$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		st, err := p.Wait()
+		if err == nil && !st.Success() {
+			err = &exec.ExitError{ProcessState: st}
+		}
+		exited <- err
+	}()
+	defer p.Kill()
+
+	if sc := recv(t, h.shutdowns).(*Conn); sc != c {
+		t.Fatal("OnShutdown is not called with the connect")
+	}
+	if err := recv(t, h.closes); err != ErrServerShutdown {
+		t.Fatalf("OnClose err %v; want %v", err, ErrServerShutdown)
+	}
+
+	// child serves the handed off listen socket
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("child echo %q err %v", buf[:n], err)
+	}
+	conn.Close()
+	if err := recv(t, exited); err != nil {
+		t.Fatalf("child exit err %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package poller
+
+import (
+	"sync/atomic"
+)
+
+// ConnStats
+// connect (or server total) io counters
+type ConnStats struct {
+	BytesIn      int64 // bytes read from connect socket, ciphertext of tls
+	BytesOut     int64 // bytes written to connect socket, ciphertext of tls, include SendFile bytes
+	MsgsIn       int64 // decoded frames (or read bytes without decoder) handled by OnMessage
+	MsgsOut      int64 // accepted Write/SendFile calls
+	DecodeErrors int64 // decoder errors, connect is closed
+}
+
+// add
+// atomic add counters of other
+func (s *ConnStats) add(o *ConnStats) {
+	atomic.AddInt64(&s.BytesIn, atomic.LoadInt64(&o.BytesIn))
+	atomic.AddInt64(&s.BytesOut, atomic.LoadInt64(&o.BytesOut))
+	atomic.AddInt64(&s.MsgsIn, atomic.LoadInt64(&o.MsgsIn))
+	atomic.AddInt64(&s.MsgsOut, atomic.LoadInt64(&o.MsgsOut))
+	atomic.AddInt64(&s.DecodeErrors, atomic.LoadInt64(&o.DecodeErrors))
+}
+
+// IoUringStats
+// io_uring ring occupancy
+type IoUringStats struct {
+	SQEntries uint32 // sq ring entries
+	SQReady   uint32 // sqes not consumed by kernel
+	CQEntries uint32 // cq ring entries
+	CQReady   uint32 // cqes not reaped
+	InFlight  int    // submitted ops without complete event
+	Backlog   int    // ops wait for sq space
+}
+
+// ServerStats
+// server counters snapshot, reactors are summed up
+type ServerStats struct {
+	ConnStats                     // total io counters of all connects
+	ConnsNum       int64          // current connects
+	Accepted       int64          // accepted connects
+	Timeouts       int64          // connects closed by read/write deadline or idle timeout
+	Rejected       int64          // accepted connects rejected by admission limits
+	EventQueueLens []int          // queued events of each io event queue
+	IoUrings       []IoUringStats // occupancy of each io_uring ring
+}
+
+// serverStats
+// server counters updated atomically
+type serverStats struct {
+	ConnStats
+	accepted int64
+	timeouts int64
+	rejected int64
+}
+
+// Stats
+// snapshot of server counters, io event queues length and io_uring rings occupancy
+func (s *Server) Stats() (st ServerStats) {
+	if s.reactors != nil {
+		for _, r := range s.reactors {
+			rs := r.Stats()
+			st.ConnStats.add(&rs.ConnStats)
+			st.ConnsNum += rs.ConnsNum
+			st.Accepted += rs.Accepted
+			st.Timeouts += rs.Timeouts
+			st.Rejected += rs.Rejected
+			st.EventQueueLens = append(st.EventQueueLens, rs.EventQueueLens...)
+			st.IoUrings = append(st.IoUrings, rs.IoUrings...)
+		}
+		return
+	}
+
+	st.ConnStats.add(&s.stats.ConnStats)
+	st.ConnsNum = s.GetConnsNum()
+	st.Accepted = atomic.LoadInt64(&s.stats.accepted)
+	st.Timeouts = atomic.LoadInt64(&s.stats.timeouts)
+	st.Rejected = atomic.LoadInt64(&s.stats.rejected)
+	st.EventQueueLens = make([]int, len(s.ioEventQueues))
+	for i, queue := range s.ioEventQueues {
+		st.EventQueueLens[i] = len(queue)
+	}
+	for _, ring := range s.iourings {
+		st.IoUrings = append(st.IoUrings, ring.stats())
+	}
+	return
+}
+
+// RangeConns
+// call f for each connect until f returns false, eg: export per connect stats
+func (s *Server) RangeConns(f func(c *Conn) bool) {
+	if s.reactors != nil {
+		for _, r := range s.reactors {
+			next := true
+			r.RangeConns(func(c *Conn) bool {
+				next = f(c)
+				return next
+			})
+			if !next {
+				return
+			}
+		}
+		return
+	}
+	s.conns.Range(func(key, value interface{}) bool {
+		return f(value.(*Conn))
+	})
+}
+
+// Stats
+// snapshot of connect io counters, zero if WithConnStats option is not set
+func (c *Conn) Stats() (st ConnStats) {
+	if c.stats != nil {
+		st.add(c.stats)
+	}
+	return
+}
+
+// addBytesIn
+func (c *Conn) addBytesIn(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&c.server.stats.BytesIn, int64(n))
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.BytesIn, int64(n))
+	}
+}
+
+// addBytesOut
+func (c *Conn) addBytesOut(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&c.server.stats.BytesOut, int64(n))
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.BytesOut, int64(n))
+	}
+}
+
+// addMsgIn
+func (c *Conn) addMsgIn() {
+	atomic.AddInt64(&c.server.stats.MsgsIn, 1)
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.MsgsIn, 1)
+	}
+}
+
+// addMsgOut
+func (c *Conn) addMsgOut() {
+	atomic.AddInt64(&c.server.stats.MsgsOut, 1)
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.MsgsOut, 1)
+	}
+}
+
+// addDecodeError
+func (c *Conn) addDecodeError() {
+	atomic.AddInt64(&c.server.stats.DecodeErrors, 1)
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.DecodeErrors, 1)
+	}
+}
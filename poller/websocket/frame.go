@@ -0,0 +1,294 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/weedge/lib/poller"
+)
+
+var (
+	ErrProtocol        = errors.New("websocket: protocol error")
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	ErrInvalidUTF8     = errors.New("websocket: invalid utf-8 text")
+	ErrBadHandshake    = errors.New("websocket: bad handshake")
+	ErrCloseSent       = errors.New("websocket: close sent")
+)
+
+// Opcode frame opcode
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+// IsControl close, ping, pong control frame
+func (op Opcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+// close frame status code
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005 // not sent, close frame without code
+	CloseAbnormalClosure         = 1006 // not sent, connect closed without close frame
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerErr       = 1011
+)
+
+const (
+	// maxFrameHeaderLen 2 bytes header, 8 bytes extended payload len, 4 bytes mask key
+	maxFrameHeaderLen = 14
+	// maxControlPayloadLen control frame payload max length
+	maxControlPayloadLen = 125
+)
+
+// CloseError close frame received from peer
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+// Frame one decoded frame
+type Frame struct {
+	Fin     bool
+	Opcode  Opcode
+	Payload []byte // unmasked payload, refer to connect read buffer, valid until next Decode
+}
+
+// FrameDecoder
+// incremental RFC 6455 frame decoder on poller.Buffer, one decoder per connect;
+// bytes of the frame are not consumed until it is complete.
+// FrameDecoder is poller.Decoder, Decode returns the raw bytes of the complete frame,
+// then get the decoded frame by Frame.
+// notice: the whole frame must fit in the connect read buffer (WithReadBufferLen)
+type FrameDecoder struct {
+	maxPayloadLen int
+	masked        bool // frames must be masked (from client) or not (from server)
+
+	frame Frame
+	ok    bool
+	err   error
+}
+
+// NewFrameDecoder
+// Creates a frame decoder with max payload length,
+// server decodes masked client frames, client decodes unmasked server frames
+func NewFrameDecoder(maxPayloadLen int, masked bool) *FrameDecoder {
+	if maxPayloadLen < maxControlPayloadLen {
+		panic("maxPayloadLen must not less than 125")
+	}
+	return &FrameDecoder{maxPayloadLen: maxPayloadLen, masked: masked}
+}
+
+// Frame
+// get the last complete frame, nil if none
+func (d *FrameDecoder) Frame() *Frame {
+	if !d.ok {
+		return nil
+	}
+	return &d.frame
+}
+
+// Err
+// get the decode error, the connect should send close frame and close
+func (d *FrameDecoder) Err() error {
+	return d.err
+}
+
+// Decode
+// decode buffered bytes, return the raw bytes of one complete frame, empty if not complete;
+// on decode error, all buffered bytes are discarded and returned, the error is got by Err
+func (d *FrameDecoder) Decode(buffer *poller.Buffer) (value []byte, err error) {
+	value = []byte{}
+	d.ok = false
+	if d.err != nil || buffer.Len() == 0 {
+		return
+	}
+
+	buf, _ := buffer.Seek(buffer.Len())
+	n, err := d.parse(buf)
+	if err != nil {
+		d.err = err
+		return buffer.Read(0, buffer.Len())
+	}
+	if n == 0 {
+		return
+	}
+
+	return buffer.Read(0, n)
+}
+
+// parse
+// parse one frame, return frame length, 0 if need more bytes
+func (d *FrameDecoder) parse(buf []byte) (n int, err error) {
+	if len(buf) < 2 {
+		return
+	}
+	b0, b1 := buf[0], buf[1]
+	// no extension is negotiated, rsv bits must be 0
+	if b0&0x70 != 0 {
+		return 0, ErrProtocol
+	}
+	fin := b0&0x80 != 0
+	op := Opcode(b0 & 0x0f)
+	switch op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+	default:
+		return 0, ErrProtocol
+	}
+	masked := b1&0x80 != 0
+	if masked != d.masked {
+		return 0, ErrProtocol
+	}
+
+	pos := 2
+	length := uint64(b1 & 0x7f)
+	switch length {
+	case 126:
+		if len(buf) < 4 {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[2:4]))
+		pos = 4
+	case 127:
+		if len(buf) < 10 {
+			return
+		}
+		length = binary.BigEndian.Uint64(buf[2:10])
+		pos = 10
+	}
+	if op.IsControl() && (!fin || length > maxControlPayloadLen) {
+		return 0, ErrProtocol
+	}
+	if length > uint64(d.maxPayloadLen) {
+		return 0, ErrMessageTooLarge
+	}
+
+	var key []byte
+	if masked {
+		if len(buf) < pos+4 {
+			return
+		}
+		key = buf[pos : pos+4]
+		pos += 4
+	}
+	end := pos + int(length)
+	if len(buf) < end {
+		return
+	}
+
+	payload := buf[pos:end]
+	if masked {
+		maskBytes(key, payload)
+	}
+	d.frame = Frame{Fin: fin, Opcode: op, Payload: payload}
+	d.ok = true
+	return end, nil
+}
+
+// AppendFrame
+// append encoded frame to dst, payload is masked by 4 bytes maskKey (client frame), nil: not masked
+func AppendFrame(dst []byte, fin bool, op Opcode, payload []byte, maskKey []byte) []byte {
+	if maskKey != nil && len(maskKey) != 4 {
+		panic("mask key must be 4 bytes")
+	}
+
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	var b1 byte
+	if maskKey != nil {
+		b1 = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		dst = append(dst, b0, b1|byte(length))
+	case length <= 0xffff:
+		dst = append(dst, b0, b1|126, 0, 0)
+		binary.BigEndian.PutUint16(dst[len(dst)-2:], uint16(length))
+	default:
+		dst = append(dst, b0, b1|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(dst[len(dst)-8:], uint64(length))
+	}
+
+	if maskKey == nil {
+		return append(dst, payload...)
+	}
+	dst = append(dst, maskKey...)
+	start := len(dst)
+	dst = append(dst, payload...)
+	maskBytes(maskKey, dst[start:])
+	return dst
+}
+
+// closePayload
+// close frame payload, 2 bytes code and utf-8 reason, empty for CloseNoStatusReceived
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	return append(b, reason...)
+}
+
+// parseClosePayload
+// code and reason of close frame payload
+func parseClosePayload(payload []byte) (code int, reason string, err error) {
+	if len(payload) == 0 {
+		return CloseNoStatusReceived, "", nil
+	}
+	if len(payload) == 1 {
+		return 0, "", ErrProtocol
+	}
+	code = int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return 0, "", ErrProtocol
+	}
+	if !utf8.Valid(payload[2:]) {
+		return 0, "", ErrInvalidUTF8
+	}
+	return code, string(payload[2:]), nil
+}
+
+// validCloseCode
+// code can be sent in close frame
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// maskBytes
+// xor bytes with mask key in place
+func maskBytes(key []byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
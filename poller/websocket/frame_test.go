@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+)
+
+var testMaskKey = []byte{0x12, 0x34, 0x56, 0x78}
+
+// decodeFrames feed bytes to buffer by chunks of size (0: all at once), decode frames after each feed,
+// payloads are copied
+func decodeFrames(d *FrameDecoder, b []byte, size int) (frames []Frame, err error) {
+	if size <= 0 {
+		size = len(b)
+	}
+	buffer := poller.NewBuffer(make([]byte, len(b)+1))
+	for len(b) > 0 {
+		n := size
+		if n > len(b) {
+			n = len(b)
+		}
+		buffer.ReadFromReader(bytes.NewReader(b[:n]))
+		b = b[n:]
+		for buffer.Len() > 0 {
+			d.Decode(buffer)
+			if d.Err() != nil {
+				return frames, d.Err()
+			}
+			f := d.Frame()
+			if f == nil {
+				break
+			}
+			frames = append(frames, Frame{Fin: f.Fin, Opcode: f.Opcode, Payload: append([]byte{}, f.Payload...)})
+		}
+	}
+	return
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   []byte
+		lengthLen int // extended payload length bytes
+	}{
+		{"empty", nil, 0},
+		{"7 bits", payload(125), 0},
+		{"16 bits min", payload(126), 2},
+		{"16 bits max", payload(0xffff), 2},
+		{"64 bits", payload(0x10000), 8},
+	}
+	for _, tt := range tests {
+		for _, key := range [][]byte{nil, testMaskKey} {
+			b := AppendFrame(nil, true, OpBinary, tt.payload, key)
+			headerLen := 2 + tt.lengthLen + len(key)
+			if len(b) != headerLen+len(tt.payload) {
+				t.Errorf("%s: frame len %d; want %d", tt.name, len(b), headerLen+len(tt.payload))
+				continue
+			}
+			if key != nil && len(tt.payload) > 0 && bytes.Equal(b[headerLen:], tt.payload) {
+				t.Errorf("%s: payload is not masked", tt.name)
+			}
+
+			for _, size := range []int{0, 1, 1000} {
+				frames, err := decodeFrames(NewFrameDecoder(0x10000, key != nil), b, size)
+				if err != nil || len(frames) != 1 {
+					t.Errorf("%s: read by %d decode %d frames err %v", tt.name, size, len(frames), err)
+					continue
+				}
+				f := frames[0]
+				if !f.Fin || f.Opcode != OpBinary || !bytes.Equal(f.Payload, tt.payload) {
+					t.Errorf("%s: read by %d frame fin %v op %x payload %d bytes", tt.name, size, f.Fin, f.Opcode, len(f.Payload))
+				}
+			}
+		}
+	}
+}
+
+func TestFrameDecodeErrors(t *testing.T) {
+	long := make([]byte, 10)
+	long[0], long[1] = 0x82, 0x80|127
+	binary.BigEndian.PutUint64(long[2:], 1<<63)
+	tests := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"unmasked client frame", AppendFrame(nil, true, OpText, []byte("a"), nil), ErrProtocol},
+		{"rsv bits", []byte{0xc1, 0x80}, ErrProtocol},
+		{"unknown opcode", []byte{0x83, 0x80}, ErrProtocol},
+		{"fragmented control", AppendFrame(nil, false, OpPing, nil, testMaskKey), ErrProtocol},
+		{"control too long", AppendFrame(nil, true, OpPing, payload(126), testMaskKey), ErrProtocol},
+		{"payload too large", AppendFrame(nil, true, OpBinary, payload(1025), testMaskKey), ErrMessageTooLarge},
+		{"64 bits length overflows int", append(long, testMaskKey...), ErrMessageTooLarge},
+	}
+	for _, tt := range tests {
+		_, err := decodeFrames(NewFrameDecoder(1024, true), tt.input, 0)
+		if err != tt.err {
+			t.Errorf("%s: err %v; want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestClosePayload(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		code    int
+		reason  string
+		err     error
+	}{
+		{nil, CloseNoStatusReceived, "", nil},
+		{closePayload(CloseNormalClosure, "bye"), CloseNormalClosure, "bye", nil},
+		{closePayload(4000, ""), 4000, "", nil},
+		{[]byte{0x03}, 0, "", ErrProtocol},
+		{closePayload(CloseNoStatusReceived+1, ""), 0, "", ErrProtocol},
+		{closePayload(999, ""), 0, "", ErrProtocol},
+		{closePayload(CloseNormalClosure, "\xff"), 0, "", ErrInvalidUTF8},
+	}
+	for _, tt := range tests {
+		code, reason, err := parseClosePayload(tt.payload)
+		if err != tt.err || code != tt.code || reason != tt.reason {
+			t.Errorf("parseClosePayload(%q) = %d %q err %v; want %d %q err %v", tt.payload, code, reason, err, tt.code, tt.reason, tt.err)
+		}
+	}
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/weedge/lib/poller/http1"
+)
+
+// acceptGUID RFC 6455 magic GUID of Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// AcceptKey
+// Sec-WebSocket-Accept of Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// IsUpgrade
+// request asks to upgrade to websocket
+func IsUpgrade(r *http1.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+// checkHandshake
+// validate upgrade request, return response status if bad
+func checkHandshake(r *http1.Request) (status int, err error) {
+	if r.Method != http.MethodGet || r.ProtoMinor < 1 || !IsUpgrade(r) {
+		return http.StatusBadRequest, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return http.StatusUpgradeRequired, ErrBadHandshake
+	}
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return http.StatusBadRequest, ErrBadHandshake
+	}
+	return http.StatusSwitchingProtocols, nil
+}
+
+// selectSubprotocol
+// first server supported subprotocol in Sec-WebSocket-Protocol of request, empty if none
+func selectSubprotocol(r *http1.Request, subprotocols []string) string {
+	for _, p := range subprotocols {
+		if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// sameOrigin
+// default origin check: no Origin header (not browser) or Origin host is request Host
+func sameOrigin(r *http1.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// handshakeResponse
+// 101 switching protocols response
+func handshakeResponse(r *http1.Request, subprotocol string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&buf, "Sec-WebSocket-Accept: %s\r\n", AcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	if subprotocol != "" {
+		fmt.Fprintf(&buf, "Sec-WebSocket-Protocol: %s\r\n", subprotocol)
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// errorResponse
+// response for bad handshake, then close connect
+func errorResponse(status int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\nConnection: close\r\n", status, http.StatusText(status))
+	if status == http.StatusUpgradeRequired {
+		buf.WriteString("Sec-WebSocket-Version: 13\r\n")
+	}
+	buf.WriteString("Content-Length: 0\r\n\r\n")
+	return buf.Bytes()
+}
+
+// headerContainsToken
+// comma separated header values contain token (case insensitive)
+func headerContainsToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,427 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/weedge/lib/log"
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/http1"
+)
+
+const (
+	defaultMaxHeaderLen  = 8 * 1024
+	defaultMaxMessageLen = 1024 * 1024
+	// closeTimeout close connect if peer doesn't reply close frame in time
+	closeTimeout = 5 * time.Second
+)
+
+// Handler
+// websocket handler, run on poller io goroutine, don't block
+type Handler interface {
+	// OnOpen handshake done
+	OnOpen(c *Conn)
+	// OnMessage complete text or binary message, reassembled from fragments;
+	// data of unfragmented message refers to connect read buffer, valid until OnMessage returns
+	OnMessage(c *Conn, op Opcode, data []byte)
+	// OnClose connect closed, err is *CloseError if close frame received
+	OnClose(c *Conn, err error)
+}
+
+// PongHandler
+// optional Handler interface, pong frame received (eg: reply of Ping keepalive)
+type PongHandler interface {
+	OnPong(c *Conn, data []byte)
+}
+
+// Conn
+// websocket connect over poller connect, write methods are goroutine safe
+type Conn struct {
+	conn        *poller.Conn
+	Request     *http1.Request // handshake request, eg: path, query, auth headers
+	Subprotocol string         // negotiated Sec-WebSocket-Protocol
+	Data        interface{}    // Business custom data
+
+	parser  *http1.Parser
+	decoder *FrameDecoder // nil before handshake done
+
+	// fragmented message being reassembled, in event goroutine
+	msgOp   Opcode
+	msg     []byte
+	msgFrag bool
+
+	lock      sync.Mutex
+	closeSent bool
+	closed    bool
+}
+
+// PollerConn
+// underlying poller connect, eg: SetIdleTimeout
+func (c *Conn) PollerConn() *poller.Conn {
+	return c.conn
+}
+
+// RemoteAddr peer address
+func (c *Conn) RemoteAddr() string {
+	return c.conn.GetAddr()
+}
+
+// WriteMessage
+// write text or binary message in one frame
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	if op != OpText && op != OpBinary {
+		panic("websocket message opcode must be text or binary")
+	}
+	return c.writeFrame(op, data)
+}
+
+// WriteText
+func (c *Conn) WriteText(text string) error {
+	return c.writeFrame(OpText, []byte(text))
+}
+
+// WriteBinary
+func (c *Conn) WriteBinary(data []byte) error {
+	return c.writeFrame(OpBinary, data)
+}
+
+// Ping
+// write ping frame, payload no more than 125 bytes
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayloadLen {
+		return ErrProtocol
+	}
+	return c.writeFrame(OpPing, data)
+}
+
+// Close
+// start closing handshake: write close frame, close connect when peer replies or after close timeout
+func (c *Conn) Close(code int, reason string) error {
+	if !validCloseCode(code) || 2+len(reason) > maxControlPayloadLen {
+		return ErrProtocol
+	}
+	err := c.writeClose(code, reason)
+	if err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	return nil
+}
+
+// writeFrame
+// encode frame and write by one poller connect write, frames are not interleaved
+func (c *Conn) writeFrame(op Opcode, payload []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closeSent || c.closed {
+		return ErrCloseSent
+	}
+	_, err := c.conn.Write(AppendFrame(make([]byte, 0, maxFrameHeaderLen+len(payload)), true, op, payload, nil))
+	return err
+}
+
+// writeClose
+// write close frame once, no data frame is written after it
+func (c *Conn) writeClose(code int, reason string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closeSent || c.closed {
+		return ErrCloseSent
+	}
+	c.closeSent = true
+	_, err := c.conn.Write(AppendFrame(nil, true, OpClose, closePayload(code, reason), nil))
+	return err
+}
+
+// setClosed
+// mark closed, return false if already closed
+func (c *Conn) setClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	return true
+}
+
+// isClosed
+// closing handshake done or failed, frames left in buffer are ignored
+func (c *Conn) isClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// Adapter
+// poller.Handler to upgrade HTTP/1.1 request to websocket, decode frames and serve messages by Handler.
+// notice: connect data (Conn.SetData) is used by adapter for websocket Conn
+type Adapter struct {
+	handler       Handler
+	maxHeaderLen  int
+	maxMessageLen int
+	subprotocols  []string
+	checkOrigin   func(r *http1.Request) bool
+}
+
+// AdapterOption Adapter opt config
+type AdapterOption func(a *Adapter)
+
+// WithMaxHeaderLen max handshake request line and headers length, default 8KB
+func WithMaxHeaderLen(n int) AdapterOption {
+	return func(a *Adapter) {
+		if n <= 0 {
+			panic("max header len must greater than 0")
+		}
+		a.maxHeaderLen = n
+	}
+}
+
+// WithMaxMessageLen max frame payload and reassembled message length, default 1MB
+func WithMaxMessageLen(n int) AdapterOption {
+	return func(a *Adapter) {
+		if n < maxControlPayloadLen {
+			panic("max message len must not less than 125")
+		}
+		a.maxMessageLen = n
+	}
+}
+
+// WithSubprotocols server supported subprotocols by preference
+func WithSubprotocols(protocols ...string) AdapterOption {
+	return func(a *Adapter) {
+		a.subprotocols = protocols
+	}
+}
+
+// WithCheckOrigin
+// accept handshake request if check returns true, default accepts no Origin or same host Origin
+func WithCheckOrigin(check func(r *http1.Request) bool) AdapterOption {
+	return func(a *Adapter) {
+		if check == nil {
+			panic("check origin func must not be nil")
+		}
+		a.checkOrigin = check
+	}
+}
+
+// NewAdapter
+// Creates poller.Handler serving websocket by handler
+func NewAdapter(handler Handler, opts ...AdapterOption) *Adapter {
+	a := &Adapter{
+		handler:       handler,
+		maxHeaderLen:  defaultMaxHeaderLen,
+		maxMessageLen: defaultMaxMessageLen,
+		checkOrigin:   sameOrigin,
+	}
+	for _, o := range opts {
+		o(a)
+	}
+	return a
+}
+
+// ReadBufferLen
+// connect read buffer len for handshake request and the max frame
+func (a *Adapter) ReadBufferLen() int {
+	if a.maxHeaderLen > a.maxMessageLen+maxFrameHeaderLen {
+		return a.maxHeaderLen
+	}
+	return a.maxMessageLen + maxFrameHeaderLen
+}
+
+// NewServer
+// Creates poller server serving websocket on address by adapter (NewAdapter with AdapterOption),
+// read buffer len default is max header len or max message len + frame header len, it's allocated per connect,
+// lower WithMaxMessageLen for many connects
+func NewServer(address string, a *Adapter, opts ...poller.Option) (*poller.Server, error) {
+	opts = append([]poller.Option{poller.WithReadBufferLen(a.ReadBufferLen())}, opts...)
+	return poller.NewServer(address, a, opts...)
+}
+
+// OnConnect
+// init connect handshake request parser
+func (a *Adapter) OnConnect(c *poller.Conn) {
+	p := http1.NewParser(a.maxHeaderLen, 0)
+	c.SetData(&Conn{conn: c, parser: p})
+	c.SetDecoder(p)
+}
+
+// OnMessage
+// handshake request before upgraded, then one frame
+func (a *Adapter) OnMessage(c *poller.Conn, bytes []byte) {
+	ws, ok := c.GetData().(*Conn)
+	if !ok || ws.isClosed() {
+		return
+	}
+	if ws.decoder == nil {
+		a.handshake(ws)
+		return
+	}
+
+	if err := ws.decoder.Err(); err != nil {
+		a.fail(ws, err)
+		return
+	}
+	f := ws.decoder.Frame()
+	if f == nil {
+		return
+	}
+	err := a.processFrame(ws, f)
+	if err != nil {
+		a.fail(ws, err)
+	}
+}
+
+// OnClose
+func (a *Adapter) OnClose(c *poller.Conn, err error) {
+	ws, ok := c.GetData().(*Conn)
+	if !ok || ws.decoder == nil {
+		return
+	}
+	if err != nil && err != io.EOF {
+		log.Debugf("websocket connect %s close err %s", c.GetAddr(), err.Error())
+	}
+	if ws.setClosed() {
+		a.handler.OnClose(ws, err)
+	}
+}
+
+// handshake
+// validate upgrade request, response 101 and switch connect decoder to frame decoder
+func (a *Adapter) handshake(ws *Conn) {
+	c := ws.conn
+	if err := ws.parser.Err(); err != nil {
+		log.Warnf("websocket connect %s parse handshake request err %s", c.GetAddr(), err.Error())
+		a.reject(c, http.StatusBadRequest)
+		return
+	}
+	req := ws.parser.Request()
+	if req == nil {
+		return
+	}
+	req.RemoteAddr = c.GetAddr()
+
+	status, err := checkHandshake(req)
+	if err == nil && !a.checkOrigin(req) {
+		status, err = http.StatusForbidden, ErrBadHandshake
+	}
+	if err != nil {
+		log.Warnf("websocket connect %s %s %s bad handshake %d", c.GetAddr(), req.Method, req.RequestURI, status)
+		a.reject(c, status)
+		return
+	}
+
+	ws.Request = req
+	ws.Subprotocol = selectSubprotocol(req, a.subprotocols)
+	_, err = c.Write(handshakeResponse(req, ws.Subprotocol))
+	if err != nil {
+		log.Warnf("websocket connect %s write handshake response err %s", c.GetAddr(), err.Error())
+		c.Close()
+		return
+	}
+
+	// frames after handshake request in buffer are decoded by frame decoder
+	ws.parser = nil
+	ws.decoder = NewFrameDecoder(a.maxMessageLen, true)
+	c.SetDecoder(ws.decoder)
+	a.handler.OnOpen(ws)
+}
+
+// reject
+// response bad handshake and close
+func (a *Adapter) reject(c *poller.Conn, status int) {
+	c.Write(errorResponse(status))
+	c.CloseAfterFlush()
+}
+
+// processFrame
+// reply ping, handle pong and close, reassemble fragmented message
+func (a *Adapter) processFrame(ws *Conn, f *Frame) error {
+	switch f.Opcode {
+	case OpPing:
+		err := ws.writeFrame(OpPong, f.Payload)
+		if err != nil && err != ErrCloseSent {
+			return err
+		}
+		return nil
+
+	case OpPong:
+		if h, ok := a.handler.(PongHandler); ok {
+			h.OnPong(ws, f.Payload)
+		}
+		return nil
+
+	case OpClose:
+		code, reason, err := parseClosePayload(f.Payload)
+		if err != nil {
+			return err
+		}
+		// reply close if peer starts closing handshake, then close connect
+		ws.writeClose(code, "")
+		ws.conn.CloseAfterFlush()
+		if ws.setClosed() {
+			a.handler.OnClose(ws, &CloseError{Code: code, Reason: reason})
+		}
+		return nil
+
+	case OpText, OpBinary:
+		if ws.msgFrag {
+			return ErrProtocol
+		}
+		if f.Fin {
+			return a.onMessage(ws, f.Opcode, f.Payload)
+		}
+		ws.msgOp = f.Opcode
+		ws.msg = append(ws.msg[:0], f.Payload...)
+		ws.msgFrag = true
+		return nil
+
+	case OpContinuation:
+		if !ws.msgFrag {
+			return ErrProtocol
+		}
+		if len(ws.msg)+len(f.Payload) > a.maxMessageLen {
+			return ErrMessageTooLarge
+		}
+		ws.msg = append(ws.msg, f.Payload...)
+		if !f.Fin {
+			return nil
+		}
+		msg := ws.msg
+		ws.msg, ws.msgFrag = nil, false
+		return a.onMessage(ws, ws.msgOp, msg)
+	}
+	return ErrProtocol
+}
+
+// onMessage
+// validate utf-8 text, OnMessage handle
+func (a *Adapter) onMessage(ws *Conn, op Opcode, data []byte) error {
+	if op == OpText && !utf8.Valid(data) {
+		return ErrInvalidUTF8
+	}
+	a.handler.OnMessage(ws, op, data)
+	return nil
+}
+
+// fail
+// protocol error: write close frame with status code, close connect
+func (a *Adapter) fail(ws *Conn, err error) {
+	code := CloseProtocolError
+	switch err {
+	case ErrMessageTooLarge:
+		code = CloseMessageTooBig
+	case ErrInvalidUTF8:
+		code = CloseInvalidFramePayloadData
+	}
+	log.Warnf("websocket connect %s err %s, close %d", ws.RemoteAddr(), err.Error(), code)
+
+	ws.writeClose(code, "")
+	ws.conn.CloseAfterFlush()
+	if ws.setClosed() {
+		a.handler.OnClose(ws, err)
+	}
+}
@@ -0,0 +1,276 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/weedge/lib/poller"
+	"github.com/weedge/lib/poller/pollertest"
+)
+
+const handshakeRequest = "GET /chat HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+
+// wsRecorder echo handler records callbacks
+type wsRecorder struct {
+	opens  int
+	msgs   []string
+	pongs  []string
+	closes []error
+}
+
+func (h *wsRecorder) OnOpen(c *Conn) {
+	h.opens++
+}
+
+func (h *wsRecorder) OnMessage(c *Conn, op Opcode, data []byte) {
+	h.msgs = append(h.msgs, string(data))
+	c.WriteMessage(op, data)
+}
+
+func (h *wsRecorder) OnClose(c *Conn, err error) {
+	h.closes = append(h.closes, err)
+}
+
+func (h *wsRecorder) OnPong(c *Conn, data []byte) {
+	h.pongs = append(h.pongs, string(data))
+}
+
+// newTestConn loopback connect of adapter with read buffer len of NewServer
+func newTestConn(t *testing.T, h Handler, opts ...AdapterOption) *pollertest.Conn {
+	a := NewAdapter(h, opts...)
+	c, err := pollertest.New(a, poller.WithReadBufferLen(a.ReadBufferLen()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// clientFrame masked client frame
+func clientFrame(fin bool, op Opcode, payload []byte) []byte {
+	return AppendFrame(nil, fin, op, payload, testMaskKey)
+}
+
+// recvFrames read unmasked server frames
+func recvFrames(t *testing.T, c *pollertest.Conn) []Frame {
+	t.Helper()
+	out, err := c.Recv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := decodeFrames(NewFrameDecoder(1<<20, false), out, 0)
+	if err != nil {
+		t.Fatalf("decode server frames err %v", err)
+	}
+	return frames
+}
+
+// upgrade write handshake request with pipelined frames, return server frames after 101 response
+func upgrade(t *testing.T, c *pollertest.Conn, frames ...[]byte) []Frame {
+	t.Helper()
+	c.Write(append([]byte(handshakeRequest), bytes.Join(frames, nil)...))
+	out, err := c.Recv(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(bytes.NewReader(out))
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	rest, _ := io.ReadAll(r)
+	got, err := decodeFrames(NewFrameDecoder(1<<20, false), rest, 0)
+	if err != nil {
+		t.Fatalf("decode server frames err %v", err)
+	}
+	return got
+}
+
+// expectClose server frames end with close frame of code
+func expectClose(t *testing.T, frames []Frame, code int) {
+	t.Helper()
+	if len(frames) == 0 || frames[len(frames)-1].Opcode != OpClose {
+		t.Fatalf("server frames %+v; want close %d", frames, code)
+	}
+	got, _, err := parseClosePayload(frames[len(frames)-1].Payload)
+	if err != nil || got != code {
+		t.Fatalf("close code %d err %v; want %d", got, err, code)
+	}
+}
+
+func TestHandshakePipelinedFrames(t *testing.T) {
+	h := &wsRecorder{}
+	c := newTestConn(t, h)
+
+	// frames sent with handshake request are decoded after upgrade
+	frames := upgrade(t, c, clientFrame(true, OpText, []byte("a")), clientFrame(true, OpBinary, []byte("b")))
+	if h.opens != 1 || !reflect.DeepEqual(h.msgs, []string{"a", "b"}) {
+		t.Fatalf("opens %d msgs %q", h.opens, h.msgs)
+	}
+	if len(frames) != 2 || frames[0].Opcode != OpText || frames[1].Opcode != OpBinary {
+		t.Fatalf("echo frames %+v", frames)
+	}
+}
+
+func TestHandshakeReject(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"not get", "POST / HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n", http.StatusBadRequest},
+		{"no upgrade", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusBadRequest},
+		{"bad version", "GET / HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n", http.StatusUpgradeRequired},
+		{"bad key", "GET / HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: x\r\nSec-WebSocket-Version: 13\r\n\r\n", http.StatusBadRequest},
+		{"cross origin", "GET / HTTP/1.1\r\nHost: a\r\nOrigin: http://b\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		h := &wsRecorder{}
+		c := newTestConn(t, h)
+		c.Write([]byte(tt.request))
+		out, _ := c.Recv(0)
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(out)), nil)
+		if err != nil || resp.StatusCode != tt.status {
+			t.Errorf("%s: response %q err %v; want %d", tt.name, out, err, tt.status)
+			continue
+		}
+		if h.opens != 0 || !c.PollerConn().IsClosed() {
+			t.Errorf("%s: opens %d closed %v", tt.name, h.opens, c.PollerConn().IsClosed())
+		}
+	}
+}
+
+func TestExtendedPayloadLength(t *testing.T) {
+	h := &wsRecorder{}
+	c := newTestConn(t, h, WithMaxMessageLen(0x20000))
+	upgrade(t, c)
+
+	for _, n := range []int{126, 0xffff, 0x10000} {
+		c.WriteChunks(clientFrame(true, OpBinary, payload(n)), 4096)
+		frames := recvFrames(t, c)
+		if len(frames) != 1 || !bytes.Equal(frames[0].Payload, payload(n)) {
+			t.Fatalf("echo of %d bytes: %d frames", n, len(frames))
+		}
+	}
+	if len(h.msgs) != 3 {
+		t.Fatalf("got %d msgs; want 3", len(h.msgs))
+	}
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	h := &wsRecorder{}
+	c := newTestConn(t, h)
+	upgrade(t, c)
+
+	// control frames are interleaved between fragments
+	c.Write(bytes.Join([][]byte{
+		clientFrame(false, OpText, []byte("hel")),
+		clientFrame(true, OpPing, []byte("p")),
+		clientFrame(false, OpContinuation, []byte("l")),
+		clientFrame(true, OpPong, []byte("keepalive")),
+		clientFrame(true, OpContinuation, []byte("o")),
+	}, nil))
+	frames := recvFrames(t, c)
+	if !reflect.DeepEqual(h.msgs, []string{"hello"}) || !reflect.DeepEqual(h.pongs, []string{"keepalive"}) {
+		t.Fatalf("msgs %q pongs %q", h.msgs, h.pongs)
+	}
+	if len(frames) != 2 || frames[0].Opcode != OpPong || string(frames[0].Payload) != "p" || string(frames[1].Payload) != "hello" {
+		t.Fatalf("server frames %+v", frames)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   int
+	}{
+		{"unmasked frame", [][]byte{AppendFrame(nil, true, OpText, []byte("a"), nil)}, CloseProtocolError},
+		{"continuation without start", [][]byte{clientFrame(true, OpContinuation, []byte("a"))}, CloseProtocolError},
+		{"new message in fragments", [][]byte{clientFrame(false, OpText, []byte("a")), clientFrame(true, OpText, []byte("b"))}, CloseProtocolError},
+		{"fragmented ping", [][]byte{clientFrame(false, OpPing, nil)}, CloseProtocolError},
+		{"frame too big", [][]byte{clientFrame(true, OpBinary, payload(126))}, CloseMessageTooBig},
+		{"fragments too big", [][]byte{clientFrame(false, OpBinary, payload(100)), clientFrame(true, OpContinuation, payload(100))}, CloseMessageTooBig},
+		{"invalid utf-8 text", [][]byte{clientFrame(true, OpText, []byte{0xff})}, CloseInvalidFramePayloadData},
+		{"invalid close code", [][]byte{clientFrame(true, OpClose, closePayload(CloseAbnormalClosure, ""))}, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		h := &wsRecorder{}
+		c := newTestConn(t, h, WithMaxMessageLen(125))
+		upgrade(t, c)
+		c.Write(bytes.Join(tt.frames, nil))
+		expectClose(t, recvFrames(t, c), tt.code)
+		if !c.PollerConn().IsClosed() || len(h.closes) != 1 {
+			t.Errorf("%s: closed %v OnClose %v", tt.name, c.PollerConn().IsClosed(), h.closes)
+		}
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	t.Run("peer starts", func(t *testing.T) {
+		h := &wsRecorder{}
+		c := newTestConn(t, h)
+		upgrade(t, c)
+		c.Write(clientFrame(true, OpClose, closePayload(CloseGoingAway, "bye")))
+		expectClose(t, recvFrames(t, c), CloseGoingAway)
+		want := &CloseError{Code: CloseGoingAway, Reason: "bye"}
+		if len(h.closes) != 1 || !reflect.DeepEqual(h.closes[0], want) || !c.PollerConn().IsClosed() {
+			t.Fatalf("OnClose %v closed %v; want %v", h.closes, c.PollerConn().IsClosed(), want)
+		}
+	})
+	t.Run("peer starts without code", func(t *testing.T) {
+		h := &wsRecorder{}
+		c := newTestConn(t, h)
+		upgrade(t, c)
+		c.Write(clientFrame(true, OpClose, nil))
+		frames := recvFrames(t, c)
+		if len(frames) != 1 || frames[0].Opcode != OpClose || len(frames[0].Payload) != 0 {
+			t.Fatalf("server frames %+v; want empty close", frames)
+		}
+		if len(h.closes) != 1 || h.closes[0].(*CloseError).Code != CloseNoStatusReceived {
+			t.Fatalf("OnClose %v", h.closes)
+		}
+	})
+	t.Run("server starts", func(t *testing.T) {
+		h := &wsRecorder{}
+		var ws *Conn
+		c := newTestConn(t, &openHandler{wsRecorder: h, onOpen: func(c *Conn) { ws = c }})
+		upgrade(t, c)
+
+		if err := ws.Close(CloseAbnormalClosure, ""); err != ErrProtocol {
+			t.Fatalf("Close(1006) err %v; want %v", err, ErrProtocol)
+		}
+		if err := ws.Close(4000, "done"); err != nil {
+			t.Fatal(err)
+		}
+		expectClose(t, recvFrames(t, c), 4000)
+		// no data frame after close frame
+		if err := ws.WriteText("late"); err != ErrCloseSent {
+			t.Fatalf("WriteText() after close err %v; want %v", err, ErrCloseSent)
+		}
+
+		c.Write(clientFrame(true, OpClose, closePayload(4000, "")))
+		if len(h.closes) != 1 || h.closes[0].(*CloseError).Code != 4000 || !c.PollerConn().IsClosed() {
+			t.Fatalf("OnClose %v closed %v", h.closes, c.PollerConn().IsClosed())
+		}
+	})
+}
+
+// openHandler wsRecorder with OnOpen hook
+type openHandler struct {
+	*wsRecorder
+	onOpen func(c *Conn)
+}
+
+func (h *openHandler) OnOpen(c *Conn) {
+	h.wsRecorder.OnOpen(c)
+	h.onOpen(c)
+}
@@ -180,6 +180,31 @@ func (wp *WorkerPool) AddTask(task *Task) {
 	return
 }
 
+// TryAddTask add task without blocking,
+// return false if the pool is not running or task chan is full, caller can run task itself
+func (wp *WorkerPool) TryAddTask(task *Task) bool {
+	if nil == task || nil == task.Do || nil == task.InParam || nil == task.OutParam {
+		log.Warn("try add task is invalid")
+		return false
+	}
+
+	// Stop waits added task before close chWorkTask
+	atomic.AddInt32(&wp.addTaskStat, 1)
+	defer atomic.AddInt32(&wp.addTaskStat, -1)
+	if atomic.LoadInt32(&(wp.stat)) != WorkerPool_Stat_Running {
+		return false
+	}
+
+	select {
+	case wp.chWorkTask <- *task:
+	default:
+		return false
+	}
+	wp.addWorkerWhenAddTask()
+
+	return true
+}
+
 // add worker when add task cond:
 // 1. 1 < work task num < (min worker num)/2
 // 2. cur worker num < max worker num